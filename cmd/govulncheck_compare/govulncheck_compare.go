@@ -6,6 +6,9 @@
 // govulncheck on the module and the subpackages that are used for the binaries,
 // as well as the binaries themselves (for comparison). It then writes the results
 // as JSON. It is intended to be run in a sandbox.
+// With -list, it only lists the packages that compile to binaries, and with
+// -packages, it compares only the given packages, so that callers can
+// compare a module one package at a time.
 // Unless it panics, this program always terminates with exit code 0.
 // If there is an error, it writes a JSON object with field "Error".
 // Otherwise, it writes a internal/govulncheck.CompareResponse as JSON.
@@ -13,21 +16,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"golang.org/x/pkgsite-metrics/internal/buildbinary"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 )
 
+var (
+	maxFindings = flag.Int("max-findings", 0, "maximum number of findings to process per scan; 0 means no limit")
+	list        = flag.Bool("list", false, "list the packages that compile to binaries instead of comparing them")
+	packages    = flag.String("packages", "", "comma-separated import paths of the packages to compare; if empty, compare all")
+	timeout     = flag.Duration("timeout", 0, "maximum time each govulncheck run may take; 0 means no limit")
+)
+
 // govulncheck compare accepts three inputs in the following order
 //   - path to govulncheck
 //   - input module to scan
-//   - full path to the vulnerability database
+//   - full paths to the vulnerability databases, separated by commas
 func main() {
 	flag.Parse()
 	run(os.Stdout, flag.Args())
@@ -39,17 +51,33 @@ func run(w io.Writer, args []string) {
 		fmt.Fprintln(w)
 	}
 	if len(args) != 3 {
-		fail(errors.New("need three args: govulncheck path, input module dir, full path to vuln db"))
+		fail(errors.New("need three args: govulncheck path, input module dir, full paths to vuln dbs"))
 		return
 	}
 	govulncheckPath := args[0]
 	modulePath := args[1]
-	vulndbPath := args[2]
+	vulndbPaths := govulncheck.SplitVulnDBDirs(args[2])
 
-	binaries, err := buildbinary.FindAndBuildBinaries(modulePath)
-	if err != nil {
-		fail(err)
+	opts := &govulncheck.RunOptions{MaxFindings: *maxFindings, Timeout: *timeout}
+	var binaries []*buildbinary.BinaryInfo
+	switch {
+	case *list:
+		pkgs, err := buildbinary.FindBinaries(modulePath)
+		if err != nil {
+			fail(err)
+			return
+		}
+		writeResponse(w, &govulncheck.CompareResponse{Packages: pkgs}, fail)
 		return
+	case *packages != "":
+		binaries = buildbinary.BuildBinaries(modulePath, strings.Split(*packages, ","))
+	default:
+		var err error
+		binaries, err = buildbinary.FindAndBuildBinaries(modulePath)
+		if err != nil {
+			fail(err)
+			return
+		}
 	}
 	defer removeBinaries(binaries)
 
@@ -68,18 +96,25 @@ func run(w io.Writer, args []string) {
 			continue // there was an error in building the binary
 		}
 
-		pair.SourceResults.Findings, err = govulncheck.RunGovulncheckCmd(govulncheckPath, govulncheck.FlagSource, binary.ImportPath, modulePath, vulndbPath, &pair.SourceResults.Stats)
+		var err error
+		pair.SourceResults.Findings, pair.SourceResults.OSVs, err = govulncheck.RunGovulncheckCmd(context.Background(), govulncheckPath, govulncheck.FlagSource, binary.ImportPath, modulePath, vulndbPaths, opts, &pair.SourceResults.Stats)
 		if err != nil {
 			pair.Error = err.Error()
 			continue
 		}
 
-		pair.BinaryResults.Findings, err = govulncheck.RunGovulncheckCmd(govulncheckPath, govulncheck.FlagBinary, binary.BinaryPath, modulePath, vulndbPath, &pair.BinaryResults.Stats)
+		pair.BinaryResults.Findings, pair.BinaryResults.OSVs, err = govulncheck.RunGovulncheckCmd(context.Background(), govulncheckPath, govulncheck.FlagBinary, binary.BinaryPath, modulePath, vulndbPaths, opts, &pair.BinaryResults.Stats)
 		if err != nil {
 			pair.Error = err.Error()
 		}
 	}
 
+	writeResponse(w, &response, fail)
+}
+
+// writeResponse writes response to w as JSON, or calls fail if it can't
+// be encoded.
+func writeResponse(w io.Writer, response *govulncheck.CompareResponse, fail func(error)) {
 	b, err := json.MarshalIndent(response, "", "\t")
 	if err != nil {
 		fail(err)
@@ -4,7 +4,9 @@
 
 // This program runs govulncheck on a module in source mode and then
 // writes the result as JSON. It is intended to be run in a sandbox.
-// For running govulncheck on binaries, see cmd/compare_sandbox.
+// For running govulncheck on binaries, see cmd/compare_sandbox. In binary
+// mode, this program only scans blobs extracted from binaries with
+// "govulncheck -mode=extract".
 //
 // Unless it panics, this program always terminates with exit code 0.
 // If there is an error, it writes a JSON object with field "Error".
@@ -12,6 +14,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -20,6 +23,21 @@ import (
 	"os"
 
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+var (
+	maxFindings  = flag.Int("max-findings", 0, "maximum number of findings to process; 0 means no limit")
+	ignoreVendor = flag.Bool("ignore-vendor", false, "analyze the module graph instead of the vendor directory")
+	timeout      = flag.Duration("timeout", 0, "maximum time govulncheck may run; 0 means no limit")
+	platform     = flag.String("platform", "", "GOOS/GOARCH pair to load packages for; if empty, the host's")
+	perPackage   = flag.Bool("perpackage", false, "scan each package with its own run of govulncheck")
+	tags         = flag.String("tags", "", "comma-separated build tags to load packages with")
+	goFlags      = flag.String("goflags", "", "space-separated flags for the go command, as in GOFLAGS")
+	tests        = flag.Bool("test", false, "analyze test files too")
+	pattern      = flag.String("pattern", govulncheck.DefaultPattern, "pattern of the packages of the module to scan")
+	goRoot       = flag.String("goroot", "", "GOROOT of the Go toolchain to use; if empty, the one on the PATH")
 )
 
 // main function for govulncheck sandbox that accepts four inputs
@@ -27,7 +45,7 @@ import (
 //   - path to govulncheck
 //   - govulncheck mode
 //   - input module or binary to analyze
-//   - full path to the vulnerability database
+//   - full paths to the vulnerability databases, separated by commas
 func main() {
 	flag.Parse()
 	run(os.Stdout, flag.Args())
@@ -41,17 +59,24 @@ func run(w io.Writer, args []string) {
 	}
 
 	if len(args) != 4 {
-		fail(errors.New("need four args: govulncheck path, mode, input module dir or binary, full path to vuln db"))
+		fail(errors.New("need four args: govulncheck path, mode, input module dir or binary, full paths to vuln dbs"))
 		return
 	}
 
 	modeFlag := args[1]
 	if modeFlag == govulncheck.FlagBinary {
-		fail(errors.New("binaries are only analyzed in compare_sandbox"))
-		return
+		ok, err := govulncheck.IsExtractBlob(args[2])
+		if err != nil {
+			fail(err)
+			return
+		}
+		if !ok {
+			fail(errors.New("binaries are only analyzed in compare_sandbox; binary mode only scans extract blobs"))
+			return
+		}
 	}
 
-	resp, err := runGovulncheck(args[0], modeFlag, args[2], args[3])
+	resp, err := runGovulncheck(args[0], modeFlag, args[2], govulncheck.SplitVulnDBDirs(args[3]))
 	if err != nil {
 		fail(err)
 		return
@@ -66,15 +91,29 @@ func run(w io.Writer, args []string) {
 	fmt.Println()
 }
 
-func runGovulncheck(govulncheckPath, modeFlag, filePath, vulnDBDir string) (*govulncheck.SandboxResponse, error) {
+func runGovulncheck(govulncheckPath, modeFlag, filePath string, vulnDBDirs []string) (*govulncheck.SandboxResponse, error) {
 	response := govulncheck.SandboxResponse{
 		Stats: govulncheck.ScanStats{},
 	}
 
-	findings, err := govulncheck.RunGovulncheckCmd(govulncheckPath, modeFlag, "./...", filePath, vulnDBDir, &response.Stats)
+	opts := &govulncheck.RunOptions{MaxFindings: *maxFindings, IgnoreVendor: *ignoreVendor, Timeout: *timeout, Platform: *platform, Tags: *tags, GoFlags: *goFlags, Tests: *tests, GoRoot: *goRoot}
+	var (
+		findings []*govulncheckapi.Finding
+		osvs     []*osv.Entry
+		err      error
+	)
+	switch {
+	case modeFlag == govulncheck.FlagBinary:
+		findings, osvs, err = govulncheck.RunGovulncheckCmd(context.Background(), govulncheckPath, modeFlag, filePath, "", vulnDBDirs, opts, &response.Stats)
+	case *perPackage:
+		findings, osvs, err = govulncheck.RunGovulncheckPerPackage(context.Background(), govulncheckPath, modeFlag, *pattern, filePath, vulnDBDirs, opts, &response.Stats)
+	default:
+		findings, osvs, err = govulncheck.RunGovulncheckCmd(context.Background(), govulncheckPath, modeFlag, *pattern, filePath, vulnDBDirs, opts, &response.Stats)
+	}
 	if err != nil {
 		return nil, err
 	}
 	response.Findings = findings
+	response.OSVs = osvs
 	return &response, nil
 }
@@ -59,9 +59,12 @@ func Test(t *testing.T) {
 		if resp.Stats.ScanSeconds <= 0 {
 			t.Errorf("got %f; want >0 scan seconds", resp.Stats.ScanSeconds)
 		}
-		if resp.Stats.ScanMemory <= 0 {
+		if resp.Stats.ScanMemory <= 0 && govulncheck.MemoryUsageAvailable() {
 			t.Errorf("got %d; want >0 scan memory", resp.Stats.ScanMemory)
 		}
+		if cpu := resp.Stats.UserCPUSeconds + resp.Stats.SystemCPUSeconds; cpu <= 0 {
+			t.Errorf("got %f; want >0 CPU seconds", cpu)
+		}
 	})
 
 	// Errors
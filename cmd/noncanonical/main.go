@@ -0,0 +1,72 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command noncanonical reports the rows of the govulncheck table whose
+// module versions are not canonical, like "1.2.3" or "v1.2.3+incompatible",
+// which the worker stored before it canonicalized versions.
+//
+// For each such module version, it prints the module path, the version,
+// its canonical form (or "-" if it has none), the number of rows and the
+// creation times of the first and last of them, separated by tabs. It
+// doesn't change the rows.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func main() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintln(out, "usage:")
+		fmt.Fprintln(out, "noncanonical")
+		fmt.Fprintln(out, "  report the govulncheck rows with non-canonical module versions")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context) error {
+	cfg, err := config.Init(ctx)
+	if err != nil {
+		return err
+	}
+	if cfg.ProjectID == "" {
+		return errors.New("missing project ID (GOOGLE_CLOUD_PROJECT environment variable)")
+	}
+	client, err := bigquery.NewClientCreate(ctx, cfg.ProjectID, cfg.BigQueryDataset)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	vs, err := govulncheck.ReadNonCanonicalVersions(ctx, client)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, v := range vs {
+		canonical := v.Canonical
+		if canonical == "" {
+			canonical = "-"
+		}
+		fmt.Printf("%s\t%s\t%s\t%d\t%s\t%s\n", v.ModulePath, v.Version, canonical, v.NumRows,
+			v.FirstCreated.UTC().Format(time.RFC3339), v.LastCreated.UTC().Format(time.RFC3339))
+		total += v.NumRows
+	}
+	log.Printf("%d rows of %d module versions are not canonical", total, len(vs))
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command scrublookup maps scrubbed values in govulncheck results back to
+// the values they were computed from.
+//
+// Scrubbed values are keyed hashes, so they cannot be inverted directly.
+// Instead, scrublookup hashes each candidate value read from standard input,
+// one per line, and prints the hash and the value separated by a tab. The
+// output can be joined against scrubbed rows offline.
+//
+// The HMAC key is read from the file named by -keyfile, or from the secret
+// named by -secret (of the form projects/PROJECT/secrets/NAME).
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+var (
+	keyFile = flag.String("keyfile", "", "file holding the HMAC key")
+	secret  = flag.String("secret", "", "secret holding the HMAC key")
+)
+
+func main() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintln(out, "usage:")
+		fmt.Fprintln(out, "scrublookup -keyfile FILE | -secret NAME < candidates")
+		fmt.Fprintln(out, "  print the scrubbed form of each candidate value")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context) error {
+	var key string
+	switch {
+	case *keyFile != "" && *secret != "":
+		return errors.New("only one of -keyfile and -secret may be provided")
+	case *keyFile != "":
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		// Ignore the final newline that editors add.
+		key = strings.TrimSuffix(string(data), "\n")
+	case *secret != "":
+		var err error
+		key, err = internal.GetSecret(ctx, *secret)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("missing -keyfile or -secret")
+	}
+	s, err := govulncheck.NewScrubber([]byte(key), "")
+	if err != nil {
+		return err
+	}
+	scan := bufio.NewScanner(os.Stdin)
+	for scan.Scan() {
+		v := strings.TrimSpace(scan.Text())
+		if v == "" {
+			continue
+		}
+		fmt.Printf("%s\t%s\n", s.Hash(v), v)
+	}
+	return scan.Err()
+}
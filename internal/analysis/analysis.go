@@ -48,8 +48,12 @@ type EnqueueParams struct {
 	User     string // user initiating enqueue
 }
 
-// Request implements queue.Task so it can be put on a TaskQueue.
-var _ queue.Task = (*ScanRequest)(nil)
+// Request implements queue.PriorityTask and queue.ModeTask so it can be
+// put on a TaskQueue.
+var (
+	_ queue.PriorityTask = (*ScanRequest)(nil)
+	_ queue.ModeTask     = (*ScanRequest)(nil)
+)
 
 func (r *ScanRequest) Name() string { return r.Binary + "_" + r.Module + "@" + r.Version }
 
@@ -59,7 +63,19 @@ func (r *ScanRequest) Params() string {
 	return scan.FormatParams(r.ScanParams)
 }
 
-func ParseScanRequest(r *http.Request, prefix string) (*ScanRequest, error) {
+// Priority implements queue.PriorityTask.
+func (r *ScanRequest) Priority() int { return r.ImportedBy }
+
+// ScanMode is the scan mode of analysis tasks, by which queues route
+// them.
+const ScanMode = "ANALYSIS"
+
+// ScanMode implements queue.ModeTask.
+func (r *ScanRequest) ScanMode() string { return ScanMode }
+
+func ParseScanRequest(r *http.Request, prefix string) (_ *ScanRequest, err error) {
+	defer func() { scan.SetExample(err, prefix+"/golang.org/x/text@v0.3.0?binary=checker") }()
+
 	mp, err := scan.ParseModuleURLPath(strings.TrimPrefix(r.URL.Path, prefix))
 	if err != nil {
 		return nil, err
@@ -135,6 +151,8 @@ const TableName = "analysis"
 //   - adding a nullable or repeated column
 //   - dropping a column
 //   - changing a column from required to nullable.
+// The worker applies them to the table when it starts, and refuses to
+// start on other changes; see bigquery.MigrateSchema.
 // See https://cloud.google.com/bigquery/docs/managing-table-schemas for details.
 
 // Result is a row in the BigQuery analysis table. It corresponds to a
@@ -201,7 +219,11 @@ func init() {
 		panic(err)
 	}
 	SchemaVersion = bigquery.SchemaVersion(s)
-	bigquery.AddTable(TableName, s)
+	bigquery.AddTableWithOptions(TableName, s, bigquery.TableOptions{
+		PartitionField: "created_at",
+		ClusterFields:  []string{"module_path"},
+		Latest:         &bigquery.LatestView{Key: []string{"module_path", "version", "binary_name"}},
+	})
 }
 
 // WorkVersionKey is the key for a WorkVersion.
@@ -219,14 +241,15 @@ func ReadWorkVersion(ctx context.Context, c *bigquery.Client, module_path, versi
 
 	const qf = `
                 SELECT module_path, version, binary_name, binary_version, binary_args, worker_version, schema_version
-                FROM %s WHERE module_path="%s" AND version="%s" AND binary_name="%s" ORDER BY created_at DESC LIMIT 1
+                FROM %s WHERE module_path=@module_path AND version=@version AND binary_name=@binary_name ORDER BY created_at DESC LIMIT 1
         `
-	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`", module_path, version, binary)
-	iter, err := c.Query(ctx, query)
-	if err != nil {
-		return nil, err
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`")
+	params := []bq.QueryParameter{
+		bigquery.Param("module_path", module_path),
+		bigquery.Param("version", version),
+		bigquery.Param("binary_name", binary),
 	}
-	err = bigquery.ForEachRow(iter, func(r *Result) bool {
+	err = bigquery.ForEach(ctx, c, query, params, func(r *Result) bool {
 		// Should be reached at most once.
 		wv = &r.WorkVersion
 		return true
@@ -277,21 +300,9 @@ func ReadResults(ctx context.Context, c *bigquery.Client, binaryName, binaryVers
 	q := bigquery.PartitionQuery{
 		From:        c.FullTableName(TableName),
 		PartitionOn: "module_path, version",
-		Where: fmt.Sprintf("binary_name='%s' AND binary_version='%s' AND binary_args='%s'",
-			binaryName, binaryVersion, binaryArgs),
-		OrderBy: "created_at DESC",
-	}
-	iter, err := c.Query(ctx, q.String())
-	if err != nil {
-		return nil, err
-	}
-	var res []*Result
-	err = bigquery.ForEachRow(iter, func(r *Result) bool {
-		res = append(res, r)
-		return true
-	})
-	if err != nil {
-		return nil, err
+		Where:       "binary_name=@binary_name AND binary_version=@binary_version AND binary_args=@binary_args",
+		OrderBy:     "created_at DESC",
 	}
-	return res, nil
+	return bigquery.Query[Result](ctx, c, q.String(), bigquery.Param("binary_name", binaryName),
+		bigquery.Param("binary_version", binaryVersion), bigquery.Param("binary_args", binaryArgs))
 }
@@ -29,6 +29,20 @@ type Client struct {
 	client               *bq.Client
 	dataset              *bq.Dataset
 	deleteDatasetOnClose bool
+	// writer appends uploaded rows with the Storage Write API.
+	writer *writer
+	// fake, if non-nil, stands in for BigQuery; see NewFake.
+	fake *Fake
+	// retention is the number of days the rows of tables are kept, by
+	// table ID; see SetRetention.
+	retention map[string]int
+	// recordCosts reports whether the costs of queries are recorded, and
+	// costs tracks their uploads; see RecordQueryCosts.
+	recordCosts bool
+	costs       sync.WaitGroup
+	// now returns the upload time of rows. It is time.Now, except in
+	// tests; see SetClock.
+	now func() time.Time
 }
 
 // NewClientCreate creates a new client for connecting to BigQuery, referring
@@ -51,15 +65,33 @@ func newClient(ctx context.Context, projectID, datasetID string) (_ *Client, err
 	if _, err := dataset.Metadata(ctx); err != nil {
 		return nil, err
 	}
+	w, err := newWriter(ctx, projectID, datasetID)
+	if err != nil {
+		return nil, errors.Join(err, client.Close())
+	}
 	return &Client{
 		client:  client,
 		dataset: dataset,
+		writer:  w,
+		now:     time.Now,
 	}, nil
 }
 
+// SetClock makes c get the upload time of rows from now instead of
+// time.Now.
+func (c *Client) SetClock(now func() time.Time) {
+	c.now = now
+}
+
+// Close appends the rows that are still buffered, then closes c.
 func (c *Client) Close() (err error) {
+	if c.fake != nil {
+		return nil
+	}
+	c.costs.Wait()
+	err = c.writer.close()
 	if c.deleteDatasetOnClose {
-		err = c.dataset.DeleteWithContents(context.Background())
+		err = errors.Join(err, c.dataset.DeleteWithContents(context.Background()))
 	}
 	return errors.Join(err, c.client.Close())
 }
@@ -138,35 +170,100 @@ func (c *Client) Table(tableID string) *bq.Table {
 // FullTableName returns the fully-qualified name of the table, suitable for
 // use in queries.
 func (c *Client) FullTableName(tableID string) string {
+	if c.fake != nil {
+		return "fake." + tableID
+	}
 	// From https://github.com/googleapis/google-cloud-go/blob/bigquery/v1.43.0/bigquery/table.go#L544.
 	return fmt.Sprintf("%s.%s.%s", c.dataset.ProjectID, c.dataset.DatasetID, tableID)
 }
 
 // CreateOrUpdateTable creates a table if it does not exist, or updates it if it does.
 // It returns true if it created the table.
+//
+// Existing tables are migrated to their registered schema, or left
+// alone with an error if that would need an illegal change; see
+// MigrateSchema.
+//
+// Tables are created with the partitioning and clustering of their
+// TableOptions. Existing tables are updated to their clustering, but
+// BigQuery can't partition an existing table, so one that is not
+// partitioned as registered must be recreated to be.
+//
+// If the TableOptions have a LatestView, the view is created or updated
+// along with the table.
+//
+// If c has a retention for the table, it is enforced; see SetRetention.
 func (c *Client) CreateOrUpdateTable(ctx context.Context, tableID string) (created bool, err error) {
 	defer derrors.Wrap(&err, "CreateOrUpdateTable(%q)", tableID)
 	schema := TableSchema(tableID)
 	if schema == nil {
 		return false, fmt.Errorf("no schema registered for table %q", tableID)
 	}
+	opts := tableOptions(tableID)
+	period := c.retentionPeriod(tableID)
+	if period > 0 && opts.PartitionField != "" {
+		opts.PartitionExpiration = period
+	}
+	if c.fake != nil {
+		created = c.fake.createTable(tableID)
+	} else {
+		created, err = c.createOrUpdateTable(ctx, tableID, schema, opts)
+		if err != nil {
+			return false, err
+		}
+		if opts.Latest != nil {
+			if err := c.createOrUpdateLatestView(ctx, tableID, schema, opts.Latest); err != nil {
+				return created, err
+			}
+		}
+	}
+	if period > 0 {
+		if err := c.enforceRetention(ctx, tableID, opts, period); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
 
+func (c *Client) createOrUpdateTable(ctx context.Context, tableID string, schema bq.Schema, opts TableOptions) (created bool, err error) {
 	meta, err := c.Table(tableID).Metadata(ctx) // check if the table already exists
 	if err != nil {
 		if !isNotFoundError(err) {
 			return false, err
 		}
-		return true, c.Table(tableID).Create(ctx, &bq.TableMetadata{Schema: schema})
+		return true, c.Table(tableID).Create(ctx, opts.metadata(schema))
 	}
 
-	if SchemaVersion(schema) == SchemaVersion(meta.Schema) {
-		// The schemas are the same, so we don't need to do anything. In fact, any
+	var update bq.TableMetadataToUpdate
+	changed := false
+	if SchemaVersion(schema) != SchemaVersion(meta.Schema) {
+		migrated, _, err := MigrateSchema(meta.Schema, schema)
+		if err != nil {
+			return false, err
+		}
+		// Dropped columns stay, so the schemas can differ after the
+		// migration has been applied.
+		if SchemaVersion(migrated) != SchemaVersion(meta.Schema) {
+			update.Schema = migrated
+			changed = true
+		}
+	}
+	if cl := opts.clustering(); cl != nil && !sameClustering(cl, meta.Clustering) {
+		update.Clustering = cl
+		changed = true
+	}
+	if tp := meta.TimePartitioning; tp != nil && opts.PartitionExpiration > 0 && tp.Expiration != opts.PartitionExpiration {
+		update.TimePartitioning = &bq.TimePartitioning{Type: tp.Type, Field: tp.Field, Expiration: opts.PartitionExpiration}
+		changed = true
+	}
+	if !changed {
+		// The table is as registered, so we don't need to do anything. In fact, any
 		// update, even an idempotent one, will result in table patching that counts
 		// towards quota limits for table metadata updates.
 		return false, nil
 	}
 
-	_, err = c.Table(tableID).Update(ctx, bq.TableMetadataToUpdate{Schema: schema}, meta.ETag)
+	_, err = c.Table(tableID).Update(ctx, update, meta.ETag)
 	// There is a race condition if multiple threads of control call this function concurrently:
 	// The table may have changed since Metadata was called above. This error is harmless: it
 	// just means that someone else updated the table before us. Ignore it.
@@ -182,52 +279,34 @@ type Row interface {
 	SetUploadTime(time.Time)
 }
 
-// Upload inserts a row into the table.
+// Upload appends a row to the table. It returns once the row is
+// appended, with the rows of concurrent uploads to the same table.
 func (c *Client) Upload(ctx context.Context, tableID string, row Row) (err error) {
 	defer derrors.Wrap(&err, "Upload(ctx, %q)", tableID)
-	u := c.Table(tableID).Inserter()
-	row.SetUploadTime(time.Now())
-	return u.Put(ctx, row)
+	row.SetUploadTime(c.now())
+	if c.fake != nil {
+		return c.fake.upload(tableID, []any{row})
+	}
+	return c.writer.upload(ctx, tableID, []any{row})
 }
 
-// UploadMany inserts multiple rows into the table.
+// UploadMany appends multiple rows to the table.
 // Each row should be a struct pointer.
-// The chunkSize parameter limits the number of rows sent in a single request; this may
-// be necessary to avoid reaching the maximum size of a request.
-// If chunkSize is <= 0, all rows will be sent in one request.
+// The chunkSize parameter is ignored: rows are appended in requests
+// small enough for the API.
 func UploadMany[T Row](ctx context.Context, client *Client, tableID string, rows []T, chunkSize int) (err error) {
-	defer derrors.Wrap(&err, "UploadMany(%q), %d rows, chunkSize=%d", tableID, len(rows), chunkSize)
+	defer derrors.Wrap(&err, "UploadMany(%q), %d rows", tableID, len(rows))
 
-	now := time.Now()
-	// Set upload time.
-	for _, r := range rows {
+	now := client.now()
+	vals := make([]any, len(rows))
+	for i, r := range rows {
 		r.SetUploadTime(now)
+		vals[i] = r
 	}
-
-	ins := client.Table(tableID).Inserter()
-	if chunkSize <= 0 {
-		return ins.Put(ctx, rows)
-	}
-	start := 0
-	for start < len(rows) {
-		end := start + chunkSize
-		if end > len(rows) {
-			end = len(rows)
-		}
-		for {
-			if err := ins.Put(ctx, rows[start:end]); err == nil {
-				break
-			} else if hasCode(err, http.StatusRequestEntityTooLarge) && end-start > 1 {
-				// Request too large; reduce this chunk size by half.
-				end = start + (end-start)/2
-				continue
-			} else {
-				return err
-			}
-		}
-		start = end
+	if client.fake != nil {
+		return client.fake.upload(tableID, vals)
 	}
-	return nil
+	return client.writer.upload(ctx, tableID, vals)
 }
 
 // ForEachRow calls f for each row in the given iterator.
@@ -262,8 +341,95 @@ func All[T any](iter *bq.RowIterator) ([]*T, error) {
 	return ts, nil
 }
 
-func (c *Client) Query(ctx context.Context, q string) (*bq.RowIterator, error) {
-	return c.client.Query(q).Read(ctx)
+// Query runs the query q with the given parameters and returns an iterator
+// over its rows. The query refers to each parameter by name, as @name.
+func (c *Client) Query(ctx context.Context, q string, params ...bq.QueryParameter) (*bq.RowIterator, error) {
+	if c.fake != nil {
+		return nil, errors.New("Query is not supported by fake clients; use bigquery.Query")
+	}
+	query := c.client.Query(q)
+	query.Parameters = params
+	if !c.recordCosts {
+		return query.Read(ctx)
+	}
+	// Wait for the job, to get its statistics.
+	job, err := query.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	c.recordCost(ctx, q, job, status)
+	return job.Read(ctx)
+}
+
+// Query runs the query q with the given parameters and returns its rows,
+// read into values of type T. The columns of the query must match the
+// fields of T, as for bq.RowIterator.Next.
+func Query[T any](ctx context.Context, c *Client, q string, params ...bq.QueryParameter) ([]*T, error) {
+	if c.fake != nil {
+		var ts []*T
+		err := fakeForEach(c.fake, q, params, func(t *T) bool {
+			ts = append(ts, t)
+			return true
+		})
+		return ts, err
+	}
+	iter, err := c.Query(ctx, q, params...)
+	if err != nil {
+		return nil, err
+	}
+	return All[T](iter)
+}
+
+// ForEach runs the query q with the given parameters and calls f with each
+// of its rows, read into a value of type T, until f returns false.
+func ForEach[T any](ctx context.Context, c *Client, q string, params []bq.QueryParameter, f func(*T) bool) error {
+	if c.fake != nil {
+		return fakeForEach(c.fake, q, params, f)
+	}
+	iter, err := c.Query(ctx, q, params...)
+	if err != nil {
+		return err
+	}
+	return ForEachRow(iter, f)
+}
+
+// Exec runs the statement q, like a MERGE or UPDATE, with the given
+// parameters, and waits for it to finish. Rows that it returns are
+// ignored.
+func (c *Client) Exec(ctx context.Context, q string, params ...bq.QueryParameter) error {
+	if c.fake != nil {
+		_, err := c.fake.query(q, params)
+		return err
+	}
+	query := c.client.Query(q)
+	query.Parameters = params
+	job, err := query.Run(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if err := status.Err(); err != nil {
+		return err
+	}
+	c.recordCost(ctx, q, job, status)
+	return nil
+}
+
+// Param returns the query parameter with the given name and value.
+// Its BigQuery type is inferred from the Go type of value, so a string
+// is a STRING and a []string an ARRAY<STRING>.
+func Param(name string, value any) bq.QueryParameter {
+	return bq.QueryParameter{Name: name, Value: value}
 }
 
 // NullFloat constructs a bq.NullFloat64
@@ -281,6 +447,16 @@ func NullInt(i int) bq.NullInt64 {
 	return bq.NullInt64{Int64: int64(i), Valid: true}
 }
 
+// NullBool constructs a bq.NullBool.
+func NullBool(b bool) bq.NullBool {
+	return bq.NullBool{Bool: b, Valid: true}
+}
+
+// NullTimestamp constructs a bq.NullTimestamp.
+func NullTimestamp(t time.Time) bq.NullTimestamp {
+	return bq.NullTimestamp{Timestamp: t, Valid: true}
+}
+
 // NullTime constructs a bq.NullTime.
 func NullTime(t time.Time) bq.NullTime {
 	return bq.NullTime{Time: civil.TimeOf(t), Valid: true}
@@ -328,16 +504,70 @@ func SchemaString(schema bq.Schema) string {
 	return b.String()
 }
 
+// TableOptions are the properties of a table other than its schema.
+type TableOptions struct {
+	// PartitionField is the TIMESTAMP or DATE column that the table is
+	// partitioned on by day. If it is empty, the table is not
+	// partitioned.
+	PartitionField string
+	// PartitionExpiration, if positive, is how long the partitions of a
+	// partitioned table are kept.
+	PartitionExpiration time.Duration
+	// ClusterFields are the columns that the table is clustered on, in
+	// order. Queries that filter on a prefix of them read fewer blocks.
+	ClusterFields []string
+	// Latest, if non-nil, describes a view of the latest rows of the
+	// table.
+	Latest *LatestView
+}
+
+// metadata returns the metadata of a new table with schema and the
+// options of o.
+func (o TableOptions) metadata(schema bq.Schema) *bq.TableMetadata {
+	meta := &bq.TableMetadata{Schema: schema, Clustering: o.clustering()}
+	if o.PartitionField != "" {
+		meta.TimePartitioning = &bq.TimePartitioning{Type: bq.DayPartitioningType, Field: o.PartitionField, Expiration: o.PartitionExpiration}
+	}
+	return meta
+}
+
+// clustering returns the clustering of o, or nil if there is none.
+func (o TableOptions) clustering() *bq.Clustering {
+	if len(o.ClusterFields) == 0 {
+		return nil
+	}
+	return &bq.Clustering{Fields: o.ClusterFields}
+}
+
+// sameClustering reports whether c and d cluster on the same columns.
+func sameClustering(c, d *bq.Clustering) bool {
+	if c == nil || d == nil {
+		return c == d
+	}
+	return strings.Join(c.Fields, ",") == strings.Join(d.Fields, ",")
+}
+
+type table struct {
+	schema bq.Schema
+	opts   TableOptions
+}
+
 var (
 	tableMu sync.Mutex
-	tables  = map[string]bq.Schema{}
+	tables  = map[string]table{}
 )
 
 // AddTable records the schema for a table, so table creation just needs the name.
 func AddTable(tableID string, s bq.Schema) {
+	AddTableWithOptions(tableID, s, TableOptions{})
+}
+
+// AddTableWithOptions is like AddTable, but also records the options that
+// the table is created with.
+func AddTableWithOptions(tableID string, s bq.Schema, opts TableOptions) {
 	tableMu.Lock()
 	defer tableMu.Unlock()
-	tables[tableID] = s
+	tables[tableID] = table{schema: s, opts: opts}
 }
 
 // TableSchema returns the schema associated with the given table,
@@ -345,7 +575,14 @@ func AddTable(tableID string, s bq.Schema) {
 func TableSchema(tableID string) bq.Schema {
 	tableMu.Lock()
 	defer tableMu.Unlock()
-	return tables[tableID]
+	return tables[tableID].schema
+}
+
+// tableOptions returns the options of the given table.
+func tableOptions(tableID string) TableOptions {
+	tableMu.Lock()
+	defer tableMu.Unlock()
+	return tables[tableID].opts
 }
 
 // PartitionQuery describes a query that returns one row for each distinct value
@@ -8,8 +8,10 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
 
 	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
 	test "golang.org/x/pkgsite-metrics/internal/testing"
 )
 
@@ -110,3 +112,102 @@ func TestSchemaString(t *testing.T) {
 		t.Errorf("\ngot  %q\nwant %q", got, want)
 	}
 }
+
+func TestTableOptions(t *testing.T) {
+	schema := bq.Schema{{Name: "created_at", Type: bq.TimestampFieldType}, {Name: "module_path", Type: bq.StringFieldType}}
+	meta := TableOptions{}.metadata(schema)
+	if meta.TimePartitioning != nil || meta.Clustering != nil {
+		t.Errorf("no options: got partitioning %v and clustering %v, want neither", meta.TimePartitioning, meta.Clustering)
+	}
+
+	opts := TableOptions{PartitionField: "created_at", ClusterFields: []string{"module_path"}}
+	meta = opts.metadata(schema)
+	if tp := meta.TimePartitioning; tp == nil || tp.Field != "created_at" || tp.Type != bq.DayPartitioningType {
+		t.Errorf("got partitioning %+v, want daily on created_at", tp)
+	}
+	if !sameClustering(meta.Clustering, &bq.Clustering{Fields: []string{"module_path"}}) {
+		t.Errorf("got clustering %+v, want on module_path", meta.Clustering)
+	}
+	if sameClustering(meta.Clustering, nil) || sameClustering(meta.Clustering, &bq.Clustering{Fields: []string{"module_path", "version"}}) {
+		t.Error("clusterings on different columns are the same")
+	}
+}
+
+func TestLatestViewQuery(t *testing.T) {
+	v := &LatestView{Key: []string{"module_path", "version"}}
+	want := "SELECT * FROM `t` AS r WHERE TRUE\n" +
+		"QUALIFY ROW_NUMBER() OVER (PARTITION BY module_path, version ORDER BY created_at DESC) = 1"
+	if got := v.query("`t`"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	v.Filter = func(table string) string { return "r.ok AND " + table + " IS NOT NULL" }
+	if got := v.query("`t`"); !strings.Contains(got, "WHERE r.ok AND `t` IS NOT NULL\n") {
+		t.Errorf("filter missing from\n%s", got)
+	}
+}
+
+func TestExportQuery(t *testing.T) {
+	day := civil.Date{Year: 2023, Month: time.October, Day: 1}
+	uri := ExportURI("gs://bucket/exports/", "govulncheck", day)
+	if want := "gs://bucket/exports/govulncheck/date=2023-10-01/*.parquet"; uri != want {
+		t.Errorf("got URI %q, want %q", uri, want)
+	}
+	q := exportQuery("`t`", uri, day)
+	for _, want := range []string{
+		`uri = "gs://bucket/exports/govulncheck/date=2023-10-01/*.parquet", format = "PARQUET"`,
+		`SELECT * FROM ` + "`t`",
+		`created_at >= TIMESTAMP("2023-10-01T00:00:00Z") AND created_at < TIMESTAMP("2023-10-02T00:00:00Z")`,
+	} {
+		if !strings.Contains(q, want) {
+			t.Errorf("query does not contain %s:\n%s", want, q)
+		}
+	}
+}
+
+func TestNewQueryCost(t *testing.T) {
+	start := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	stats := &bq.JobStatistics{
+		StartTime:           start,
+		EndTime:             start.Add(1500 * time.Millisecond),
+		TotalBytesProcessed: 100,
+		Details:             &bq.QueryStatistics{StatementType: "SELECT", TotalBytesBilled: 10 << 20, SlotMillis: 7},
+	}
+	got := newQueryCost("SELECT 1", "job", stats)
+	want := &QueryCost{JobID: "job", Query: "SELECT 1", StatementType: "SELECT",
+		BytesProcessed: 100, BytesBilled: 10 << 20, SlotMillis: 7, Seconds: 1.5}
+	if *got != *want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPromoteQuery(t *testing.T) {
+	got := promoteQuery([]promotion{
+		{src: "`p.s.a`", dst: "`p.d.a`", columns: []string{"`x`", "`y`"}},
+		{src: "`p.s.b`", dst: "`p.d.b`", columns: []string{"`z`"}},
+	})
+	want := "BEGIN TRANSACTION;\n" +
+		"DELETE FROM `p.d.a` WHERE TRUE;\n" +
+		"INSERT INTO `p.d.a` (`x`, `y`) SELECT `x`, `y` FROM `p.s.a`;\n" +
+		"DELETE FROM `p.d.b` WHERE TRUE;\n" +
+		"INSERT INTO `p.d.b` (`z`) SELECT `z` FROM `p.s.b`;\n" +
+		"COMMIT TRANSACTION;\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUpsertQuery(t *testing.T) {
+	schema := bq.Schema{{Name: "k"}, {Name: "v"}}
+	got := upsertQuery("`t`", schema, []string{"k"})
+	for _, want := range []string{
+		"MERGE `t` AS t\n\t\tUSING UNNEST(@rows) AS s",
+		"ON t.`k` IS NOT DISTINCT FROM s.`k`\n",
+		"UPDATE SET `k` = s.`k`, `v` = s.`v`",
+		"INSERT (`k`, `v`) VALUES (s.`k`, s.`v`)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// ExportURI returns the gs:// URI pattern of the Parquet files of the rows
+// of the table with tableID created on day, under the URI prefix. The
+// directories form a date-partitioned dataset, as DuckDB and Spark read
+// them:
+//
+//	<prefix>/<tableID>/date=YYYY-MM-DD/*.parquet
+func ExportURI(prefix, tableID string, day civil.Date) string {
+	return fmt.Sprintf("%s/%s/date=%s/*.parquet", strings.TrimSuffix(prefix, "/"), tableID, day)
+}
+
+// ExportDay writes the rows of the table with tableID that were created on
+// day, in UTC, to Parquet files at ExportURI(prefix, tableID, day). It
+// replaces the files of earlier exports of the day, so it can be retried.
+func (c *Client) ExportDay(ctx context.Context, tableID, prefix string, day civil.Date) (err error) {
+	defer derrors.Wrap(&err, "ExportDay(%q, %q, %s)", tableID, prefix, day)
+	if !strings.HasPrefix(prefix, "gs://") {
+		return fmt.Errorf("export prefix %q is not a gs:// URI", prefix)
+	}
+	return c.Exec(ctx, exportQuery("`"+c.FullTableName(tableID)+"`", ExportURI(prefix, tableID, day), day))
+}
+
+// exportQuery returns the statement that exports the rows of table
+// created on day to the files matching uri.
+func exportQuery(table, uri string, day civil.Date) string {
+	const qf = `
+		EXPORT DATA OPTIONS (uri = "%s", format = "PARQUET", compression = "SNAPPY", overwrite = true) AS
+		SELECT * FROM %s
+		WHERE created_at >= TIMESTAMP("%s") AND created_at < TIMESTAMP("%s")
+	`
+	start := day.In(time.UTC)
+	return fmt.Sprintf(qf, uri, table, start.Format(time.RFC3339), start.AddDate(0, 0, 1).Format(time.RFC3339))
+}
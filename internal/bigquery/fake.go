@@ -0,0 +1,338 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+)
+
+// A Fake is an in-memory stand-in for BigQuery, for tests that must run
+// without a Google Cloud project. Clients backed by a Fake create tables
+// and upload rows to it, and read rows with Query, ForEach and Exec.
+//
+// A Fake can't run SQL. Tests answer queries with the functions they
+// register with HandleQuery, typically by filtering the rows of Rows.
+// Client.Query, Client.Dataset and Client.Table are not supported.
+type Fake struct {
+	mu       sync.Mutex
+	tables   map[string][]any // uploaded rows, by table ID
+	handlers []fakeHandler
+}
+
+type fakeHandler struct {
+	match string
+	f     FakeQueryFunc
+}
+
+// A FakeQueryFunc answers a query for a Fake. The rows it returns are a
+// slice of structs, pointers to structs, or map[string]bq.Value values.
+// They are read into the row type of the reader by column name, as
+// BigQuery would, so they need not have that type.
+type FakeQueryFunc func(query string, params []bq.QueryParameter) (rows any, err error)
+
+// NewFake returns an empty Fake.
+func NewFake() *Fake {
+	return &Fake{tables: map[string][]any{}}
+}
+
+// Client returns a client for the dataset of f.
+func (f *Fake) Client() *Client {
+	return &Client{fake: f, now: time.Now}
+}
+
+// HandleQuery makes f answer the queries and statements that contain
+// match with qf. Handlers are tried in the order they were added.
+func (f *Fake) HandleQuery(match string, qf FakeQueryFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = append(f.handlers, fakeHandler{match, qf})
+}
+
+// Rows returns the rows uploaded to the table with tableID, in upload
+// order.
+func (f *Fake) Rows(tableID string) []any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]any(nil), f.tables[tableID]...)
+}
+
+// createTable creates the table with tableID unless it exists, and reports
+// whether it did.
+func (f *Fake) createTable(tableID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.tables[tableID]; ok {
+		return false
+	}
+	f.tables[tableID] = []any{}
+	return true
+}
+
+func (f *Fake) upload(tableID string, rows []any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.tables[tableID]; !ok {
+		return fmt.Errorf("fake: table %q not found", tableID)
+	}
+	f.tables[tableID] = append(f.tables[tableID], rows...)
+	return nil
+}
+
+// upsert replaces the rows of the table with tableID that have the key of
+// one of rows with it, and appends the others.
+func (f *Fake) upsert(tableID string, key []string, rows []any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, ok := f.tables[tableID]
+	if !ok {
+		return fmt.Errorf("fake: table %q not found", tableID)
+	}
+	keyOf := func(row any) ([]bq.Value, error) {
+		values, err := saveFakeRow(row)
+		if err != nil {
+			return nil, fmt.Errorf("fake: %w", err)
+		}
+		var k []bq.Value
+		for _, col := range key {
+			v, _ := lookupColumn(values, col)
+			if v, ok := unwrapNull(v); ok {
+				k = append(k, v)
+			} else {
+				k = append(k, nil)
+			}
+		}
+		return k, nil
+	}
+	for _, r := range rows {
+		k, err := keyOf(r)
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i, o := range old {
+			ko, err := keyOf(o)
+			if err != nil {
+				return err
+			}
+			if reflect.DeepEqual(k, ko) {
+				old[i] = r
+				replaced = true
+			}
+		}
+		if !replaced {
+			old = append(old, r)
+		}
+	}
+	f.tables[tableID] = old
+	return nil
+}
+
+// query returns the rows of the handler for q.
+func (f *Fake) query(q string, params []bq.QueryParameter) ([]any, error) {
+	f.mu.Lock()
+	var qf FakeQueryFunc
+	for _, h := range f.handlers {
+		if strings.Contains(q, h.match) {
+			qf = h.f
+			break
+		}
+	}
+	f.mu.Unlock()
+	if qf == nil {
+		return nil, fmt.Errorf("fake: no handler for query %q", q)
+	}
+	rows, err := qf(q, params)
+	if err != nil || rows == nil {
+		return nil, err
+	}
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("fake: handler returned %T, want a slice", rows)
+	}
+	var out []any
+	for i := 0; i < rv.Len(); i++ {
+		out = append(out, rv.Index(i).Interface())
+	}
+	return out, nil
+}
+
+// fakeForEach is ForEach for a client backed by f.
+func fakeForEach[T any](f *Fake, q string, params []bq.QueryParameter, fn func(*T) bool) error {
+	rows, err := f.query(q, params)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		t, err := loadFakeRow[T](r)
+		if err != nil {
+			return err
+		}
+		if !fn(t) {
+			break
+		}
+	}
+	return nil
+}
+
+// loadFakeRow returns row as a *T. Rows of other types are read into a T
+// by column name.
+func loadFakeRow[T any](row any) (*T, error) {
+	switch r := row.(type) {
+	case *T:
+		return r, nil
+	case T:
+		return &r, nil
+	}
+	values, ok := row.(map[string]bq.Value)
+	if !ok {
+		var err error
+		values, err = saveFakeRow(row)
+		if err != nil {
+			return nil, fmt.Errorf("fake: %w", err)
+		}
+	}
+	var t T
+	v := reflect.ValueOf(&t).Elem()
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("fake: can't read a row into a %T", t)
+	}
+	if err := loadStruct(v, values); err != nil {
+		return nil, fmt.Errorf("fake: %w", err)
+	}
+	return &t, nil
+}
+
+// saveFakeRow returns the values of the columns of row, a struct or a
+// pointer to one. A StructSaver without a schema saves no columns, so
+// the schema is inferred from row.
+func saveFakeRow(row any) (map[string]bq.Value, error) {
+	schema, err := InferSchema(row)
+	if err != nil {
+		return nil, err
+	}
+	values, _, err := (&bq.StructSaver{Struct: row, Schema: schema}).Save()
+	return values, err
+}
+
+// loadStruct sets the fields of dst, a struct, to the values of their
+// columns. Fields of embedded structs are columns of dst, as in
+// InferSchema.
+func loadStruct(dst reflect.Value, values map[string]bq.Value) error {
+	for i := 0; i < dst.NumField(); i++ {
+		sf := dst.Type().Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("bigquery"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			if err := loadStruct(dst.Field(i), values); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		v, ok := lookupColumn(values, name)
+		if !ok {
+			continue
+		}
+		if err := setValue(dst.Field(i), v); err != nil {
+			return fmt.Errorf("column %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// lookupColumn returns the value of the column name. Like BigQuery, it
+// ignores the case of column names.
+func lookupColumn(values map[string]bq.Value, name string) (bq.Value, bool) {
+	if v, ok := values[name]; ok {
+		return v, true
+	}
+	for k, v := range values {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// nullValueFields are the names of the value fields of the bq.Null types.
+var nullValueFields = map[reflect.Type]string{
+	reflect.TypeOf(bq.NullString{}):    "StringVal",
+	reflect.TypeOf(bq.NullInt64{}):     "Int64",
+	reflect.TypeOf(bq.NullFloat64{}):   "Float64",
+	reflect.TypeOf(bq.NullBool{}):      "Bool",
+	reflect.TypeOf(bq.NullTimestamp{}): "Timestamp",
+	reflect.TypeOf(bq.NullDate{}):      "Date",
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	civilDateType = reflect.TypeOf(civil.Date{})
+)
+
+// setValue sets dst to v, a value of a column. Null values leave dst
+// unchanged.
+func setValue(dst reflect.Value, v bq.Value) error {
+	v, ok := unwrapNull(v)
+	if !ok {
+		return nil
+	}
+	t := dst.Type()
+	if name, ok := nullValueFields[t]; ok {
+		if err := setValue(dst.FieldByName(name), v); err != nil {
+			return err
+		}
+		dst.FieldByName("Valid").SetBool(true)
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case t.Kind() == reflect.Pointer:
+		p := reflect.New(t.Elem())
+		if err := setValue(p.Elem(), v); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	case t.Kind() == reflect.Struct && t != timeType && t != civilDateType:
+		m, ok := v.(map[string]bq.Value)
+		if !ok {
+			break
+		}
+		return loadStruct(dst, m)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+		if rv.Kind() != reflect.Slice {
+			break
+		}
+		s := reflect.MakeSlice(t, rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := setValue(s.Index(i), rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+		return nil
+	}
+	// Numbers convert to strings as runes, which no column means.
+	if !rv.Type().ConvertibleTo(t) || (t.Kind() == reflect.String) != (rv.Kind() == reflect.String) {
+		return errors.New("can't set a " + t.String() + " to a " + rv.Type().String())
+	}
+	dst.Set(rv.Convert(t))
+	return nil
+}
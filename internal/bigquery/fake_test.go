@@ -0,0 +1,112 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+type fakeRow struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	Module    string    `bigquery:"module_path"`
+	Count     int       `bigquery:"count"`
+	Note      bq.NullString
+	Tags      []string `bigquery:"tags"`
+}
+
+func (r *fakeRow) SetUploadTime(t time.Time) { r.CreatedAt = t }
+
+func TestFake(t *testing.T) {
+	const tableID = "fake-test"
+	schema, err := InferSchema(fakeRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddTable(tableID, schema)
+
+	ctx := context.Background()
+	f := NewFake()
+	c := f.Client()
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	c.SetClock(func() time.Time { return now })
+
+	if err := c.Upload(ctx, tableID, &fakeRow{Module: "a"}); err == nil {
+		t.Error("uploading to a missing table: got nil, want error")
+	}
+	for i, want := range []bool{true, false} {
+		created, err := c.CreateOrUpdateTable(ctx, tableID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != want {
+			t.Errorf("call %d: got created %t, want %t", i, created, want)
+		}
+	}
+	if err := c.Upload(ctx, tableID, &fakeRow{Module: "a", Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	rows := []*fakeRow{{Module: "b", Count: 2, Note: NullString("n"), Tags: []string{"x"}}, {Module: "c"}}
+	if err := UploadMany(ctx, c, tableID, rows, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(f.Rows(tableID)); got != 3 {
+		t.Fatalf("got %d rows, want 3", got)
+	}
+	if got := f.Rows(tableID)[1].(*fakeRow).CreatedAt; !got.Equal(now) {
+		t.Errorf("got upload time %s, want %s", got, now)
+	}
+
+	// Queries are answered by handlers, which may return rows of another
+	// type than the reader's.
+	f.HandleQuery("WHERE module_path = @m", func(q string, params []bq.QueryParameter) (any, error) {
+		var out []any
+		for _, r := range f.Rows(tableID) {
+			if r.(*fakeRow).Module == params[0].Value {
+				out = append(out, r)
+			}
+		}
+		return out, nil
+	})
+	f.HandleQuery("MERGE", func(string, []bq.QueryParameter) (any, error) { return nil, nil })
+
+	type summary struct {
+		ModulePath string        `bigquery:"module_path"`
+		Count      bq.NullInt64  `bigquery:"count"`
+		Note       string        `bigquery:"note"`
+		Tags       []string      `bigquery:"tags"`
+		Missing    bq.NullString `bigquery:"missing"`
+	}
+	got, err := Query[summary](ctx, c, "SELECT * FROM t WHERE module_path = @m", Param("m", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d rows, want 1", len(got))
+	}
+	s := got[0]
+	if s.ModulePath != "b" || s.Count != NullInt(2) || s.Note != "n" || len(s.Tags) != 1 || s.Missing.Valid {
+		t.Errorf("got %+v", s)
+	}
+
+	n := 0
+	err = ForEach(ctx, c, "SELECT * FROM t WHERE module_path = @m", []bq.QueryParameter{Param("m", "a")}, func(r *fakeRow) bool {
+		n++
+		return true
+	})
+	if err != nil || n != 1 {
+		t.Errorf("ForEach: got %d rows, %v; want 1, nil", n, err)
+	}
+	if err := c.Exec(ctx, "MERGE t USING s"); err != nil {
+		t.Error(err)
+	}
+	if _, err := Query[summary](ctx, c, "SELECT 1"); err == nil || !strings.Contains(err.Error(), "no handler") {
+		t.Errorf("unhandled query: got %v, want no handler error", err)
+	}
+}
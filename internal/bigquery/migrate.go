@@ -0,0 +1,91 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"fmt"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+// MigrateSchema returns the schema that a table with schema live must be
+// updated to in order to hold rows with schema want, and describes each
+// change it makes.
+//
+// Only changes that BigQuery can make to an existing table are allowed:
+//   - adding a nullable or repeated column, including one nested in a
+//     record;
+//   - dropping a column, which stays in the table but is relaxed to
+//     nullable, since new rows don't have it;
+//   - changing a column from required to nullable.
+//
+// The columns of live keep their order, and added columns follow them.
+// MigrateSchema returns an error listing every other change, like
+// changing the type of a column or adding a required one.
+func MigrateSchema(live, want bq.Schema) (_ bq.Schema, changes []string, err error) {
+	var illegal []string
+	s := migrateFields("", live, want, &changes, &illegal)
+	if len(illegal) > 0 {
+		return nil, nil, fmt.Errorf("illegal schema changes: %s", strings.Join(illegal, "; "))
+	}
+	return s, changes, nil
+}
+
+// migrateFields migrates the fields of a record, whose column names begin
+// with prefix.
+func migrateFields(prefix string, live, want bq.Schema, changes, illegal *[]string) bq.Schema {
+	wantByName := map[string]*bq.FieldSchema{}
+	for _, f := range want {
+		wantByName[f.Name] = f
+	}
+	var (
+		out  bq.Schema
+		kept = map[string]bool{}
+	)
+	for _, l := range live {
+		name := prefix + l.Name
+		f := *l
+		kept[l.Name] = true
+		w, ok := wantByName[l.Name]
+		if !ok {
+			if f.Required {
+				f.Required = false
+				*changes = append(*changes, fmt.Sprintf("relax dropped column %s to nullable", name))
+			}
+			out = append(out, &f)
+			continue
+		}
+		switch {
+		case w.Type != l.Type:
+			*illegal = append(*illegal, fmt.Sprintf("column %s changes type from %s to %s", name, l.Type, w.Type))
+		case w.Repeated != l.Repeated:
+			*illegal = append(*illegal, fmt.Sprintf("column %s changes whether it is repeated", name))
+		case w.Required && !l.Required:
+			*illegal = append(*illegal, fmt.Sprintf("column %s becomes required", name))
+		}
+		if l.Required && !w.Required {
+			f.Required = false
+			*changes = append(*changes, fmt.Sprintf("relax column %s to nullable", name))
+		}
+		if l.Type == bq.RecordFieldType && w.Type == bq.RecordFieldType {
+			f.Schema = migrateFields(name+".", l.Schema, w.Schema, changes, illegal)
+		}
+		out = append(out, &f)
+	}
+	for _, w := range want {
+		if kept[w.Name] {
+			continue
+		}
+		name := prefix + w.Name
+		if w.Required {
+			*illegal = append(*illegal, fmt.Sprintf("added column %s is required", name))
+			continue
+		}
+		out = append(out, w)
+		*changes = append(*changes, fmt.Sprintf("add column %s", name))
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"strings"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestMigrateSchema(t *testing.T) {
+	str := func(name string, required bool) *bq.FieldSchema {
+		return &bq.FieldSchema{Name: name, Type: bq.StringFieldType, Required: required}
+	}
+	record := func(name string, fields ...*bq.FieldSchema) *bq.FieldSchema {
+		return &bq.FieldSchema{Name: name, Type: bq.RecordFieldType, Repeated: true, Schema: fields}
+	}
+	live := bq.Schema{str("a", true), str("b", true), record("r", str("x", true))}
+
+	t.Run("legal", func(t *testing.T) {
+		want := bq.Schema{
+			str("new", false), // added in the middle
+			str("a", false),   // relaxed
+			record("r", str("x", true), str("y", false)),
+			// b is dropped
+		}
+		got, changes, err := MigrateSchema(live, want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotStr := SchemaString(got)
+		wantStr := SchemaString(bq.Schema{str("a", false), str("b", false), record("r", str("x", true), str("y", false)), str("new", false)})
+		if gotStr != wantStr {
+			t.Errorf("got schema\n%s\nwant\n%s", gotStr, wantStr)
+		}
+		// Live columns keep their order.
+		var names []string
+		for _, f := range got {
+			names = append(names, f.Name)
+		}
+		if g, w := strings.Join(names, ","), "a,b,r,new"; g != w {
+			t.Errorf("got columns %s, want %s", g, w)
+		}
+		wantChanges := []string{
+			"relax column a to nullable",
+			"relax dropped column b to nullable",
+			"add column r.y",
+			"add column new",
+		}
+		if g, w := strings.Join(changes, "\n"), strings.Join(wantChanges, "\n"); g != w {
+			t.Errorf("got changes\n%s\nwant\n%s", g, w)
+		}
+	})
+
+	t.Run("illegal", func(t *testing.T) {
+		want := bq.Schema{
+			{Name: "a", Type: bq.IntegerFieldType, Required: true},
+			str("b", true),
+			record("r", str("x", true), str("z", true)),
+			str("c", true),
+		}
+		_, _, err := MigrateSchema(live, want)
+		if err == nil {
+			t.Fatal("got nil, want error")
+		}
+		for _, s := range []string{"column a changes type", "added column r.z is required", "added column c is required"} {
+			if !strings.Contains(err.Error(), s) {
+				t.Errorf("error %q does not mention %q", err, s)
+			}
+		}
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		got, changes, err := MigrateSchema(live, live)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 0 || SchemaVersion(got) != SchemaVersion(live) {
+			t.Errorf("got changes %v, want none", changes)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// Promote replaces the rows of the tables with tableIDs in the dataset of
+// c with those of the tables of the same names in the staging dataset, of
+// the same project. It is used to publish tables that were computed and
+// validated in staging, such as after a schema or worker version bump.
+//
+// The staging tables must have their registered schemas. The tables of c
+// are created or migrated to them first. All rows are then replaced in one
+// transaction, so either all of the tables are promoted or none are.
+func (c *Client) Promote(ctx context.Context, staging string, tableIDs []string) (err error) {
+	defer derrors.Wrap(&err, "Promote(%q, %v)", staging, tableIDs)
+	if c.fake != nil {
+		return errors.New("Promote is not supported by fake clients")
+	}
+	if staging == "" || staging == c.dataset.DatasetID {
+		return fmt.Errorf("%w: can't promote dataset %q to %q", derrors.InvalidArgument, staging, c.dataset.DatasetID)
+	}
+	if len(tableIDs) == 0 {
+		return fmt.Errorf("%w: no tables to promote", derrors.InvalidArgument)
+	}
+	src := c.client.DatasetInProject(c.dataset.ProjectID, staging)
+	var ps []promotion
+	for _, t := range tableIDs {
+		schema := TableSchema(t)
+		if schema == nil {
+			return fmt.Errorf("no schema registered for table %q", t)
+		}
+		meta, err := src.Table(t).Metadata(ctx)
+		if err != nil {
+			return err
+		}
+		if SchemaVersion(meta.Schema) != SchemaVersion(schema) {
+			return fmt.Errorf("staging table %s.%s does not have the registered schema", staging, t)
+		}
+		if _, err := c.CreateOrUpdateTable(ctx, t); err != nil {
+			return err
+		}
+		p := promotion{
+			src: fmt.Sprintf("`%s.%s.%s`", c.dataset.ProjectID, staging, t),
+			dst: "`" + c.FullTableName(t) + "`",
+		}
+		for _, f := range schema {
+			p.columns = append(p.columns, "`"+f.Name+"`")
+		}
+		ps = append(ps, p)
+	}
+	return c.Exec(ctx, promoteQuery(ps))
+}
+
+// A promotion is the copy of the rows of one table by Promote.
+type promotion struct {
+	src, dst string
+	// columns are the registered columns, which may be fewer than those of
+	// dst; see MigrateSchema.
+	columns []string
+}
+
+// promoteQuery returns the script that replaces the rows of the dst table
+// of each promotion with those of its src table, in a transaction.
+func promoteQuery(ps []promotion) string {
+	var b strings.Builder
+	b.WriteString("BEGIN TRANSACTION;\n")
+	for _, p := range ps {
+		cols := strings.Join(p.columns, ", ")
+		fmt.Fprintf(&b, "DELETE FROM %s WHERE TRUE;\n", p.dst)
+		fmt.Fprintf(&b, "INSERT INTO %s (%s) SELECT %s FROM %s;\n", p.dst, cols, cols, p.src)
+	}
+	b.WriteString("COMMIT TRANSACTION;\n")
+	return b.String()
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"cloud.google.com/go/civil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// A rowEncoder encodes rows of a table as the protocol buffer messages
+// that the Storage Write API appends.
+type rowEncoder struct {
+	schema     bq.Schema
+	descriptor protoreflect.MessageDescriptor
+	// normalized is the self-contained form of descriptor that is sent
+	// to the API.
+	normalized *descriptorpb.DescriptorProto
+}
+
+// newRowEncoder returns an encoder for rows with schema. Every column is
+// optional in the messages, so the encoder also serves tables that
+// relaxed columns, and tables that still have dropped ones.
+func newRowEncoder(schema bq.Schema) (*rowEncoder, error) {
+	ss, err := adapt.BQSchemaToStorageTableSchema(relaxSchema(schema))
+	if err != nil {
+		return nil, err
+	}
+	d, err := adapt.StorageSchemaToProto2Descriptor(ss, "root")
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("got descriptor %T, want a message descriptor", d)
+	}
+	n, err := adapt.NormalizeDescriptor(md)
+	if err != nil {
+		return nil, err
+	}
+	return &rowEncoder{schema: schema, descriptor: md, normalized: n}, nil
+}
+
+// relaxSchema returns a copy of schema in which no column is required.
+func relaxSchema(schema bq.Schema) bq.Schema {
+	var out bq.Schema
+	for _, f := range schema {
+		g := *f
+		g.Required = false
+		if g.Type == bq.RecordFieldType {
+			g.Schema = relaxSchema(f.Schema)
+		}
+		out = append(out, &g)
+	}
+	return out
+}
+
+// encode returns the serialized message for row, a struct or pointer to
+// one that bq.StructSaver can save.
+func (e *rowEncoder) encode(row any) ([]byte, error) {
+	values, _, err := (&bq.StructSaver{Struct: row, Schema: e.schema}).Save()
+	if err != nil {
+		return nil, err
+	}
+	m := dynamicpb.NewMessage(e.descriptor)
+	if err := setFields(m, e.schema, values); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+// setFields sets the fields of m to values, the values of a record with
+// schema as saved by bq.StructSaver.
+func setFields(m *dynamicpb.Message, schema bq.Schema, values map[string]bq.Value) error {
+	fields := m.Descriptor().Fields()
+	for _, f := range schema {
+		v, ok := values[f.Name]
+		if !ok || v == nil {
+			continue
+		}
+		fd := fields.ByName(protoreflect.Name(strings.ToLower(f.Name)))
+		if fd == nil {
+			return fmt.Errorf("column %s has no field in the row message", f.Name)
+		}
+		if !f.Repeated {
+			pv, ok, err := fieldValue(fd, f, v)
+			if err != nil {
+				return err
+			}
+			if ok {
+				m.Set(fd, pv)
+			}
+			continue
+		}
+		list := m.Mutable(fd).List()
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fmt.Errorf("column %s: got %T, want a slice", f.Name, v)
+		}
+		for i := 0; i < rv.Len(); i++ {
+			pv, ok, err := fieldValue(fd, f, rv.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+			if ok {
+				list.Append(pv)
+			}
+		}
+	}
+	return nil
+}
+
+// fieldValue returns the value of the field fd for v, a value of the
+// column f. It returns false if v is null.
+func fieldValue(fd protoreflect.FieldDescriptor, f *bq.FieldSchema, v any) (_ protoreflect.Value, ok bool, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("column %s: %w", f.Name, err)
+		}
+	}()
+	if f.Type == bq.RecordFieldType {
+		rec, ok := v.(map[string]bq.Value)
+		if !ok {
+			return protoreflect.Value{}, false, fmt.Errorf("got %T, want a record", v)
+		}
+		nested := dynamicpb.NewMessage(fd.Message())
+		if err := setFields(nested, f.Schema, rec); err != nil {
+			return protoreflect.Value{}, false, err
+		}
+		return protoreflect.ValueOfMessage(nested), true, nil
+	}
+	v, ok = unwrapNull(v)
+	if !ok {
+		return protoreflect.Value{}, false, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch f.Type {
+	case bq.StringFieldType:
+		if rv.Kind() == reflect.String {
+			return protoreflect.ValueOfString(rv.String()), true, nil
+		}
+	case bq.BytesFieldType:
+		if b, ok := v.([]byte); ok {
+			return protoreflect.ValueOfBytes(b), true, nil
+		}
+	case bq.IntegerFieldType:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return protoreflect.ValueOfInt64(rv.Int()), true, nil
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			return protoreflect.ValueOfInt64(int64(rv.Uint())), true, nil
+		}
+	case bq.FloatFieldType:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return protoreflect.ValueOfFloat64(rv.Float()), true, nil
+		}
+	case bq.BooleanFieldType:
+		if rv.Kind() == reflect.Bool {
+			return protoreflect.ValueOfBool(rv.Bool()), true, nil
+		}
+	case bq.TimestampFieldType:
+		if t, ok := v.(time.Time); ok {
+			return protoreflect.ValueOfInt64(t.UnixMicro()), true, nil
+		}
+	case bq.DateFieldType:
+		if d, ok := v.(civil.Date); ok {
+			return protoreflect.ValueOfInt32(int32(d.DaysSince(civil.Date{Year: 1970, Month: time.January, Day: 1}))), true, nil
+		}
+	default:
+		return protoreflect.Value{}, false, fmt.Errorf("unsupported type %s", f.Type)
+	}
+	return protoreflect.Value{}, false, fmt.Errorf("got %T for a %s", v, f.Type)
+}
+
+// unwrapNull returns the value of v if it is one of the bq.Null types,
+// and false if that is null. Other values are returned as they are.
+func unwrapNull(v any) (any, bool) {
+	switch n := v.(type) {
+	case bq.NullString:
+		return n.StringVal, n.Valid
+	case bq.NullInt64:
+		return n.Int64, n.Valid
+	case bq.NullFloat64:
+		return n.Float64, n.Valid
+	case bq.NullBool:
+		return n.Bool, n.Valid
+	case bq.NullTimestamp:
+		return n.Timestamp, n.Valid
+	case bq.NullDate:
+		return n.Date, n.Valid
+	case nil:
+		return nil, false
+	}
+	return v, true
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestRowEncoder(t *testing.T) {
+	type nested struct {
+		Name  string
+		Count int
+	}
+	type row struct {
+		Name    string
+		Missing bq.NullString
+		Flag    bq.NullBool
+		Created time.Time
+		Day     civil.Date
+		Nested  nested
+		List    []nested
+		Tags    []string
+	}
+	schema, err := bq.InferSchema(row{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := newRowEncoder(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2023, 10, 1, 12, 0, 0, 1000, time.UTC)
+	b, err := enc.encode(&row{
+		Name:    "m",
+		Flag:    NullBool(false),
+		Created: created,
+		Day:     civil.Date{Year: 1970, Month: time.January, Day: 3},
+		Nested:  nested{"a", 1},
+		List:    []nested{{"b", 2}, {"c", 3}},
+		Tags:    []string{"x", "y"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := dynamicpb.NewMessage(enc.descriptor)
+	if err := proto.Unmarshal(b, m); err != nil {
+		t.Fatal(err)
+	}
+	field := func(m protoreflect.Message, name string) protoreflect.FieldDescriptor {
+		t.Helper()
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			t.Fatalf("no field %s", name)
+		}
+		return fd
+	}
+	get := func(m protoreflect.Message, name string) protoreflect.Value {
+		return m.Get(field(m, name))
+	}
+
+	if got := get(m, "name").String(); got != "m" {
+		t.Errorf("name: got %q, want m", got)
+	}
+	if m.Has(field(m, "missing")) {
+		t.Error("missing: got a value for a null column")
+	}
+	if !m.Has(field(m, "flag")) || get(m, "flag").Bool() {
+		t.Error("flag: want false, set")
+	}
+	if got, want := get(m, "created").Int(), created.UnixMicro(); got != want {
+		t.Errorf("created: got %d, want %d", got, want)
+	}
+	if got := get(m, "day").Int(); got != 2 {
+		t.Errorf("day: got %d, want 2", got)
+	}
+	n := get(m, "nested").Message()
+	if got := get(n, "name").String(); got != "a" {
+		t.Errorf("nested.name: got %q, want a", got)
+	}
+	list := get(m, "list").List()
+	if list.Len() != 2 || get(list.Get(1).Message(), "count").Int() != 3 {
+		t.Errorf("list: got %d elements, want 2 ending with count 3", list.Len())
+	}
+	tags := get(m, "tags").List()
+	if tags.Len() != 2 || tags.Get(0).String() != "x" {
+		t.Errorf("tags: got %d elements, want [x y]", tags.Len())
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// QueryCostTableName is the table recording the cost of the queries and
+// statements run by clients; see Client.RecordQueryCosts.
+const QueryCostTableName = "query_costs"
+
+// A QueryCost is the cost of a query or statement run by a client.
+type QueryCost struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	JobID     string    `bigquery:"job_id"`
+	Query     string    `bigquery:"query"`
+	// StatementType is the kind of statement, like "SELECT" or "MERGE".
+	StatementType  string `bigquery:"statement_type"`
+	BytesProcessed int64  `bigquery:"bytes_processed"`
+	BytesBilled    int64  `bigquery:"bytes_billed"`
+	CacheHit       bool   `bigquery:"cache_hit"`
+	SlotMillis     int64  `bigquery:"slot_millis"`
+	// Seconds is the time the job ran for.
+	Seconds float64 `bigquery:"seconds"`
+}
+
+// SetUploadTime is used by Client.Upload.
+func (c *QueryCost) SetUploadTime(t time.Time) { c.CreatedAt = t }
+
+func init() {
+	s, err := InferSchema(QueryCost{})
+	if err != nil {
+		panic(err)
+	}
+	AddTable(QueryCostTableName, s)
+}
+
+// newQueryCost returns the cost of the job with jobID, which ran q, from
+// its statistics.
+func newQueryCost(q, jobID string, stats *bq.JobStatistics) *QueryCost {
+	c := &QueryCost{
+		JobID:          jobID,
+		Query:          q,
+		BytesProcessed: stats.TotalBytesProcessed,
+	}
+	if !stats.StartTime.IsZero() && !stats.EndTime.IsZero() {
+		c.Seconds = stats.EndTime.Sub(stats.StartTime).Seconds()
+	}
+	if qs, ok := stats.Details.(*bq.QueryStatistics); ok {
+		c.StatementType = qs.StatementType
+		c.BytesBilled = qs.TotalBytesBilled
+		c.CacheHit = qs.CacheHit
+		c.SlotMillis = qs.SlotMillis
+	}
+	return c
+}
+
+// RecordQueryCosts creates QueryCostTableName if it doesn't exist, then
+// makes c record the cost of each query and statement it runs to it, for
+// Query, ForEach and the Query and Exec methods.
+func (c *Client) RecordQueryCosts(ctx context.Context) error {
+	if _, err := c.CreateOrUpdateTable(ctx, QueryCostTableName); err != nil {
+		return err
+	}
+	c.recordCosts = true
+	return nil
+}
+
+// recordCost records the cost of job, which ran q and finished with
+// status, if c records query costs. The cost is uploaded in the
+// background, so the caller doesn't wait for it; Close waits for the
+// uploads to finish.
+func (c *Client) recordCost(ctx context.Context, q string, job *bq.Job, status *bq.JobStatus) {
+	if !c.recordCosts || status.Statistics == nil {
+		return
+	}
+	cost := newQueryCost(q, job.ID(), status.Statistics)
+	// The upload must not be canceled along with the query's context.
+	ctx, cancel := context.WithTimeout(log.NewContext(context.Background(), log.FromContext(ctx)), appendTimeout)
+	c.costs.Add(1)
+	go func() {
+		defer c.costs.Done()
+		defer cancel()
+		if err := c.Upload(ctx, QueryCostTableName, cost); err != nil {
+			log.Warnf(ctx, "recording the cost of job %s: %v", cost.JobID, err)
+		}
+	}()
+}
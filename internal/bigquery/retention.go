@@ -0,0 +1,152 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// RetentionTableName is the table recording when retention was last
+// enforced for each table.
+const RetentionTableName = "retention"
+
+// Methods of enforcing retention.
+const (
+	// RetentionExpiration drops the partitions of a table older than the
+	// retention period, which BigQuery does continuously.
+	RetentionExpiration = "expiration"
+	// RetentionDelete deletes the rows of a table older than the retention
+	// period, at most once every retentionInterval.
+	RetentionDelete = "delete"
+)
+
+// retentionInterval is the time between two enforcements of the retention
+// of a table.
+const retentionInterval = 24 * time.Hour
+
+// A RetentionRun records an enforcement of the retention of a table.
+type RetentionRun struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	TableID   string    `bigquery:"table_id"`
+	Days      int       `bigquery:"days"`
+	Method    string    `bigquery:"method"`
+	// Cutoff is the creation time before which rows are dropped.
+	Cutoff time.Time `bigquery:"cutoff"`
+}
+
+// SetUploadTime is used by Client.Upload.
+func (r *RetentionRun) SetUploadTime(t time.Time) { r.CreatedAt = t }
+
+func init() {
+	s, err := InferSchema(RetentionRun{})
+	if err != nil {
+		panic(err)
+	}
+	AddTable(RetentionTableName, s)
+}
+
+// ParseRetention parses a comma-separated list of TABLE=DAYS entries, like
+// "govulncheck=365,analysis=90", into the number of days the rows of each
+// table are kept.
+func ParseRetention(spec string) (map[string]int, error) {
+	days := map[string]int{}
+	for _, e := range strings.Split(spec, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		tableID, d, ok := strings.Cut(e, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: retention %q is not TABLE=DAYS", derrors.InvalidArgument, e)
+		}
+		n, err := strconv.Atoi(d)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%w: retention of %s: %q is not a positive number of days", derrors.InvalidArgument, tableID, d)
+		}
+		days[tableID] = n
+	}
+	return days, nil
+}
+
+// SetRetention makes CreateOrUpdateTable keep the rows of each table in
+// days for that many days, by their created_at column. Partitioned tables
+// get a partition expiration; the old rows of other tables are deleted.
+//
+// Removing the retention of a table does not remove the expiration of its
+// partitions.
+func (c *Client) SetRetention(days map[string]int) {
+	c.retention = days
+}
+
+// retentionPeriod returns how long the rows of the table with tableID are
+// kept, or 0 if they are kept forever. The rows of RetentionTableName are
+// always kept.
+func (c *Client) retentionPeriod(tableID string) time.Duration {
+	if tableID == RetentionTableName {
+		return 0
+	}
+	return time.Duration(c.retention[tableID]) * 24 * time.Hour
+}
+
+// enforceRetention drops the rows of the table with tableID that are
+// older than period, unless that was done less than retentionInterval ago,
+// and records it in RetentionTableName.
+func (c *Client) enforceRetention(ctx context.Context, tableID string, opts TableOptions, period time.Duration) (err error) {
+	defer derrors.Wrap(&err, "enforceRetention(%q, %s)", tableID, period)
+
+	if _, err := c.CreateOrUpdateTable(ctx, RetentionTableName); err != nil {
+		return err
+	}
+	now := c.now()
+	last, err := c.lastRetentionRun(ctx, tableID)
+	if err != nil {
+		return err
+	}
+	if last != nil && now.Sub(last.CreatedAt) < retentionInterval {
+		return nil
+	}
+	run := &RetentionRun{
+		TableID: tableID,
+		Days:    int(period / (24 * time.Hour)),
+		Method:  RetentionExpiration,
+		Cutoff:  now.Add(-period),
+	}
+	if opts.PartitionField == "" {
+		run.Method = RetentionDelete
+		if err := c.Exec(ctx, retentionDeleteQuery("`"+c.FullTableName(tableID)+"`"), Param("cutoff", run.Cutoff)); err != nil {
+			return err
+		}
+	}
+	return c.Upload(ctx, RetentionTableName, run)
+}
+
+// lastRetentionRun returns the latest enforcement of the retention of the
+// table with tableID, or nil if there is none.
+func (c *Client) lastRetentionRun(ctx context.Context, tableID string) (*RetentionRun, error) {
+	const qf = `
+		SELECT * FROM %s
+		WHERE table_id = @table_id
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	q := fmt.Sprintf(qf, "`"+c.FullTableName(RetentionTableName)+"`")
+	runs, err := Query[RetentionRun](ctx, c, q, Param("table_id", tableID))
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return runs[0], nil
+}
+
+// retentionDeleteQuery returns the statement that deletes the rows of
+// table created before the @cutoff parameter.
+func retentionDeleteQuery(table string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE created_at < @cutoff", table)
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRetention(t *testing.T) {
+	got, err := ParseRetention(" govulncheck=365, analysis=90,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"govulncheck": 365, "analysis": 90}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	for _, spec := range []string{"govulncheck", "govulncheck=0", "govulncheck=1y"} {
+		if _, err := ParseRetention(spec); err == nil {
+			t.Errorf("ParseRetention(%q): got nil, want error", spec)
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	const tableID = "retention-test"
+	schema, err := InferSchema(fakeRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddTable(tableID, schema)
+
+	ctx := context.Background()
+	f := NewFake()
+	c := f.Client()
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	c.SetClock(func() time.Time { return now })
+	c.SetRetention(map[string]int{tableID: 30})
+
+	var deletes []time.Time
+	f.HandleQuery("DELETE FROM `fake."+tableID+"`", func(q string, params []bq.QueryParameter) (any, error) {
+		deletes = append(deletes, params[0].Value.(time.Time))
+		return nil, nil
+	})
+	f.HandleQuery("FROM `fake."+RetentionTableName+"`", func(q string, params []bq.QueryParameter) (any, error) {
+		var last []any
+		for _, r := range f.Rows(RetentionTableName) {
+			if r.(*RetentionRun).TableID == params[0].Value {
+				last = []any{r}
+			}
+		}
+		return last, nil
+	})
+
+	// Rows are deleted at most once a day.
+	for _, d := range []time.Duration{0, time.Hour, 25 * time.Hour} {
+		now = time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC).Add(d)
+		if _, err := c.CreateOrUpdateTable(ctx, tableID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(deletes) != 2 {
+		t.Fatalf("got %d deletes, want 2", len(deletes))
+	}
+	if want := now.AddDate(0, 0, -30); !deletes[1].Equal(want) {
+		t.Errorf("got cutoff %s, want %s", deletes[1], want)
+	}
+	runs := f.Rows(RetentionTableName)
+	if len(runs) != 2 {
+		t.Fatalf("got %d retention runs, want 2", len(runs))
+	}
+	if r := runs[1].(*RetentionRun); r.TableID != tableID || r.Days != 30 || r.Method != RetentionDelete || !r.CreatedAt.Equal(now) {
+		t.Errorf("got %+v", r)
+	}
+}
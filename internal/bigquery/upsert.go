@@ -0,0 +1,68 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// Upsert merges rows into the table with tableID, setting their upload
+// time. Each row replaces the rows of the table that have the same values
+// in the key columns, with NULLs equal to each other, and is inserted if
+// there are none. No two of the rows may have the same key.
+//
+// Unlike UploadMany, Upsert runs a MERGE statement, which counts towards
+// the limits on concurrent DML statements on the table, so it suits
+// tables that are written at low rates.
+func Upsert[T Row](ctx context.Context, c *Client, tableID string, key []string, rows []T) (err error) {
+	defer derrors.Wrap(&err, "Upsert(%q, %v), %d rows", tableID, key, len(rows))
+	if len(rows) == 0 {
+		return nil
+	}
+	schema := TableSchema(tableID)
+	if schema == nil {
+		return fmt.Errorf("no schema registered for table %q", tableID)
+	}
+	now := c.now()
+	vals := make([]any, len(rows))
+	for i, r := range rows {
+		r.SetUploadTime(now)
+		vals[i] = r
+	}
+	if c.fake != nil {
+		return c.fake.upsert(tableID, key, vals)
+	}
+	return c.Exec(ctx, upsertQuery("`"+c.FullTableName(tableID)+"`", schema, key), Param("rows", rows))
+}
+
+// upsertQuery returns the MERGE statement that merges the rows of the
+// @rows parameter into table, matching them on the key columns. It names
+// the columns of schema, so that the table may keep dropped ones.
+func upsertQuery(table string, schema bq.Schema, key []string) string {
+	const qf = `
+		MERGE %s AS t
+		USING UNNEST(@rows) AS s
+		ON %s
+		WHEN MATCHED THEN UPDATE SET %s
+		WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)
+	`
+	var conds, sets, cols, vals []string
+	for _, k := range key {
+		conds = append(conds, fmt.Sprintf("t.`%s` IS NOT DISTINCT FROM s.`%[1]s`", k))
+	}
+	for _, f := range schema {
+		col := "`" + f.Name + "`"
+		sets = append(sets, fmt.Sprintf("%s = s.%[1]s", col))
+		cols = append(cols, col)
+		vals = append(vals, "s."+col)
+	}
+	return fmt.Sprintf(qf, table, strings.Join(conds, " AND "),
+		strings.Join(sets, ", "), strings.Join(cols, ", "), strings.Join(vals, ", "))
+}
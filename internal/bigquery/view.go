@@ -0,0 +1,79 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+// A LatestView describes a view of a table that has, for each value of
+// its key columns, only the row with the latest created_at. The view of
+// table T is named T_latest; see LatestViewName.
+type LatestView struct {
+	// Key is the columns that identify what a row is about, like a
+	// module version and scan mode.
+	Key []string
+	// Filter, if non-nil, returns a condition on the rows of the table,
+	// with alias r, that rows must meet to be in the view. Its argument
+	// is the quoted full name of the table.
+	Filter func(table string) string
+}
+
+// LatestViewName returns the name of the latest view of the table with
+// tableID.
+func LatestViewName(tableID string) string {
+	return tableID + "_latest"
+}
+
+// query returns the query of the view of table, a quoted full table name.
+func (v *LatestView) query(table string) string {
+	cond := "TRUE"
+	if v.Filter != nil {
+		cond = v.Filter(table)
+	}
+	return fmt.Sprintf(`SELECT * FROM %s AS r WHERE %s
+QUALIFY ROW_NUMBER() OVER (PARTITION BY %s ORDER BY created_at DESC) = 1`,
+		table, cond, strings.Join(v.Key, ", "))
+}
+
+// viewDescription returns the description of a latest view of the table
+// with tableID and schema. It records the schema version, since a view
+// selecting * has the columns of the table when the view was last
+// written.
+func viewDescription(tableID string, schema bq.Schema) string {
+	return fmt.Sprintf("Latest rows of %s. Schema version %s.", tableID, SchemaVersion(schema))
+}
+
+// createOrUpdateLatestView creates the latest view v of the table with
+// tableID and schema, or rewrites it if its query or the schema version
+// changed.
+func (c *Client) createOrUpdateLatestView(ctx context.Context, tableID string, schema bq.Schema, v *LatestView) error {
+	view := c.Table(LatestViewName(tableID))
+	query := v.query("`" + c.FullTableName(tableID) + "`")
+	desc := viewDescription(tableID, schema)
+	meta, err := view.Metadata(ctx)
+	if err != nil {
+		if !isNotFoundError(err) {
+			return err
+		}
+		err = view.Create(ctx, &bq.TableMetadata{ViewQuery: query, Description: desc})
+		if isAlreadyExistsError(err) {
+			return nil
+		}
+		return err
+	}
+	if meta.ViewQuery == query && meta.Description == desc {
+		return nil
+	}
+	_, err = view.Update(ctx, bq.TableMetadataToUpdate{ViewQuery: query, Description: desc}, meta.ETag)
+	if isRaceChangeError(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,242 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+const (
+	// writeBatchRows is the number of buffered rows of a table that are
+	// appended without waiting for writeBatchDelay.
+	writeBatchRows = 500
+
+	// writeBatchDelay is how long rows of a table are buffered, waiting
+	// for others to be appended with them.
+	writeBatchDelay = time.Second
+
+	// maxAppendBytes bounds the size of the rows of one append request,
+	// below the 10MB limit of the API.
+	maxAppendBytes = 9 << 20
+
+	// appendTimeout bounds the time to append a batch. Batches are
+	// appended outside of the context of any one upload.
+	appendTimeout = 2 * time.Minute
+)
+
+// A writer appends rows to the tables of a dataset with the BigQuery
+// Storage Write API, through a committed stream per table. Unlike rows
+// inserted by streaming, appended rows can be queried, updated and
+// deleted as soon as the append succeeds.
+//
+// Rows are buffered per table, and a batch is appended once it has
+// writeBatchRows rows or its first row has waited writeBatchDelay.
+type writer struct {
+	client             *managedwriter.Client
+	projectID, dataset string
+
+	mu     sync.Mutex // guards tables, and the batches of each table
+	tables map[string]*tableWriter
+}
+
+// A tableWriter appends the rows of one table.
+type tableWriter struct {
+	w       *writer
+	tableID string
+	enc     *rowEncoder
+
+	// Guarded by w.mu.
+	rows    [][]byte
+	waiting []chan error // the uploads of rows
+	timer   *time.Timer  // non-nil while rows is not empty
+
+	appendMu sync.Mutex // serializes appends, keeping batches in order
+	// stream is nil until the first append, and after a failed one.
+	// Guarded by appendMu.
+	stream *managedwriter.ManagedStream
+}
+
+// A batch is rows to append, and the uploads waiting for them.
+type batch struct {
+	rows    [][]byte
+	waiting []chan error
+}
+
+func newWriter(ctx context.Context, projectID, datasetID string) (*writer, error) {
+	client, err := managedwriter.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{
+		client:    client,
+		projectID: projectID,
+		dataset:   datasetID,
+		tables:    map[string]*tableWriter{},
+	}, nil
+}
+
+// table returns the writer of the table with tableID.
+// w.mu must be held.
+func (w *writer) table(tableID string) (*tableWriter, error) {
+	if tw := w.tables[tableID]; tw != nil {
+		return tw, nil
+	}
+	schema := TableSchema(tableID)
+	if schema == nil {
+		return nil, fmt.Errorf("no schema registered for table %q", tableID)
+	}
+	enc, err := newRowEncoder(schema)
+	if err != nil {
+		return nil, err
+	}
+	tw := &tableWriter{w: w, tableID: tableID, enc: enc}
+	w.tables[tableID] = tw
+	return tw, nil
+}
+
+// upload appends rows, structs or pointers to them, to the table with
+// tableID. It returns when the batch with the rows has been appended, or
+// when ctx is done; in that case the rows may still be appended.
+func (w *writer) upload(ctx context.Context, tableID string, rows []any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	tw, err := w.table(tableID)
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	var data [][]byte
+	for _, r := range rows {
+		b, err := tw.enc.encode(r)
+		if err != nil {
+			return err
+		}
+		data = append(data, b)
+	}
+
+	done := make(chan error, 1)
+	w.mu.Lock()
+	tw.rows = append(tw.rows, data...)
+	tw.waiting = append(tw.waiting, done)
+	if len(tw.rows) >= writeBatchRows {
+		b := tw.take()
+		w.mu.Unlock()
+		tw.flush(b)
+	} else {
+		if tw.timer == nil {
+			tw.timer = time.AfterFunc(writeBatchDelay, func() {
+				w.mu.Lock()
+				b := tw.take()
+				w.mu.Unlock()
+				tw.flush(b)
+			})
+		}
+		w.mu.Unlock()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take removes the buffered rows of tw and returns them.
+// tw.w.mu must be held.
+func (tw *tableWriter) take() batch {
+	if tw.timer != nil {
+		tw.timer.Stop()
+		tw.timer = nil
+	}
+	b := batch{tw.rows, tw.waiting}
+	tw.rows = nil
+	tw.waiting = nil
+	return b
+}
+
+// flush appends the rows of b and reports the result to its uploads.
+func (tw *tableWriter) flush(b batch) {
+	if len(b.rows) == 0 {
+		return
+	}
+	tw.appendMu.Lock()
+	err := tw.append(b.rows)
+	tw.appendMu.Unlock()
+	for _, c := range b.waiting {
+		c <- err
+	}
+}
+
+// append appends rows to the stream of tw, in requests of at most
+// maxAppendBytes. tw.appendMu must be held.
+func (tw *tableWriter) append(rows [][]byte) (err error) {
+	defer derrors.Wrap(&err, "append(%q, %d rows)", tw.tableID, len(rows))
+	ctx, cancel := context.WithTimeout(context.Background(), appendTimeout)
+	defer cancel()
+
+	if tw.stream == nil {
+		tw.stream, err = tw.w.client.NewManagedStream(ctx,
+			managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(tw.w.projectID, tw.w.dataset, tw.tableID)),
+			managedwriter.WithType(managedwriter.CommittedStream),
+			managedwriter.WithSchemaDescriptor(tw.enc.normalized))
+		if err != nil {
+			return err
+		}
+	}
+	for len(rows) > 0 {
+		n, size := 0, 0
+		for n < len(rows) && (n == 0 || size+len(rows[n]) <= maxAppendBytes) {
+			size += len(rows[n])
+			n++
+		}
+		res, err := tw.stream.AppendRows(ctx, rows[:n])
+		if err == nil {
+			_, err = res.GetResult(ctx)
+		}
+		if err != nil {
+			// Start over with a new stream, whose offsets don't depend on
+			// the failed append.
+			err = errors.Join(err, tw.stream.Close())
+			tw.stream = nil
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// close appends the buffered rows of all tables, then closes the streams.
+func (w *writer) close() error {
+	w.mu.Lock()
+	var tws []*tableWriter
+	var bs []batch
+	for _, tw := range w.tables {
+		tws = append(tws, tw)
+		bs = append(bs, tw.take())
+	}
+	w.mu.Unlock()
+
+	var errs []error
+	for i, tw := range tws {
+		tw.flush(bs[i])
+		tw.appendMu.Lock()
+		if tw.stream != nil {
+			errs = append(errs, tw.stream.Close())
+			tw.stream = nil
+		}
+		tw.appendMu.Unlock()
+	}
+	errs = append(errs, w.client.Close())
+	return errors.Join(errs...)
+}
@@ -28,8 +28,21 @@ func FindAndBuildBinaries(modulePath string) (binaries []*BinaryInfo, err error)
 	if err != nil {
 		return nil, err
 	}
+	return BuildBinaries(modulePath, buildTargets), nil
+}
+
+// FindBinaries returns the import paths of the packages of a module that
+// compile to binaries.
+func FindBinaries(modulePath string) (_ []string, err error) {
+	defer derrors.Wrap(&err, "FindBinaries")
+	return findBinaries(modulePath)
+}
 
-	for i, target := range buildTargets {
+// BuildBinaries builds the packages of a module with the given import
+// paths. A package that fails to build has a BinaryInfo with an Error.
+func BuildBinaries(modulePath string, importPaths []string) []*BinaryInfo {
+	var binaries []*BinaryInfo
+	for i, target := range importPaths {
 		path, buildTime, err := runBuild(modulePath, target, i)
 		b := &BinaryInfo{
 			BinaryPath: path,
@@ -41,7 +54,7 @@ func FindAndBuildBinaries(modulePath string) (binaries []*BinaryInfo, err error)
 		}
 		binaries = append(binaries, b)
 	}
-	return binaries, nil
+	return binaries
 }
 
 // runBuild takes a given module and import path and attempts to build a binary
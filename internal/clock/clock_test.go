@@ -0,0 +1,40 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	var now func() time.Time = f.Now
+	if got := now(); !got.Equal(start) {
+		t.Errorf("got %s, want %s", got, start)
+	}
+
+	// Concurrent advances all count.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Advance(time.Minute)
+			_ = f.Now()
+		}()
+	}
+	wg.Wait()
+	if got, want := now(), start.Add(10*time.Minute); !got.Equal(want) {
+		t.Errorf("after advances: got %s, want %s", got, want)
+	}
+
+	f.Set(start)
+	if got := now(); !got.Equal(start) {
+		t.Errorf("after set: got %s, want %s", got, start)
+	}
+}
@@ -44,6 +44,22 @@ var (
 	// BigQueryError is used to capture server errors returned by BigQuery.
 	BigQueryError = errors.New("BigQuery error")
 
+	// InsertVolumeExceeded occurs when a run has inserted more
+	// data into BigQuery than it is allowed to.
+	InsertVolumeExceeded = errors.New("BigQuery insert volume exceeded")
+
+	// PolicyDenied occurs when a module is denied by the module policy,
+	// so it is neither downloaded nor scanned.
+	PolicyDenied = errors.New("module denied by policy")
+
+	// ScanDeferred occurs when the scan of a module is deferred because
+	// its run is behind schedule, so it is neither downloaded nor scanned.
+	ScanDeferred = errors.New("scan deferred")
+
+	// LocalReplaceError occurs when a module's go.mod replaces a dependency
+	// with a local path, which is never part of the module zip.
+	LocalReplaceError = errors.New("go.mod replaces a dependency with a local path")
+
 	// ScanModulePanicError is used to capture panic issues.
 	ScanModulePanicError = errors.New("scan module panic")
 
@@ -114,6 +130,18 @@ var (
 
 	// ScanModuleTooManyOpenFiles occurs when there are too many files open while scanning.
 	ScanModuleTooManyOpenFiles = errors.New("scan module too many open files")
+
+	// ScanModuleTimeout occurs when govulncheck runs for longer than it
+	// may and is killed.
+	ScanModuleTimeout = errors.New("scan module timed out")
+
+	// SandboxError occurs when the sandbox that govulncheck runs in
+	// can't be set up. This is not an error with the module.
+	SandboxError = errors.New("sandbox error")
+
+	// ExtractBlobError occurs when the blob to scan in EXTRACT mode is
+	// missing or was not written by "govulncheck -mode=extract".
+	ExtractBlobError = errors.New("extract blob error")
 )
 
 // Wrap adds context to the error and allows
@@ -223,8 +251,22 @@ func CategorizeError(err error) string {
 		return "MEM LIMIT EXCEEDED"
 	case errors.Is(err, ScanModuleTooManyOpenFiles):
 		return "TOO MANY OPEN FILES"
+	case errors.Is(err, ScanModuleTimeout):
+		return "TIMEOUT"
 	case errors.Is(err, ProxyError):
 		return "PROXY"
+	case errors.Is(err, SandboxError):
+		return "SANDBOX"
+	case errors.Is(err, ExtractBlobError):
+		return "BLOB"
+	case errors.Is(err, PolicyDenied):
+		return "POLICY DENIED"
+	case errors.Is(err, ScanDeferred):
+		return "DEFERRED"
+	case errors.Is(err, LocalReplaceError):
+		return "LOCAL REPLACE"
+	case errors.Is(err, InsertVolumeExceeded):
+		return "BIGQUERY - INSERT VOLUME EXCEEDED"
 	case errors.Is(err, BigQueryError):
 		return "BIGQUERY"
 	case errors.Is(err, ScanSyntheticModuleError):
@@ -0,0 +1,215 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+// AdoptionLagQueryParams are the query params of the
+// /govulncheck/adoption-lag endpoint.
+type AdoptionLagQueryParams struct {
+	OSV    string // if set, only report this OSV entry
+	Since  string // only consider rows created on or after this date (YYYY-MM-DD)
+	Window int    // days after which a module that hasn't remediated is reported as unremediated
+	Format string // "json" (the default) or "csv"
+}
+
+// DefaultAdoptionWindowDays is the default window of an adoption lag
+// request.
+const DefaultAdoptionWindowDays = 90
+
+// A Remediation describes when a module was affected by an OSV entry and
+// when it stopped being affected.
+type Remediation struct {
+	ModulePath string `bigquery:"module_path"`
+	OSV        string `bigquery:"osv"`
+	// FirstAffected is the creation time of the first row of the module
+	// that reports the entry.
+	FirstAffected time.Time `bigquery:"first_affected"`
+	// RemediatedAt is the creation time of the first row of the module
+	// after FirstAffected that doesn't report the entry. It is null if
+	// there is none.
+	RemediatedAt bq.NullTimestamp `bigquery:"remediated_at"`
+}
+
+// ReadRemediations returns the remediations of the modules affected by
+// the OSV entry with ID osvID, or by any entry if osvID is empty,
+// according to the IMPORTS rows without errors created at or after
+// since. IMPORTS rows are used because they report every vuln the module
+// imports, whether or not it is called. Ad hoc scans are ignored.
+func ReadRemediations(ctx context.Context, c *bigquery.Client, osvID string, since time.Time) (_ []*Remediation, err error) {
+	defer derrors.Wrap(&err, "ReadRemediations(%q, %s)", osvID, since)
+
+	const qf = `
+		WITH scans AS (
+			SELECT module_path, created_at, ARRAY(SELECT DISTINCT v.id FROM UNNEST(vulns) AS v) AS ids
+			FROM %s AS r
+			WHERE scan_mode = "IMPORTS" AND error = ""
+				AND created_at >= TIMESTAMP("%s")
+				AND NOT STARTS_WITH(suffix, "%s")
+				AND %s
+		),
+		affected AS (
+			SELECT s.module_path, id AS osv, MIN(s.created_at) AS first_affected
+			FROM scans AS s, UNNEST(s.ids) AS id
+			%s
+			GROUP BY s.module_path, id
+		)
+		SELECT a.module_path, a.osv, a.first_affected, MIN(s.created_at) AS remediated_at
+		FROM affected AS a
+		LEFT JOIN scans AS s
+			ON s.module_path = a.module_path
+			AND s.created_at > a.first_affected
+			AND a.osv NOT IN UNNEST(s.ids)
+		GROUP BY a.module_path, a.osv, a.first_affected
+		ORDER BY a.osv, a.module_path
+	`
+	var (
+		where  string
+		params []bq.QueryParameter
+	)
+	if osvID != "" {
+		if err := ValidateOSVID(osvID); err != nil {
+			return nil, err
+		}
+		where = `WHERE id = @osv_id`
+		params = append(params, bigquery.Param("osv_id", osvID))
+	}
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, since.UTC().Format(time.RFC3339), AdHocSuffixPrefix,
+		notInvalidatedCondition(table, "r"), where)
+	return bigquery.Query[Remediation](ctx, c, query, params...)
+}
+
+// FixedVersions returns the versions of modules that fix the
+// vulnerability of e, as module@version strings with a "v" prefix on the
+// version. The standard library and toolchain are omitted, since modules
+// don't remediate their vulnerabilities by upgrading.
+func FixedVersions(e *osv.Entry) []string {
+	var mvs []string
+	for _, a := range e.Affected {
+		if a.Module.Path == osv.GoStdModulePath || a.Module.Path == osv.GoCmdModulePath {
+			continue
+		}
+		for _, r := range a.Ranges {
+			for _, ev := range r.Events {
+				if ev.Fixed != "" {
+					mvs = append(mvs, a.Module.Path+"@v"+ev.Fixed)
+				}
+			}
+		}
+	}
+	return mvs
+}
+
+// An AdoptionLag summarizes how long the modules affected by an OSV entry
+// took to stop being affected after a fix was available.
+type AdoptionLag struct {
+	OSV string `json:"osv"`
+	// FixAvailable is when a fix was first available: the later of the
+	// entry's publication and the release of its earliest fixed version.
+	FixAvailable time.Time `json:"fix_available"`
+	// Remediated is the number of modules that remediated within the
+	// window.
+	Remediated int `json:"remediated"`
+	// The distribution of the days it took them, counted from FixAvailable
+	// or, for modules that were first affected later, from then.
+	P50Days  float64 `json:"p50_days"`
+	P90Days  float64 `json:"p90_days"`
+	MaxDays  float64 `json:"max_days"`
+	MeanDays float64 `json:"mean_days"`
+	// Unremediated are the modules that did not remediate within the
+	// window, sorted.
+	Unremediated []string `json:"unremediated"`
+	// Pending is the number of modules that have not remediated but whose
+	// window hasn't ended.
+	Pending int `json:"pending"`
+}
+
+// SummarizeAdoptionLag summarizes rems, which must be sorted by OSV ID,
+// into one AdoptionLag for each OSV ID in fixes, which maps OSV IDs to the
+// time their fix became available. Remediations of other entries are
+// ignored. A module that doesn't remediate within window is unremediated
+// if window has passed by now, and pending otherwise.
+func SummarizeAdoptionLag(rems []*Remediation, fixes map[string]time.Time, window time.Duration, now time.Time) []*AdoptionLag {
+	var (
+		lags []*AdoptionLag
+		cur  *AdoptionLag
+		days []float64
+	)
+	finish := func() {
+		if cur == nil {
+			return
+		}
+		sort.Float64s(days)
+		cur.Remediated = len(days)
+		if len(days) > 0 {
+			cur.P50Days = percentile(days, 50)
+			cur.P90Days = percentile(days, 90)
+			cur.MaxDays = days[len(days)-1]
+			var sum float64
+			for _, d := range days {
+				sum += d
+			}
+			cur.MeanDays = sum / float64(len(days))
+		}
+		sort.Strings(cur.Unremediated)
+		lags = append(lags, cur)
+	}
+	for _, r := range rems {
+		fix, ok := fixes[r.OSV]
+		if !ok {
+			continue
+		}
+		if cur == nil || cur.OSV != r.OSV {
+			finish()
+			cur = &AdoptionLag{OSV: r.OSV, FixAvailable: fix, Unremediated: []string{}}
+			days = nil
+		}
+		start := fix
+		if r.FirstAffected.After(start) {
+			start = r.FirstAffected
+		}
+		if r.RemediatedAt.Valid {
+			lag := r.RemediatedAt.Timestamp.Sub(start)
+			if lag < 0 {
+				// The module stopped being affected before the fix,
+				// for example by dropping the dependency.
+				lag = 0
+			}
+			if lag <= window {
+				days = append(days, lag.Hours()/24)
+				continue
+			}
+		}
+		if now.Sub(start) > window {
+			cur.Unremediated = append(cur.Unremediated, r.ModulePath)
+		} else {
+			cur.Pending++
+		}
+	}
+	finish()
+	return lags
+}
+
+// percentile returns the p-th percentile of sorted, which must not be
+// empty, by the nearest-rank method.
+func percentile(sorted []float64, p int) float64 {
+	i := int(math.Ceil(float64(p)/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
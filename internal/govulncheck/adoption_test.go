@@ -0,0 +1,80 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+func TestFixedVersions(t *testing.T) {
+	e := &osv.Entry{
+		Affected: []osv.Affected{
+			{
+				Module: osv.Module{Path: "example.com/a"},
+				Ranges: []osv.Range{{Events: []osv.RangeEvent{
+					{Introduced: "0"}, {Fixed: "1.2.3"}, {Introduced: "1.3.0"}, {Fixed: "1.3.1"},
+				}}},
+			},
+			{
+				Module: osv.Module{Path: osv.GoStdModulePath},
+				Ranges: []osv.Range{{Events: []osv.RangeEvent{{Introduced: "0"}, {Fixed: "1.20.5"}}}},
+			},
+		},
+	}
+	got := FixedVersions(e)
+	want := []string{"example.com/a@v1.2.3", "example.com/a@v1.3.1"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSummarizeAdoptionLag(t *testing.T) {
+	fix := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	at := func(d int) time.Time { return fix.Add(time.Duration(d) * day) }
+	const never = -1000
+	rem := func(osvID, mod string, first, remediated int) *Remediation {
+		r := &Remediation{ModulePath: mod, OSV: osvID, FirstAffected: at(first)}
+		if remediated != never {
+			r.RemediatedAt = bigquery.NullTimestamp(at(remediated))
+		}
+		return r
+	}
+	rems := []*Remediation{
+		rem("GO-1", "a", -10, 2),
+		rem("GO-1", "b", -10, 4),
+		rem("GO-1", "c", 5, 15),      // lag counted from first affected
+		rem("GO-1", "d", -10, -5),    // remediated before the fix
+		rem("GO-1", "e", -10, never), // never remediated
+		rem("GO-1", "f", -10, 50),    // remediated after the window
+		rem("GO-2", "a", 80, never),  // window not over
+		rem("GO-3", "a", -10, never), // no fix
+		rem("GO-4", "zz", -10, 40),   // fix later
+	}
+	fixes := map[string]time.Time{"GO-1": fix, "GO-2": fix, "GO-4": at(35)}
+	got := SummarizeAdoptionLag(rems, fixes, 30*day, at(100))
+	want := []*AdoptionLag{
+		{
+			OSV:          "GO-1",
+			FixAvailable: fix,
+			Remediated:   4,
+			P50Days:      2,
+			P90Days:      10,
+			MaxDays:      10,
+			MeanDays:     4,
+			Unremediated: []string{"e", "f"},
+		},
+		{OSV: "GO-2", FixAvailable: fix, Unremediated: []string{}, Pending: 1},
+		{OSV: "GO-4", FixAvailable: at(35), Remediated: 1, P50Days: 5, P90Days: 5, MaxDays: 5, MeanDays: 5, Unremediated: []string{}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
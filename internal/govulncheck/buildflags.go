@@ -0,0 +1,84 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// allowedGoFlags are the flags that a scan request may pass to the go
+// command. Flags that run other programs, like -toolexec, or that change
+// how the module is resolved, like -mod, are not allowed.
+var allowedGoFlags = map[string]bool{
+	"-buildvcs":   true,
+	"-trimpath":   true,
+	"-modcacherw": true,
+}
+
+// ParseTags parses a comma-separated list of build tags, like
+// "sqlite,netgo". It returns the tags in order, without duplicates.
+// The empty string is an empty list.
+func ParseTags(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		if !isBuildTag(t) {
+			return nil, fmt.Errorf("invalid build tag %q", t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	return tags, nil
+}
+
+// isBuildTag reports whether s can be a build tag.
+func isBuildTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseGoFlags parses a space-separated list of flags for the go command,
+// as in GOFLAGS, like "-trimpath -buildvcs=false". Only the flags in
+// allowedGoFlags are accepted, and their values may not contain spaces.
+// The empty string is an empty list.
+func ParseGoFlags(s string) ([]string, error) {
+	flags := strings.Fields(s)
+	for _, f := range flags {
+		name, _, _ := strings.Cut(f, "=")
+		if !strings.HasPrefix(name, "-") || !allowedGoFlags["-"+strings.TrimLeft(name, "-")] {
+			return nil, fmt.Errorf("go flag %q is not allowed", f)
+		}
+	}
+	return flags, nil
+}
+
+// goFlags returns the value of GOFLAGS for o, or the empty string if
+// it needs none.
+func (o *RunOptions) goFlags() string {
+	var flags []string
+	if o.IgnoreVendor {
+		flags = append(flags, "-mod=mod")
+	}
+	if o.Tags != "" {
+		flags = append(flags, "-tags="+o.Tags)
+	}
+	if o.GoFlags != "" {
+		flags = append(flags, strings.Fields(o.GoFlags)...)
+	}
+	return strings.Join(flags, " ")
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTags(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"sqlite", []string{"sqlite"}},
+		{"netgo,sqlite_omit_load_extension,netgo,go1.21", []string{"netgo", "sqlite_omit_load_extension", "go1.21"}},
+	} {
+		got, err := ParseTags(test.in)
+		if err != nil {
+			t.Fatalf("%q: %v", test.in, err)
+		}
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.in, got, test.want)
+		}
+	}
+	for _, in := range []string{",", "netgo,", "net go", "netgo -toolexec=x", "a=b", "a;b"} {
+		if _, err := ParseTags(in); err == nil {
+			t.Errorf("%q: got nil error", in)
+		}
+	}
+}
+
+func TestParseGoFlags(t *testing.T) {
+	got, err := ParseGoFlags(" -trimpath  -buildvcs=false --modcacherw ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-trimpath", "-buildvcs=false", "--modcacherw"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, in := range []string{"-toolexec=/bin/sh", "-mod=mod", "-tags=x", "trimpath", "-trimpath -overlay=o.json"} {
+		if _, err := ParseGoFlags(in); err == nil {
+			t.Errorf("%q: got nil error", in)
+		}
+	}
+}
+
+func TestRunOptionsGoFlags(t *testing.T) {
+	for _, test := range []struct {
+		opts RunOptions
+		want string
+	}{
+		{RunOptions{}, ""},
+		{RunOptions{IgnoreVendor: true}, "-mod=mod"},
+		{RunOptions{IgnoreVendor: true, Tags: "netgo,sqlite", GoFlags: "-trimpath  -buildvcs=false"},
+			"-mod=mod -tags=netgo,sqlite -trimpath -buildvcs=false"},
+	} {
+		if got := test.opts.goFlags(); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.opts, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// A Checkpoint records that part of a scan task is done. A task that
+// writes its rows in several parts, or sub-scans, like a compare-mode scan,
+// which writes the rows of each package separately, records a checkpoint
+// in the events table after each one. If the task dies and is retried,
+// the retry skips the sub-scans that checkpoints of the same lineage and
+// work version say are done.
+type Checkpoint struct {
+	// Task is the name of the task, which is the same for all its
+	// attempts.
+	Task string `json:"task"`
+	// Lineage identifies the attempts of one request; see Lineage.
+	Lineage     string       `json:"lineage"`
+	WorkVersion *WorkVersion `json:"work_version"`
+	// SubScan is the sub-scan that is done: PackageSubScan for the rows
+	// of a package, or SubScanSummary for the summary row, which is
+	// written last.
+	SubScan string `json:"sub_scan"`
+	// Pair summarizes the results of the package of a PackageSubScan, so
+	// that the summary row can be written without scanning it again.
+	Pair *PairSummary `json:"pair,omitempty"`
+}
+
+// SubScanSummary is the sub-scan of a compare-mode scan that writes its
+// summary row.
+const SubScanSummary = "summary"
+
+// PackageSubScan returns the sub-scan of a compare-mode scan that writes
+// the rows of the package with the given import path.
+func PackageSubScan(importPath string) string {
+	return "package " + importPath
+}
+
+// Lineage returns the lineage of the attempts of the task with the given
+// name for r. Retries of a task have the same name and params, so they
+// share a lineage, while a task that is enqueued again with the same name
+// but different params starts a new one.
+func Lineage(taskName string, r *Request) string {
+	h := sha256.Sum256([]byte(taskName + "?" + r.Params()))
+	return hex.EncodeToString(h[:])
+}
+
+// NewCheckpointEvent returns the event recording c for the run with the
+// given suffix.
+func NewCheckpointEvent(suffix string, c *Checkpoint) *Event {
+	return NewEvent(suffix, EventCheckpoint, SeverityInfo,
+		fmt.Sprintf("%s: %s done", c.Task, c.SubScan), c)
+}
+
+// ReadCheckpoints returns the checkpoints of the task with the given name
+// and lineage in the run with the given suffix.
+func ReadCheckpoints(ctx context.Context, c *bigquery.Client, suffix, task, lineage string) (_ []*Checkpoint, err error) {
+	defer derrors.Wrap(&err, "ReadCheckpoints(%q, %q)", suffix, task)
+
+	const qf = `
+		SELECT * FROM %s
+		WHERE suffix = "%s" AND type = "%s"
+			AND JSON_VALUE(payload, "$.task") = %q
+			AND JSON_VALUE(payload, "$.lineage") = "%s"
+		ORDER BY created_at
+	`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(EventTableName)+"`", suffix, EventCheckpoint, task, lineage)
+	events, err := bigquery.Query[Event](ctx, c, query)
+	if err != nil {
+		return nil, err
+	}
+	var cps []*Checkpoint
+	for _, e := range events {
+		var cp Checkpoint
+		if err := json.Unmarshal([]byte(e.Payload.JSONVal), &cp); err != nil {
+			return nil, err
+		}
+		cps = append(cps, &cp)
+	}
+	return cps, nil
+}
+
+// CompletedSubScans returns the checkpoints of cps with the given lineage
+// and work version wv, by sub-scan. Sub-scans done with another work
+// version must be done again, so that the rows of the task are the same
+// as if it had run without interruption.
+func CompletedSubScans(cps []*Checkpoint, lineage string, wv *WorkVersion) map[string]*Checkpoint {
+	done := map[string]*Checkpoint{}
+	for _, c := range cps {
+		if c.Lineage == lineage && c.WorkVersion.Equal(wv) {
+			done[c.SubScan] = c
+		}
+	}
+	return done
+}
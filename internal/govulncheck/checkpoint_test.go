@@ -0,0 +1,109 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+func TestLineage(t *testing.T) {
+	req := func(suffix string) *Request {
+		return &Request{QueryParams: QueryParams{Mode: "COMPARE", Suffix: suffix}}
+	}
+	l := Lineage("task", req("run"))
+	if got := Lineage("task", req("run")); got != l {
+		t.Errorf("same task and params: got %s, want %s", got, l)
+	}
+	if got := Lineage("other", req("run")); got == l {
+		t.Error("different task: got same lineage")
+	}
+	if got := Lineage("task", req("run2")); got == l {
+		t.Error("different params: got same lineage")
+	}
+}
+
+func TestCompletedSubScans(t *testing.T) {
+	wv := &WorkVersion{
+		WorkerVersion:      "1",
+		VulnDBLastModified: time.Date(2023, 6, 1, 12, 0, 0, 0, time.FixedZone("X", 3600)),
+		OSVFilterHash:      bigquery.NullString("h"),
+	}
+	newer := *wv
+	newer.WorkerVersion = "2"
+	// Checkpoints are read back from JSON.
+	roundTrip := func(c *Checkpoint) *Checkpoint {
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Checkpoint
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		return &got
+	}
+	cps := []*Checkpoint{
+		roundTrip(&Checkpoint{Lineage: "L", WorkVersion: wv, SubScan: PackageSubScan("m/a"), Pair: &PairSummary{BinaryOnly: []string{"A"}}}),
+		roundTrip(&Checkpoint{Lineage: "L", WorkVersion: &newer, SubScan: PackageSubScan("m/b")}),
+		roundTrip(&Checkpoint{Lineage: "other", WorkVersion: wv, SubScan: PackageSubScan("m/c")}),
+		roundTrip(&Checkpoint{Lineage: "L", WorkVersion: wv, SubScan: SubScanSummary}),
+	}
+	got := CompletedSubScans(cps, "L", wv)
+	var gotSubScans []string
+	for s := range got {
+		gotSubScans = append(gotSubScans, s)
+	}
+	sort.Strings(gotSubScans)
+	if want := []string{"package m/a", "summary"}; !cmp.Equal(gotSubScans, want) {
+		t.Errorf("got %v, want %v", gotSubScans, want)
+	}
+	if diff := cmp.Diff(&PairSummary{BinaryOnly: []string{"A"}}, got["package m/a"].Pair); diff != "" {
+		t.Errorf("pair mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSummarizePairsFromJSON(t *testing.T) {
+	// A summary computed from pair summaries read back from checkpoints
+	// is the same as one computed from the results.
+	resp := &CompareResponse{FindingsForMod: map[string]*ComparePair{
+		"m/a": {
+			BinaryResults: SandboxResponse{
+				Findings: []*govulncheckapi.Finding{called("A")},
+				Stats:    ScanStats{ScanSeconds: 0.1, BuildTime: 1300 * time.Millisecond},
+			},
+			SourceResults: SandboxResponse{Stats: ScanStats{ScanSeconds: 0.7}},
+		},
+		"m/b": {
+			BinaryResults: SandboxResponse{Stats: ScanStats{ScanSeconds: 0.2, BuildTime: 100 * time.Millisecond}},
+			SourceResults: SandboxResponse{
+				Findings: []*govulncheckapi.Finding{called("B")},
+				Stats:    ScanStats{ScanSeconds: 0.3},
+			},
+		},
+		"m/c": {Error: "build failed"},
+	}}
+	pairs := map[string]*PairSummary{}
+	for pkg, p := range resp.FindingsForMod {
+		data, err := json.Marshal(p.Summarize(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ps PairSummary
+		if err := json.Unmarshal(data, &ps); err != nil {
+			t.Fatal(err)
+		}
+		pairs[pkg] = &ps
+	}
+	if diff := cmp.Diff(SummarizeCompare(resp, nil), SummarizePairs(pairs)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+// Row types of compare-mode rows; see Result.RowType.
+const (
+	RowTypePair    = "pair"    // the results of one mode for one package
+	RowTypeSummary = "summary" // a summary of all the packages of a module
+)
+
+// ModeCompareSummary is the scan mode of compare-mode summary rows.
+const ModeCompareSummary = "COMPARE - SUMMARY"
+
+// Diff returns the sorted IDs of the OSV entries whose vulnerable symbols
+// are called according to only one of the binary and source results of p.
+// Entries rejected by filter are ignored; a nil filter keeps everything.
+func (p *ComparePair) Diff(filter *OSVFilter) (binaryOnly, sourceOnly []string) {
+	bin := calledOSVs(p.BinaryResults.Findings, filter)
+	src := calledOSVs(p.SourceResults.Findings, filter)
+	for id := range bin {
+		if !src[id] {
+			binaryOnly = append(binaryOnly, id)
+		}
+	}
+	for id := range src {
+		if !bin[id] {
+			sourceOnly = append(sourceOnly, id)
+		}
+	}
+	sort.Strings(binaryOnly)
+	sort.Strings(sourceOnly)
+	return binaryOnly, sourceOnly
+}
+
+// calledOSVs returns the IDs of the OSV entries of the findings at the
+// symbol level.
+func calledOSVs(findings []*govulncheckapi.Finding, filter *OSVFilter) map[string]bool {
+	ids := map[string]bool{}
+	for _, f := range findings {
+		if len(f.Trace) > 0 && f.Trace[0].Function != "" && filter.Keep(f.OSV) {
+			ids[f.OSV] = true
+		}
+	}
+	return ids
+}
+
+// CompareSummary summarizes a compare-mode scan of a module.
+type CompareSummary struct {
+	// Packages is the number of packages whose binary and source
+	// results were compared.
+	Packages int `bigquery:"packages"`
+	// BinaryMissed is the number of packages where binary mode missed
+	// findings of source mode, and SourceMissed the reverse.
+	BinaryMissed int `bigquery:"binary_missed"`
+	SourceMissed int `bigquery:"source_missed"`
+	// BinaryOnlyOSVs is the number of OSV entries found by binary mode
+	// but not source mode in some package, and SourceOnlyOSVs the reverse.
+	BinaryOnlyOSVs int `bigquery:"binary_only_osvs"`
+	SourceOnlyOSVs int `bigquery:"source_only_osvs"`
+	// BuildSeconds is the total time spent building binaries.
+	BuildSeconds float64 `bigquery:"build_seconds"`
+	// BinaryToSourceTimeRatio is the ratio of the total binary scan time
+	// to the total source scan time. It is zero if no time was spent
+	// scanning source.
+	BinaryToSourceTimeRatio float64 `bigquery:"binary_to_source_time_ratio"`
+}
+
+// SummarizeCompare summarizes the pairs of resp without errors.
+func SummarizeCompare(resp *CompareResponse, filter *OSVFilter) *CompareSummary {
+	pairs := map[string]*PairSummary{}
+	for pkg, p := range resp.FindingsForMod {
+		pairs[pkg] = p.Summarize(filter)
+	}
+	return SummarizePairs(pairs)
+}
+
+// A PairSummary holds what a CompareSummary needs from the results of
+// one package, so that a module can be summarized without keeping the
+// findings of all its packages.
+type PairSummary struct {
+	Error             bool     `json:"error,omitempty"`
+	BinaryOnly        []string `json:"binary_only,omitempty"`
+	SourceOnly        []string `json:"source_only,omitempty"`
+	BuildSeconds      float64  `json:"build_seconds,omitempty"`
+	BinaryScanSeconds float64  `json:"binary_scan_seconds,omitempty"`
+	SourceScanSeconds float64  `json:"source_scan_seconds,omitempty"`
+}
+
+// Summarize returns the summary of p. Entries rejected by filter are
+// ignored.
+func (p *ComparePair) Summarize(filter *OSVFilter) *PairSummary {
+	if p.Error != "" {
+		return &PairSummary{Error: true}
+	}
+	binOnly, srcOnly := p.Diff(filter)
+	return &PairSummary{
+		BinaryOnly:        binOnly,
+		SourceOnly:        srcOnly,
+		BuildSeconds:      p.BinaryResults.Stats.BuildTime.Seconds(),
+		BinaryScanSeconds: p.BinaryResults.Stats.ScanSeconds,
+		SourceScanSeconds: p.SourceResults.Stats.ScanSeconds,
+	}
+}
+
+// SummarizePairs summarizes the pairs without errors, given by package
+// import path. Packages are visited in order, so that the sums don't
+// depend on map iteration order.
+func SummarizePairs(pairs map[string]*PairSummary) *CompareSummary {
+	s := &CompareSummary{}
+	binOnly := map[string]bool{}
+	srcOnly := map[string]bool{}
+	var binSeconds, srcSeconds float64
+	pkgs := make([]string, 0, len(pairs))
+	for pkg := range pairs {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		p := pairs[pkg]
+		if p.Error {
+			continue
+		}
+		s.Packages++
+		if len(p.SourceOnly) > 0 {
+			s.BinaryMissed++
+		}
+		if len(p.BinaryOnly) > 0 {
+			s.SourceMissed++
+		}
+		for _, id := range p.BinaryOnly {
+			binOnly[id] = true
+		}
+		for _, id := range p.SourceOnly {
+			srcOnly[id] = true
+		}
+		s.BuildSeconds += p.BuildSeconds
+		binSeconds += p.BinaryScanSeconds
+		srcSeconds += p.SourceScanSeconds
+	}
+	s.BinaryOnlyOSVs = len(binOnly)
+	s.SourceOnlyOSVs = len(srcOnly)
+	if srcSeconds > 0 {
+		s.BinaryToSourceTimeRatio = binSeconds / srcSeconds
+	}
+	return s
+}
+
+// CompareStats aggregates the compare-mode summaries of a run.
+type CompareStats struct {
+	Suffix string `bigquery:"-"`
+	// Modules is the number of modules with a summary.
+	Modules int `bigquery:"modules"`
+	// The remaining fields are sums of the CompareSummary fields of the
+	// modules, except for MeanTimeRatio.
+	Packages       int     `bigquery:"packages"`
+	BinaryMissed   int     `bigquery:"binary_missed"`
+	SourceMissed   int     `bigquery:"source_missed"`
+	BinaryOnlyOSVs int     `bigquery:"binary_only_osvs"`
+	SourceOnlyOSVs int     `bigquery:"source_only_osvs"`
+	BuildSeconds   float64 `bigquery:"build_seconds"`
+	// MeanTimeRatio is the mean of the modules' binary to source scan
+	// time ratios, ignoring modules for which it is unknown.
+	MeanTimeRatio float64 `bigquery:"mean_time_ratio"`
+}
+
+// ReadCompareStats aggregates the summary rows of the compare-mode scans
+// of the run with the given suffix. Only summary rows are read, so that
+// packages are not counted once per mode; if a module version has several
+// summary rows, only the latest is used.
+func ReadCompareStats(ctx context.Context, c *bigquery.Client, suffix string) (_ *CompareStats, err error) {
+	defer derrors.Wrap(&err, "ReadCompareStats(%q)", suffix)
+
+	const qf = `
+		WITH summaries AS (
+			SELECT compare_summary AS s
+			FROM %s AS r
+			WHERE row_type = "%s" AND suffix = @suffix AND %s
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY module_path, version ORDER BY created_at DESC) = 1
+		)
+		SELECT
+			COUNT(*) AS modules,
+			IFNULL(SUM(s.packages), 0) AS packages,
+			IFNULL(SUM(s.binary_missed), 0) AS binary_missed,
+			IFNULL(SUM(s.source_missed), 0) AS source_missed,
+			IFNULL(SUM(s.binary_only_osvs), 0) AS binary_only_osvs,
+			IFNULL(SUM(s.source_only_osvs), 0) AS source_only_osvs,
+			IFNULL(SUM(s.build_seconds), 0) AS build_seconds,
+			IFNULL(AVG(NULLIF(s.binary_to_source_time_ratio, 0)), 0) AS mean_time_ratio
+		FROM summaries
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, RowTypeSummary, notInvalidatedCondition(table, "r"))
+	params := []bq.QueryParameter{bigquery.Param("suffix", suffix)}
+	stats := &CompareStats{}
+	err = bigquery.ForEach(ctx, c, query, params, func(s *CompareStats) bool {
+		stats = s
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.Suffix = suffix
+	return stats, nil
+}
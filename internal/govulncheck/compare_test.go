@@ -0,0 +1,87 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+func called(id string) *govulncheckapi.Finding {
+	return &govulncheckapi.Finding{OSV: id, Trace: []*govulncheckapi.Frame{{Function: "F"}}}
+}
+
+func imported(id string) *govulncheckapi.Finding {
+	return &govulncheckapi.Finding{OSV: id, Trace: []*govulncheckapi.Frame{{Package: "p"}}}
+}
+
+func TestComparePairDiff(t *testing.T) {
+	p := &ComparePair{
+		BinaryResults: SandboxResponse{Findings: []*govulncheckapi.Finding{called("A"), called("B"), called("B"), imported("C")}},
+		SourceResults: SandboxResponse{Findings: []*govulncheckapi.Finding{called("B"), called("D"), called("C")}},
+	}
+	bin, src := p.Diff(nil)
+	if want := []string{"A"}; !cmp.Equal(bin, want) {
+		t.Errorf("binaryOnly: got %v, want %v", bin, want)
+	}
+	if want := []string{"C", "D"}; !cmp.Equal(src, want) {
+		t.Errorf("sourceOnly: got %v, want %v", src, want)
+	}
+
+	filter, err := ParseOSVFilter("-D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, src = p.Diff(filter)
+	if want := []string{"C"}; !cmp.Equal(src, want) {
+		t.Errorf("filtered sourceOnly: got %v, want %v", src, want)
+	}
+}
+
+func TestSummarizeCompare(t *testing.T) {
+	resp := &CompareResponse{FindingsForMod: map[string]*ComparePair{
+		"m/a": {
+			BinaryResults: SandboxResponse{
+				Findings: []*govulncheckapi.Finding{called("A")},
+				Stats:    ScanStats{ScanSeconds: 2, BuildTime: 3 * time.Second},
+			},
+			SourceResults: SandboxResponse{
+				Findings: []*govulncheckapi.Finding{called("B")},
+				Stats:    ScanStats{ScanSeconds: 4},
+			},
+		},
+		"m/b": {
+			BinaryResults: SandboxResponse{
+				Findings: []*govulncheckapi.Finding{called("A")},
+				Stats:    ScanStats{ScanSeconds: 1, BuildTime: time.Second},
+			},
+			SourceResults: SandboxResponse{
+				Findings: []*govulncheckapi.Finding{called("A")},
+				Stats:    ScanStats{ScanSeconds: 4},
+			},
+		},
+		"m/c": {Error: "build failed"},
+	}}
+	got := SummarizeCompare(resp, nil)
+	want := &CompareSummary{
+		Packages:                2,
+		BinaryMissed:            1,
+		SourceMissed:            1,
+		BinaryOnlyOSVs:          1,
+		SourceOnlyOSVs:          1,
+		BuildSeconds:            4,
+		BinaryToSourceTimeRatio: 3.0 / 8,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if got := SummarizeCompare(&CompareResponse{}, nil); *got != (CompareSummary{}) {
+		t.Errorf("empty response: got %+v, want zero", got)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// Values of the analysis_confidence column, which says how much of a
+// module govulncheck could analyze.
+const (
+	// ConfidenceFull means all packages loaded without errors.
+	ConfidenceFull = "full"
+	// ConfidencePartial means govulncheck ran, but some packages
+	// had errors, so their call graphs may be incomplete.
+	ConfidencePartial = "partial"
+	// ConfidenceNone means the packages could not be loaded,
+	// so there are no results.
+	ConfidenceNone = "none"
+)
+
+// AnalysisConfidence returns the analysis confidence of a scan in which
+// packagesWithErrors packages had errors. loadFailed reports whether the
+// scan failed because packages could not be loaded.
+func AnalysisConfidence(packagesWithErrors int, loadFailed bool) string {
+	switch {
+	case loadFailed:
+		return ConfidenceNone
+	case packagesWithErrors > 0:
+		return ConfidencePartial
+	default:
+		return ConfidenceFull
+	}
+}
+
+// positionRegexp matches the position at the start of an error
+// reported for a Go file, as printed by go list and go/packages.
+var positionRegexp = regexp.MustCompile(`^(\S+\.go):\d+(:\d+)?: `)
+
+// CountPackageErrors returns the number of packages with errors in
+// output, which is the standard error of govulncheck or an error that
+// includes it. Packages are identified by the directories of the files
+// in which errors are reported.
+func CountPackageErrors(output string) int {
+	dirs := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if m := positionRegexp.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			dirs[filepath.Dir(m[1])] = true
+		}
+	}
+	return len(dirs)
+}
+
+// A ConfidenceRate is the rate of rows with called vulnerabilities
+// among rows with a given analysis confidence.
+type ConfidenceRate struct {
+	Confidence string `bigquery:"confidence"`
+	NumRows    int    `bigquery:"num_rows"`
+	NumCalled  int    `bigquery:"num_called"`
+}
+
+// CalledRate returns the fraction of rows with a called vulnerability.
+func (r *ConfidenceRate) CalledRate() float64 {
+	if r.NumRows == 0 {
+		return 0
+	}
+	return float64(r.NumCalled) / float64(r.NumRows)
+}
+
+// ReadConfidenceRates returns the rate of GOVULNCHECK rows with called
+// vulnerabilities for each analysis confidence, among the rows of the
+// run with the given suffix. Rows written before confidence was recorded
+// have confidence "unknown".
+func ReadConfidenceRates(ctx context.Context, c *bigquery.Client, suffix string) (_ []*ConfidenceRate, err error) {
+	defer derrors.Wrap(&err, "ReadConfidenceRates(%q)", suffix)
+
+	const qf = `
+		SELECT
+			IFNULL(analysis_confidence, "unknown") AS confidence,
+			COUNT(*) AS num_rows,
+			COUNTIF(ARRAY_LENGTH(vulns) > 0) AS num_called
+		FROM %s AS r
+		WHERE suffix = @suffix AND scan_mode = "GOVULNCHECK" AND %s
+		GROUP BY confidence
+		ORDER BY confidence
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, notInvalidatedCondition(table, "r"))
+	return bigquery.Query[ConfidenceRate](ctx, c, query, bigquery.Param("suffix", suffix))
+}
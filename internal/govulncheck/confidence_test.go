@@ -0,0 +1,49 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import "testing"
+
+func TestCountPackageErrors(t *testing.T) {
+	for _, test := range []struct {
+		output string
+		want   int
+	}{
+		{"", 0},
+		{"govulncheck: no go.mod file", 0},
+		{
+			`govulncheck: loading packages:
+There are errors with the provided package patterns:
+
+/tmp/modules/m/a/a.go:3:8: could not import example.com/x (no required module provides package "example.com/x")
+/tmp/modules/m/a/b.go:10:2: undefined: y
+/tmp/modules/m/c/c.go:5: syntax error: unexpected newline
+
+For details on package patterns, see https://pkg.go.dev/cmd/go#hdr-Package_lists_and_patterns.`,
+			2,
+		},
+	} {
+		if got := CountPackageErrors(test.output); got != test.want {
+			t.Errorf("%q: got %d, want %d", test.output, got, test.want)
+		}
+	}
+}
+
+func TestAnalysisConfidence(t *testing.T) {
+	for _, test := range []struct {
+		n          int
+		loadFailed bool
+		want       string
+	}{
+		{0, false, ConfidenceFull},
+		{2, false, ConfidencePartial},
+		{2, true, ConfidenceNone},
+		{0, true, ConfidenceNone},
+	} {
+		if got := AnalysisConfidence(test.n, test.loadFailed); got != test.want {
+			t.Errorf("AnalysisConfidence(%d, %t) = %q, want %q", test.n, test.loadFailed, got, test.want)
+		}
+	}
+}
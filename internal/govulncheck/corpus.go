@@ -0,0 +1,112 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+const (
+	// ModeTombstone is the scan mode of tombstone rows.
+	ModeTombstone = "TOMBSTONE"
+
+	// MaintenanceSuffix is the suffix of rows written by maintenance
+	// jobs rather than scans.
+	MaintenanceSuffix = "maintenance"
+)
+
+// CorpusQueryParams are the query params of the
+// /govulncheck/reconcile-corpus endpoint.
+type CorpusQueryParams struct {
+	File   string // path to file containing the corpus; if missing, use DB
+	Min    int    // minimum imported-by count for a module to be in the corpus
+	DryRun bool   // if true, report the modules to tombstone but don't write rows
+}
+
+// Tombstone returns a row marking modulePath as removed from the corpus.
+// Tombstone rows have no version and no vulns, so they are never the
+// latest row for a module version.
+func Tombstone(modulePath string) *Result {
+	return &Result{
+		ModulePath:  modulePath,
+		Suffix:      MaintenanceSuffix,
+		ScanMode:    ModeTombstone,
+		InCorpus:    bigquery.NullBool(false),
+		WorkVersion: WorkVersion{SchemaVersion: SchemaVersion},
+	}
+}
+
+// A StoredModule is a module with rows in the govulncheck table.
+type StoredModule struct {
+	ModulePath string `bigquery:"module_path"`
+	// RequestedModulePath is the module path that was requested for
+	// the latest row, if a higher major version was scanned instead.
+	RequestedModulePath bq.NullString `bigquery:"requested_module_path"`
+}
+
+// ReadLiveModules returns the modules whose latest row is not a tombstone.
+// The standard library and modules with scrubbed paths, which are never in
+// the corpus, are omitted.
+func ReadLiveModules(ctx context.Context, c *bigquery.Client) (_ []*StoredModule, err error) {
+	defer derrors.Wrap(&err, "ReadLiveModules")
+
+	const qf = `
+		SELECT module_path, requested_module_path
+		FROM (
+			SELECT module_path, requested_module_path, in_corpus,
+				ROW_NUMBER() OVER (PARTITION BY module_path ORDER BY created_at DESC) AS rownum
+			FROM %s
+			WHERE module_path != "stdlib" AND NOT IFNULL(scrubbed, FALSE) AND scan_mode != "%s"
+		)
+		WHERE rownum = 1 AND IFNULL(in_corpus, TRUE)
+		ORDER BY module_path
+	`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`", ModeInvalidation)
+	return bigquery.Query[StoredModule](ctx, c, query)
+}
+
+// RemovedModules returns the paths of the modules in stored that are not
+// in corpus. A module is in the corpus if either its path or the path that
+// was requested for it is.
+func RemovedModules(stored []*StoredModule, corpus map[string]bool) []string {
+	var removed []string
+	for _, m := range stored {
+		if corpus[m.ModulePath] || (m.RequestedModulePath.Valid && corpus[m.RequestedModulePath.StringVal]) {
+			continue
+		}
+		removed = append(removed, m.ModulePath)
+	}
+	return removed
+}
+
+// notRemovedCondition returns a SQL condition that holds for rows of the
+// table alias that were created after the latest tombstone of their module,
+// if any.
+func notRemovedCondition(table, alias string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+			SELECT 1 FROM %s AS t
+			WHERE t.module_path = %s.module_path AND t.in_corpus = FALSE AND t.created_at > %[2]s.created_at
+		)`, table, alias)
+}
+
+// CorpusHash returns the SHA-256 hash of the module versions of a run,
+// given as module@version strings. The order of modules doesn't matter.
+func CorpusHash(modules []string) string {
+	ms := append([]string(nil), modules...)
+	sort.Strings(ms)
+	h := sha256.New()
+	for _, m := range ms {
+		fmt.Fprintln(h, m)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestRemovedModules(t *testing.T) {
+	stored := []*StoredModule{
+		{ModulePath: "example.com/a"},
+		{ModulePath: "example.com/b"},
+		{ModulePath: "example.com/c/v2", RequestedModulePath: bigquery.NullString("example.com/c")},
+		{ModulePath: "example.com/d/v3", RequestedModulePath: bigquery.NullString("example.com/d")},
+	}
+	corpus := map[string]bool{"example.com/a": true, "example.com/c": true, "example.com/e": true}
+	got := RemovedModules(stored, corpus)
+	want := []string{"example.com/b", "example.com/d/v3"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTombstone(t *testing.T) {
+	r := Tombstone("example.com/m")
+	if r.InCorpus != bigquery.NullBool(false) || r.Version != "" || len(r.Vulns) != 0 {
+		t.Errorf("got %+v, want a tombstone with no version or vulns", r)
+	}
+	if r.ScanMode == ModeGovulncheck || r.ScanMode == ModeBinary {
+		t.Errorf("tombstone has scan mode %q", r.ScanMode)
+	}
+}
+
+func TestCorpusHash(t *testing.T) {
+	h1 := CorpusHash([]string{"a@v1.0.0", "b@v2.0.0"})
+	h2 := CorpusHash([]string{"b@v2.0.0", "a@v1.0.0"})
+	if h1 != h2 {
+		t.Errorf("hash depends on order: %s != %s", h1, h2)
+	}
+	if len(h1) != 64 {
+		t.Errorf("got %q, want a hex SHA-256", h1)
+	}
+	if h3 := CorpusHash([]string{"a@v1.0.0", "b@v2.0.1"}); h3 == h1 {
+		t.Error("different corpora have the same hash")
+	}
+}
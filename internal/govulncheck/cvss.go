@@ -0,0 +1,106 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+// cvssWeights are the weights of the CVSS v3 base metrics, by metric
+// and value. The weights of PR under a changed scope are in
+// cvssPRChanged.
+var cvssWeights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"UI": {"N": 0.85, "R": 0.62},
+	"S":  {"U": 0, "C": 0},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+var cvssPRChanged = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}
+
+// CVSSBaseScore computes the base score of a CVSS v3.0 or v3.1 vector,
+// as specified in https://www.first.org/cvss/v3.1/specification-document.
+// Temporal and environmental metrics in the vector are ignored.
+func CVSSBaseScore(vector string) (float64, error) {
+	parts := strings.Split(vector, "/")
+	if parts[0] != "CVSS:3.0" && parts[0] != "CVSS:3.1" {
+		return 0, fmt.Errorf("%q: not a CVSS v3 vector", vector)
+	}
+	m := map[string]string{}
+	for _, p := range parts[1:] {
+		k, v, ok := strings.Cut(p, ":")
+		if !ok {
+			return 0, fmt.Errorf("%q: bad metric %q", vector, p)
+		}
+		if _, ok := cvssWeights[k]; !ok {
+			continue
+		}
+		if _, ok := cvssWeights[k][v]; !ok {
+			return 0, fmt.Errorf("%q: bad value for %s", vector, k)
+		}
+		m[k] = v
+	}
+	for k := range cvssWeights {
+		if _, ok := m[k]; !ok {
+			return 0, fmt.Errorf("%q: missing metric %s", vector, k)
+		}
+	}
+	w := func(k string) float64 { return cvssWeights[k][m[k]] }
+
+	changed := m["S"] == "C"
+	pr := w("PR")
+	if changed {
+		pr = cvssPRChanged[m["PR"]]
+	}
+	iss := 1 - (1-w("C"))*(1-w("I"))*(1-w("A"))
+	var impact float64
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	} else {
+		impact = 6.42 * iss
+	}
+	if impact <= 0 {
+		return 0, nil
+	}
+	exploitability := 8.22 * w("AV") * w("AC") * pr * w("UI")
+	score := impact + exploitability
+	if changed {
+		score *= 1.08
+	}
+	return cvssRoundup(math.Min(score, 10)), nil
+}
+
+// cvssRoundup returns the smallest number with one decimal place that is
+// equal to or higher than x, avoiding floating-point artifacts as
+// described in Appendix A of the CVSS v3.1 specification.
+func cvssRoundup(x float64) float64 {
+	i := int(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
+
+// cvssScore returns the base score of the first CVSS v3 severity of e
+// that can be computed. It returns false if there is none.
+func cvssScore(e *osv.Entry) (float64, bool) {
+	for _, s := range e.Severity {
+		if s.Type != osv.SeverityCVSSV3 {
+			continue
+		}
+		if score, err := CVSSBaseScore(s.Score); err == nil {
+			return score, true
+		}
+	}
+	return 0, false
+}
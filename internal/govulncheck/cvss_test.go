@@ -0,0 +1,78 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+func TestCVSSBaseScore(t *testing.T) {
+	for _, test := range []struct {
+		vector string
+		want   float64
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", 10},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:U/C:L/I:N/A:N", 4.3},
+		{"CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:N/I:N/A:H", 5.9},
+		{"CVSS:3.0/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", 7.8},
+		{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:C/C:L/I:L/A:N", 6.4},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N", 0},
+		// Temporal metrics are ignored.
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:P/RL:O", 9.8},
+	} {
+		got, err := CVSSBaseScore(test.vector)
+		if err != nil {
+			t.Fatalf("%s: %v", test.vector, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.vector, got, test.want)
+		}
+	}
+	for _, vector := range []string{
+		"",
+		"AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:2.0/AV:N/AC:L/Au:N/C:P/I:P/A:P",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H",
+		"CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+	} {
+		if _, err := CVSSBaseScore(vector); err == nil {
+			t.Errorf("%q: got nil, want error", vector)
+		}
+	}
+}
+
+func TestEnrichVulnsSeverity(t *testing.T) {
+	entries := []*osv.Entry{
+		{
+			ID:      "GO-0000-0001",
+			Summary: "s",
+			Aliases: []string{"CVE-2023-0001", "GHSA-xxxx-yyyy-zzzz"},
+			Severity: []osv.Severity{
+				{Type: "CVSS_V2", Score: "AV:N/AC:L/Au:N/C:P/I:P/A:P"},
+				{Type: osv.SeverityCVSSV3, Score: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"},
+			},
+		},
+		{ID: "GO-0000-0002"},
+	}
+	vulns := []*Vuln{{ID: "GO-0000-0001"}, {ID: "GO-0000-0002"}}
+	if missing := EnrichVulns(vulns, entries); len(missing) != 0 {
+		t.Fatalf("missing: %v", missing)
+	}
+	v := vulns[0]
+	if len(v.Aliases) != 2 || v.Aliases[0] != "CVE-2023-0001" {
+		t.Errorf("Aliases = %v", v.Aliases)
+	}
+	if !v.CVSSScore.Valid || v.CVSSScore.Float64 != 9.8 {
+		t.Errorf("CVSSScore = %v, want 9.8", v.CVSSScore)
+	}
+	v = vulns[1]
+	if v.Aliases != nil || v.CVSSScore.Valid {
+		t.Errorf("%s: got Aliases %v, CVSSScore %v; want empty", v.ID, v.Aliases, v.CVSSScore)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// DailyTableName is the table of daily aggregates of the govulncheck
+// table, one row per day and scan mode. Dashboards read it instead of
+// recomputing the aggregates from all rows.
+const DailyTableName = "govulncheck_daily"
+
+// A DailySummary aggregates the valid rows of the govulncheck table
+// created on a day, in UTC, in one scan mode.
+type DailySummary struct {
+	CreatedAt time.Time  `bigquery:"created_at"`
+	Date      civil.Date `bigquery:"date"`
+	ScanMode  string     `bigquery:"scan_mode"`
+	// Modules is the number of distinct module versions scanned, and
+	// Scans the number of rows.
+	Modules int `bigquery:"modules"`
+	Scans   int `bigquery:"scans"`
+	// Failures is the number of rows with an error category, and
+	// FailuresByCategory their number per category, most frequent first.
+	Failures           int              `bigquery:"failures"`
+	FailuresByCategory []*CategoryCount `bigquery:"failures_by_category"`
+	// VulnsCalled and VulnsImported are the sums of the vulns_called and
+	// vulns_imported columns, and ModulesCallingVulns and
+	// ModulesImportingVulns the numbers of rows where they are positive.
+	VulnsCalled           int `bigquery:"vulns_called"`
+	VulnsImported         int `bigquery:"vulns_imported"`
+	ModulesCallingVulns   int `bigquery:"modules_calling_vulns"`
+	ModulesImportingVulns int `bigquery:"modules_importing_vulns"`
+	// P50ScanSeconds and P95ScanSeconds are approximate percentiles of
+	// the scan time of rows without errors. They are null if there are
+	// none.
+	P50ScanSeconds bq.NullFloat64 `bigquery:"p50_scan_seconds"`
+	P95ScanSeconds bq.NullFloat64 `bigquery:"p95_scan_seconds"`
+}
+
+// A CategoryCount is the number of rows with an error category.
+type CategoryCount struct {
+	Category string `bigquery:"category"`
+	Count    int    `bigquery:"count"`
+}
+
+func init() {
+	s, err := bigquery.InferSchema(DailySummary{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(DailyTableName, s)
+}
+
+// UpdateDaily computes the summaries of day and writes them to the
+// govulncheck_daily table, replacing those written before, so that it
+// can be run again for a day whose rows have changed, such as by
+// invalidations.
+func UpdateDaily(ctx context.Context, c *bigquery.Client, day civil.Date) (err error) {
+	defer derrors.Wrap(&err, "UpdateDaily(%s)", day)
+	query := dailyQuery("`"+c.FullTableName(TableName)+"`", "`"+c.FullTableName(DailyTableName)+"`",
+		bigquery.TableSchema(DailyTableName))
+	start := day.In(time.UTC)
+	params := []bq.QueryParameter{
+		bigquery.Param("date", day),
+		bigquery.Param("start", start),
+		bigquery.Param("end", start.AddDate(0, 0, 1)),
+	}
+	return c.Exec(ctx, query, params...)
+}
+
+// dailyQuery returns the script that replaces the rows of the daily table
+// for the @date parameter with the summaries of the rows of the results
+// table created between the @start and @end parameters. It names the
+// columns of schema, the schema of the daily table.
+func dailyQuery(results, daily string, schema bq.Schema) string {
+	const qf = `
+		BEGIN TRANSACTION;
+		DELETE FROM %[2]s WHERE date = @date;
+		INSERT INTO %[2]s (%[6]s)
+		WITH scans AS (
+			SELECT * FROM %[1]s AS r
+			WHERE r.created_at >= @start AND r.created_at < @end
+				AND r.scan_mode NOT IN ("%[3]s", "%[4]s") AND %[5]s
+		),
+		failures AS (
+			SELECT scan_mode, ARRAY_AGG(STRUCT(error_category AS category, n AS count) ORDER BY n DESC) AS failures_by_category
+			FROM (
+				SELECT scan_mode, error_category, COUNT(*) AS n
+				FROM scans
+				WHERE error_category != ""
+				GROUP BY scan_mode, error_category
+			)
+			GROUP BY scan_mode
+		)
+		SELECT
+			CURRENT_TIMESTAMP() AS created_at,
+			@date AS date,
+			s.scan_mode,
+			COUNT(DISTINCT CONCAT(s.module_path, "@", s.version)) AS modules,
+			COUNT(*) AS scans,
+			COUNTIF(s.error_category != "") AS failures,
+			IFNULL(ANY_VALUE(f.failures_by_category), []) AS failures_by_category,
+			IFNULL(SUM(s.vulns_called), 0) AS vulns_called,
+			IFNULL(SUM(s.vulns_imported), 0) AS vulns_imported,
+			COUNTIF(s.vulns_called > 0) AS modules_calling_vulns,
+			COUNTIF(s.vulns_imported > 0) AS modules_importing_vulns,
+			APPROX_QUANTILES(IF(s.error_category = "", s.scan_seconds, NULL), 100)[SAFE_OFFSET(50)] AS p50_scan_seconds,
+			APPROX_QUANTILES(IF(s.error_category = "", s.scan_seconds, NULL), 100)[SAFE_OFFSET(95)] AS p95_scan_seconds
+		FROM scans AS s
+		LEFT JOIN failures AS f ON f.scan_mode = s.scan_mode
+		GROUP BY s.scan_mode;
+		COMMIT TRANSACTION;
+	`
+	var cols []string
+	for _, f := range schema {
+		cols = append(cols, "`"+f.Name+"`")
+	}
+	return fmt.Sprintf(qf, results, daily, ModeInvalidation, ModeTombstone,
+		notInvalidatedCondition(results, "r"), strings.Join(cols, ", "))
+}
@@ -0,0 +1,33 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"strings"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestDailyQuery(t *testing.T) {
+	schema := bq.Schema{
+		{Name: "created_at", Type: bq.TimestampFieldType},
+		{Name: "date", Type: bq.DateFieldType},
+	}
+	query := dailyQuery("`results`", "`daily`", schema)
+	for _, want := range []string{
+		"BEGIN TRANSACTION;",
+		"DELETE FROM `daily` WHERE date = @date;",
+		"INSERT INTO `daily` (`created_at`, `date`)",
+		"FROM `results` AS r",
+		`r.scan_mode NOT IN ("INVALIDATION", "TOMBSTONE")`,
+		"i.invalidates.created_at = r.created_at",
+		"COMMIT TRANSACTION;",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query does not contain %s:\n%s", want, query)
+		}
+	}
+}
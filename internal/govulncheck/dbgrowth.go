@@ -0,0 +1,55 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// A DBGrowthPoint relates the rate of modules with called vulnerabilities
+// on one day to the size of the vuln DB they were scanned with, so that
+// growth in findings can be attributed to growth of the DB or to changes
+// in the corpus.
+type DBGrowthPoint struct {
+	Day string `bigquery:"day"` // YYYY-MM-DD
+	// EntryCount is the largest vuln DB entry count of the day's rows.
+	EntryCount int `bigquery:"entry_count"`
+	NumRows    int `bigquery:"num_rows"`
+	// NumCalled is the number of rows with at least one called vuln.
+	NumCalled  int     `bigquery:"num_called"`
+	CalledRate float64 `bigquery:"called_rate"`
+}
+
+// ReadDBGrowth returns a DBGrowthPoint for each day since the given time,
+// in order, computed from successful GOVULNCHECK rows that record the
+// vuln DB entry count.
+func ReadDBGrowth(ctx context.Context, c *bigquery.Client, since time.Time) (_ []*DBGrowthPoint, err error) {
+	defer derrors.Wrap(&err, "ReadDBGrowth(%s)", since)
+
+	const qf = `
+		SELECT
+			FORMAT_DATE("%%F", DATE(created_at)) AS day,
+			MAX(vulndb_entry_count) AS entry_count,
+			COUNT(*) AS num_rows,
+			COUNTIF(ARRAY_LENGTH(vulns) > 0) AS num_called,
+			COUNTIF(ARRAY_LENGTH(vulns) > 0) / COUNT(*) AS called_rate
+		FROM %s AS r
+		WHERE scan_mode = "%s"
+			AND error = ""
+			AND %s
+			AND vulndb_entry_count IS NOT NULL
+			AND created_at >= TIMESTAMP("%s")
+		GROUP BY day
+		ORDER BY day
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, ModeGovulncheck, notInvalidatedCondition(table, "r"), since.UTC().Format(time.RFC3339))
+	return bigquery.Query[DBGrowthPoint](ctx, c, query)
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// DeadLetterTableName is the table of scan tasks that failed on their
+// last attempt, and so were dropped from the queue.
+const DeadLetterTableName = "dead_letters"
+
+// maxDeadLetterError is the maximum length of the Error of a DeadLetter.
+const maxDeadLetterError = 1000
+
+// A DeadLetter is a row of the dead_letters table.
+type DeadLetter struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	// URI is the path and query of the scan request of the task, from
+	// which it can be enqueued again.
+	URI      string `bigquery:"uri"`
+	TaskName string `bigquery:"task_name"`
+	// The module version, mode and run of the task. They are empty if
+	// the URI is not a valid scan request.
+	ModulePath string `bigquery:"module_path"`
+	Version    string `bigquery:"version"`
+	ScanMode   string `bigquery:"scan_mode"`
+	Suffix     string `bigquery:"suffix"`
+	// Attempts is the number of times the task was attempted.
+	Attempts int `bigquery:"attempts"`
+	// ErrorCategory and Error describe the error of the last attempt.
+	// Error is truncated.
+	ErrorCategory string `bigquery:"error_category"`
+	Error         string `bigquery:"error"`
+}
+
+func (d *DeadLetter) SetUploadTime(t time.Time) { d.CreatedAt = t }
+
+func init() {
+	s, err := bigquery.InferSchema(DeadLetter{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(DeadLetterTableName, s)
+}
+
+// NewDeadLetter returns the dead letter of the scan task of r, which
+// failed with err on its last attempt.
+func NewDeadLetter(r *http.Request, prefix string, attempts int, err error) *DeadLetter {
+	d := &DeadLetter{
+		URI:           r.URL.RequestURI(),
+		TaskName:      r.Header.Get("X-CloudTasks-TaskName"),
+		Attempts:      attempts,
+		ErrorCategory: derrors.CategorizeError(err),
+		Error:         err.Error(),
+	}
+	if len(d.Error) > maxDeadLetterError {
+		d.Error = d.Error[:maxDeadLetterError]
+	}
+	if sreq, err := ParseRequest(r, prefix); err == nil {
+		d.ModulePath = sreq.Module
+		d.Version = sreq.Version
+		d.ScanMode = sreq.Mode
+		d.Suffix = sreq.QueryParams.Suffix
+	}
+	return d
+}
+
+// Request returns the scan request of the task of d, which was served at
+// the path prefix.
+func (d *DeadLetter) Request(prefix string) (*Request, error) {
+	r, err := http.NewRequest(http.MethodPost, d.URI, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRequest(r, prefix)
+}
+
+// RequeueQueryParams are the query params of the
+// /govulncheck/requeue-failed endpoint.
+type RequeueQueryParams struct {
+	Category string // if non-empty, only requeue dead letters with this category
+	Suffix   string // suffix of the new run
+	// Since, if non-empty, is an RFC 3339 time before which dead letters
+	// are not requeued, such as the time the fix of their error shipped.
+	Since  string
+	DryRun bool // if true, report the tasks to requeue but don't requeue them
+}
+
+// ReadDeadLetters returns the latest dead letter of each task URI that
+// has the given error category, or any category if it is empty, and was
+// written at or after since.
+func ReadDeadLetters(ctx context.Context, c *bigquery.Client, category string, since time.Time) (_ []*DeadLetter, err error) {
+	defer derrors.Wrap(&err, "ReadDeadLetters(%q, %s)", category, since)
+
+	const qf = `
+		SELECT * EXCEPT (rownum)
+		FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY uri ORDER BY created_at DESC) AS rownum
+			FROM %s
+			WHERE created_at >= TIMESTAMP("%s")%s
+		)
+		WHERE rownum = 1
+		ORDER BY module_path, version, uri
+	`
+	var cond string
+	if category != "" {
+		cond = fmt.Sprintf(` AND error_category = "%s"`, category)
+	}
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(DeadLetterTableName)+"`", since.UTC().Format(time.RFC3339), cond)
+	return bigquery.Query[DeadLetter](ctx, c, query)
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// EventTableName is the name of the table of run events.
+const EventTableName = "govulncheck-events"
+
+// Types of events.
+const (
+	EventEnqueued         = "ENQUEUED"          // a run's modules were enqueued
+	EventRetryEnqueued    = "RETRY ENQUEUED"    // a run's errored modules were enqueued again
+	EventAnomaly          = "ANOMALY"           // a run health alert fired
+	EventCorpusMismatch   = "CORPUS MISMATCH"   // a task was enqueued from a different corpus
+	EventCorpusReconciled = "CORPUS RECONCILED" // modules removed from the corpus were tombstoned
+	EventRowsInvalidated  = "ROWS INVALIDATED"  // rows known to be wrong were invalidated; see Invalidate
+	EventCheckpoint       = "CHECKPOINT"        // part of a scan task is done; see Checkpoint
+	EventRequeued         = "REQUEUED"          // dead letters were enqueued again; see DeadLetter
+	EventCanceled         = "CANCELED"          // a run's pending tasks were canceled
+)
+
+// Severities of events.
+const (
+	SeverityInfo    = "INFO"
+	SeverityWarning = "WARNING"
+	SeverityError   = "ERROR"
+)
+
+// An Event is a row in the BigQuery govulncheck-events table.
+// It records something that happened to a run as a whole, so that
+// operators can follow a run after its logs have expired.
+type Event struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	// Suffix is the suffix of the run, or MaintenanceSuffix for events
+	// that don't belong to a run.
+	Suffix   string `bigquery:"suffix"`
+	Type     string `bigquery:"type"`
+	Severity string `bigquery:"severity"`
+	Message  string `bigquery:"message"`
+	// Payload holds details of the event, which depend on its type.
+	Payload bq.NullJSON `bigquery:"payload"`
+}
+
+func (e *Event) SetUploadTime(t time.Time) { e.CreatedAt = t }
+
+func init() {
+	s, err := bigquery.InferSchema(Event{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(EventTableName, s)
+}
+
+// NewEvent returns an event with the given fields. If payload is
+// non-nil, it is stored as JSON.
+func NewEvent(suffix, typ, severity, message string, payload any) *Event {
+	e := &Event{
+		Suffix:   suffix,
+		Type:     typ,
+		Severity: severity,
+		Message:  message,
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			// Keep the event, which is more useful than its details.
+			e.Message += fmt.Sprintf(" (payload: %v)", err)
+		} else {
+			e.Payload = bq.NullJSON{JSONVal: string(data), Valid: true}
+		}
+	}
+	return e
+}
+
+// ReadEvents returns the events of the run with the given suffix,
+// oldest first. Checkpoints, which are of no interest to operators, are
+// omitted.
+func ReadEvents(ctx context.Context, c *bigquery.Client, suffix string) (_ []*Event, err error) {
+	defer derrors.Wrap(&err, "ReadEvents(%q)", suffix)
+
+	const qf = `SELECT * FROM %s WHERE suffix = "%s" AND type != "%s" ORDER BY created_at`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(EventTableName)+"`", suffix, EventCheckpoint)
+	return bigquery.Query[Event](ctx, c, query)
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+const ExposureTableName = "govulncheck-exposure"
+
+// Exposure is a row in the BigQuery govulncheck-exposure table.
+// It records how long a module has been affected by an OSV entry,
+// according to the rows of one scan mode.
+//
+// A module can stop being affected, for example by upgrading a
+// dependency, and later be affected again. Each contiguous sequence
+// of scans that report the OSV is an episode.
+type Exposure struct {
+	ModulePath string `bigquery:"module_path"`
+	ScanMode   string `bigquery:"scan_mode"`
+	OSVID      string `bigquery:"osv_id"`
+	// FirstSeen and LastSeen are the creation times of the first
+	// and last rows that report the OSV.
+	FirstSeen time.Time `bigquery:"first_seen"`
+	LastSeen  time.Time `bigquery:"last_seen"`
+	// FirstVersion is the version of the module in the first row
+	// that reports the OSV.
+	FirstVersion string `bigquery:"first_version"`
+	// Affected reports whether the latest row still reports the OSV.
+	Affected bool `bigquery:"affected"`
+	// Episodes is the number of episodes.
+	Episodes int `bigquery:"episodes"`
+	// ExposureSeconds is the sum of the durations of the episodes.
+	// An episode lasts from its first row to its last one.
+	ExposureSeconds int64     `bigquery:"exposure_seconds"`
+	UpdatedAt       time.Time `bigquery:"updated_at"`
+}
+
+func init() {
+	s, err := bigquery.InferSchema(Exposure{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(ExposureTableName, s)
+}
+
+// exposureQuery computes the exposures of all modules from the
+// govulncheck table. Ad hoc scans and rows with errors, which say
+// nothing about the module's vulns, are ignored.
+const exposureQuery = `
+	WITH scans AS (
+		SELECT module_path, scan_mode, version, created_at, vulns
+		FROM %[1]s AS r
+		WHERE scan_mode IN ("GOVULNCHECK", "IMPORTS")
+			AND NOT STARTS_WITH(suffix, "%[2]s")
+			AND error_category = ""
+			AND %[3]s
+	),
+	osvs AS (
+		SELECT DISTINCT module_path, scan_mode, v.id AS osv_id
+		FROM scans, UNNEST(vulns) AS v
+	),
+	presence AS (
+		SELECT s.module_path, s.scan_mode, o.osv_id, s.version, s.created_at,
+			EXISTS(SELECT 1 FROM UNNEST(s.vulns) AS v WHERE v.id = o.osv_id) AS present
+		FROM scans AS s JOIN osvs AS o USING (module_path, scan_mode)
+	),
+	starts AS (
+		SELECT *,
+			IF(present AND NOT IFNULL(LAG(present) OVER w, FALSE), 1, 0) AS start,
+			ROW_NUMBER() OVER (PARTITION BY module_path, scan_mode, osv_id ORDER BY created_at DESC) AS recency
+		FROM presence
+		WINDOW w AS (PARTITION BY module_path, scan_mode, osv_id ORDER BY created_at)
+	),
+	episodes AS (
+		SELECT *, SUM(start) OVER (PARTITION BY module_path, scan_mode, osv_id ORDER BY created_at) AS episode
+		FROM starts
+	),
+	durations AS (
+		SELECT module_path, scan_mode, osv_id,
+			SUM(seconds) AS exposure_seconds
+		FROM (
+			SELECT module_path, scan_mode, osv_id, episode,
+				TIMESTAMP_DIFF(MAX(created_at), MIN(created_at), SECOND) AS seconds
+			FROM episodes
+			WHERE present
+			GROUP BY module_path, scan_mode, osv_id, episode
+		)
+		GROUP BY module_path, scan_mode, osv_id
+	)
+	SELECT
+		module_path, scan_mode, osv_id,
+		MIN(IF(present, created_at, NULL)) AS first_seen,
+		MAX(IF(present, created_at, NULL)) AS last_seen,
+		ARRAY_AGG(IF(present, version, NULL) IGNORE NULLS ORDER BY created_at LIMIT 1)[OFFSET(0)] AS first_version,
+		LOGICAL_OR(recency = 1 AND present) AS affected,
+		MAX(episode) AS episodes,
+		ANY_VALUE(d.exposure_seconds) AS exposure_seconds,
+		CURRENT_TIMESTAMP() AS updated_at
+	FROM episodes JOIN durations AS d USING (module_path, scan_mode, osv_id)
+	GROUP BY module_path, scan_mode, osv_id
+`
+
+// UpdateExposures recomputes the govulncheck-exposure table from the
+// govulncheck table, and merges the result into it. It is meant to be
+// run periodically.
+//
+// Exposures are recomputed from all rows, rather than updated from new
+// ones, so that episodes are correct no matter the order in which rows
+// were uploaded.
+func UpdateExposures(ctx context.Context, c *bigquery.Client) (err error) {
+	defer derrors.Wrap(&err, "UpdateExposures")
+
+	const qf = `
+		MERGE %s AS t
+		USING (%s) AS e
+		ON t.module_path = e.module_path AND t.scan_mode = e.scan_mode AND t.osv_id = e.osv_id
+		WHEN MATCHED THEN UPDATE SET
+			first_seen = e.first_seen, last_seen = e.last_seen, first_version = e.first_version,
+			affected = e.affected, episodes = e.episodes, exposure_seconds = e.exposure_seconds,
+			updated_at = e.updated_at
+		WHEN NOT MATCHED BY TARGET THEN INSERT ROW
+		WHEN NOT MATCHED BY SOURCE THEN DELETE
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	source := fmt.Sprintf(exposureQuery, table, AdHocSuffixPrefix, notInvalidatedCondition(table, "r"))
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(ExposureTableName)+"`", source)
+	return c.Exec(ctx, query)
+}
+
+// ReadExposures returns the exposures of the module to OSV entries,
+// ordered by scan mode and OSV ID.
+func ReadExposures(ctx context.Context, c *bigquery.Client, modulePath string) (_ []*Exposure, err error) {
+	defer derrors.Wrap(&err, "ReadExposures(%q)", modulePath)
+
+	const qf = `SELECT * FROM %s WHERE module_path = @module_path ORDER BY scan_mode, osv_id`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(ExposureTableName)+"`")
+	return bigquery.Query[Exposure](ctx, c, query, bigquery.Param("module_path", modulePath))
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// extractBlobName is the name in the header of the blobs that
+// "govulncheck -mode=extract" writes.
+const extractBlobName = "govulncheck-extract"
+
+// IsExtractBlob reports whether the file at path is a blob written by
+// "govulncheck -mode=extract". govulncheck scans such blobs in binary
+// mode as it would the binaries they were extracted from, without the
+// binaries themselves.
+func IsExtractBlob(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var header struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	// A binary is not JSON, so any decoding error means that the file
+	// is not a blob.
+	if err := json.NewDecoder(f).Decode(&header); err != nil {
+		return false, nil
+	}
+	return header.Name == extractBlobName, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsExtractBlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		contents string
+		want     bool
+	}{
+		{`{"name":"govulncheck-extract","version":"0.1.0"}` + "\n" + `{"modules":[]}`, true},
+		{`{"name":"something-else","version":"0.1.0"}`, false},
+		{"\x7fELF\x02\x01\x01", false},
+		{"", false},
+	} {
+		path := filepath.Join(dir, "blob")
+		if err := os.WriteFile(path, []byte(test.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := IsExtractBlob(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%q: got %t, want %t", test.contents, got, test.want)
+		}
+	}
+	if _, err := IsExtractBlob(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing file: got nil, want error")
+	}
+}
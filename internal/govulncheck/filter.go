@@ -0,0 +1,99 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+// An OSVFilter selects findings by OSV ID.
+//
+// If the allowlist is non-empty, only findings whose ID is on it are kept.
+// Findings whose ID is on the denylist are always dropped.
+type OSVFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// ParseOSVFilter parses a filter specification. The specification is a list
+// of OSV IDs separated by commas or white space. An ID preceded by "-" is
+// added to the denylist; any other ID is added to the allowlist.
+// Lines beginning with "#" are ignored, so the specification can be the
+// contents of a file.
+//
+// It returns nil if the specification contains no IDs.
+func ParseOSVFilter(spec string) (*OSVFilter, error) {
+	f := &OSVFilter{allow: map[string]bool{}, deny: map[string]bool{}}
+	for _, line := range strings.Split(spec, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, id := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' }) {
+			if deny, ok := strings.CutPrefix(id, "-"); ok {
+				if deny == "" {
+					return nil, fmt.Errorf("invalid OSV filter entry %q", id)
+				}
+				f.deny[deny] = true
+			} else {
+				f.allow[id] = true
+			}
+		}
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Keep reports whether a finding with the given OSV ID passes the filter.
+// A nil filter keeps everything.
+func (f *OSVFilter) Keep(id string) bool {
+	if f == nil {
+		return true
+	}
+	if f.deny[id] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[id]
+}
+
+// Filter returns the findings that pass the filter, along with
+// the number of findings that were dropped.
+func (f *OSVFilter) Filter(findings []*govulncheckapi.Finding) (_ []*govulncheckapi.Finding, dropped int) {
+	if f == nil {
+		return findings, 0
+	}
+	var kept []*govulncheckapi.Finding
+	for _, fi := range findings {
+		if f.Keep(fi.OSV) {
+			kept = append(kept, fi)
+		} else {
+			dropped++
+		}
+	}
+	return kept, dropped
+}
+
+// Hash returns a string that identifies the filter, for use in
+// a WorkVersion. A nil filter hashes to the empty string.
+func (f *OSVFilter) Hash() string {
+	if f == nil {
+		return ""
+	}
+	allow := maps.Keys(f.allow)
+	deny := maps.Keys(f.deny)
+	sort.Strings(allow)
+	sort.Strings(deny)
+	h := sha256.New()
+	fmt.Fprintf(h, "allow:%s\ndeny:%s\n", strings.Join(allow, ","), strings.Join(deny, ","))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
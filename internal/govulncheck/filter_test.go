@@ -0,0 +1,57 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+func TestOSVFilter(t *testing.T) {
+	findings := []*govulncheckapi.Finding{{OSV: "A"}, {OSV: "B"}, {OSV: "C"}}
+	for _, test := range []struct {
+		spec        string
+		wantKept    string
+		wantDropped int
+	}{
+		{"", "ABC", 0},
+		{"# comment only\n", "ABC", 0},
+		{"A,B", "AB", 1},
+		{"-B", "AC", 1},
+		{"A B\n-A", "B", 2},
+		{"# allow\nC\n# deny\n-A\n", "C", 2},
+	} {
+		f, err := ParseOSVFilter(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept, dropped := f.Filter(findings)
+		got := ""
+		for _, k := range kept {
+			got += k.OSV
+		}
+		if got != test.wantKept || dropped != test.wantDropped {
+			t.Errorf("%q: got %q, %d dropped; want %q, %d dropped", test.spec, got, dropped, test.wantKept, test.wantDropped)
+		}
+	}
+
+	if _, err := ParseOSVFilter("A,-"); err == nil {
+		t.Error("got nil error for bad entry")
+	}
+
+	f1, _ := ParseOSVFilter("A,B,-C")
+	f2, _ := ParseOSVFilter("-C\nB A")
+	f3, _ := ParseOSVFilter("A,B")
+	if f1.Hash() != f2.Hash() {
+		t.Error("equivalent filters have different hashes")
+	}
+	if f1.Hash() == f3.Hash() {
+		t.Error("different filters have the same hash")
+	}
+	if got := (*OSVFilter)(nil).Hash(); got != "" {
+		t.Errorf("nil filter hash: got %q, want empty", got)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+// FingerprintVersion is the version of the recipe of Fingerprint, stored
+// with each fingerprint. It must be incremented whenever the recipe
+// changes, so that fingerprints computed by different recipes are never
+// compared.
+const FingerprintVersion = 1
+
+// Fingerprint returns a stable identifier of the finding f, so that the
+// same finding can be joined across rows and runs.
+//
+// The fingerprint is the hex-encoded SHA-256 hash of these lines, each
+// terminated by a newline:
+//
+//	fingerprint/FingerprintVersion
+//	the OSV ID
+//	the module path of the vulnerable symbol
+//	the package path of the vulnerable symbol
+//	the entry function
+//	the vulnerable symbol
+//
+// The vulnerable symbol is the first frame of the trace, and the entry
+// function the last, if the trace has more than one frame. A function is
+// written as [Receiver.]Function, where a pointer receiver is written
+// without its '*', and the entry function is qualified by its package
+// path. Missing parts are empty lines.
+//
+// Positions and versions are left out, so that a finding keeps its
+// fingerprint when code moves or a module is upgraded without fixing
+// it, as are the frames between the entry and the vulnerable symbol,
+// which depend on how traces are computed and normalized.
+func Fingerprint(f *govulncheckapi.Finding) string {
+	var vuln, entry *govulncheckapi.Frame
+	if len(f.Trace) > 0 {
+		vuln = f.Trace[0]
+	} else {
+		vuln = &govulncheckapi.Frame{}
+	}
+	var entryFunc string
+	if len(f.Trace) > 1 {
+		entry = f.Trace[len(f.Trace)-1]
+		if fn := frameFunc(entry); fn != "" {
+			entryFunc = entry.Package + "." + fn
+		}
+	}
+	h := sha256.New()
+	for _, s := range []string{
+		fmt.Sprintf("fingerprint/%d", FingerprintVersion),
+		f.OSV,
+		vuln.Module,
+		vuln.Package,
+		entryFunc,
+		frameFunc(vuln),
+	} {
+		fmt.Fprintln(h, s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// frameFunc returns the function of fr as [Receiver.]Function, or the
+// empty string if it has none.
+func frameFunc(fr *govulncheckapi.Frame) string {
+	if fr.Function == "" {
+		return ""
+	}
+	if r := strings.TrimPrefix(fr.Receiver, "*"); r != "" {
+		return r + "." + fr.Function
+	}
+	return fr.Function
+}
+
+// DiffFingerprints returns the sorted fingerprints of the findings of
+// current that stored doesn't have, and of those of stored that current
+// doesn't have. If the vulns of either row lack fingerprints of the
+// current recipe, it returns nil, nil, since they can't be compared.
+func DiffFingerprints(stored, current *Result) (added, removed []string) {
+	fps := func(r *Result) map[string]bool {
+		m := map[string]bool{}
+		for _, v := range r.Vulns {
+			if !v.Fingerprint.Valid || v.FingerprintVersion.Int64 != FingerprintVersion {
+				return nil
+			}
+			m[v.Fingerprint.StringVal] = true
+		}
+		return m
+	}
+	s, c := fps(stored), fps(current)
+	if s == nil || c == nil {
+		return nil, nil
+	}
+	for fp := range c {
+		if !s[fp] {
+			added = append(added, fp)
+		}
+	}
+	for fp := range s {
+		if !c[fp] {
+			removed = append(removed, fp)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// A FindingSighting records when a finding was reported for a module.
+type FindingSighting struct {
+	ScanMode           string `bigquery:"scan_mode"`
+	OSVID              string `bigquery:"osv_id"`
+	Fingerprint        string `bigquery:"fingerprint"`
+	FingerprintVersion int    `bigquery:"fingerprint_version"`
+	// FirstSeen and LastSeen are the creation times of the first and
+	// last rows that report the finding.
+	FirstSeen time.Time `bigquery:"first_seen"`
+	LastSeen  time.Time `bigquery:"last_seen"`
+}
+
+// ReadFindingSightings returns when each finding with a fingerprint was
+// first and last reported for the module, according to the rows used
+// for exposures, ordered by scan mode, OSV ID and first sighting.
+func ReadFindingSightings(ctx context.Context, c *bigquery.Client, modulePath string) (_ []*FindingSighting, err error) {
+	defer derrors.Wrap(&err, "ReadFindingSightings(%q)", modulePath)
+
+	const qf = `
+		SELECT
+			scan_mode, v.id AS osv_id, v.fingerprint, v.fingerprint_version,
+			MIN(created_at) AS first_seen, MAX(created_at) AS last_seen
+		FROM %s AS r, UNNEST(vulns) AS v
+		WHERE module_path = @module_path
+			AND scan_mode IN ("GOVULNCHECK", "IMPORTS")
+			AND NOT STARTS_WITH(suffix, "%s")
+			AND error_category = ""
+			AND v.fingerprint IS NOT NULL
+			AND %s
+		GROUP BY scan_mode, osv_id, fingerprint, fingerprint_version
+		ORDER BY scan_mode, osv_id, first_seen
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, AdHocSuffixPrefix, notInvalidatedCondition(table, "r"))
+	return bigquery.Query[FindingSighting](ctx, c, query, bigquery.Param("module_path", modulePath))
+}
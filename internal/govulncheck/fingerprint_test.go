@@ -0,0 +1,85 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+func TestFingerprint(t *testing.T) {
+	finding := func(osv, symbol, receiver, entry string, line int) *govulncheckapi.Finding {
+		return &govulncheckapi.Finding{
+			OSV: osv,
+			Trace: []*govulncheckapi.Frame{
+				{Module: "example.com/dep", Version: "v1.0.0", Package: "example.com/dep/p", Function: symbol, Receiver: receiver},
+				{Module: "example.com/m", Package: "example.com/m/q", Function: "helper", Position: &govulncheckapi.Position{Line: line}},
+				{Module: "example.com/m", Package: "example.com/m", Function: entry, Position: &govulncheckapi.Position{Line: line}},
+			},
+		}
+	}
+	base := finding("GO-1", "F", "*T", "main", 10)
+	fp := Fingerprint(base)
+
+	// The recipe of a FingerprintVersion must not change.
+	if want := "1dc761c5afe5cd5faee8aa21bff5b180b547e6dc5d3576a7936419372c8b3f2d"; FingerprintVersion == 1 && fp != want {
+		t.Errorf("got %s, want %s", fp, want)
+	}
+
+	// Positions, versions, pointer receivers and intermediate frames
+	// don't matter.
+	same := finding("GO-1", "F", "T", "main", 20)
+	same.Trace[0].Version = "v1.1.0"
+	same.Trace[1].Function = "other"
+	if got := Fingerprint(same); got != fp {
+		t.Errorf("same finding: got %s, want %s", got, fp)
+	}
+	short := finding("GO-1", "F", "*T", "main", 10)
+	short.Trace = []*govulncheckapi.Frame{short.Trace[0], short.Trace[2]}
+	if got := Fingerprint(short); got != fp {
+		t.Errorf("shorter trace: got %s, want %s", got, fp)
+	}
+
+	// The OSV, symbol and entry do.
+	for _, f := range []*govulncheckapi.Finding{
+		finding("GO-2", "F", "*T", "main", 10),
+		finding("GO-1", "G", "*T", "main", 10),
+		finding("GO-1", "F", "", "main", 10),
+		finding("GO-1", "F", "*T", "init", 10),
+	} {
+		if got := Fingerprint(f); got == fp {
+			t.Errorf("%s %s.%s from %s: got the fingerprint of the base finding", f.OSV,
+				f.Trace[0].Receiver, f.Trace[0].Function, f.Trace[2].Function)
+		}
+	}
+}
+
+func TestDiffFingerprints(t *testing.T) {
+	vuln := func(fp string) *Vuln {
+		return &Vuln{Fingerprint: bigquery.NullString(fp), FingerprintVersion: bigquery.NullInt(FingerprintVersion)}
+	}
+	stored := &Result{Vulns: []*Vuln{vuln("a"), vuln("b"), vuln("c")}}
+	current := &Result{Vulns: []*Vuln{vuln("d"), vuln("b"), vuln("a")}}
+	added, removed := DiffFingerprints(stored, current)
+	if want := []string{"d"}; !cmp.Equal(added, want) {
+		t.Errorf("added: got %v, want %v", added, want)
+	}
+	if want := []string{"c"}; !cmp.Equal(removed, want) {
+		t.Errorf("removed: got %v, want %v", removed, want)
+	}
+
+	// Rows written before fingerprints, or with another recipe, can't
+	// be compared.
+	old := &Result{Vulns: []*Vuln{{ID: "GO-1"}}}
+	other := &Result{Vulns: []*Vuln{{Fingerprint: bigquery.NullString("a"), FingerprintVersion: bigquery.NullInt(FingerprintVersion + 1)}}}
+	for _, r := range []*Result{old, other} {
+		if added, removed := DiffFingerprints(r, current); added != nil || removed != nil {
+			t.Errorf("got %v, %v; want nil, nil", added, removed)
+		}
+	}
+}
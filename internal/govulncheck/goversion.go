@@ -0,0 +1,42 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// GoVersionSemver returns the semantic version of the Go version v, as
+// reported by "go env GOVERSION" or written in go and toolchain
+// directives: "go1.21.3", "1.21" and "go1.21rc1" become "v1.21.3",
+// "v1.21.0" and "v1.21.0-rc1". Anything after a space, as in
+// "go1.21.3 X:boringcrypto", is ignored.
+func GoVersionSemver(v string) (string, error) {
+	orig := v
+	v, _, _ = strings.Cut(v, " ")
+	v = strings.TrimPrefix(v, "go")
+	pre := ""
+	for _, p := range []string{"rc", "beta"} {
+		if i := strings.Index(v, p); i >= 0 {
+			v, pre = v[:i], "-"+v[i:]
+			break
+		}
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", fmt.Errorf("%q is not a Go version", orig)
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	sv := "v" + strings.Join(parts, ".") + pre
+	if !semver.IsValid(sv) {
+		return "", fmt.Errorf("%q is not a Go version", orig)
+	}
+	return sv, nil
+}
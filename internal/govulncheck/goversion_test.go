@@ -0,0 +1,33 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import "testing"
+
+func TestGoVersionSemver(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"go1.21.3", "v1.21.3"},
+		{"1.21.3", "v1.21.3"},
+		{"1.21", "v1.21.0"},
+		{"go1.21rc1", "v1.21.0-rc1"},
+		{"go1.20beta2", "v1.20.0-beta2"},
+		{"go1.21.3 X:boringcrypto", "v1.21.3"},
+	} {
+		got, err := GoVersionSemver(test.in)
+		if err != nil {
+			t.Fatalf("%q: %v", test.in, err)
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+	for _, in := range []string{"", "go", "1", "go1.21.3.4", "devel go1.22-abcdef", "go1.x"} {
+		if got, err := GoVersionSemver(in); err == nil {
+			t.Errorf("%q: got %q, want error", in, got)
+		}
+	}
+}
@@ -9,10 +9,13 @@ package govulncheck
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
@@ -24,7 +27,9 @@ import (
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/osv"
 	"golang.org/x/pkgsite-metrics/internal/scan"
+	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
 const (
@@ -34,33 +39,146 @@ const (
 	// ModeGovulncheck runs the govulncheck binary in default (source) mode.
 	ModeGovulncheck = "GOVULNCHECK"
 
+	// ModeImports runs the govulncheck binary in source mode at the level
+	// of imported packages, without call graph analysis. GOVULNCHECK scans
+	// also write rows in this mode, copying their own results.
+	ModeImports = "IMPORTS"
+
 	// FlagBinary is the flag passed to govulncheck to run in binary mode.
 	FlagBinary = "binary"
 
 	// FlagSource is the flag passed to govulncheck to run in source mode.
 	FlagSource = "source"
+
+	// FlagImports is passed to RunGovulncheckCmd instead of a govulncheck
+	// mode flag to run govulncheck in source mode at package level.
+	FlagImports = "imports"
 )
 
 // EnqueueQueryParams for govulncheck/enqueue.
 type EnqueueQueryParams struct {
-	Suffix string // appended to task queue IDs to generate unique tasks
-	Mode   string // type of analysis to run
-	Min    int    // minimum import-by count for a module to be included
-	File   string // path to file containing modules; if missing, use DB
+	Suffix  string // appended to task queue IDs to generate unique tasks
+	Mode    string // type of analysis to run
+	Min     int    // minimum import-by count for a module to be included
+	File    string // path to file containing modules; if missing, use DB
+	NoMajor bool   // if true, don't probe for higher major versions of modules
+	Spread  int    // if positive, spread task dispatch over this many minutes
+	Start   string // if non-empty, no task is dispatched before this RFC 3339 time
+	DryRun  bool   // if true, create tasks but don't enqueue them
+
+	// Scans of a run that is behind schedule can be deferred; see
+	// DeferThreshold. That is off unless Deadline and DeferBelow are set.
+	Deadline     string // soft deadline of the run, in RFC 3339 format
+	DeferBelow   int    // highest imported-by count below which scans may be deferred
+	DeferRefresh int    // minutes between recomputations of the deferral threshold
 }
 
 // Request contains information passed to a scan endpoint.
 type Request struct {
 	scan.ModuleURLPath
 	QueryParams
+	// ParamsVersion is the version of the query params of the request.
+	// It is 0 for tasks enqueued before params were versioned.
+	ParamsVersion int
+	// UnknownParams are the query params that aren't fields of QueryParams,
+	// most likely because the request was formatted by a newer version.
+	UnknownParams []string
+	// WorkVersionHash is the hash of the work version of the worker that
+	// enqueued the request; see WorkVersion.Hash. It is not a query param.
+	// If it is set, the request has an idempotency key.
+	WorkVersionHash string
 }
 
+// ParamsVersion is the version of QueryParams. Increment it when a change
+// to QueryParams would make a worker misinterpret tasks formatted by an
+// older or newer version, for example when the default of a param changes,
+// and have ParseRequest apply the appropriate defaults for older versions.
+//
+// Adding a param doesn't need a new version: workers ignore unknown params,
+// and treat missing params as having their zero values.
+const ParamsVersion = 1
+
 // QueryParams has query parameters for a govulncheck scan request.
 type QueryParams struct {
 	ImportedBy int    // imported-by count
 	Mode       string // govulncheck mode
 	Insecure   bool   // if true, run outside sandbox
 	Serve      bool   // serve results back to client instead of writing them to BigQuery
+	Format     string // format of served results; see ValidateFormat
+	Progress   bool   // with Serve, stream progress and then results as server-sent events
+	OSV        string // OSV filter overriding the configured one; see ParseOSVFilter
+	NoMajor    bool   // if true, don't replace the module with its highest major version
+	BasePath   string // module path requested before major-version probing, if any
+	Suffix     string // identifies the run; see ValidateSuffix
+	// MaxFindings overrides the configured maximum number of findings
+	// processed per scan, if positive.
+	MaxFindings int
+	// NoVendor makes govulncheck ignore the module's vendor directory
+	// and analyze its module graph instead (-mod=mod).
+	NoVendor bool
+	// VendorCompare scans vendored modules both with and without their
+	// vendor directory, and records whether the results differ.
+	VendorCompare bool
+	// DropReplaces drops replace directives with local paths from the
+	// module's go.mod and scans it anyway, instead of failing.
+	DropReplaces bool
+	// Shadow scans the module even if its work version is unchanged, and
+	// instead of writing the row, writes how it differs from the stored
+	// row to the govulncheck-shadow table.
+	Shadow bool
+	// ToolVersion selects an installed version of govulncheck to run
+	// instead of the configured one.
+	ToolVersion string
+	// CorpusHash is the hash of the modules enqueued for the run;
+	// see CorpusHash.
+	CorpusHash string
+	// VulnDBs is a comma-separated list of the directories of the vuln
+	// DBs to use instead of the configured ones, in order. Each must be
+	// allowed by the worker's configuration.
+	VulnDBs string
+	// Platforms is a comma-separated list of GOOS/GOARCH pairs to scan
+	// the module for, each in its own scan; see ParsePlatforms. If it is
+	// empty, the module is scanned for the worker's platform.
+	Platforms string
+	// PerPackage scans each package of the module with its own run of
+	// govulncheck, so that packages that don't build don't fail the
+	// scan; see RunGovulncheckPerPackage.
+	PerPackage bool
+	// Tags is a comma-separated list of build tags to load the module's
+	// packages with; see ParseTags.
+	Tags string
+	// GoFlags is a space-separated list of other flags for the go
+	// command, as in GOFLAGS; see ParseGoFlags.
+	GoFlags string
+	// IncludeTests makes govulncheck analyze the module's test files too
+	// (-test), so that vulnerabilities reachable only from tests are
+	// found.
+	IncludeTests bool
+	// Pattern selects the packages of the module to scan, like "./cmd/..."
+	// or an import path in the module; see ValidatePattern. If it is
+	// empty, all of them are scanned. It lets a large module be split
+	// into several scans.
+	Pattern string
+	// GoPrivate is a comma-separated list of module path patterns, as in
+	// GOPRIVATE. Modules matching them are fetched from the configured
+	// private proxy instead of the public one, and not checked against
+	// the checksum database.
+	GoPrivate string
+	// Netrc is the name of the secret holding the netrc file with the
+	// credentials for the private proxy. It must be one of the secrets
+	// allowed by the worker's configuration.
+	Netrc string
+	// GoVersion selects the installed Go toolchain to scan the module
+	// with by its Go version, like "go1.21.3". If it is empty, the
+	// module's go and toolchain directives select it.
+	GoVersion string
+	// Blob is the name of a blob extracted from a binary of the module
+	// with "govulncheck -mode=extract", to be scanned in EXTRACT mode.
+	Blob string
+	// Attempt is the number of the attempt to scan the module, counting
+	// from 1. It is set when a scan that failed with a transient error
+	// is enqueued again; 0 means the first attempt.
+	Attempt int
 }
 
 // The below methods implement queue.Task.
@@ -70,7 +188,34 @@ func (r *Request) Name() string { return r.Module + "@" + r.Version }
 func (r *Request) Path() string { return r.ModuleURLPath.Path() }
 
 func (r *Request) Params() string {
-	return scan.FormatParams(r.QueryParams)
+	return scan.FormatParamsVersion(r.QueryParams, ParamsVersion)
+}
+
+// Priority implements queue.PriorityTask.
+func (r *Request) Priority() int { return r.ImportedBy }
+
+// IdempotencyKey implements queue.IdempotentTask. The key is made of
+// the module version, the query params other than Suffix and CorpusHash,
+// which include the mode, and WorkVersionHash, so that a scan of the same
+// work enqueued by another run is a duplicate. Retries, which have a
+// different Attempt, are not. It is empty if WorkVersionHash is.
+func (r *Request) IdempotencyKey() string {
+	if r.WorkVersionHash == "" {
+		return ""
+	}
+	qp := r.QueryParams
+	qp.Suffix = ""
+	qp.CorpusHash = ""
+	return r.Path() + "?" + scan.FormatParamsVersion(qp, ParamsVersion) + "#" + r.WorkVersionHash
+}
+
+// ScanMode implements queue.ModeTask. Requests without a mode are
+// GOVULNCHECK scans.
+func (r *Request) ScanMode() string {
+	if r.Mode == "" {
+		return ModeGovulncheck
+	}
+	return strings.ToUpper(r.Mode)
 }
 
 // ParseRequest parses an http request r for an endpoint
@@ -82,43 +227,166 @@ func (r *Request) Params() string {
 //   - <module>/@latest
 //
 // (These are the same forms that the module proxy accepts.)
-func ParseRequest(r *http.Request, prefix string) (*Request, error) {
+//
+// The version, unless it is "latest", is canonicalized; see
+// version.Canonical. Rows of the same version must be stored with the
+// same version string, or they won't join.
+//
+// Query params that aren't fields of QueryParams are not an error;
+// they are recorded in the UnknownParams field of the result.
+//
+// Errors are *scan.RequestErrors.
+func ParseRequest(r *http.Request, prefix string) (_ *Request, err error) {
+	defer func() { scan.SetExample(err, prefix+"/golang.org/x/text@v0.3.0?importedby=10") }()
+
 	mp, err := scan.ParseModuleURLPath(strings.TrimPrefix(r.URL.Path, prefix))
 	if err != nil {
 		return nil, err
 	}
+	if mp.Version != version.Latest {
+		v, err := version.Canonical(mp.Version)
+		if err != nil {
+			return nil, scan.NewRequestError(scan.ErrBadModulePath, "", "invalid path %q: %v", r.URL.Path, err)
+		}
+		mp.Version = v
+	}
 
 	rp := QueryParams{ImportedBy: -1}
 	if err := scan.ParseParams(r, &rp); err != nil {
 		return nil, err
 	}
 	if rp.ImportedBy < 0 {
-		return nil, errors.New(`missing or negative "importedby" query param`)
+		return nil, scan.NewRequestError(scan.ErrMissingParam, "importedby", `missing or negative "importedby" query param`)
+	}
+	if err := ValidateSuffix(rp.Suffix); err != nil {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "suffix", "%v", err)
+	}
+	if _, err := ParsePlatforms(rp.Platforms); err != nil {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "platforms", "%v", err)
+	}
+	if _, err := ParseTags(rp.Tags); err != nil {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "tags", "%v", err)
+	}
+	if _, err := ParseGoFlags(rp.GoFlags); err != nil {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "goflags", "%v", err)
+	}
+	if err := ValidatePattern(mp.Module, rp.Pattern); err != nil {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "pattern", "%v", err)
+	}
+	if err := ValidateGoPrivate(rp.GoPrivate); err != nil {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "goprivate", "%v", err)
+	}
+	if err := ValidateFormat(rp.Format); err != nil {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "format", "%v", err)
+	}
+	if rp.GoVersion != "" {
+		if _, err := GoVersionSemver(rp.GoVersion); err != nil {
+			return nil, scan.NewRequestError(scan.ErrBadParam, "goversion", "%v", err)
+		}
+	}
+	pv, err := scan.ParseParamsVersion(r)
+	if err != nil {
+		return nil, err
 	}
 	return &Request{
 		ModuleURLPath: mp,
 		QueryParams:   rp,
+		ParamsVersion: pv,
+		UnknownParams: scan.UnknownParams(r, &rp),
 	}, nil
 }
 
+// AdHocSuffixPrefix begins the suffix of scans that are not part of
+// an enqueued run.
+const AdHocSuffixPrefix = "adhoc-"
+
+// AdHocSuffix returns the suffix for ad hoc scans requested at t.
+func AdHocSuffix(t time.Time) string {
+	return AdHocSuffixPrefix + t.UTC().Format("20060102")
+}
+
+// IsAdHocSuffix reports whether suffix identifies ad hoc scans.
+func IsAdHocSuffix(suffix string) bool {
+	return strings.HasPrefix(suffix, AdHocSuffixPrefix)
+}
+
+const maxSuffixLen = 64
+
+// ValidateSuffix checks that a run suffix is at most 64 characters long and
+// consists of ASCII letters, digits, '-', '_' and '.'. The empty suffix is
+// valid.
+func ValidateSuffix(suffix string) error {
+	if len(suffix) > maxSuffixLen {
+		return fmt.Errorf("suffix %q is longer than %d characters", suffix, maxSuffixLen)
+	}
+	for _, r := range suffix {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("suffix %q contains invalid character %q", suffix, r)
+		}
+	}
+	return nil
+}
+
 // ConvertGovulncheckFinding takes a finding from govulncheck and converts it to
 // a bigquery vuln.
 func ConvertGovulncheckFinding(f *govulncheckapi.Finding) *Vuln {
 	vulnerableFrame := f.Trace[0]
 	vuln := &Vuln{
-		ID:          f.OSV,
-		PackagePath: vulnerableFrame.Package,
-		ModulePath:  vulnerableFrame.Module,
-		Version:     vulnerableFrame.Version,
-		Called:      false,
+		ID:                 f.OSV,
+		PackagePath:        vulnerableFrame.Package,
+		ModulePath:         vulnerableFrame.Module,
+		Version:            vulnerableFrame.Version,
+		Called:             false,
+		Fingerprint:        bigquery.NullString(Fingerprint(f)),
+		FingerprintVersion: bigquery.NullInt(FingerprintVersion),
+	}
+	if f.FixedVersion != "" {
+		vuln.FixedVersion = bigquery.NullString(f.FixedVersion)
 	}
-	if vulnerableFrame.Function != "" {
+	switch {
+	case vulnerableFrame.Function != "":
 		vuln.Called = true
+		vuln.Level = bigquery.NullString(LevelSymbol)
+	case vulnerableFrame.Package != "":
+		vuln.Level = bigquery.NullString(LevelPackage)
+	default:
+		vuln.Level = bigquery.NullString(LevelModule)
 	}
 
 	return vuln
 }
 
+// EnrichVulns sets the fields of vulns that come from their OSV entries,
+// which are looked up in entries. A vuln whose entry is missing
+// from entries or has been withdrawn is marked WithdrawnOrMissing
+// and its enriched fields are left null. EnrichVulns returns the
+// IDs of those vulns.
+func EnrichVulns(vulns []*Vuln, entries []*osv.Entry) (missing []string) {
+	byID := make(map[string]*osv.Entry, len(entries))
+	for _, e := range entries {
+		byID[e.ID] = e
+	}
+	for _, v := range vulns {
+		e := byID[v.ID]
+		if e == nil || e.Withdrawn != nil {
+			v.WithdrawnOrMissing = bigquery.NullBool(true)
+			missing = append(missing, v.ID)
+			continue
+		}
+		v.WithdrawnOrMissing = bigquery.NullBool(false)
+		if e.Summary != "" {
+			v.Summary = bigquery.NullString(e.Summary)
+		}
+		v.Aliases = e.Aliases
+		if score, ok := cvssScore(e); ok {
+			v.CVSSScore = bigquery.NullFloat(score)
+		}
+	}
+	return missing
+}
+
 const TableName = "govulncheck"
 
 // Note: before modifying Result or Vuln, make sure the change
@@ -127,6 +395,8 @@ const TableName = "govulncheck"
 //   - adding a nullable or repeated column
 //   - dropping a column
 //   - changing a column from required to nullable.
+// The worker applies them to the table when it starts, and refuses to
+// start on other changes; see bigquery.MigrateSchema.
 // See https://cloud.google.com/bigquery/docs/managing-table-schemas for details.
 
 // Result is a row in the BigQuery govulncheck table.
@@ -145,8 +415,182 @@ type Result struct {
 	BinaryBuildSeconds bq.NullFloat64 `bigquery:"build_seconds"`
 	ScanMemory         int64          `bigquery:"scan_memory"`
 	ScanMode           string         `bigquery:"scan_mode"`
-	WorkVersion                       // InferSchema flattens embedded fields
-	Vulns              []*Vuln        `bigquery:"vulns"`
+	// Blob and ExtractSeconds are populated only in EXTRACT mode. Blob is
+	// the name of the scanned blob, and ExtractSeconds the time it took to
+	// extract it from its binary, if recorded. ScanSeconds covers only
+	// the scan of the blob.
+	Blob           bq.NullString  `bigquery:"blob"`
+	ExtractSeconds bq.NullFloat64 `bigquery:"extract_seconds"`
+	// ScanStartedAt and ScanFinishedAt are the times at which govulncheck
+	// started and finished running. Unlike CreatedAt, they do not depend
+	// on when the row was uploaded. They are null if no scan was run.
+	ScanStartedAt  bq.NullTimestamp `bigquery:"scan_started_at"`
+	ScanFinishedAt bq.NullTimestamp `bigquery:"scan_finished_at"`
+	// ScanUserCPUSeconds and ScanSystemCPUSeconds are the CPU time
+	// govulncheck and its children spent in user and system mode, and
+	// ScanMaxRSS is their peak resident set size in kb. Together with
+	// ScanSeconds they tell scans slowed by CPU contention from those
+	// waiting on I/O or throttled. They are null if they were not measured.
+	ScanUserCPUSeconds   bq.NullFloat64 `bigquery:"scan_user_cpu_seconds"`
+	ScanSystemCPUSeconds bq.NullFloat64 `bigquery:"scan_system_cpu_seconds"`
+	ScanMaxRSS           bq.NullInt64   `bigquery:"scan_max_rss"`
+	// VulnsCalled and VulnsImported are the numbers of distinct
+	// vulnerabilities whose symbols the module calls and whose packages
+	// it imports, counted from all of govulncheck's findings before
+	// they are selected for the row's mode; see SetVulnCounts. They are
+	// null if govulncheck was not run.
+	VulnsCalled   bq.NullInt64 `bigquery:"vulns_called"`
+	VulnsImported bq.NullInt64 `bigquery:"vulns_imported"`
+	// FindingsFiltered is the number of findings dropped by an OSVFilter.
+	// It is null if no filter was applied.
+	FindingsFiltered bq.NullInt64 `bigquery:"findings_filtered"`
+	// FindingsCapped is true if govulncheck reported more findings than
+	// the per-scan maximum, so some were dropped.
+	FindingsCapped bq.NullBool `bigquery:"findings_capped"`
+	// RequestedModulePath is the module path that was requested, if it
+	// differs from ModulePath because a higher major version was chosen.
+	RequestedModulePath bq.NullString `bigquery:"requested_module_path"`
+	// Vendored is true if the module has a vendor directory. It is null
+	// if the module was not downloaded.
+	Vendored bq.NullBool `bigquery:"vendored"`
+	// IgnoredVendor is true if the module was scanned with -mod=mod,
+	// ignoring its vendor directory.
+	IgnoredVendor bq.NullBool `bigquery:"ignored_vendor"`
+	// VendorFindingsDiffer is true if scanning the vendored module without
+	// its vendor directory gave a different set of vulnerabilities. It is
+	// null unless both scans were run.
+	VendorFindingsDiffer bq.NullBool `bigquery:"vendor_findings_differ"`
+	// ReplacedDropped is true if replace directives with local paths were
+	// dropped from the module's go.mod so that it could be scanned, so its
+	// results may not match a build of the module by its authors.
+	ReplacedDropped bq.NullBool `bigquery:"replaced_dropped"`
+	// PolicyHash is the hash of the module policy that denied the module.
+	// It is null unless the error category is "POLICY DENIED".
+	PolicyHash bq.NullString `bigquery:"policy_hash"`
+	// VulnDBEntryCount is the number of entries in the vuln DB used for
+	// the scan. It is null in rows written before it was recorded.
+	VulnDBEntryCount bq.NullInt64 `bigquery:"vulndb_entry_count"`
+	// PackagesWithErrors is the number of packages that govulncheck
+	// reported errors for. It is null if govulncheck was not run.
+	PackagesWithErrors bq.NullInt64 `bigquery:"packages_with_errors"`
+	// PackageErrors are the packages that could not be scanned when the
+	// module's packages were scanned one at a time, with their errors.
+	PackageErrors []*PackageError `bigquery:"package_errors"`
+	// AnalysisConfidence says how much of the module was analyzed:
+	// ConfidenceFull, ConfidencePartial or ConfidenceNone. It is null
+	// if govulncheck was not run.
+	AnalysisConfidence bq.NullString `bigquery:"analysis_confidence"`
+	// ModCacheWaitSeconds is the time the scan waited for the shared
+	// Go caches. It is null if the module was not downloaded.
+	ModCacheWaitSeconds bq.NullFloat64 `bigquery:"modcache_wait_seconds"`
+	// IsolatedModCache is true if the scan had its own module cache.
+	// It is null if the module was not downloaded.
+	IsolatedModCache bq.NullBool `bigquery:"isolated_modcache"`
+	// RiskScore is the risk score of the module; see RiskScore. It is set
+	// only in GOVULNCHECK rows without errors.
+	RiskScore bq.NullFloat64 `bigquery:"risk_score"`
+	// CorpusHash is the hash of the modules enqueued for the run that
+	// produced the row. It is null for scans that were not enqueued.
+	CorpusHash bq.NullString `bigquery:"corpus_hash"`
+	// RowType distinguishes the rows of compare-mode scans:
+	// RowTypePair for the results of one mode for one package, and
+	// RowTypeSummary for the summary of the module. It is null in
+	// other rows.
+	RowType bq.NullString `bigquery:"row_type"`
+	// CompareSummary is set only in compare-mode summary rows.
+	CompareSummary *CompareSummary `bigquery:"compare_summary,nullable"`
+	// Platform is the GOOS/GOARCH pair the module was scanned for, if it
+	// was requested with the platforms param. It is null for scans for
+	// the worker's own platform.
+	Platform bq.NullString `bigquery:"platform"`
+	// Private is true if the module matched the GOPRIVATE patterns of the
+	// request and was fetched from the private proxy. It is null otherwise.
+	Private bq.NullBool `bigquery:"private"`
+	// BuildTags and GoFlags are the build tags and other go command
+	// flags the module was scanned with, if they were requested with the
+	// tags and goflags params. They are null otherwise.
+	BuildTags bq.NullString `bigquery:"build_tags"`
+	GoFlags   bq.NullString `bigquery:"goflags"`
+	// RequestedGoVersion is the Go version that the goversion param or,
+	// failing that, the module's go and toolchain directives asked for.
+	// EffectiveGoVersion is the version of the toolchain that scanned
+	// the module, which can differ from the go_version of the work
+	// version, that of the worker's default toolchain. Stdlib findings
+	// are those of the effective version.
+	RequestedGoVersion bq.NullString `bigquery:"requested_go_version"`
+	EffectiveGoVersion bq.NullString `bigquery:"effective_go_version"`
+	// IncludeTests is true if the module's test files were analyzed, as
+	// requested with the includetests param. It is null otherwise.
+	IncludeTests bq.NullBool `bigquery:"include_tests"`
+	// Pattern is the package pattern the module was scanned with, if it
+	// was requested with the pattern param. It is null if all the
+	// packages of the module were scanned.
+	Pattern bq.NullString `bigquery:"pattern"`
+	// ScannerConfig describes the govulncheck that ran the scan, as it
+	// reported itself. It is null if govulncheck was not run or didn't
+	// report its config.
+	ScannerConfig *ScannerConfig `bigquery:"scanner_config,nullable"`
+	// Errors records every error encountered while producing the row, in
+	// order, up to MaxErrorRecords. Error and ErrorCategory describe the
+	// last error that failed the scan.
+	Errors []*ErrorRecord `bigquery:"errors"`
+	// Attempt is the number of the attempt that produced the row,
+	// counting from 1, and MaxAttempts is the number of attempts allowed
+	// for its error category. Both are null unless the row's error
+	// category is retried automatically.
+	Attempt     bq.NullInt64 `bigquery:"attempt"`
+	MaxAttempts bq.NullInt64 `bigquery:"max_attempts"`
+	// DeferThreshold is the imported-by count below which scans of the
+	// run were being deferred. It is null unless the error category is
+	// "DEFERRED".
+	DeferThreshold bq.NullInt64 `bigquery:"defer_threshold"`
+	// Invalidated is true in correction rows, which mark the row described
+	// by Invalidates as invalid; see Invalidate. Both are null in other
+	// rows.
+	Invalidated bq.NullBool   `bigquery:"invalidated"`
+	Invalidates *Invalidation `bigquery:"invalidates,nullable"`
+	// InCorpus is false in tombstone rows, which mark modules that were
+	// removed from the corpus; see Tombstone. It is null in other rows.
+	InCorpus bq.NullBool `bigquery:"in_corpus"`
+	// Scrubbed reports whether some fields hold hashes of their values;
+	// see Scrubber.
+	Scrubbed    bq.NullBool `bigquery:"scrubbed"`
+	WorkVersion             // InferSchema flattens embedded fields
+	Vulns       []*Vuln     `bigquery:"vulns"`
+}
+
+// ScannerConfig is the config message of a govulncheck run; see
+// govulncheckapi.Config.
+type ScannerConfig struct {
+	ProtocolVersion string           `bigquery:"protocol_version"`
+	ScannerName     string           `bigquery:"scanner_name"`
+	ScannerVersion  string           `bigquery:"scanner_version"`
+	DB              string           `bigquery:"db"`
+	DBLastModified  bq.NullTimestamp `bigquery:"db_last_modified"`
+	GoVersion       string           `bigquery:"go_version"`
+	GOOS            string           `bigquery:"goos"`
+	GOARCH          string           `bigquery:"goarch"`
+}
+
+// ConvertScannerConfig converts the config message of a govulncheck run.
+// It returns nil if c is nil.
+func ConvertScannerConfig(c *govulncheckapi.Config) *ScannerConfig {
+	if c == nil {
+		return nil
+	}
+	sc := &ScannerConfig{
+		ProtocolVersion: c.ProtocolVersion,
+		ScannerName:     c.ScannerName,
+		ScannerVersion:  c.ScannerVersion,
+		DB:              c.DB,
+		GoVersion:       c.GoVersion,
+		GOOS:            c.GOOS,
+		GOARCH:          c.GOARCH,
+	}
+	if c.DBLastModified != nil {
+		sc.DBLastModified = bigquery.NullTimestamp(*c.DBLastModified)
+	}
+	return sc
 }
 
 // WorkVersion contains information that can be used to avoid duplicate work.
@@ -163,26 +607,109 @@ type WorkVersion struct {
 	SchemaVersion string ` bigquery:"schema_version"`
 	// When the vuln DB was last modified.
 	VulnDBLastModified time.Time `bigquery:"vulndb_last_modified"`
+	// A hash of the OSVFilter applied to findings, if any.
+	OSVFilterHash bq.NullString `bigquery:"osv_filter_hash"`
+	// The version of govulncheck that was run: the installed version, if
+	// it was selected by version, or else the version recorded in the
+	// worker's default binary. It is null in rows written before the
+	// default binary's version was recorded.
+	GovulncheckVersion bq.NullString `bigquery:"govulncheck_version"`
+	// A hash of the directories and last-modified times of the vuln DBs,
+	// if more than one was used; see VulnDBsHash.
+	VulnDBsHash bq.NullString `bigquery:"vulndbs_hash"`
 }
 
+// Equal reports whether v1 and v2 are the same work version. Two nil
+// work versions are equal, and a nil work version is not equal to a
+// non-nil one. Whether a module without a work version needs to be
+// scanned is up to the caller.
 func (v1 *WorkVersion) Equal(v2 *WorkVersion) bool {
 	if v1 == nil || v2 == nil {
-		return false
+		return v1 == v2
 	}
 	return v1.GoVersion == v2.GoVersion &&
 		v1.WorkerVersion == v2.WorkerVersion &&
 		v1.SchemaVersion == v2.SchemaVersion &&
-		v1.VulnDBLastModified.Equal(v2.VulnDBLastModified)
+		v1.VulnDBLastModified.Equal(v2.VulnDBLastModified) &&
+		v1.OSVFilterHash == v2.OSVFilterHash &&
+		v1.GovulncheckVersion == v2.GovulncheckVersion &&
+		v1.VulnDBsHash == v2.VulnDBsHash
+}
+
+// Hash returns the SHA-256 hash of v. Work versions that are Equal have
+// the same hash.
+func (v *WorkVersion) Hash() string {
+	h := sha256.New()
+	fmt.Fprintln(h, v.GoVersion)
+	fmt.Fprintln(h, v.WorkerVersion)
+	fmt.Fprintln(h, v.SchemaVersion)
+	fmt.Fprintln(h, v.VulnDBLastModified.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintln(h, v.OSVFilterHash)
+	fmt.Fprintln(h, v.GovulncheckVersion)
+	fmt.Fprintln(h, v.VulnDBsHash)
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 func (vr *Result) SetUploadTime(t time.Time) { vr.CreatedAt = t }
 
+// AddError records err as the error that failed the scan, replacing any
+// previous one, and adds it to vr.Errors.
 func (vr *Result) AddError(err error) {
+	vr.AddPhaseError("", err)
+}
+
+// AddPhaseError is like AddError, recording that err happened in the
+// given phase of the scan.
+func (vr *Result) AddPhaseError(phase string, err error) {
 	if err == nil {
 		return
 	}
 	vr.Error = err.Error()
 	vr.ErrorCategory = derrors.CategorizeError(err)
+	vr.RecordError(phase, err)
+}
+
+// MaxErrorRecords is the maximum number of records in Result.Errors.
+// Later errors are dropped.
+const MaxErrorRecords = 10
+
+// maxErrorRecordMessage is the maximum length of an ErrorRecord message.
+const maxErrorRecordMessage = 1024
+
+// RecordError adds err, which happened in the given phase of the scan,
+// to vr.Errors without making it the error of vr. Use it for errors that
+// did not stop the scan.
+func (vr *Result) RecordError(phase string, err error) {
+	if err == nil || len(vr.Errors) >= MaxErrorRecords {
+		return
+	}
+	attempt := 1
+	for _, e := range vr.Errors {
+		if e.Phase == phase {
+			attempt++
+		}
+	}
+	msg := err.Error()
+	if len(msg) > maxErrorRecordMessage {
+		msg = msg[:maxErrorRecordMessage]
+	}
+	vr.Errors = append(vr.Errors, &ErrorRecord{
+		Phase:    phase,
+		Message:  msg,
+		Category: derrors.CategorizeError(err),
+		Attempt:  attempt,
+	})
+}
+
+// An ErrorRecord is a record in Result.Errors.
+type ErrorRecord struct {
+	// Phase is the phase of the scan in which the error happened,
+	// such as "downloading" or "scanning". It is empty if unknown.
+	Phase    string `bigquery:"phase"`
+	Message  string `bigquery:"message"`
+	Category string `bigquery:"category"`
+	// Attempt counts the errors in the same phase, starting at 1.
+	Attempt int `bigquery:"attempt"`
 }
 
 // Vuln is a record in Result.
@@ -191,12 +718,114 @@ type Vuln struct {
 	PackagePath string `bigquery:"package_path"`
 	ModulePath  string `bigquery:"module_path"`
 	Version     string `bigquery:"version"`
+	// Level is the level at which the vulnerability was found:
+	// LevelSymbol, LevelPackage or LevelModule.
+	// It is null in rows written before it was recorded.
+	Level bq.NullString `bigquery:"level"`
+	// FixedVersion is the earliest version of the module in which the
+	// vulnerability is fixed. It is null if there is no fix, or in rows
+	// written before it was recorded.
+	FixedVersion bq.NullString `bigquery:"fixed_version"`
+	// Summary is the summary of the OSV entry for ID.
+	// It is null if the entry was not available; see WithdrawnOrMissing.
+	Summary bq.NullString `bigquery:"summary"`
+	// Aliases are the IDs of the OSV entry for ID in other databases,
+	// such as CVE and GHSA IDs. Like Summary, they come from the entry.
+	Aliases []string `bigquery:"aliases"`
+	// CVSSScore is the CVSS v3 base score of the OSV entry for ID.
+	// It is null if the entry has no CVSS v3 severity; the Go vuln DB
+	// does not publish them.
+	CVSSScore bq.NullFloat64 `bigquery:"cvss_score"`
+	// WithdrawnOrMissing is true if the OSV entry for ID was
+	// withdrawn or was not present in the govulncheck output,
+	// typically because the vuln DB changed during the scan.
+	// Such vulns are not enriched from the entry.
+	WithdrawnOrMissing bq.NullBool `bigquery:"withdrawn_or_missing"`
+	// TraceJSON is the trace of the finding, encoded by EncodeTrace.
+	// It is null unless traces are stored as JSON; see TraceStorageJSON.
+	TraceJSON bq.NullString `bigquery:"trace_json"`
+	// Trace is the trace of the finding, frame by frame. It is empty
+	// unless traces are stored in columns; see TraceStorageColumn.
+	Trace []*TraceFrame `bigquery:"trace"`
 	// Called is currently used to differentiate between
 	// called and imported vulnerabilities. We need it
 	// because we don't conduct an imports analysis yet
 	// use the full results of govulncheck source analysis.
 	// It is not part of the bigquery schema.
+	//
+	// Deprecated: Called is always false in rows read from BigQuery.
+	// Use Result.CalledVulns and Result.ImportedVulns instead.
 	Called bool `bigquery:"-"`
+	// SourceDB is the directory of the vuln DB that the vuln's entry was
+	// read from. It is null unless the scan used more than one vuln DB.
+	SourceDB bq.NullString `bigquery:"source_db"`
+	// Fingerprint identifies the finding across rows; see Fingerprint.
+	// FingerprintVersion is the version of its recipe. Both are null in
+	// rows written before fingerprints were computed.
+	Fingerprint        bq.NullString `bigquery:"fingerprint"`
+	FingerprintVersion bq.NullInt64  `bigquery:"fingerprint_version"`
+}
+
+// Levels at which a vulnerability can be found, from most to least precise.
+const (
+	LevelSymbol  = "symbol"  // a vulnerable symbol is called
+	LevelPackage = "package" // a vulnerable package is imported
+	LevelModule  = "module"  // a vulnerable module is required
+)
+
+// CalledVulns returns the vulns of r whose vulnerable symbols are called.
+//
+// It only uses columns stored in BigQuery, so it can be used on rows read
+// back from it. Rows written before vulns recorded their level store only
+// called vulns in GOVULNCHECK mode, and not enough information to tell
+// in other modes.
+func (r *Result) CalledVulns() []*Vuln {
+	var vs []*Vuln
+	for _, v := range r.Vulns {
+		if v.Level.Valid {
+			if v.Level.StringVal == LevelSymbol {
+				vs = append(vs, v)
+			}
+		} else if r.ScanMode == ModeGovulncheck {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// ImportedVulns returns the vulns of r whose vulnerable packages are
+// imported, whether or not they are called.
+//
+// Like CalledVulns, it only uses columns stored in BigQuery. Rows written
+// before vulns recorded their level only stored vulns whose packages
+// are imported.
+func (r *Result) ImportedVulns() []*Vuln {
+	var vs []*Vuln
+	for _, v := range r.Vulns {
+		if !v.Level.Valid || v.Level.StringVal == LevelSymbol || v.Level.StringVal == LevelPackage {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// SetVulnCounts sets r.VulnsCalled and r.VulnsImported from vulns,
+// which should be all the vulns of the scan, not just those of
+// r's mode. A vulnerability found more than once counts once.
+func (r *Result) SetVulnCounts(vulns []*Vuln) {
+	called := map[string]bool{}
+	imported := map[string]bool{}
+	for _, v := range vulns {
+		switch v.Level.StringVal {
+		case LevelSymbol:
+			called[v.ID] = true
+			imported[v.ID] = true
+		case LevelPackage:
+			imported[v.ID] = true
+		}
+	}
+	r.VulnsCalled = bigquery.NullInt(len(called))
+	r.VulnsImported = bigquery.NullInt(len(imported))
 }
 
 // SchemaVersion changes whenever the govulncheck schema changes.
@@ -208,35 +837,81 @@ func init() {
 		panic(err)
 	}
 	SchemaVersion = bigquery.SchemaVersion(s)
-	bigquery.AddTable(TableName, s)
+	// Rows are looked up by module, and most queries cover recent runs.
+	bigquery.AddTableWithOptions(TableName, s, bigquery.TableOptions{
+		PartitionField: "created_at",
+		ClusterFields:  []string{"module_path"},
+		Latest: &bigquery.LatestView{
+			Key: []string{"module_path", "version", "scan_mode"},
+			Filter: func(table string) string {
+				return fmt.Sprintf(`r.scan_mode != "%s" AND %s`, ModeInvalidation, notInvalidatedCondition(table, "r"))
+			},
+		},
+	})
 }
 
+// A WorkState is the work version of the most recent row for a module
+// version, together with its error category.
 type WorkState struct {
-	WorkVersion   *WorkVersion
+	WorkVersion   *WorkVersion // never nil
 	ErrorCategory string
 }
 
+// workStateRow is a row read by ReadWorkState and ReadWorkStates. Its columns are nullable
+// because rows written before a column was added hold nulls.
+type workStateRow struct {
+	ModulePath         string           `bigquery:"module_path"`
+	Version            string           `bigquery:"version"`
+	GoVersion          bq.NullString    `bigquery:"go_version"`
+	WorkerVersion      bq.NullString    `bigquery:"worker_version"`
+	SchemaVersion      bq.NullString    `bigquery:"schema_version"`
+	VulnDBLastModified bq.NullTimestamp `bigquery:"vulndb_last_modified"`
+	OSVFilterHash      bq.NullString    `bigquery:"osv_filter_hash"`
+	GovulncheckVersion bq.NullString    `bigquery:"govulncheck_version"`
+	VulnDBsHash        bq.NullString    `bigquery:"vulndbs_hash"`
+	ErrorCategory      bq.NullString    `bigquery:"error_category"`
+}
+
+// workState returns the work state of r. Null columns become zero values,
+// which don't match the work version of any scan, so the module is
+// scanned again.
+func (r *workStateRow) workState() *WorkState {
+	wv := &WorkVersion{
+		GoVersion:          r.GoVersion.StringVal,
+		WorkerVersion:      r.WorkerVersion.StringVal,
+		SchemaVersion:      r.SchemaVersion.StringVal,
+		OSVFilterHash:      r.OSVFilterHash,
+		GovulncheckVersion: r.GovulncheckVersion,
+		VulnDBsHash:        r.VulnDBsHash,
+	}
+	if r.VulnDBLastModified.Valid {
+		wv.VulnDBLastModified = r.VulnDBLastModified.Timestamp
+	}
+	return &WorkState{WorkVersion: wv, ErrorCategory: r.ErrorCategory.StringVal}
+}
+
 // ReadWorkState reads the most recent work version for module_path@version
 // in the govulncheck table together with its accompanying error category.
+// Correction rows and the rows they invalidate are ignored, so that
+// invalidated module versions are scanned again.
+// It returns nil if there is no row for module_path@version.
 func ReadWorkState(ctx context.Context, c *bigquery.Client, module_path, version string) (ws *WorkState, err error) {
 	defer derrors.Wrap(&err, "ReadWorkState")
 
 	const qf = `
-                SELECT module_path, version, go_version, worker_version, schema_version, vulndb_last_modified, error_category
-                FROM %s WHERE module_path="%s" AND version="%s" ORDER BY created_at DESC LIMIT 1
+                SELECT module_path, version, go_version, worker_version, schema_version, vulndb_last_modified, osv_filter_hash, govulncheck_version, vulndbs_hash, error_category
+                FROM %s AS r WHERE module_path=@module_path AND version=@version AND scan_mode != "%s" AND %s
+                ORDER BY created_at DESC LIMIT 1
         `
-	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`", module_path, version)
-	iter, err := c.Query(ctx, query)
-	if err != nil {
-		return nil, err
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, ModeInvalidation, notInvalidatedCondition(table, "r"))
+	params := []bq.QueryParameter{
+		bigquery.Param("module_path", module_path),
+		bigquery.Param("version", version),
 	}
-
-	err = bigquery.ForEachRow(iter, func(r *Result) bool {
+	err = bigquery.ForEach(ctx, c, query, params, func(r *workStateRow) bool {
 		// This should be reachable at most once.
-		ws = &WorkState{
-			WorkVersion:   &r.WorkVersion,
-			ErrorCategory: r.ErrorCategory,
-		}
+		ws = r.workState()
 		return true
 	})
 	if err != nil {
@@ -245,16 +920,147 @@ func ReadWorkState(ctx context.Context, c *bigquery.Client, module_path, version
 	return ws, nil
 }
 
+// maxWorkStateBatch is the maximum number of module versions whose work
+// states ReadWorkStates reads in one query. It keeps queries well under
+// the BigQuery limit on query length.
+const maxWorkStateBatch = 10000
+
+// ReadWorkStates is like ReadWorkState, but reads the work states of all
+// the module versions in modspecs with one query per maxWorkStateBatch
+// module versions. The returned map is keyed by module_path@version, and
+// has no entry for module versions without a row.
+func ReadWorkStates(ctx context.Context, c *bigquery.Client, modspecs []scan.ModuleSpec) (_ map[string]*WorkState, err error) {
+	defer derrors.Wrap(&err, "ReadWorkStates(%d modules)", len(modspecs))
+
+	const qf = `
+		SELECT module_path, version, go_version, worker_version, schema_version, vulndb_last_modified, osv_filter_hash, govulncheck_version, vulndbs_hash, error_category
+		FROM %s AS r
+		WHERE CONCAT(module_path, "@", version) IN UNNEST(@modules) AND scan_mode != "%s" AND %s
+		QUALIFY ROW_NUMBER() OVER (PARTITION BY module_path, version ORDER BY created_at DESC) = 1
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	wss := map[string]*WorkState{}
+	for _, batch := range workStateBatches(modspecs, maxWorkStateBatch) {
+		query := fmt.Sprintf(qf, table, ModeInvalidation, notInvalidatedCondition(table, "r"))
+		params := []bq.QueryParameter{
+			bigquery.Param("modules", batch),
+		}
+		err = bigquery.ForEach(ctx, c, query, params, func(r *workStateRow) bool {
+			wss[r.ModulePath+"@"+r.Version] = r.workState()
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return wss, nil
+}
+
+// workStateBatches returns the distinct module_path@version strings of
+// modspecs, split into batches of at most size.
+func workStateBatches(modspecs []scan.ModuleSpec, size int) [][]string {
+	var (
+		batches [][]string
+		batch   []string
+	)
+	seen := map[string]bool{}
+	for _, ms := range modspecs {
+		key := ms.Path + "@" + ms.Version
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		batch = append(batch, key)
+		if len(batch) == size {
+			batches = append(batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
 // ScanStats contains monitoring information for a govulncheck run.
 type ScanStats struct {
 	// ScanSeconds is the amount of time a scan took to run, in seconds.
 	ScanSeconds float64
-	// ScanMemory is the peak (heap) memory used by govulncheck, in kb.
+	// ScanMemory is the peak memory used by govulncheck, in kb.
 	ScanMemory uint64
 	// BuildTime is the amount of time it takes to build a given binary
 	// *BEFORE* scanning it with govulncheck.
 	// This is only used in COMPARE - BINARY mode
 	BuildTime time.Duration
+	// StartedAt and FinishedAt are the wall-clock times at which
+	// govulncheck started and finished running.
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// UserCPUSeconds and SystemCPUSeconds are the CPU time used by
+	// govulncheck and the processes it waited for, in user and system mode.
+	UserCPUSeconds   float64
+	SystemCPUSeconds float64
+	// MaxRSS is the peak resident set size of govulncheck, in kb.
+	// It is zero if it is not measured on this system.
+	MaxRSS uint64
+	// FindingsCapped reports whether findings were dropped because
+	// there were more than the maximum allowed per scan.
+	FindingsCapped bool
+	// Vendored reports whether the module has a vendor directory
+	// (vendor/modules.txt). It is nil if the module was not downloaded.
+	Vendored *bool `json:",omitempty"`
+	// VendorFindingsDiffer reports whether scanning a vendored module
+	// with and without its vendor directory gave different sets of
+	// vulnerabilities. It is nil unless both scans were run.
+	VendorFindingsDiffer *bool `json:",omitempty"`
+	// ReplacesDropped reports whether replace directives with local
+	// paths were dropped from the module's go.mod.
+	ReplacesDropped bool
+	// PackagesWithErrors is the number of packages with errors
+	// reported by govulncheck; see CountPackageErrors.
+	PackagesWithErrors int
+	// ModCacheWait is the time the scan waited for the shared Go
+	// caches, which are locked while they are cleaned.
+	ModCacheWait time.Duration
+	// IsolatedModCache reports whether the scan had its own module cache.
+	IsolatedModCache bool
+	// Config is the config message govulncheck reported, if any.
+	Config *govulncheckapi.Config `json:",omitempty"`
+	// PackageErrors are the packages that could not be scanned, when
+	// the packages of a module are scanned one at a time.
+	PackageErrors []*PackageError `json:",omitempty"`
+	// Errors are errors that did not stop the scan, such as a failure
+	// to scan a vendored module without its vendor directory.
+	Errors []error `json:"-"`
+}
+
+// SetScanTimes sets the scan start and finish times of r from stats,
+// if they are known.
+func (r *Result) SetScanTimes(stats *ScanStats) {
+	if !stats.StartedAt.IsZero() {
+		r.ScanStartedAt = bigquery.NullTimestamp(stats.StartedAt)
+	}
+	if !stats.FinishedAt.IsZero() {
+		r.ScanFinishedAt = bigquery.NullTimestamp(stats.FinishedAt)
+	}
+}
+
+// SetCPUUsage sets the CPU times and peak resident set size of r from
+// stats, if they were measured.
+func (r *Result) SetCPUUsage(stats *ScanStats) {
+	if stats.UserCPUSeconds > 0 || stats.SystemCPUSeconds > 0 {
+		r.ScanUserCPUSeconds = bigquery.NullFloat(stats.UserCPUSeconds)
+		r.ScanSystemCPUSeconds = bigquery.NullFloat(stats.SystemCPUSeconds)
+	}
+	if stats.MaxRSS > 0 {
+		r.ScanMaxRSS = bigquery.NullInt(int(stats.MaxRSS))
+	}
+}
+
+// SetScannerConfig sets the scanner config of r from stats,
+// if govulncheck reported it.
+func (r *Result) SetScannerConfig(stats *ScanStats) {
+	r.ScannerConfig = ConvertScannerConfig(stats.Config)
 }
 
 // SandboxResponse contains the raw govulncheck result
@@ -262,7 +1068,10 @@ type ScanStats struct {
 // for capturing result of govulncheck run in a sandbox.
 type SandboxResponse struct {
 	Findings []*govulncheckapi.Finding
-	Stats    ScanStats
+	// OSVs are the OSV entries for Findings that were
+	// present in the govulncheck output.
+	OSVs  []*osv.Entry `json:",omitempty"`
+	Stats ScanStats
 }
 
 func UnmarshalSandboxResponse(output []byte) (*SandboxResponse, error) {
@@ -283,6 +1092,9 @@ func UnmarshalSandboxResponse(output []byte) (*SandboxResponse, error) {
 type CompareResponse struct {
 	// Map from package import path to pair of binary & source mode findings
 	FindingsForMod map[string]*ComparePair
+	// Packages are the import paths of the module's packages that compile
+	// to binaries. It is set only when they are listed instead of compared.
+	Packages []string `json:",omitempty"`
 }
 
 type ComparePair struct {
@@ -306,39 +1118,319 @@ func UnmarshalCompareResponse(output []byte) (*CompareResponse, error) {
 	return &res, nil
 }
 
-func RunGovulncheckCmd(govulncheckPath, modeFlag, pattern, moduleDir, vulndbDir string, stats *ScanStats) ([]*govulncheckapi.Finding, error) {
-	stdOut := bytes.Buffer{}
-	stdErr := bytes.Buffer{}
-	uri := "file://" + vulndbDir
-	if runtime.GOOS == "windows" {
-		uri = "file:///" + filepath.ToSlash(vulndbDir)
+// RunOptions holds optional settings for RunGovulncheckCmd.
+type RunOptions struct {
+	// MaxFindings, if positive, is the maximum number of findings
+	// processed; ScanStats.FindingsCapped reports whether any were dropped.
+	MaxFindings int
+	// Progress, if non-nil, is called with each progress message
+	// as govulncheck reports it.
+	Progress func(*govulncheckapi.Progress)
+	// IgnoreVendor makes govulncheck analyze the module graph
+	// instead of the vendor directory (-mod=mod).
+	IgnoreVendor bool
+	// GoRoot, if non-empty, is the GOROOT of the Go toolchain that
+	// govulncheck uses, instead of the one on the PATH.
+	GoRoot string
+	// Timeout, if positive, is how long govulncheck may run before
+	// it is killed. RunGovulncheckCmd then returns an error wrapping
+	// derrors.ScanModuleTimeout.
+	Timeout time.Duration
+	// Platform, if non-empty, is the GOOS/GOARCH pair that govulncheck
+	// loads packages for, instead of the host's.
+	Platform string
+	// Tags is a comma-separated list of build tags, and GoFlags a
+	// space-separated list of other flags for the go command; see
+	// ParseTags and ParseGoFlags. Both are passed in GOFLAGS.
+	Tags    string
+	GoFlags string
+	// Tests makes govulncheck analyze test files too. It is ignored in
+	// binary mode.
+	Tests bool
+	// Env holds additional environment variables for govulncheck,
+	// such as GOPRIVATE.
+	Env []string
+	// VulnDBCaches are caches of vuln DBs served over HTTPS. A vuln DB
+	// with the URL of one of them is read from the cache.
+	VulnDBCaches []*VulnDBCache
+}
+
+// setEnv sets the environment of cmd, which runs the go command or a
+// program that runs it, according to o.
+func (o *RunOptions) setEnv(cmd *exec.Cmd) {
+	if flags := o.goFlags(); flags != "" {
+		cmd.Env = append(cmd.Environ(), "GOFLAGS="+flags)
 	}
-	args := []string{"-mode", modeFlag, "-json", "-db", uri}
-	if moduleDir != "" {
-		args = append(args, "-C", moduleDir)
+	if goos, goarch, ok := strings.Cut(o.Platform, "/"); ok {
+		cmd.Env = append(cmd.Environ(), "GOOS="+goos, "GOARCH="+goarch)
+	}
+	if len(o.Env) > 0 {
+		cmd.Env = append(cmd.Environ(), o.Env...)
 	}
-	args = append(args, pattern)
-	govulncheckCmd := exec.Command(govulncheckPath, args...)
+	if o.GoRoot != "" {
+		cmd.Env = append(cmd.Environ(),
+			"GOROOT="+o.GoRoot,
+			"GOTOOLCHAIN=local",
+			"PATH="+filepath.Join(o.GoRoot, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+}
 
-	govulncheckCmd.Stdout = &stdOut
-	govulncheckCmd.Stderr = &stdErr
+// vulnDBs returns vulndbDirs with the URLs of cached vuln DBs replaced
+// by the URLs of their caches.
+func (o *RunOptions) vulnDBs(vulndbDirs []string) []string {
+	if len(o.VulnDBCaches) == 0 {
+		return vulndbDirs
+	}
+	dbs := make([]string, len(vulndbDirs))
+	for i, d := range vulndbDirs {
+		dbs[i] = d
+		for _, c := range o.VulnDBCaches {
+			if IsVulnDBURL(d) && strings.TrimSuffix(d, "/") == c.Remote() && c.URL() != "" {
+				dbs[i] = c.URL()
+				break
+			}
+		}
+	}
+	return dbs
+}
+
+// maxStderr is the maximum number of bytes of the standard error of
+// govulncheck that RunGovulncheckCmd keeps.
+const maxStderr = 1 << 20
+
+// A limitedBuffer is an io.Writer that keeps the first max bytes
+// written to it and counts the rest.
+type limitedBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.buf.Len(); room < len(p) {
+		if room < 0 {
+			room = 0
+		}
+		b.dropped += len(p) - room
+		p = p[:room]
+	}
+	b.buf.Write(p)
+	return n, nil
+}
 
-	start := time.Now()
-	if err := govulncheckCmd.Run(); err != nil {
-		return nil, errors.New(stdErr.String())
+// String returns the bytes kept by b, followed by a note of how many
+// were dropped, if any.
+func (b *limitedBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
 	}
-	stats.ScanSeconds = time.Since(start).Seconds()
-	stats.ScanMemory = getMemoryUsage(govulncheckCmd)
+	return fmt.Sprintf("%s\n... (%d more bytes)", b.buf.String(), b.dropped)
+}
+
+// pipeWaitDelay is how long RunGovulncheckCmd waits, after govulncheck
+// exits or is killed, for its output pipe to be closed. Processes started
+// by govulncheck can hold the pipe open after govulncheck itself exits.
+const pipeWaitDelay = 10 * time.Second
+
+// govulncheckExitVulnsFound is the status with which govulncheck exits
+// when it finds vulnerabilities, in the versions and output modes that
+// report them that way. Its output is complete.
+const govulncheckExitVulnsFound = 3
+
+// An exitClass classifies how govulncheck exited.
+type exitClass int
 
-	handler := NewMetricsHandler()
-	err := govulncheckapi.HandleJSON(&stdOut, handler)
+const (
+	exitSucceeded  exitClass = iota // with status 0
+	exitVulnsFound                  // with govulncheckExitVulnsFound
+	exitFailed                      // with another status, or killed
+)
+
+// classifyExit classifies the error returned by waiting for govulncheck.
+func classifyExit(err error) exitClass {
+	if err == nil {
+		return exitSucceeded
+	}
+	var eerr *exec.ExitError
+	if errors.As(err, &eerr) && eerr.ExitCode() == govulncheckExitVulnsFound {
+		return exitVulnsFound
+	}
+	return exitFailed
+}
+
+// exitError returns the error for a govulncheck run that failed with err,
+// from the standard error it wrote. If it wrote nothing, the error says
+// how govulncheck exited.
+func exitError(err error, stderr string) error {
+	if strings.TrimSpace(stderr) == "" {
+		return fmt.Errorf("govulncheck: %v", err)
+	}
+	return errors.New(stderr)
+}
+
+// RunGovulncheckCmd runs govulncheck with the vuln DBs in vulndbDirs,
+// which are local directories or HTTPS URLs, and returns its findings
+// along with the OSV entries for them. opts may be nil.
+//
+// govulncheck is killed, along with the processes it started where that
+// is supported, when ctx is done or opts.Timeout has passed. The scan time
+// and memory in stats are set whenever govulncheck ran, even if it failed
+// or was killed.
+//
+// Exiting with govulncheckExitVulnsFound is not a failure: the findings
+// are returned as if govulncheck had exited with status 0. Any other
+// non-zero status is, and the error holds govulncheck's standard error.
+//
+// The pipe to govulncheck is closed and govulncheck is waited for on every
+// return path, including when it can't be started, times out, or writes
+// malformed output.
+//
+// The JSON output of govulncheck is decoded as it is written, and only one
+// finding per OSV is kept, so memory use doesn't grow with the size of the
+// output. At most maxStderr bytes of its standard error are kept.
+func RunGovulncheckCmd(ctx context.Context, govulncheckPath, modeFlag, pattern, moduleDir string, vulndbDirs []string, opts *RunOptions, stats *ScanStats) ([]*govulncheckapi.Finding, []*osv.Entry, error) {
+	if opts == nil {
+		opts = &RunOptions{}
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	stdErr := limitedBuffer{max: maxStderr}
+	args := govulncheckArgs(modeFlag, pattern, moduleDir, opts.vulnDBs(vulndbDirs))
+	if opts.Tests && modeFlag != FlagBinary {
+		args = append([]string{"-test"}, args...)
+	}
+	govulncheckCmd := exec.CommandContext(ctx, govulncheckPath, args...)
+	govulncheckCmd.WaitDelay = pipeWaitDelay
+	if killProcessGroup != nil {
+		killProcessGroup(govulncheckCmd)
+	}
+	opts.setEnv(govulncheckCmd)
+
+	stdOut, err := govulncheckCmd.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	govulncheckCmd.Stderr = &stdErr
+
+	handler := NewMetricsHandler(opts.MaxFindings)
+	handler.progress = opts.Progress
+	stats.StartedAt = time.Now()
+	if err := govulncheckCmd.Start(); err != nil {
+		// Start closes the pipe when it fails.
+		return nil, nil, err
 	}
-	return handler.Findings(), nil
+	var peakMemory func() uint64
+	if trackMemory != nil {
+		peakMemory = trackMemory(govulncheckCmd)
+	}
+	// Handle the output as it is written, so progress is reported promptly.
+	herr := govulncheckapi.HandleJSON(stdOut, handler)
+	if herr != nil {
+		// The rest of the output is useless. Don't leave govulncheck
+		// blocked on a full pipe.
+		govulncheckCmd.Process.Kill()
+	}
+	// Wait closes the pipe.
+	err = govulncheckCmd.Wait()
+	stats.FinishedAt = time.Now()
+	stats.ScanSeconds = stats.FinishedAt.Sub(stats.StartedAt).Seconds()
+	if ps := govulncheckCmd.ProcessState; ps != nil {
+		stats.UserCPUSeconds = ps.UserTime().Seconds()
+		stats.SystemCPUSeconds = ps.SystemTime().Seconds()
+		if getMaxRSS != nil {
+			stats.MaxRSS = getMaxRSS(govulncheckCmd)
+		}
+		if peakMemory != nil {
+			stats.ScanMemory = peakMemory()
+		}
+	}
+	stats.Config = handler.ScannerConfig()
+	stats.PackagesWithErrors = CountPackageErrors(stdErr.String())
+	if cerr := ctx.Err(); cerr != nil {
+		if errors.Is(cerr, context.DeadlineExceeded) {
+			elapsed := stats.FinishedAt.Sub(stats.StartedAt).Round(time.Second)
+			return nil, nil, fmt.Errorf("govulncheck timed out after %s: %w", elapsed, derrors.ScanModuleTimeout)
+		}
+		return nil, nil, fmt.Errorf("govulncheck canceled: %w", cerr)
+	}
+	if herr != nil {
+		// Keep the stderr output, which says why govulncheck failed
+		// if it did.
+		return nil, nil, fmt.Errorf("%w\n%s", herr, stdErr.String())
+	}
+	// Only a genuine failure loses the findings; exiting because
+	// vulnerabilities were found does not.
+	if classifyExit(err) == exitFailed {
+		return nil, nil, exitError(err, stdErr.String())
+	}
+	stats.FindingsCapped = handler.Capped()
+	return handler.Findings(), handler.OSVs(), nil
+}
+
+// govulncheckArgs returns the arguments to govulncheck for
+// RunGovulncheckCmd.
+//
+// Each vuln DB is passed with its own -db flag, in order, for versions
+// of govulncheck that merge several DBs. Vuln DBs served over HTTP(S)
+// are passed as is.
+//
+// govulncheck takes vuln DBs as file URLs, and source-mode patterns
+// are package patterns, so both need forward slashes. Paths given to -C
+// are converted too, since Windows accepts forward slashes and tools
+// that quote the -C argument may not handle backslashes. The pattern of
+// a binary-mode scan is a file path and is passed unchanged.
+//
+// FlagImports becomes source mode with "-scan package", which stops
+// govulncheck before it builds the call graph.
+func govulncheckArgs(modeFlag, pattern, moduleDir string, vulndbDirs []string) []string {
+	var args []string
+	if modeFlag == FlagImports {
+		args = []string{"-mode", FlagSource, "-scan", "package", "-json"}
+	} else {
+		args = []string{"-mode", modeFlag, "-json"}
+	}
+	for _, dir := range vulndbDirs {
+		if strings.HasPrefix(dir, "https://") || strings.HasPrefix(dir, "http://") {
+			args = append(args, "-db", dir)
+			continue
+		}
+		uri := "file://" + dir
+		if runtime.GOOS == "windows" {
+			uri = "file:///" + filepath.ToSlash(dir)
+		}
+		args = append(args, "-db", uri)
+	}
+	if moduleDir != "" {
+		args = append(args, "-C", filepath.ToSlash(moduleDir))
+	}
+	if modeFlag != FlagBinary {
+		pattern = filepath.ToSlash(pattern)
+	}
+	return append(args, pattern)
 }
 
-// getMemoryUsage is overridden with a Unix-specific function on Linux.
-var getMemoryUsage = func(c *exec.Cmd) uint64 {
-	return 0
+// trackMemory, if non-nil, starts measuring the memory used by a command
+// that has just started. The function it returns, if non-nil, reports the
+// peak memory used by the command once it has finished, in kb. It is set
+// on Unix systems, where the peak is the maximum resident set size, and on
+// Windows, where it is the peak committed memory of the command's job.
+var trackMemory func(c *exec.Cmd) func() uint64
+
+// getMaxRSS, if non-nil, returns the peak resident set size of a command
+// that has finished, in kb. It is set on Unix systems.
+var getMaxRSS func(c *exec.Cmd) uint64
+
+// killProcessGroup, if non-nil, makes a command that is not yet started
+// run in its own process group, which is killed when its context is done.
+// It is set on Unix systems.
+var killProcessGroup func(c *exec.Cmd)
+
+// MemoryUsageAvailable reports whether ScanStats.ScanMemory is measured
+// on this system. If it is not, ScanMemory is always zero.
+func MemoryUsageAvailable() bool {
+	return trackMemory != nil
 }
@@ -6,14 +6,20 @@ package govulncheck
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	bq "cloud.google.com/go/bigquery"
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/scan"
 	test "golang.org/x/pkgsite-metrics/internal/testing"
 	"google.golang.org/api/iterator"
 )
@@ -56,22 +62,28 @@ func TestConvertGovulncheckFinding(t *testing.T) {
 			name: "called",
 			vuln: vuln1,
 			wantVuln: &Vuln{
-				ID:          "GO-YYYY-XXXX",
-				PackagePath: "example.com/repo/module/package",
-				ModulePath:  "example.com/repo/module",
-				Version:     "v0.0.1",
-				Called:      true,
+				ID:                 "GO-YYYY-XXXX",
+				PackagePath:        "example.com/repo/module/package",
+				ModulePath:         "example.com/repo/module",
+				Version:            "v0.0.1",
+				Level:              bigquery.NullString(LevelSymbol),
+				Called:             true,
+				Fingerprint:        bigquery.NullString(Fingerprint(vuln1)),
+				FingerprintVersion: bigquery.NullInt(FingerprintVersion),
 			},
 		},
 		{
 			name: "Not called",
 			vuln: vuln2,
 			wantVuln: &Vuln{
-				ID:          "GO-YYYY-XXXX",
-				PackagePath: "example.com/repo/module/package",
-				ModulePath:  "example.com/repo/module",
-				Version:     "v1.0.0",
-				Called:      false,
+				ID:                 "GO-YYYY-XXXX",
+				PackagePath:        "example.com/repo/module/package",
+				ModulePath:         "example.com/repo/module",
+				Version:            "v1.0.0",
+				Level:              bigquery.NullString(LevelPackage),
+				Called:             false,
+				Fingerprint:        bigquery.NullString(Fingerprint(vuln2)),
+				FingerprintVersion: bigquery.NullInt(FingerprintVersion),
 			},
 		},
 	}
@@ -84,6 +96,528 @@ func TestConvertGovulncheckFinding(t *testing.T) {
 	}
 }
 
+func TestCalledImportedVulns(t *testing.T) {
+	ids := func(vs []*Vuln) []string {
+		var r []string
+		for _, v := range vs {
+			r = append(r, v.ID)
+		}
+		return r
+	}
+	vuln := func(id, level string) *Vuln {
+		v := &Vuln{ID: id}
+		if level != "" {
+			v.Level = bigquery.NullString(level)
+		}
+		return v
+	}
+	for _, test := range []struct {
+		name         string
+		row          *Result
+		wantCalled   []string
+		wantImported []string
+	}{
+		{
+			name: "levels",
+			row: &Result{
+				ScanMode: "IMPORTS",
+				Vulns: []*Vuln{
+					vuln("S", LevelSymbol),
+					vuln("P", LevelPackage),
+					vuln("M", LevelModule),
+				},
+			},
+			wantCalled:   []string{"S"},
+			wantImported: []string{"S", "P"},
+		},
+		{
+			name:         "no levels, govulncheck mode",
+			row:          &Result{ScanMode: "GOVULNCHECK", Vulns: []*Vuln{vuln("A", "")}},
+			wantCalled:   []string{"A"},
+			wantImported: []string{"A"},
+		},
+		{
+			name:         "no levels, imports mode",
+			row:          &Result{ScanMode: "IMPORTS", Vulns: []*Vuln{vuln("A", "")}},
+			wantCalled:   nil,
+			wantImported: []string{"A"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.wantCalled, ids(test.row.CalledVulns())); diff != "" {
+				t.Errorf("CalledVulns mismatch (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantImported, ids(test.row.ImportedVulns())); diff != "" {
+				t.Errorf("ImportedVulns mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetVulnCounts(t *testing.T) {
+	vuln := func(id, level string) *Vuln {
+		return &Vuln{ID: id, Level: bigquery.NullString(level)}
+	}
+	var r Result
+	r.SetVulnCounts([]*Vuln{
+		// A is found at every level, once for each called symbol.
+		vuln("A", LevelModule),
+		vuln("A", LevelPackage),
+		vuln("A", LevelSymbol),
+		vuln("A", LevelSymbol),
+		vuln("B", LevelModule),
+		vuln("B", LevelPackage),
+		vuln("C", LevelModule),
+	})
+	if g, w := r.VulnsCalled, bigquery.NullInt(1); g != w {
+		t.Errorf("VulnsCalled: got %v, want %v", g, w)
+	}
+	if g, w := r.VulnsImported, bigquery.NullInt(2); g != w {
+		t.Errorf("VulnsImported: got %v, want %v", g, w)
+	}
+
+	r.SetVulnCounts(nil)
+	if g, w := r.VulnsCalled, bigquery.NullInt(0); g != w {
+		t.Errorf("no vulns: VulnsCalled: got %v, want %v", g, w)
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	want := &Request{
+		ModuleURLPath: scan.ModuleURLPath{Module: "example.com/m", Version: "v1.2.3"},
+		QueryParams:   QueryParams{ImportedBy: 3, Mode: "GOVULNCHECK", Suffix: "run-2023.01_a"},
+		ParamsVersion: ParamsVersion,
+	}
+	r, err := http.NewRequest("POST", "https://worker/govulncheck/scan/"+want.Path()+"?"+want.Params(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseRequest(r, "/govulncheck/scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestRequestIdempotencyKey(t *testing.T) {
+	req := func() *Request {
+		return &Request{
+			ModuleURLPath:   scan.ModuleURLPath{Module: "example.com/m", Version: "v1.2.3"},
+			QueryParams:     QueryParams{ImportedBy: 3, Mode: "COMPARE", Suffix: "run1", CorpusHash: "c1"},
+			WorkVersionHash: "wv1",
+		}
+	}
+	key := req().IdempotencyKey()
+	if key == "" {
+		t.Fatal("got empty key")
+	}
+
+	// Other runs of the same work have the same key.
+	r := req()
+	r.QueryParams.Suffix = "run2"
+	r.CorpusHash = "c2"
+	if got := r.IdempotencyKey(); got != key {
+		t.Errorf("other run: got %q, want %q", got, key)
+	}
+
+	for _, test := range []struct {
+		name   string
+		change func(*Request)
+	}{
+		{"work version", func(r *Request) { r.WorkVersionHash = "wv2" }},
+		{"mode", func(r *Request) { r.Mode = "GOVULNCHECK" }},
+		{"version", func(r *Request) { r.Version = "v1.2.4" }},
+		{"attempt", func(r *Request) { r.Attempt = 2 }},
+		{"tags", func(r *Request) { r.Tags = "a" }},
+	} {
+		r := req()
+		test.change(r)
+		if got := r.IdempotencyKey(); got == key {
+			t.Errorf("changing %s: got the same key %q", test.name, got)
+		}
+	}
+
+	r = req()
+	r.WorkVersionHash = ""
+	if got := r.IdempotencyKey(); got != "" {
+		t.Errorf("no work version hash: got %q, want empty", got)
+	}
+}
+
+func TestParseRequestVersion(t *testing.T) {
+	for _, test := range []struct {
+		path, want string
+	}{
+		{"example.com/m@v1.2.3", "v1.2.3"},
+		{"example.com/m@1.2.3", "v1.2.3"},
+		{"example.com/m/@v/v1.2.3+incompatible", "v1.2.3"},
+		{"example.com/m/v2@v2.0.0+incompatible", "v2.0.0+incompatible"},
+		{"example.com/m@v1.2", "v1.2.0"},
+		{"example.com/m/@latest", "latest"},
+		{"example.com/m@master", ""},
+	} {
+		r, err := http.NewRequest("POST", "https://worker/govulncheck/scan/"+test.path+"?importedby=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseRequest(r, "/govulncheck/scan")
+		if test.want == "" {
+			if !errors.Is(err, scan.ErrBadModulePath) {
+				t.Errorf("%s: got %v, want ErrBadModulePath", test.path, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if got.Version != test.want {
+			t.Errorf("%s: got version %q, want %q", test.path, got.Version, test.want)
+		}
+	}
+}
+
+func TestParseRequestPlatforms(t *testing.T) {
+	for _, test := range []struct {
+		platforms string
+		wantErr   bool
+	}{
+		{"", false},
+		{"linux/amd64,darwin/arm64", false},
+		{"linux", true},
+	} {
+		r, err := http.NewRequest("POST", "https://worker/govulncheck/scan/example.com/m@v1.2.3?importedby=1&platforms="+test.platforms, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseRequest(r, "/govulncheck/scan")
+		if test.wantErr {
+			if !errors.Is(err, scan.ErrBadParam) {
+				t.Errorf("%q: got %v, want ErrBadParam", test.platforms, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.platforms, err)
+			continue
+		}
+		if got.Platforms != test.platforms {
+			t.Errorf("got platforms %q, want %q", got.Platforms, test.platforms)
+		}
+	}
+}
+
+func TestRequestCrossVersion(t *testing.T) {
+	const path = "https://worker/govulncheck/scan/example.com/m@v1.2.3?"
+	for _, test := range []struct {
+		name   string
+		params string
+		want   *Request
+	}{
+		{
+			// Tasks enqueued before params were versioned, which lack
+			// the params added since.
+			name:   "unversioned",
+			params: "importedby=3&mode=GOVULNCHECK&insecure=false&serve=false",
+			want: &Request{
+				QueryParams: QueryParams{ImportedBy: 3, Mode: "GOVULNCHECK"},
+			},
+		},
+		{
+			// Tasks enqueued by a newer version, with params this
+			// version doesn't know.
+			name:   "newer",
+			params: "importedby=3&mode=GOVULNCHECK&future=x&shadow=true&params_version=" + fmt.Sprint(ParamsVersion+1),
+			want: &Request{
+				QueryParams:   QueryParams{ImportedBy: 3, Mode: "GOVULNCHECK", Shadow: true},
+				ParamsVersion: ParamsVersion + 1,
+				UnknownParams: []string{"future"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := http.NewRequest("POST", path+test.params, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseRequest(r, "/govulncheck/scan")
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.want.ModuleURLPath = scan.ModuleURLPath{Module: "example.com/m", Version: "v1.2.3"}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+
+			// Re-formatting the request drops unknown params and
+			// marks it with the current version.
+			r, err = http.NewRequest("POST", path+got.Params(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := ParseRequest(r, "/govulncheck/scan")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.ParamsVersion != ParamsVersion || len(again.UnknownParams) != 0 {
+				t.Errorf("re-formatted: got version %d, unknown %v; want %d, none",
+					again.ParamsVersion, again.UnknownParams, ParamsVersion)
+			}
+			if !cmp.Equal(again.QueryParams, got.QueryParams) {
+				t.Errorf("re-formatted: got %+v, want %+v", again.QueryParams, got.QueryParams)
+			}
+		})
+	}
+}
+
+func TestValidateSuffix(t *testing.T) {
+	for _, s := range []string{"", "adhoc-20230101", "run_1.2"} {
+		if err := ValidateSuffix(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range []string{"a b", "a/b", "ü", strings.Repeat("x", maxSuffixLen+1)} {
+		if err := ValidateSuffix(s); err == nil {
+			t.Errorf("%q: got nil error", s)
+		}
+	}
+	if s := AdHocSuffix(time.Date(2023, 4, 5, 23, 0, 0, 0, time.UTC)); s != "adhoc-20230405" || !IsAdHocSuffix(s) {
+		t.Errorf("AdHocSuffix: got %q", s)
+	}
+}
+
+func TestAddError(t *testing.T) {
+	var r Result
+	r.AddError(nil)
+	if r.Error != "" || len(r.Errors) != 0 {
+		t.Fatalf("nil error recorded: %+v", r)
+	}
+	r.AddPhaseError("downloading", fmt.Errorf("timeout: %w", derrors.ProxyError))
+	r.RecordError("scanning", errors.New("vendor scan failed"))
+	r.AddPhaseError("downloading", fmt.Errorf("500: %w", derrors.ProxyError))
+	r.AddPhaseError("scanning", fmt.Errorf("boom: %w", derrors.ScanModuleGovulncheckError))
+	// The last error that failed the scan is the error of the row.
+	if got, want := r.Error, "boom: scan module govulncheck error"; got != want {
+		t.Errorf("Error: got %q, want %q", got, want)
+	}
+	if got, want := r.ErrorCategory, derrors.CategorizeError(derrors.ScanModuleGovulncheckError); got != want {
+		t.Errorf("ErrorCategory: got %q, want %q", got, want)
+	}
+	proxyCat := derrors.CategorizeError(derrors.ProxyError)
+	want := []*ErrorRecord{
+		{Phase: "downloading", Message: "timeout: proxy error", Category: proxyCat, Attempt: 1},
+		{Phase: "scanning", Message: "vendor scan failed", Category: derrors.CategorizeError(errors.New("")), Attempt: 1},
+		{Phase: "downloading", Message: "500: proxy error", Category: proxyCat, Attempt: 2},
+		{Phase: "scanning", Message: r.Error, Category: r.ErrorCategory, Attempt: 2},
+	}
+	if diff := cmp.Diff(want, r.Errors); diff != "" {
+		t.Errorf("Errors mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Records are capped, and long messages truncated.
+	for i := 0; i < 2*MaxErrorRecords; i++ {
+		r.AddError(errors.New(strings.Repeat("x", 2*maxErrorRecordMessage)))
+	}
+	if got := len(r.Errors); got != MaxErrorRecords {
+		t.Errorf("got %d records, want %d", got, MaxErrorRecords)
+	}
+	if got := len(r.Errors[MaxErrorRecords-1].Message); got != maxErrorRecordMessage {
+		t.Errorf("got message length %d, want %d", got, maxErrorRecordMessage)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 5}
+	for _, s := range []string{"abc", "def", "gh"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v; want %d, nil", s, n, err, len(s))
+		}
+	}
+	if got, want := b.String(), "abcde\n... (3 more bytes)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	b = &limitedBuffer{max: 5}
+	b.Write([]byte("abc"))
+	if got, want := b.String(), "abc"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSetCPUUsage(t *testing.T) {
+	var r Result
+	r.SetCPUUsage(&ScanStats{ScanSeconds: 2})
+	if r.ScanUserCPUSeconds.Valid || r.ScanSystemCPUSeconds.Valid || r.ScanMaxRSS.Valid {
+		t.Errorf("unmeasured: got %v, %v, %v; want nulls", r.ScanUserCPUSeconds, r.ScanSystemCPUSeconds, r.ScanMaxRSS)
+	}
+	r.SetCPUUsage(&ScanStats{UserCPUSeconds: 1.5, SystemCPUSeconds: 0, MaxRSS: 2048})
+	if got, want := r.ScanUserCPUSeconds, bigquery.NullFloat(1.5); got != want {
+		t.Errorf("user: got %v, want %v", got, want)
+	}
+	if got, want := r.ScanSystemCPUSeconds, bigquery.NullFloat(0); got != want {
+		t.Errorf("system: got %v, want %v", got, want)
+	}
+	if got, want := r.ScanMaxRSS, bigquery.NullInt(2048); got != want {
+		t.Errorf("max RSS: got %v, want %v", got, want)
+	}
+}
+
+func TestWorkVersionEqual(t *testing.T) {
+	wv := func() *WorkVersion {
+		return &WorkVersion{
+			GoVersion:          "go1.21.0",
+			WorkerVersion:      "w",
+			SchemaVersion:      "s",
+			VulnDBLastModified: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+			OSVFilterHash:      bigquery.NullString("f"),
+			GovulncheckVersion: bigquery.NullString("v1.0.0"),
+			VulnDBsHash:        bigquery.NullString("h"),
+		}
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		for _, test := range []struct {
+			v1, v2 *WorkVersion
+			want   bool
+		}{
+			{nil, nil, true},
+			{nil, wv(), false},
+			{wv(), nil, false},
+			{nil, &WorkVersion{}, false},
+			{&WorkVersion{}, &WorkVersion{}, true},
+			{wv(), wv(), true},
+		} {
+			if got := test.v1.Equal(test.v2); got != test.want {
+				t.Errorf("%+v.Equal(%+v) = %t, want %t", test.v1, test.v2, got, test.want)
+			}
+		}
+	})
+
+	t.Run("zero timestamps", func(t *testing.T) {
+		// A zero time read back from BigQuery may have a different location.
+		v1, v2 := wv(), wv()
+		v1.VulnDBLastModified = time.Time{}
+		v2.VulnDBLastModified = time.Time{}.In(time.FixedZone("X", 3600))
+		if !v1.Equal(v2) {
+			t.Error("zero times: got not equal, want equal")
+		}
+		if v1.Hash() != v2.Hash() {
+			t.Error("zero times: got different hashes, want the same")
+		}
+		if v1.Equal(wv()) {
+			t.Error("zero and non-zero times: got equal, want not equal")
+		}
+	})
+
+	// Changing any field makes work versions differ, so that a new field
+	// can't be left out of Equal.
+	t.Run("fields", func(t *testing.T) {
+		typ := reflect.TypeOf(WorkVersion{})
+		for i := 0; i < typ.NumField(); i++ {
+			v := wv()
+			f := reflect.ValueOf(v).Elem().Field(i)
+			switch x := f.Addr().Interface().(type) {
+			case *string:
+				*x += "-changed"
+			case *time.Time:
+				*x = x.Add(time.Second)
+			case *bq.NullString:
+				*x = bq.NullString{}
+			default:
+				t.Fatalf("field %s: unhandled type %s", typ.Field(i).Name, f.Type())
+			}
+			if v.Equal(wv()) || wv().Equal(v) {
+				t.Errorf("changing %s: got equal, want not equal", typ.Field(i).Name)
+			}
+			if v.Hash() == wv().Hash() {
+				t.Errorf("changing %s: got the same hash, want different", typ.Field(i).Name)
+			}
+		}
+	})
+}
+
+func TestWorkStateRow(t *testing.T) {
+	lmt := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name string
+		row  workStateRow
+		want WorkState
+	}{
+		{
+			name: "null columns",
+			row:  workStateRow{},
+			want: WorkState{WorkVersion: &WorkVersion{}},
+		},
+		{
+			name: "zero timestamp",
+			row: workStateRow{
+				GoVersion:          bigquery.NullString("go1.21.0"),
+				VulnDBLastModified: bigquery.NullTimestamp(time.Time{}),
+				ErrorCategory:      bigquery.NullString("MISC"),
+			},
+			want: WorkState{WorkVersion: &WorkVersion{GoVersion: "go1.21.0"}, ErrorCategory: "MISC"},
+		},
+		{
+			name: "all columns",
+			row: workStateRow{
+				GoVersion:          bigquery.NullString("go1.21.0"),
+				WorkerVersion:      bigquery.NullString("w"),
+				SchemaVersion:      bigquery.NullString("s"),
+				VulnDBLastModified: bigquery.NullTimestamp(lmt),
+				OSVFilterHash:      bigquery.NullString("f"),
+				GovulncheckVersion: bigquery.NullString("v1.0.0"),
+				VulnDBsHash:        bigquery.NullString("h"),
+			},
+			want: WorkState{WorkVersion: &WorkVersion{
+				GoVersion:          "go1.21.0",
+				WorkerVersion:      "w",
+				SchemaVersion:      "s",
+				VulnDBLastModified: lmt,
+				OSVFilterHash:      bigquery.NullString("f"),
+				GovulncheckVersion: bigquery.NullString("v1.0.0"),
+				VulnDBsHash:        bigquery.NullString("h"),
+			}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.row.workState()
+			if got.WorkVersion == nil {
+				t.Fatal("got nil work version")
+			}
+			if !got.WorkVersion.Equal(test.want.WorkVersion) || got.ErrorCategory != test.want.ErrorCategory {
+				t.Errorf("got %+v, %+v; want %+v, %+v", got.WorkVersion, got.ErrorCategory, test.want.WorkVersion, test.want.ErrorCategory)
+			}
+		})
+	}
+}
+
+func TestWorkStateBatches(t *testing.T) {
+	modspecs := []scan.ModuleSpec{
+		{Path: "a", Version: "v1.0.0"},
+		{Path: "b", Version: "v1.0.0"},
+		{Path: "a", Version: "v1.0.0", ImportedBy: 3},
+		{Path: "a", Version: "v1.1.0"},
+		{Path: "c", Version: "v2.0.0"},
+	}
+	for _, test := range []struct {
+		size int
+		want [][]string
+	}{
+		{10, [][]string{{"a@v1.0.0", "b@v1.0.0", "a@v1.1.0", "c@v2.0.0"}}},
+		{2, [][]string{{"a@v1.0.0", "b@v1.0.0"}, {"a@v1.1.0", "c@v2.0.0"}}},
+		{3, [][]string{{"a@v1.0.0", "b@v1.0.0", "a@v1.1.0"}, {"c@v2.0.0"}}},
+	} {
+		got := workStateBatches(modspecs, test.size)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("size %d: mismatch (-want, +got):\n%s", test.size, diff)
+		}
+	}
+	if got := workStateBatches(nil, 10); got != nil {
+		t.Errorf("no modules: got %v, want nil", got)
+	}
+}
+
 func TestIntegration(t *testing.T) {
 	test.NeedsIntegrationEnv(t)
 
@@ -126,6 +660,11 @@ func TestIntegration(t *testing.T) {
 			VulnDBLastModified: tm,
 		},
 		ErrorCategory: "SOME ERROR",
+		ScanMode:      "IMPORTS",
+		Vulns: []*Vuln{
+			{ID: "GO-1", PackagePath: "p", ModulePath: "m", Version: "v", Level: bigquery.NullString(LevelSymbol)},
+			{ID: "GO-2", PackagePath: "p", ModulePath: "m", Version: "v", Level: bigquery.NullString(LevelPackage)},
+		},
 	}
 
 	t.Run("upload", func(t *testing.T) {
@@ -146,6 +685,13 @@ func TestIntegration(t *testing.T) {
 		if diff := cmp.Diff(row, got); diff != "" {
 			t.Errorf("mismatch (-want, +got):\n%s", diff)
 		}
+		// The called/imported split survives the round trip.
+		if g, w := len(got.CalledVulns()), 1; g != w {
+			t.Errorf("got %d called vulns, want %d", g, w)
+		}
+		if g, w := len(got.ImportedVulns()), 2; g != w {
+			t.Errorf("got %d imported vulns, want %d", g, w)
+		}
 	})
 	t.Run("work versions", func(t *testing.T) {
 		ws, err := ReadWorkState(ctx, client, "m", "v")
@@ -166,6 +712,84 @@ func TestIntegration(t *testing.T) {
 		if want := row.ErrorCategory; want != egot {
 			t.Errorf("got %+v, want %+v", egot, want)
 		}
+
+		wss, err := ReadWorkStates(ctx, client, []scan.ModuleSpec{{Path: "m", Version: "v"}, {Path: "m", Version: "v2"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, w := len(wss), 1; g != w {
+			t.Fatalf("got %d work states, want %d", g, w)
+		}
+		if got := wss["m@v"]; got == nil || !got.WorkVersion.Equal(&row.WorkVersion) {
+			t.Errorf("got %+v, want work version %+v", got, row.WorkVersion)
+		}
+		// Module paths are passed as parameters, so quotes can't change the query.
+		ws, err = ReadWorkState(ctx, client, `m" OR "" = "`, "v")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws != nil {
+			t.Errorf("got work state %+v for quoted path, want nil", ws)
+		}
+	})
+	t.Run("invalidate", func(t *testing.T) {
+		const suffix = "fixture"
+		var rows []bigquery.Row
+		for _, m := range []string{"a", "b", "c"} {
+			rows = append(rows, &Result{
+				ModulePath:         m,
+				Version:            "v1.0.0",
+				Suffix:             suffix,
+				ScanMode:           ModeGovulncheck,
+				AnalysisConfidence: bigquery.NullString(ConfidenceFull),
+				WorkVersion:        WorkVersion{SchemaVersion: SchemaVersion},
+				Vulns:              []*Vuln{{ID: "GO-1", PackagePath: "p", ModulePath: "d", Version: "v1.0.0"}},
+			})
+		}
+		must(bigquery.UploadMany(ctx, client, TableName, rows, 0))
+		numRows := func() int {
+			t.Helper()
+			rates, err := ReadConfidenceRates(ctx, client, suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for _, r := range rates {
+				n += r.NumRows
+			}
+			return n
+		}
+		if got, want := numRows(), 3; got != want {
+			t.Fatalf("before: got %d rows, want %d", got, want)
+		}
+
+		f := &InvalidationFilter{Suffix: suffix}
+		targets, err := ReadInvalidationTargets(ctx, client, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(targets), 3; got != want {
+			t.Fatalf("got %d targets, want %d", got, want)
+		}
+		must(Invalidate(ctx, client, f, "bogus vulns"))
+		if got := numRows(); got != 0 {
+			t.Errorf("after: got %d rows, want 0", got)
+		}
+		ws, err := ReadWorkState(ctx, client, "a", "v1.0.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws != nil {
+			t.Errorf("got work state %+v for invalidated row, want nil", ws)
+		}
+		// Invalidating again selects nothing.
+		targets, err = ReadInvalidationTargets(ctx, client, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(targets) != 0 {
+			t.Errorf("got %d targets after invalidating, want 0", len(targets))
+		}
 	})
 }
 
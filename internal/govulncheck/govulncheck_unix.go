@@ -8,11 +8,31 @@ package govulncheck
 
 import (
 	"os/exec"
+	"runtime"
 	"syscall"
 )
 
 func init() {
-	getMemoryUsage = func(c *exec.Cmd) uint64 {
-		return uint64(c.ProcessState.SysUsage().(*syscall.Rusage).Maxrss)
+	trackMemory = func(c *exec.Cmd) func() uint64 {
+		return func() uint64 { return maxRSS(c) }
 	}
+	getMaxRSS = maxRSS
+	// Processes started by govulncheck, like go list, would otherwise
+	// keep running after it is killed.
+	killProcessGroup = func(c *exec.Cmd) {
+		c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		c.Cancel = func() error {
+			return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		}
+	}
+}
+
+// maxRSS returns the maximum resident set size of a command that has
+// finished, in kb. Darwin reports it in bytes, other systems in kb.
+func maxRSS(c *exec.Cmd) uint64 {
+	rss := uint64(c.ProcessState.SysUsage().(*syscall.Rusage).Maxrss)
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		rss /= 1024
+	}
+	return rss
 }
@@ -0,0 +1,295 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package govulncheck
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// TestRunGovulncheckCmdLeaks checks that RunGovulncheckCmd doesn't leak
+// open files or goroutines, whether govulncheck succeeds or not.
+func TestRunGovulncheckCmdLeaks(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("can't count open files")
+	}
+	for _, test := range []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		wantErr string
+	}{
+		{
+			name:   "success",
+			script: `echo '{"progress": {"message": "Scanning"}}'`,
+		},
+		{
+			name:    "failure",
+			script:  "echo 'govulncheck: loading packages: no Go files' >&2; exit 1",
+			wantErr: "loading packages",
+		},
+		{
+			name: "timeout",
+			// exec replaces the shell, so the pipe is held by the
+			// process that is killed.
+			script:  "exec sleep 60",
+			timeout: 100 * time.Millisecond,
+			wantErr: "timed out",
+		},
+		{
+			name:    "malformed",
+			script:  "echo 'not json'; exec sleep 60",
+			wantErr: "invalid character",
+		},
+		{
+			name:    "not executable",
+			wantErr: "permission denied",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "govulncheck")
+			mode := os.FileMode(0755)
+			if test.script == "" {
+				mode = 0644
+			}
+			if err := os.WriteFile(path, []byte("#!/bin/sh\n"+test.script+"\n"), mode); err != nil {
+				t.Fatal(err)
+			}
+			fds, goroutines := countOpenFiles(t), runtime.NumGoroutine()
+
+			opts := &RunOptions{Timeout: test.timeout}
+			_, _, err := RunGovulncheckCmd(context.Background(), path, FlagSource, "./...", "", []string{t.TempDir()}, opts, &ScanStats{})
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("got error %v, want error containing %q", err, test.wantErr)
+			}
+
+			// Goroutines may take a moment to exit.
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := runtime.NumGoroutine(); got > goroutines {
+				t.Errorf("goroutines: got %d, want at most %d", got, goroutines)
+			}
+			if got := countOpenFiles(t); got > fds {
+				t.Errorf("open files: got %d, want at most %d", got, fds)
+			}
+		})
+	}
+}
+
+// TestRunGovulncheckCmdKill checks that RunGovulncheckCmd kills
+// govulncheck and the processes it started when it times out or is
+// canceled, and reports how long it ran.
+func TestRunGovulncheckCmdKill(t *testing.T) {
+	// The shell waits for sleep, which holds the output pipe, so
+	// RunGovulncheckCmd returns promptly only if both are killed.
+	path := filepath.Join(t.TempDir(), "govulncheck")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nsleep 60\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		timeout time.Duration
+		cancel  bool
+		want    error
+	}{
+		{"timeout", 200 * time.Millisecond, false, derrors.ScanModuleTimeout},
+		{"canceled", 0, true, context.Canceled},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				time.AfterFunc(200*time.Millisecond, cancel)
+			}
+			stats := &ScanStats{}
+			start := time.Now()
+			_, _, err := RunGovulncheckCmd(ctx, path, FlagSource, "./...", "", []string{t.TempDir()}, &RunOptions{Timeout: test.timeout}, stats)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got error %v, want %v", err, test.want)
+			}
+			if d := time.Since(start); d >= pipeWaitDelay {
+				t.Errorf("returned after %s; child processes were not killed", d)
+			}
+			if stats.ScanSeconds <= 0 {
+				t.Errorf("got ScanSeconds %g, want positive", stats.ScanSeconds)
+			}
+		})
+	}
+}
+
+func countOpenFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestGovulncheckArgs(t *testing.T) {
+	got := govulncheckArgs(FlagSource, "./...", "/tmp/mod", []string{"/tmp/vulndb"})
+	want := []string{"-mode", "source", "-json", "-db", "file:///tmp/vulndb", "-C", "/tmp/mod", "./..."}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	got = govulncheckArgs(FlagSource, "./...", "", []string{"/tmp/vulndb", "/tmp/private"})
+	want = []string{"-mode", "source", "-json", "-db", "file:///tmp/vulndb", "-db", "file:///tmp/private", "./..."}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("two DBs: mismatch (-want, +got):\n%s", diff)
+	}
+	got = govulncheckArgs(FlagImports, "./...", "/tmp/mod", []string{"/tmp/vulndb"})
+	want = []string{"-mode", "source", "-scan", "package", "-json", "-db", "file:///tmp/vulndb", "-C", "/tmp/mod", "./..."}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("imports: mismatch (-want, +got):\n%s", diff)
+	}
+	got = govulncheckArgs(FlagSource, "./...", "", []string{"http://127.0.0.1:1234", "/tmp/private"})
+	want = []string{"-mode", "source", "-json", "-db", "http://127.0.0.1:1234", "-db", "file:///tmp/private", "./..."}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("URL: mismatch (-want, +got):\n%s", diff)
+	}
+	if !MemoryUsageAvailable() {
+		t.Error("memory usage is not measured on Unix")
+	}
+}
+
+func TestRunGovulncheckPerPackage(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	modDir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":   "module example.com/m\n\ngo 1.20\n",
+		"a/a.go":   "package a\n",
+		"b/b.go":   "package b\n",
+		"c/c.go":   "package c\n",
+		"cmd/x.go": "package main\n\nfunc main() {}\n",
+	} {
+		path := filepath.Join(modDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The fake govulncheck fails to build example.com/m/b, and reports
+	// GO-1 as imported for every other package, and as called for c.
+	script := `for pkg; do :; done
+case $pkg in
+*/b) echo "b/b.go:1:1: build failed" >&2; exit 1;;
+*/c) echo '{"finding": {"osv": "GO-1", "trace": [{"module": "m", "function": "F"}]}}';;
+*) echo '{"finding": {"osv": "GO-1", "trace": [{"module": "m"}]}}';;
+esac`
+	path := filepath.Join(t.TempDir(), "govulncheck")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var stats ScanStats
+	findings, _, err := RunGovulncheckPerPackage(context.Background(), path, FlagSource, "./...", modDir, []string{t.TempDir()}, nil, &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Trace[0].Function != "F" {
+		t.Errorf("got findings %+v, want one called finding for GO-1", findings)
+	}
+	if len(stats.PackageErrors) != 1 || stats.PackageErrors[0].Package != "example.com/m/b" {
+		t.Errorf("got package errors %+v, want one for example.com/m/b", stats.PackageErrors)
+	}
+	if got, want := stats.PackagesWithErrors, 1; got != want {
+		t.Errorf("got %d packages with errors, want %d", got, want)
+	}
+
+	// The scan fails if no package can be scanned.
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho 'build failed' >&2; exit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stats = ScanStats{}
+	_, _, err = RunGovulncheckPerPackage(context.Background(), path, FlagSource, "./...", modDir, []string{t.TempDir()}, nil, &stats)
+	if err == nil || !strings.Contains(err.Error(), "none of 4 packages") {
+		t.Errorf("got error %v, want one saying no package could be scanned", err)
+	}
+	if got, want := len(stats.PackageErrors), 4; got != want {
+		t.Errorf("got %d package errors, want %d", got, want)
+	}
+}
+
+func TestRunGovulncheckCmdTests(t *testing.T) {
+	// The script fails unless its first argument is -test.
+	path := filepath.Join(t.TempDir(), "govulncheck")
+	script := "#!/bin/sh\n[ \"$1\" = -test ] || { echo \"args: $*\" >&2; exit 1; }\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &RunOptions{Tests: true}
+	if _, _, err := RunGovulncheckCmd(context.Background(), path, FlagSource, "./...", "", []string{t.TempDir()}, opts, &ScanStats{}); err != nil {
+		t.Fatal(err)
+	}
+	opts.Tests = false
+	if _, _, err := RunGovulncheckCmd(context.Background(), path, FlagSource, "./...", "", []string{t.TempDir()}, opts, &ScanStats{}); err == nil {
+		t.Error("without Tests: got nil, want error")
+	}
+}
+
+func TestRunGovulncheckCmdExitStatus(t *testing.T) {
+	finding := `{"finding": {"osv": "GO-2023-0001", "trace": [{"module": "m"}]}}`
+	for _, test := range []struct {
+		name    string
+		script  string
+		want    int // number of findings
+		wantErr string
+	}{
+		{
+			name:   "vulns found",
+			script: "echo '" + finding + "'; exit 3",
+			want:   1,
+		},
+		{
+			name:    "failure",
+			script:  "echo '" + finding + "'; echo 'govulncheck: internal error' >&2; exit 1",
+			wantErr: "internal error",
+		},
+		{
+			name:    "failure without stderr",
+			script:  "exit 2",
+			wantErr: "exit status 2",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "govulncheck")
+			if err := os.WriteFile(path, []byte("#!/bin/sh\n"+test.script+"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			findings, _, err := RunGovulncheckCmd(context.Background(), path, FlagSource, "./...", "", []string{t.TempDir()}, nil, &ScanStats{})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(findings) != test.want {
+				t.Errorf("got %d findings, want %d", len(findings), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+
+package govulncheck
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32                   = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW          = modkernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject  = modkernel32.NewProc("AssignProcessToJobObject")
+	procQueryInformationJobObject = modkernel32.NewProc("QueryInformationJobObject")
+)
+
+const (
+	jobObjectExtendedLimitInformationClass = 9
+	processSetQuota                        = 0x0100
+	processTerminate                       = 0x0001
+)
+
+// jobObjectExtendedLimitInformation is JOBOBJECT_EXTENDED_LIMIT_INFORMATION.
+type jobObjectExtendedLimitInformation struct {
+	BasicLimitInformation struct {
+		PerProcessUserTimeLimit int64
+		PerJobUserTimeLimit     int64
+		LimitFlags              uint32
+		MinimumWorkingSetSize   uintptr
+		MaximumWorkingSetSize   uintptr
+		ActiveProcessLimit      uint32
+		Affinity                uintptr
+		PriorityClass           uint32
+		SchedulingClass         uint32
+	}
+	IoInfo struct {
+		ReadOperationCount  uint64
+		WriteOperationCount uint64
+		OtherOperationCount uint64
+		ReadTransferCount   uint64
+		WriteTransferCount  uint64
+		OtherTransferCount  uint64
+	}
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+func init() {
+	trackMemory = trackJobMemory
+}
+
+// trackJobMemory assigns the started command to a new job object, so
+// that the memory used by the processes it starts is counted too. It
+// returns nil if the job object cannot be set up.
+//
+// Processes the command starts before it is assigned to the job are not
+// counted. govulncheck starts none before it has read its arguments.
+func trackJobMemory(c *exec.Cmd) func() uint64 {
+	job, _, _ := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return nil
+	}
+	proc, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(c.Process.Pid))
+	if err != nil {
+		syscall.CloseHandle(syscall.Handle(job))
+		return nil
+	}
+	defer syscall.CloseHandle(proc)
+	if r, _, _ := procAssignProcessToJobObject.Call(job, uintptr(proc)); r == 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+		return nil
+	}
+	return func() uint64 {
+		defer syscall.CloseHandle(syscall.Handle(job))
+		var info jobObjectExtendedLimitInformation
+		r, _, _ := procQueryInformationJobObject.Call(job, jobObjectExtendedLimitInformationClass,
+			uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), 0)
+		if r == 0 {
+			return 0
+		}
+		return uint64(info.PeakJobMemoryUsed) / 1024
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+
+package govulncheck
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGovulncheckArgsWindows(t *testing.T) {
+	for _, test := range []struct {
+		name                                string
+		mode, pattern, moduleDir, vulndbDir string
+		want                                []string
+	}{
+		{
+			name:      "source",
+			mode:      FlagSource,
+			pattern:   `.\...`,
+			moduleDir: `C:\Users\gopher\mod`,
+			vulndbDir: `C:\vulndb`,
+			want: []string{"-mode", "source", "-json", "-db", "file:///C:/vulndb",
+				"-C", "C:/Users/gopher/mod", "./..."},
+		},
+		{
+			name:      "no module dir",
+			mode:      FlagSource,
+			pattern:   `.\cmd\...`,
+			vulndbDir: `D:\db`,
+			want:      []string{"-mode", "source", "-json", "-db", "file:///D:/db", "./cmd/..."},
+		},
+		{
+			name:      "binary",
+			mode:      FlagBinary,
+			pattern:   `C:\bin\x.exe`,
+			moduleDir: `C:\mod`,
+			vulndbDir: `C:\vulndb`,
+			want: []string{"-mode", "binary", "-json", "-db", "file:///C:/vulndb",
+				"-C", "C:/mod", `C:\bin\x.exe`},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := govulncheckArgs(test.mode, test.pattern, test.moduleDir, []string{test.vulndbDir})
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMemoryUsageAvailable(t *testing.T) {
+	if !MemoryUsageAvailable() {
+		t.Error("memory usage is not measured on Windows")
+	}
+}
+
+func TestTrackJobMemory(t *testing.T) {
+	cmd := exec.Command("cmd", "/c", "echo")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	peak := trackJobMemory(cmd)
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if peak == nil {
+		t.Fatal("got nil, want a peak memory function")
+	}
+	if got := peak(); got == 0 {
+		t.Error("got 0 kb, want >0")
+	}
+}
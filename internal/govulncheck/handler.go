@@ -5,6 +5,9 @@
 package govulncheck
 
 import (
+	"sort"
+	"sync"
+
 	"golang.org/x/exp/maps"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
 	"golang.org/x/pkgsite-metrics/internal/osv"
@@ -12,31 +15,72 @@ import (
 
 // NewMetricsHandler returns a handler that returns a set of all findings.
 // For use in the ecosystem metrics pipeline.
-func NewMetricsHandler() *MetricsHandler {
-	m := make(map[string]*govulncheckapi.Finding)
+// It is safe for concurrent use.
+//
+// If maxFindings is positive, the handler stops storing findings for
+// new OSVs once it has seen that many; see Capped. Later findings still
+// replace uncalled ones for OSVs already stored.
+func NewMetricsHandler(maxFindings int) *MetricsHandler {
 	return &MetricsHandler{
-		byOSV: m,
+		maxFindings: maxFindings,
+		byOSV:       make(map[string]*govulncheckapi.Finding),
+		entries:     make(map[string]*osv.Entry),
 	}
 }
 
+// A MetricsHandler collects findings and OSV entries. It skips the
+// messages of other kinds that newer versions of govulncheck emit,
+// but counts them; see Skipped.
 type MetricsHandler struct {
-	byOSV map[string]*govulncheckapi.Finding
+	govulncheckapi.SkippedMessages
+
+	maxFindings int
+	progress    func(*govulncheckapi.Progress) // if non-nil, called for each progress message
+
+	mu       sync.Mutex
+	config   *govulncheckapi.Config // the config message, if any
+	byOSV    map[string]*govulncheckapi.Finding
+	entries  map[string]*osv.Entry // OSV entries in the stream, by ID
+	nfinding int                   // number of findings seen, including dropped ones
 }
 
 func (h *MetricsHandler) Config(c *govulncheckapi.Config) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.config = c
 	return nil
 }
 
+// ScannerConfig returns the config message handled so far,
+// or nil if there was none.
+func (h *MetricsHandler) ScannerConfig() *govulncheckapi.Config {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.config
+}
+
 func (h *MetricsHandler) Progress(p *govulncheckapi.Progress) error {
+	if h.progress != nil {
+		h.progress(p)
+	}
 	return nil
 }
 
 func (h *MetricsHandler) OSV(e *osv.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries[e.ID] = e
 	return nil
 }
 
 func (h *MetricsHandler) Finding(finding *govulncheckapi.Finding) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nfinding++
 	f, found := h.byOSV[finding.OSV]
+	if !found && h.maxFindings > 0 && h.nfinding > h.maxFindings {
+		return nil
+	}
 	if !found || f.Trace[0].Function == "" {
 		// If the vuln wasn't called in the first trace, replace it with
 		// the new finding (that way if the vuln is called at any point
@@ -46,6 +90,43 @@ func (h *MetricsHandler) Finding(finding *govulncheckapi.Finding) error {
 	return nil
 }
 
+// FindingCount returns the number of findings seen so far,
+// including those dropped because of the cap.
+func (h *MetricsHandler) FindingCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.nfinding
+}
+
+// Capped reports whether findings were dropped because
+// more than the maximum were seen.
+func (h *MetricsHandler) Capped() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.maxFindings > 0 && h.nfinding > h.maxFindings
+}
+
+// Findings returns a snapshot of the findings handled so far,
+// one per OSV ID, sorted by ID.
 func (h *MetricsHandler) Findings() []*govulncheckapi.Finding {
-	return maps.Values(h.byOSV)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fs := maps.Values(h.byOSV)
+	sort.Slice(fs, func(i, j int) bool { return fs[i].OSV < fs[j].OSV })
+	return fs
+}
+
+// OSVs returns a snapshot of the OSV entries handled so far that
+// have findings, sorted by ID.
+func (h *MetricsHandler) OSVs() []*osv.Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var es []*osv.Entry
+	for id := range h.byOSV {
+		if e, ok := h.entries[id]; ok {
+			es = append(es, e)
+		}
+	}
+	sort.Slice(es, func(i, j int) bool { return es[i].ID < es[j].ID })
+	return es
 }
@@ -5,9 +5,20 @@
 package govulncheck
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/osv"
 )
 
 func TestMetricsHandler(t *testing.T) {
@@ -39,7 +50,7 @@ func TestMetricsHandler(t *testing.T) {
 	}
 
 	t.Run("Called finding overwrites uncalled w/ same ID", func(t *testing.T) {
-		h := NewMetricsHandler()
+		h := NewMetricsHandler(0)
 		h.Finding(uncalledFinding)
 		h.Finding(calledFinding)
 		findings := h.Findings()
@@ -48,3 +59,172 @@ func TestMetricsHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestEnrichMissingOSV(t *testing.T) {
+	withdrawn := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	stream := `
+{"osv": {"id": "GO-0000-0001", "summary": "present"}}
+{"osv": {"id": "GO-0000-0002", "summary": "withdrawn", "withdrawn": "` + withdrawn.Format(time.RFC3339) + `"}}
+{"finding": {"osv": "GO-0000-0001", "trace": [{"module": "example.com/a", "version": "v1.0.0"}]}}
+{"finding": {"osv": "GO-0000-0002", "trace": [{"module": "example.com/b", "version": "v1.0.0"}]}}
+{"finding": {"osv": "GO-0000-0003", "trace": [{"module": "example.com/c", "version": "v1.0.0"}]}}
+`
+	h := NewMetricsHandler(0)
+	if err := govulncheckapi.HandleJSON(strings.NewReader(stream), h); err != nil {
+		t.Fatal(err)
+	}
+	var vulns []*Vuln
+	for _, f := range h.Findings() {
+		vulns = append(vulns, ConvertGovulncheckFinding(f))
+	}
+	sort.Slice(vulns, func(i, j int) bool { return vulns[i].ID < vulns[j].ID })
+
+	missing := EnrichVulns(vulns, h.OSVs())
+	sort.Strings(missing)
+	if want := []string{"GO-0000-0002", "GO-0000-0003"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing: got %v, want %v", missing, want)
+	}
+	for _, v := range vulns {
+		wantMissing := v.ID != "GO-0000-0001"
+		if got := v.WithdrawnOrMissing.Bool; got != wantMissing || !v.WithdrawnOrMissing.Valid {
+			t.Errorf("%s: WithdrawnOrMissing = %v, want %t", v.ID, v.WithdrawnOrMissing, wantMissing)
+		}
+		if got := v.Summary.Valid; got == wantMissing {
+			t.Errorf("%s: Summary = %v, want valid=%t", v.ID, v.Summary, !wantMissing)
+		}
+	}
+}
+
+// TestMetricsHandlerConcurrent is most useful when run with -race.
+func TestMetricsHandlerConcurrent(t *testing.T) {
+	const (
+		goroutines = 8
+		ids        = 500
+	)
+	h := NewMetricsHandler(0)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < ids; i++ {
+				id := fmt.Sprintf("GO-0000-%04d", i)
+				fn := ""
+				if i%goroutines == g {
+					fn = "f" // exactly one goroutine reports each vuln as called
+				}
+				f := &govulncheckapi.Finding{
+					OSV:   id,
+					Trace: []*govulncheckapi.Frame{{Module: "example.com/m", Function: fn}},
+				}
+				if err := h.Finding(f); err != nil {
+					t.Error(err)
+				}
+				if err := h.OSV(&osv.Entry{ID: id}); err != nil {
+					t.Error(err)
+				}
+				if err := h.Progress(&govulncheckapi.Progress{}); err != nil {
+					t.Error(err)
+				}
+				_ = h.Findings()
+			}
+		}()
+	}
+	wg.Wait()
+
+	findings := h.Findings()
+	if len(findings) != ids {
+		t.Fatalf("got %d findings, want %d", len(findings), ids)
+	}
+	for i, f := range findings {
+		if want := fmt.Sprintf("GO-0000-%04d", i); f.OSV != want {
+			t.Fatalf("finding %d: got %s, want %s", i, f.OSV, want)
+		}
+		if f.Trace[0].Function == "" {
+			t.Errorf("%s: called finding was lost", f.OSV)
+		}
+	}
+	if got := len(h.OSVs()); got != ids {
+		t.Errorf("got %d OSVs, want %d", got, ids)
+	}
+}
+
+func TestMetricsHandlerCap(t *testing.T) {
+	h := NewMetricsHandler(2)
+	for i := 0; i < 5; i++ {
+		f := &govulncheckapi.Finding{
+			OSV:   fmt.Sprintf("GO-0000-%04d", i),
+			Trace: []*govulncheckapi.Frame{{Module: "example.com/m"}},
+		}
+		if err := h.Finding(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(h.Findings()); got != 2 {
+		t.Errorf("got %d findings, want 2", got)
+	}
+	if got := h.FindingCount(); got != 5 {
+		t.Errorf("got count %d, want 5", got)
+	}
+	if !h.Capped() {
+		t.Error("got not capped, want capped")
+	}
+
+	// A called finding past the cap still replaces an uncalled one.
+	called := &govulncheckapi.Finding{
+		OSV:   "GO-0000-0001",
+		Trace: []*govulncheckapi.Frame{{Module: "example.com/m", Function: "F"}},
+	}
+	if err := h.Finding(called); err != nil {
+		t.Fatal(err)
+	}
+	fs := h.Findings()
+	if len(fs) != 2 || fs[1] != called {
+		t.Errorf("got findings %v, want GO-0000-0001 to be called", fs)
+	}
+}
+
+func TestMetricsHandlerSkipped(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "govulncheckapi", "testdata", "sarif.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewMetricsHandler(0)
+	if err := govulncheckapi.HandleJSON(bytes.NewReader(data), h); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(h.Findings()); got != 1 {
+		t.Errorf("got %d findings, want 1", got)
+	}
+	if got, want := h.Skipped(), map[string]int{"sarif": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Skipped() = %v, want %v", got, want)
+	}
+}
+
+func TestMetricsHandlerConfig(t *testing.T) {
+	const stream = `{"config":{"protocol_version":"v1.0.0","scanner_name":"govulncheck","scanner_version":"v1.0.1",` +
+		`"db":"file:///vulndb","db_last_modified":"2023-06-01T00:00:00Z","go_version":"go1.21.0","goos":"linux","goarch":"amd64"}}`
+	h := NewMetricsHandler(0)
+	if err := govulncheckapi.HandleJSON(strings.NewReader(stream), h); err != nil {
+		t.Fatal(err)
+	}
+	got := ConvertScannerConfig(h.ScannerConfig())
+	want := &ScannerConfig{
+		ProtocolVersion: "v1.0.0",
+		ScannerName:     "govulncheck",
+		ScannerVersion:  "v1.0.1",
+		DB:              "file:///vulndb",
+		DBLastModified:  bigquery.NullTimestamp(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)),
+		GoVersion:       "go1.21.0",
+		GOOS:            "linux",
+		GOARCH:          "amd64",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if got := ConvertScannerConfig(NewMetricsHandler(0).ScannerConfig()); got != nil {
+		t.Errorf("no config message: got %+v, want nil", got)
+	}
+}
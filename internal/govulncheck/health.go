@@ -0,0 +1,170 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// HealthQueryParams has query parameters for a govulncheck/check-health request.
+type HealthQueryParams struct {
+	Suffix   string // suffix identifying the active run
+	Previous string // suffix identifying the previous, completed run
+	Hours    int    // only consider rows of the active run from the last Hours hours
+}
+
+// RunHealth summarizes the rows of a run.
+type RunHealth struct {
+	NumRows         int     `bigquery:"num_rows"`
+	NumErrors       int     `bigquery:"num_errors"`
+	NumOOMs         int     `bigquery:"num_ooms"`
+	MeanScanSeconds float64 `bigquery:"mean_scan_seconds"`
+}
+
+// ErrorRate returns the fraction of rows with an error.
+func (h *RunHealth) ErrorRate() float64 { return h.rate(h.NumErrors) }
+
+// OOMRate returns the fraction of rows that exceeded the memory limit.
+func (h *RunHealth) OOMRate() float64 { return h.rate(h.NumOOMs) }
+
+func (h *RunHealth) rate(n int) float64 {
+	if h.NumRows == 0 {
+		return 0
+	}
+	return float64(n) / float64(h.NumRows)
+}
+
+// ReadRunHealth summarizes the rows in the govulncheck table with the given
+// suffix whose scans finished at or after since. Rows without a scan
+// finish time use their creation time instead. IMPORTS rows written by
+// GOVULNCHECK scans are ignored since they duplicate the GOVULNCHECK rows
+// without scanning.
+func ReadRunHealth(ctx context.Context, c *bigquery.Client, suffix string, since time.Time) (_ *RunHealth, err error) {
+	defer derrors.Wrap(&err, "ReadRunHealth(%q, %s)", suffix, since)
+
+	const qf = `
+		SELECT
+			COUNT(*) AS num_rows,
+			COUNTIF(error != "" AND error_category != "%s") AS num_errors,
+			COUNTIF(error_category = "%s") AS num_ooms,
+			IFNULL(AVG(scan_seconds), 0) AS mean_scan_seconds
+		FROM %s AS r
+		WHERE suffix = @suffix
+			AND COALESCE(scan_finished_at, created_at) >= TIMESTAMP("%s")
+			AND %s
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, derrors.CategorizeError(derrors.ScanDeferred),
+		derrors.CategorizeError(derrors.ScanModuleMemoryLimitExceeded),
+		table, since.UTC().Format(time.RFC3339), notImportsCopyCondition(table, "r"))
+	params := []bq.QueryParameter{bigquery.Param("suffix", suffix)}
+	h := &RunHealth{}
+	err = bigquery.ForEach(ctx, c, query, params, func(r *RunHealth) bool {
+		h = r
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Thresholds determine when the health of a run is anomalous
+// compared to a previous run.
+type Thresholds struct {
+	ErrorRateIncrease float64
+	ScanTimeRatio     float64
+	OOMRateIncrease   float64
+}
+
+// Metrics reported in an Alert.
+const (
+	MetricErrorRate = "error_rate"
+	MetricScanTime  = "mean_scan_seconds"
+	MetricOOMRate   = "oom_rate"
+)
+
+// Anomalies compares h to prev and returns an Alert for
+// each metric that exceeds its threshold.
+// It returns nil if either run has no rows.
+func (h *RunHealth) Anomalies(prev *RunHealth, th Thresholds) []*Alert {
+	if h.NumRows == 0 || prev.NumRows == 0 {
+		return nil
+	}
+	var alerts []*Alert
+	add := func(metric string, value, baseline, threshold float64) {
+		alerts = append(alerts, &Alert{
+			Metric:    metric,
+			Value:     value,
+			Baseline:  baseline,
+			Threshold: threshold,
+		})
+	}
+	if th.ErrorRateIncrease > 0 && h.ErrorRate()-prev.ErrorRate() > th.ErrorRateIncrease {
+		add(MetricErrorRate, h.ErrorRate(), prev.ErrorRate(), th.ErrorRateIncrease)
+	}
+	if th.ScanTimeRatio > 0 && prev.MeanScanSeconds > 0 && h.MeanScanSeconds/prev.MeanScanSeconds > th.ScanTimeRatio {
+		add(MetricScanTime, h.MeanScanSeconds, prev.MeanScanSeconds, th.ScanTimeRatio)
+	}
+	if th.OOMRateIncrease > 0 && h.OOMRate()-prev.OOMRate() > th.OOMRateIncrease {
+		add(MetricOOMRate, h.OOMRate(), prev.OOMRate(), th.OOMRateIncrease)
+	}
+	return alerts
+}
+
+const AlertTableName = "govulncheck-alerts"
+
+// Alert is a row in the BigQuery govulncheck-alerts table.
+// It records that an anomaly was reported for a run, so
+// that the same anomaly is not reported twice.
+type Alert struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	Suffix    string    `bigquery:"suffix"`
+	Previous  string    `bigquery:"previous"`
+	Metric    string    `bigquery:"metric"`
+	Value     float64   `bigquery:"value"`
+	Baseline  float64   `bigquery:"baseline"`
+	Threshold float64   `bigquery:"threshold"`
+}
+
+func (a *Alert) SetUploadTime(t time.Time) { a.CreatedAt = t }
+
+func (a *Alert) String() string {
+	return fmt.Sprintf("run %q: %s is %.3g (previous run %q: %.3g, threshold %.3g)",
+		a.Suffix, a.Metric, a.Value, a.Previous, a.Baseline, a.Threshold)
+}
+
+func init() {
+	s, err := bigquery.InferSchema(Alert{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(AlertTableName, s)
+}
+
+// ReadAlertedMetrics returns the set of metrics for which an alert was
+// already recorded for the run with the given suffix.
+func ReadAlertedMetrics(ctx context.Context, c *bigquery.Client, suffix string) (_ map[string]bool, err error) {
+	defer derrors.Wrap(&err, "ReadAlertedMetrics(%q)", suffix)
+
+	const qf = `SELECT * FROM %s WHERE suffix = @suffix`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(AlertTableName)+"`")
+	params := []bq.QueryParameter{bigquery.Param("suffix", suffix)}
+	metrics := map[string]bool{}
+	err = bigquery.ForEach(ctx, c, query, params, func(a *Alert) bool {
+		metrics[a.Metric] = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
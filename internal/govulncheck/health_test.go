@@ -0,0 +1,52 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAnomalies(t *testing.T) {
+	th := Thresholds{ErrorRateIncrease: 0.1, ScanTimeRatio: 1.5, OOMRateIncrease: 0.05}
+	prev := &RunHealth{NumRows: 100, NumErrors: 10, NumOOMs: 1, MeanScanSeconds: 10}
+	for _, test := range []struct {
+		name string
+		cur  *RunHealth
+		want []string
+	}{
+		{
+			name: "healthy",
+			cur:  &RunHealth{NumRows: 50, NumErrors: 6, NumOOMs: 1, MeanScanSeconds: 12},
+			want: nil,
+		},
+		{
+			name: "no rows",
+			cur:  &RunHealth{},
+			want: nil,
+		},
+		{
+			name: "errors",
+			cur:  &RunHealth{NumRows: 10, NumErrors: 4, MeanScanSeconds: 10},
+			want: []string{MetricErrorRate},
+		},
+		{
+			name: "all",
+			cur:  &RunHealth{NumRows: 10, NumErrors: 4, NumOOMs: 2, MeanScanSeconds: 16},
+			want: []string{MetricErrorRate, MetricScanTime, MetricOOMRate},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, a := range test.cur.Anomalies(prev, th) {
+				got = append(got, a.Metric)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// HistoryQueryParams are the query params of the
+// /govulncheck/history/MODULE endpoint.
+type HistoryQueryParams struct {
+	Limit  int    // maximum number of rows to return
+	Since  string // only return rows created on or after this date (YYYY-MM-DD)
+	Until  string // only return rows created before this date (YYYY-MM-DD)
+	Before string // cursor: only return rows created before this RFC 3339 time
+	Format string // "json" (the default) or "csv"
+}
+
+// DefaultHistoryLimit and MaxHistoryLimit are the default and maximum
+// number of rows returned by a history request.
+const (
+	DefaultHistoryLimit = 100
+	MaxHistoryLimit     = 1000
+)
+
+// A HistoryRow summarizes one row of a module's scan history.
+type HistoryRow struct {
+	Version       string    `bigquery:"version"`
+	CreatedAt     time.Time `bigquery:"created_at"`
+	ScanMode      string    `bigquery:"scan_mode"`
+	ErrorCategory string    `bigquery:"error_category"`
+	// CalledOSVs are the sorted IDs of the vulns whose symbols are called.
+	CalledOSVs []string `bigquery:"called_osvs"`
+}
+
+// ReadHistory returns the rows of the module with the given path created
+// in [since, until) and before the cursor, newest first, up to limit rows.
+// Zero times impose no bound. Ad hoc scans, tombstones, correction rows
+// and the rows they invalidate are omitted.
+//
+// It also returns the cursor for the next page, or the zero time if there
+// are no more rows. Rows with the same creation time are written together,
+// so a page never ends in the middle of them unless they fill it; in that
+// case the rest of them are skipped.
+func ReadHistory(ctx context.Context, c *bigquery.Client, modulePath string, since, until, cursor time.Time, limit int) (_ []*HistoryRow, next time.Time, err error) {
+	defer derrors.Wrap(&err, "ReadHistory(%q, %s, %s, %s, %d)", modulePath, since, until, cursor, limit)
+
+	const qf = `
+		SELECT
+			version,
+			created_at,
+			scan_mode,
+			error_category,
+			ARRAY(
+				SELECT DISTINCT v.id FROM UNNEST(vulns) AS v
+				WHERE scan_mode = "%s" OR IFNULL(v.level = "%s", FALSE)
+				ORDER BY v.id
+			) AS called_osvs
+		FROM %s AS r
+		WHERE module_path = @module_path AND scan_mode NOT IN ("%s", "%s") AND NOT STARTS_WITH(suffix, "%s")
+			AND %s %s
+		ORDER BY created_at DESC, version DESC, scan_mode
+		LIMIT %d
+	`
+	var cond string
+	if !since.IsZero() {
+		cond += fmt.Sprintf(` AND created_at >= TIMESTAMP("%s")`, since.UTC().Format(time.RFC3339Nano))
+	}
+	if !until.IsZero() {
+		cond += fmt.Sprintf(` AND created_at < TIMESTAMP("%s")`, until.UTC().Format(time.RFC3339Nano))
+	}
+	if !cursor.IsZero() {
+		cond += fmt.Sprintf(` AND created_at < TIMESTAMP("%s")`, cursor.UTC().Format(time.RFC3339Nano))
+	}
+	// Read one more row than needed, to know whether there are more.
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, ModeGovulncheck, LevelSymbol, table,
+		ModeTombstone, ModeInvalidation, AdHocSuffixPrefix, notInvalidatedCondition(table, "r"), cond, limit+1)
+	rows, err := bigquery.Query[HistoryRow](ctx, c, query, bigquery.Param("module_path", modulePath))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	rows, next = historyPage(rows, limit)
+	return rows, next, nil
+}
+
+// historyPage trims rows, which are sorted newest first and number at
+// most limit+1, to a page and returns the cursor for the next page. If the
+// page would end in the middle of the rows with some creation time, those
+// rows are left for the next page, unless they are the whole page.
+func historyPage(rows []*HistoryRow, limit int) (_ []*HistoryRow, next time.Time) {
+	if len(rows) <= limit {
+		return rows, time.Time{}
+	}
+	last := rows[limit-1].CreatedAt
+	if !rows[limit].CreatedAt.Equal(last) {
+		return rows[:limit], last
+	}
+	i := limit
+	for i > 0 && rows[i-1].CreatedAt.Equal(last) {
+		i--
+	}
+	if i == 0 {
+		return rows[:limit], last
+	}
+	return rows[:i], rows[i-1].CreatedAt
+}
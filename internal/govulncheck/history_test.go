@@ -0,0 +1,43 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryPage(t *testing.T) {
+	t0 := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	rowsAt := func(hours ...int) []*HistoryRow {
+		var rows []*HistoryRow
+		for _, h := range hours {
+			rows = append(rows, &HistoryRow{CreatedAt: t0.Add(time.Duration(h) * time.Hour)})
+		}
+		return rows
+	}
+	for _, test := range []struct {
+		name     string
+		rows     []*HistoryRow
+		limit    int
+		wantRows int
+		wantNext time.Time
+	}{
+		{"empty", nil, 3, 0, time.Time{}},
+		{"short page", rowsAt(5, 4), 3, 2, time.Time{}},
+		{"last page", rowsAt(5, 4, 3), 3, 3, time.Time{}},
+		{"more", rowsAt(5, 4, 3, 2), 3, 3, t0.Add(3 * time.Hour)},
+		// The rows at hour 3 continue past the page.
+		{"tie at end", rowsAt(5, 3, 3, 3), 3, 1, t0.Add(5 * time.Hour)},
+		{"all tied", rowsAt(3, 3, 3, 3), 3, 3, t0.Add(3 * time.Hour)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rows, next := historyPage(test.rows, test.limit)
+			if len(rows) != test.wantRows || !next.Equal(test.wantNext) {
+				t.Errorf("got %d rows, next %s; want %d rows, next %s", len(rows), next, test.wantRows, test.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// ModeInvalidation is the scan mode of correction rows, which mark
+// another row as invalid; see Invalidate.
+const ModeInvalidation = "INVALIDATION"
+
+// InvalidateQueryParams are the query params of the
+// /govulncheck/invalidate endpoint.
+type InvalidateQueryParams struct {
+	Suffix string // invalidate the rows of the run with this suffix
+	From   string // invalidate rows created at or after this time (RFC 3339)
+	To     string // invalidate rows created before this time (RFC 3339)
+	Reason string // why the rows are invalid; required
+	DryRun bool   // if true, report the rows to invalidate but don't write rows
+	// Rescan, if non-empty, is the suffix of a run that scans the affected
+	// module versions again.
+	Rescan string
+}
+
+// An Invalidation describes the row that a correction row invalidates.
+type Invalidation struct {
+	Suffix    string    `bigquery:"suffix"`
+	ScanMode  string    `bigquery:"scan_mode"`
+	CreatedAt time.Time `bigquery:"created_at"`
+	Reason    string    `bigquery:"reason"`
+}
+
+// An InvalidationFilter selects the rows to invalidate: those of the run
+// with Suffix, if it is non-empty, that were created in [From, To). Zero
+// times impose no bound, but at least one of Suffix, From and To must be
+// set.
+type InvalidationFilter struct {
+	Suffix   string
+	From, To time.Time
+}
+
+// ParseInvalidationFilter returns the filter described by params.
+func ParseInvalidationFilter(params *InvalidateQueryParams) (*InvalidationFilter, error) {
+	if params.Reason == "" {
+		return nil, errors.New("missing reason")
+	}
+	f := &InvalidationFilter{Suffix: params.Suffix}
+	if f.Suffix != "" {
+		if err := ValidateSuffix(f.Suffix); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if params.From != "" {
+		f.From, err = time.Parse(time.RFC3339, params.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %v", err)
+		}
+	}
+	if params.To != "" {
+		f.To, err = time.Parse(time.RFC3339, params.To)
+		if err != nil {
+			return nil, fmt.Errorf("to: %v", err)
+		}
+	}
+	if f.Suffix == "" && f.From.IsZero() && f.To.IsZero() {
+		return nil, errors.New("need a suffix or a time range")
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return nil, errors.New("empty time range")
+	}
+	return f, nil
+}
+
+// condition returns a SQL condition that holds for the rows selected by f
+// that can be invalidated: scan rows that have not been invalidated yet.
+// Tombstones and correction rows are never selected.
+func (f *InvalidationFilter) condition(table string) string {
+	cond := fmt.Sprintf(`r.scan_mode NOT IN ("%s", "%s") AND %s`,
+		ModeTombstone, ModeInvalidation, notInvalidatedCondition(table, "r"))
+	if f.Suffix != "" {
+		cond += fmt.Sprintf(` AND r.suffix = "%s"`, f.Suffix)
+	}
+	if !f.From.IsZero() {
+		cond += fmt.Sprintf(` AND r.created_at >= TIMESTAMP("%s")`, f.From.UTC().Format(time.RFC3339Nano))
+	}
+	if !f.To.IsZero() {
+		cond += fmt.Sprintf(` AND r.created_at < TIMESTAMP("%s")`, f.To.UTC().Format(time.RFC3339Nano))
+	}
+	return cond
+}
+
+// notInvalidatedCondition returns a SQL condition that holds for rows of
+// the table alias that no correction row invalidates.
+func notInvalidatedCondition(table, alias string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+			SELECT 1 FROM %s AS i
+			WHERE i.scan_mode = "%s" AND i.module_path = %s.module_path AND i.version = %[3]s.version
+				AND i.invalidates.suffix = %[3]s.suffix AND i.invalidates.scan_mode = %[3]s.scan_mode
+				AND i.invalidates.created_at = %[3]s.created_at
+		)`, table, ModeInvalidation, alias)
+}
+
+// An InvalidationTarget is a module version with rows selected by an
+// InvalidationFilter.
+type InvalidationTarget struct {
+	ModulePath string `bigquery:"module_path"`
+	Version    string `bigquery:"version"`
+	ScanMode   string `bigquery:"scan_mode"`
+	ImportedBy int    `bigquery:"imported_by"`
+	// Rows is the number of selected rows of the module version in the
+	// scan mode.
+	Rows int `bigquery:"num_rows"`
+	// Scrubbed is true if the module path of the rows was scrubbed, so
+	// the module version can't be scanned again.
+	Scrubbed bool `bigquery:"scrubbed"`
+	// The remaining fields record the params of the latest selected scan.
+	RequestedModulePath bq.NullString `bigquery:"requested_module_path"`
+	IgnoredVendor       bq.NullBool   `bigquery:"ignored_vendor"`
+	ReplacedDropped     bq.NullBool   `bigquery:"replaced_dropped"`
+}
+
+// ReadInvalidationTargets returns the module versions and scan modes with
+// rows selected by f, sorted.
+func ReadInvalidationTargets(ctx context.Context, c *bigquery.Client, f *InvalidationFilter) (_ []*InvalidationTarget, err error) {
+	defer derrors.Wrap(&err, "ReadInvalidationTargets(%+v)", f)
+
+	const qf = `
+		SELECT module_path, version, scan_mode,
+			MAX(imported_by) AS imported_by,
+			COUNT(*) AS num_rows,
+			LOGICAL_OR(IFNULL(scrubbed, FALSE)) AS scrubbed,
+			ARRAY_AGG(STRUCT(requested_module_path, ignored_vendor, replaced_dropped)
+				ORDER BY created_at DESC LIMIT 1)[OFFSET(0)].*
+		FROM %s AS r
+		WHERE %s
+		GROUP BY module_path, version, scan_mode
+		ORDER BY module_path, version, scan_mode
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, f.condition(table))
+	return bigquery.Query[InvalidationTarget](ctx, c, query)
+}
+
+// Invalidate writes a correction row for each row selected by f, giving
+// reason. Rows can't be deleted while they are in BigQuery's streaming
+// buffer, so invalid rows are kept, and queries that read the govulncheck
+// table exclude the rows that correction rows refer to.
+//
+// A correction row is a copy of the row it invalidates, without vulns or
+// errors, with scan mode ModeInvalidation and suffix MaintenanceSuffix, so
+// that it isn't counted as part of any run.
+func Invalidate(ctx context.Context, c *bigquery.Client, f *InvalidationFilter, reason string) (err error) {
+	defer derrors.Wrap(&err, "Invalidate(%+v, %q)", f, reason)
+
+	const qf = `
+		MERGE %s AS t
+		USING (
+			SELECT * REPLACE (
+				CURRENT_TIMESTAMP() AS created_at,
+				"%s" AS suffix,
+				"%s" AS scan_mode,
+				"" AS error,
+				"" AS error_category,
+				CAST(NULL AS FLOAT64) AS risk_score,
+				IF(FALSE, compare_summary, NULL) AS compare_summary,
+				ARRAY(SELECT e FROM UNNEST(errors) AS e WHERE FALSE) AS errors,
+				ARRAY(SELECT v FROM UNNEST(vulns) AS v WHERE FALSE) AS vulns,
+				TRUE AS invalidated,
+				STRUCT(r.suffix AS suffix, r.scan_mode AS scan_mode, r.created_at AS created_at, %s AS reason) AS invalidates
+			)
+			FROM %s AS r
+			WHERE %s
+		) AS s
+		ON FALSE
+		WHEN NOT MATCHED THEN INSERT ROW
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, MaintenanceSuffix, ModeInvalidation,
+		strconv.Quote(reason), table, f.condition(table))
+	return c.Exec(ctx, query)
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInvalidationFilter(t *testing.T) {
+	from := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	for _, test := range []struct {
+		name    string
+		params  InvalidateQueryParams
+		want    InvalidationFilter
+		wantErr string
+	}{
+		{
+			name:   "suffix",
+			params: InvalidateQueryParams{Suffix: "run-1", Reason: "r"},
+			want:   InvalidationFilter{Suffix: "run-1"},
+		},
+		{
+			name:   "range",
+			params: InvalidateQueryParams{From: "2023-06-01T00:00:00Z", To: "2023-06-02T00:00:00Z", Reason: "r"},
+			want:   InvalidationFilter{From: from, To: to},
+		},
+		{
+			name:   "open range",
+			params: InvalidateQueryParams{From: "2023-06-01T00:00:00Z", Reason: "r"},
+			want:   InvalidationFilter{From: from},
+		},
+		{
+			name:    "no reason",
+			params:  InvalidateQueryParams{Suffix: "run-1"},
+			wantErr: "missing reason",
+		},
+		{
+			name:    "no filter",
+			params:  InvalidateQueryParams{Reason: "r"},
+			wantErr: "need a suffix or a time range",
+		},
+		{
+			name:    "bad suffix",
+			params:  InvalidateQueryParams{Suffix: "a b", Reason: "r"},
+			wantErr: "invalid character",
+		},
+		{
+			name:    "bad time",
+			params:  InvalidateQueryParams{From: "2023-06-01", Reason: "r"},
+			wantErr: "from:",
+		},
+		{
+			name:    "empty range",
+			params:  InvalidateQueryParams{From: "2023-06-02T00:00:00Z", To: "2023-06-01T00:00:00Z", Reason: "r"},
+			wantErr: "empty time range",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseInvalidationFilter(&test.params)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Suffix != test.want.Suffix || !got.From.Equal(test.want.From) || !got.To.Equal(test.want.To) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidationFilterCondition(t *testing.T) {
+	f := &InvalidationFilter{
+		Suffix: "run-1",
+		From:   time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	cond := f.condition("`t`")
+	for _, want := range []string{
+		`r.scan_mode NOT IN ("TOMBSTONE", "INVALIDATION")`,
+		`i.invalidates.created_at = r.created_at`,
+		`r.suffix = "run-1"`,
+		`r.created_at >= TIMESTAMP("2023-06-01T00:00:00Z")`,
+	} {
+		if !strings.Contains(cond, want) {
+			t.Errorf("condition does not contain %s:\n%s", want, cond)
+		}
+	}
+	if strings.Contains(cond, "r.created_at <") {
+		t.Errorf("condition has an upper bound:\n%s", cond)
+	}
+}
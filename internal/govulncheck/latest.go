@@ -0,0 +1,73 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// LatestTableName is the table with the latest valid row of each module
+// version and scan mode in the govulncheck table. Its rows are Results.
+//
+// It has the rows of the govulncheck_latest view, but is cheaper to
+// query, since the view picks them out of all scans on every query. It
+// lags the govulncheck table by the time since the last UpdateLatest.
+const LatestTableName = "govulncheck-latest"
+
+func init() {
+	s, err := bigquery.InferSchema(Result{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTableWithOptions(LatestTableName, s, bigquery.TableOptions{
+		ClusterFields: []string{"module_path"},
+	})
+}
+
+// UpdateLatest merges the latest valid rows of the govulncheck table into
+// the govulncheck-latest table. Rows of a module version and scan mode
+// are replaced if a later row was uploaded, or if they were invalidated,
+// and deleted if no valid row is left. It is meant to be run
+// periodically.
+func UpdateLatest(ctx context.Context, c *bigquery.Client) (err error) {
+	defer derrors.Wrap(&err, "UpdateLatest")
+	query := latestMergeQuery("`"+c.FullTableName(TableName)+"`", "`"+c.FullTableName(LatestTableName)+"`",
+		bigquery.TableSchema(LatestTableName))
+	return c.Exec(ctx, query)
+}
+
+// latestMergeQuery returns the MERGE statement that updates the latest
+// table from the results table. It names the columns of schema, the
+// schema of the latest table, so that the tables may order their columns
+// differently and the results table may keep dropped ones.
+func latestMergeQuery(results, latest string, schema bq.Schema) string {
+	const qf = `
+		MERGE %[2]s AS t
+		USING (
+			SELECT * FROM %[1]s AS r
+			WHERE r.scan_mode != "%[3]s" AND %[4]s
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY module_path, version, scan_mode ORDER BY created_at DESC) = 1
+		) AS s
+		ON t.module_path = s.module_path AND t.version = s.version AND t.scan_mode = s.scan_mode
+		WHEN MATCHED AND (t.created_at != s.created_at OR t.suffix != s.suffix) THEN UPDATE SET %[5]s
+		WHEN NOT MATCHED BY TARGET THEN INSERT (%[6]s) VALUES (%[7]s)
+		WHEN NOT MATCHED BY SOURCE THEN DELETE
+	`
+	var sets, cols, vals []string
+	for _, f := range schema {
+		col := "`" + f.Name + "`"
+		sets = append(sets, fmt.Sprintf("%s = s.%[1]s", col))
+		cols = append(cols, col)
+		vals = append(vals, "s."+col)
+	}
+	return fmt.Sprintf(qf, results, latest, ModeInvalidation, notInvalidatedCondition(results, "r"),
+		strings.Join(sets, ", "), strings.Join(cols, ", "), strings.Join(vals, ", "))
+}
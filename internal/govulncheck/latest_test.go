@@ -0,0 +1,33 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"strings"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestLatestMergeQuery(t *testing.T) {
+	schema := bq.Schema{
+		{Name: "module_path", Type: bq.StringFieldType},
+		{Name: "created_at", Type: bq.TimestampFieldType},
+	}
+	query := latestMergeQuery("`results`", "`latest`", schema)
+	for _, want := range []string{
+		"MERGE `latest` AS t",
+		"FROM `results` AS r",
+		`r.scan_mode != "INVALIDATION"`,
+		"i.invalidates.created_at = r.created_at",
+		"UPDATE SET `module_path` = s.`module_path`, `created_at` = s.`created_at`",
+		"INSERT (`module_path`, `created_at`) VALUES (s.`module_path`, s.`created_at`)",
+		"WHEN NOT MATCHED BY SOURCE THEN DELETE",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query does not contain %s:\n%s", want, query)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// An OSVMatch is a module version whose latest result
+// has a finding for an OSV entry.
+type OSVMatch struct {
+	ModulePath string `bigquery:"module_path"`
+	Version    string `bigquery:"version"`
+	// Called reports whether a vulnerable symbol is called.
+	Called       bool          `bigquery:"called"`
+	FixedVersion bq.NullString `bigquery:"fixed_version"`
+}
+
+// OSVQueryParams are the query params of the
+// /govulncheck/osv/ID endpoint.
+type OSVQueryParams struct {
+	Called bool   // if true, only return modules that call a vulnerable symbol
+	Since  string // only consider rows created on or after this date (YYYY-MM-DD)
+	Format string // "json" (the default) or "csv"
+	// IncludeRemoved includes modules that were removed from the corpus.
+	IncludeRemoved bool
+}
+
+var osvIDRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateOSVID checks that id looks like an OSV ID.
+func ValidateOSVID(id string) error {
+	if !osvIDRegexp.MatchString(id) {
+		return fmt.Errorf("invalid OSV ID %q", id)
+	}
+	return nil
+}
+
+// ReadResultsByOSV returns the module versions affected by the OSV entry
+// with the given ID, according to the latest GOVULNCHECK and IMPORTS rows
+// for each module created at or after since. Ad hoc scans are ignored.
+// If onlyCalled is true, only module versions that call a vulnerable
+// symbol are returned. Unless includeRemoved is true, modules that were
+// removed from the corpus after their latest scan are ignored.
+//
+// A vuln is called if it appears in a GOVULNCHECK row, which only holds
+// called vulns, or if its level says so.
+func ReadResultsByOSV(ctx context.Context, c *bigquery.Client, osvID string, onlyCalled, includeRemoved bool, since time.Time) (_ []*OSVMatch, err error) {
+	defer derrors.Wrap(&err, "ReadResultsByOSV(%q, %t, %t, %s)", osvID, onlyCalled, includeRemoved, since)
+
+	if err := ValidateOSVID(osvID); err != nil {
+		return nil, err
+	}
+	const qf = `
+		WITH latest AS (
+			SELECT module_path, version, scan_mode, created_at, vulns
+			FROM %s AS r
+			WHERE scan_mode IN ("GOVULNCHECK", "IMPORTS")
+				AND created_at >= TIMESTAMP("%s")
+				AND NOT STARTS_WITH(suffix, "%s")
+				AND %s
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY module_path, scan_mode ORDER BY created_at DESC) = 1
+		)
+		SELECT
+			module_path,
+			version,
+			LOGICAL_OR(scan_mode = "GOVULNCHECK" OR IFNULL(v.level = "%s", FALSE)) AS called,
+			MAX(v.fixed_version) AS fixed_version
+		FROM latest, UNNEST(vulns) AS v
+		WHERE v.id = @osv_id %s
+		GROUP BY module_path, version
+		%s
+		ORDER BY module_path, version
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	removed := ""
+	if !includeRemoved {
+		removed = "AND " + notRemovedCondition(table, "latest")
+	}
+	having := ""
+	if onlyCalled {
+		having = "HAVING called"
+	}
+	query := fmt.Sprintf(qf, table, since.UTC().Format(time.RFC3339),
+		AdHocSuffixPrefix, notInvalidatedCondition(table, "r"), LevelSymbol, removed, having)
+	return bigquery.Query[OSVMatch](ctx, c, query, bigquery.Param("osv_id", osvID))
+}
@@ -0,0 +1,203 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+// VulnDBURL is the URL of the Go vulnerability database.
+const VulnDBURL = "https://vuln.go.dev"
+
+// osvCacheCheckInterval is how often an OSVCache checks whether the
+// local vuln DB has changed.
+const osvCacheCheckInterval = time.Minute
+
+// An OSVCache is a read-through cache of OSV entries. Entries are read
+// from a local vuln DB, or from a vuln DB server if the local one doesn't
+// have them. The cache is emptied whenever the local DB's last-modified
+// time changes, so entries are never older than the local DB.
+//
+// An OSVCache is safe for concurrent use.
+type OSVCache struct {
+	dbDir        string
+	url          string
+	lastModified func() (time.Time, error)
+	now          func() time.Time
+	client       *http.Client
+
+	// checkInterval is how often lastModified is called.
+	checkInterval time.Duration
+
+	mu       sync.Mutex
+	modified time.Time             // last-modified time of the DB when entries were cached
+	checked  time.Time             // when modified was last checked
+	entries  map[string]*osv.Entry // nil values record entries that were not found
+}
+
+// NewOSVCache returns a cache of the OSV entries in the vuln DB at dbDir.
+// Entries not in the DB are fetched from the vuln DB server at url, unless
+// url is empty. lastModified returns the last-modified time of the DB at
+// dbDir, and now the current time.
+func NewOSVCache(dbDir, url string, lastModified func() (time.Time, error), now func() time.Time) *OSVCache {
+	return &OSVCache{
+		dbDir:         dbDir,
+		url:           url,
+		lastModified:  lastModified,
+		now:           now,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		checkInterval: osvCacheCheckInterval,
+		entries:       map[string]*osv.Entry{},
+	}
+}
+
+// Get returns the OSV entry with the given ID. If no vuln DB has the
+// entry, it returns an error wrapping derrors.NotFound.
+func (c *OSVCache) Get(ctx context.Context, id string) (_ *osv.Entry, err error) {
+	defer derrors.Wrap(&err, "OSVCache.Get(%q)", id)
+
+	if err := ValidateOSVID(id); err != nil {
+		return nil, fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	e, ok := c.entries[id]
+	c.mu.Unlock()
+	if !ok {
+		// Concurrent misses for the same ID may both read the entry.
+		// That is harmless.
+		e, err = c.read(ctx, id)
+		if err != nil && !errors.Is(err, derrors.NotFound) {
+			// Don't cache errors other than a missing entry.
+			return nil, err
+		}
+		c.mu.Lock()
+		c.entries[id] = e
+		c.mu.Unlock()
+	}
+	if e == nil {
+		return nil, derrors.NotFound
+	}
+	return e, nil
+}
+
+// AffectedModules returns the paths of the modules affected by the OSV
+// entry with the given ID.
+func (c *OSVCache) AffectedModules(ctx context.Context, id string) ([]string, error) {
+	e, err := c.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var mods []string
+	for _, a := range e.Affected {
+		mods = append(mods, a.Module.Path)
+	}
+	return mods, nil
+}
+
+// EnrichMissing enriches the vulns whose IDs are in missing, which were
+// not enriched by EnrichVulns, with the entries in the cache. It returns
+// the IDs that are still missing. A nil cache enriches nothing.
+func (c *OSVCache) EnrichMissing(ctx context.Context, vulns []*Vuln, missing []string) []string {
+	if c == nil || len(missing) == 0 {
+		return missing
+	}
+	ids := map[string]bool{}
+	for _, id := range missing {
+		ids[id] = true
+	}
+	var (
+		vs      []*Vuln
+		entries []*osv.Entry
+	)
+	for _, v := range vulns {
+		if ids[v.ID] {
+			vs = append(vs, v)
+		}
+	}
+	for id := range ids {
+		if e, err := c.Get(ctx, id); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return EnrichVulns(vs, entries)
+}
+
+// refresh empties the cache if the local DB changed since the entries
+// were cached.
+func (c *OSVCache) refresh() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if !c.checked.IsZero() && now.Sub(c.checked) < c.checkInterval {
+		return nil
+	}
+	m, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	c.checked = now
+	if !m.Equal(c.modified) {
+		c.modified = m
+		c.entries = map[string]*osv.Entry{}
+	}
+	return nil
+}
+
+// read reads the entry with the given ID from the local DB, or from the
+// vuln DB server if the local DB doesn't have it.
+func (c *OSVCache) read(ctx context.Context, id string) (*osv.Entry, error) {
+	data, err := os.ReadFile(filepath.Join(c.dbDir, "ID", id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = c.fetch(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e osv.Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// fetch fetches the entry with the given ID from the vuln DB server.
+func (c *OSVCache) fetch(ctx context.Context, id string) ([]byte, error) {
+	if c.url == "" {
+		return nil, derrors.NotFound
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/ID/"+id+".json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, derrors.NotFound
+	default:
+		return nil, fmt.Errorf("fetching %s: %s", req.URL, resp.Status)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/clock"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+func TestOSVCache(t *testing.T) {
+	ctx := context.Background()
+	dbDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dbDir, "ID"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeEntry := func(id, summary string) {
+		t.Helper()
+		data := fmt.Sprintf(`{"id":%q,"summary":%q,"affected":[{"package":{"name":"example.com/m"}}]}`, id, summary)
+		if err := os.WriteFile(filepath.Join(dbDir, "ID", id+".json"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEntry("GO-2023-0001", "old")
+
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.URL.Path != "/ID/GO-2023-0002.json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"id":"GO-2023-0002","summary":"remote","affected":[{"package":{"name":"example.com/a"}},{"package":{"name":"example.com/b"}}]}`)
+	}))
+	defer srv.Close()
+
+	modified := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(modified)
+	c := NewOSVCache(dbDir, srv.URL, func() (time.Time, error) { return modified, nil }, clk.Now)
+
+	summary := func(id string) string {
+		t.Helper()
+		e, err := c.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.Summary
+	}
+
+	if got := summary("GO-2023-0001"); got != "old" {
+		t.Errorf("got %q, want %q", got, "old")
+	}
+	// The entry is cached: changing the file doesn't change it...
+	writeEntry("GO-2023-0001", "new")
+	if got := summary("GO-2023-0001"); got != "old" {
+		t.Errorf("cached: got %q, want %q", got, "old")
+	}
+	// ...until the DB is refreshed...
+	modified = modified.Add(time.Hour)
+	if got := summary("GO-2023-0001"); got != "old" {
+		t.Errorf("before check: got %q, want %q", got, "old")
+	}
+	// ...and the cache notices, which it checks for every
+	// osvCacheCheckInterval.
+	clk.Advance(osvCacheCheckInterval)
+	if got := summary("GO-2023-0001"); got != "new" {
+		t.Errorf("after refresh: got %q, want %q", got, "new")
+	}
+
+	// Entries missing from the local DB are fetched and cached.
+	if got := summary("GO-2023-0002"); got != "remote" {
+		t.Errorf("got %q, want %q", got, "remote")
+	}
+	mods, err := c.AffectedModules(ctx, "GO-2023-0002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com/a", "example.com/b"}; !cmp.Equal(mods, want) {
+		t.Errorf("got %v, want %v", mods, want)
+	}
+	if fetches != 1 {
+		t.Errorf("got %d fetches, want 1", fetches)
+	}
+
+	// Unknown entries are NotFound, and that is cached too.
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(ctx, "GO-2023-0003"); !errors.Is(err, derrors.NotFound) {
+			t.Errorf("got %v, want NotFound", err)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("got %d fetches, want 2", fetches)
+	}
+}
+
+func TestEnrichMissing(t *testing.T) {
+	ctx := context.Background()
+	c := NewOSVCache(filepath.Join("..", "testdata", "vulndb"), "", func() (time.Time, error) { return time.Time{}, nil }, time.Now)
+	vulns := []*Vuln{{ID: "GO-2021-0113"}, {ID: "GO-2099-0001"}}
+	missing := EnrichVulns(vulns, nil)
+	got := c.EnrichMissing(ctx, vulns, missing)
+	if want := []string{"GO-2099-0001"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if w := vulns[0].WithdrawnOrMissing; !w.Valid || w.Bool {
+		t.Errorf("%s not enriched: %+v", vulns[0].ID, vulns[0])
+	}
+	var nilCache *OSVCache
+	if got := nilCache.EnrichMissing(ctx, vulns, missing); !cmp.Equal(got, missing) {
+		t.Errorf("nil cache: got %v, want %v", got, missing)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+const OSVSummaryTableName = "govulncheck-osvs"
+
+// OSVSummary is a row in the BigQuery govulncheck-osvs table.
+// It summarizes the modules of the corpus affected by one OSV entry,
+// according to the GOVULNCHECK and IMPORTS rows.
+type OSVSummary struct {
+	OSVID string `bigquery:"osv_id"`
+	// FirstObserved and LastObserved are the creation times of the
+	// first and last rows that report the OSV.
+	FirstObserved time.Time `bigquery:"first_observed"`
+	LastObserved  time.Time `bigquery:"last_observed"`
+	// Modules is the number of modules that any row reports as
+	// affected.
+	Modules int `bigquery:"modules"`
+	// AffectedModules is the number of modules that are still in the
+	// corpus and whose latest row of some scan mode reports the OSV.
+	AffectedModules int       `bigquery:"affected_modules"`
+	UpdatedAt       time.Time `bigquery:"updated_at"`
+}
+
+func init() {
+	s, err := bigquery.InferSchema(OSVSummary{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(OSVSummaryTableName, s)
+}
+
+// OSVsQueryParams are the query params of the /govulncheck/osvs endpoint.
+type OSVsQueryParams struct {
+	Sort       string // "affected" (the default), "modules" or "id"
+	Since      string // only list OSVs last observed on or after this date (YYYY-MM-DD)
+	FirstSince string // only list OSVs first observed on or after this date (YYYY-MM-DD)
+	Limit      int    // maximum number of OSVs to list; 0 means no limit
+}
+
+// osvSummaryOrders maps the sort orders of OSVsQueryParams to ORDER BY
+// clauses. Ties are broken by ID, so that the order is stable.
+var osvSummaryOrders = map[string]string{
+	"affected": "affected_modules DESC, osv_id",
+	"modules":  "modules DESC, osv_id",
+	"id":       "osv_id",
+}
+
+// osvSummaryQuery computes the OSV summaries from the govulncheck table.
+// Ad hoc scans and rows with errors, which say nothing about the
+// module's vulns, are ignored.
+const osvSummaryQuery = `
+	WITH scans AS (
+		SELECT module_path, created_at, vulns,
+			ROW_NUMBER() OVER (PARTITION BY module_path, scan_mode ORDER BY created_at DESC) = 1
+				AND %[4]s AS current
+		FROM %[1]s AS r
+		WHERE scan_mode IN ("GOVULNCHECK", "IMPORTS")
+			AND NOT STARTS_WITH(suffix, "%[2]s")
+			AND error_category = ""
+			AND %[3]s
+	)
+	SELECT
+		v.id AS osv_id,
+		MIN(s.created_at) AS first_observed,
+		MAX(s.created_at) AS last_observed,
+		COUNT(DISTINCT s.module_path) AS modules,
+		COUNT(DISTINCT IF(s.current, s.module_path, NULL)) AS affected_modules,
+		CURRENT_TIMESTAMP() AS updated_at
+	FROM scans AS s, UNNEST(s.vulns) AS v
+	GROUP BY osv_id
+`
+
+// UpdateOSVSummaries recomputes the govulncheck-osvs table from the
+// govulncheck table, and merges the result into it. It is meant to be
+// run periodically.
+func UpdateOSVSummaries(ctx context.Context, c *bigquery.Client) (err error) {
+	defer derrors.Wrap(&err, "UpdateOSVSummaries")
+
+	const qf = `
+		MERGE %s AS t
+		USING (%s) AS o
+		ON t.osv_id = o.osv_id
+		WHEN MATCHED THEN UPDATE SET
+			first_observed = o.first_observed, last_observed = o.last_observed,
+			modules = o.modules, affected_modules = o.affected_modules,
+			updated_at = o.updated_at
+		WHEN NOT MATCHED BY TARGET THEN INSERT ROW
+		WHEN NOT MATCHED BY SOURCE THEN DELETE
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	source := fmt.Sprintf(osvSummaryQuery, table, AdHocSuffixPrefix,
+		notInvalidatedCondition(table, "r"), notRemovedCondition(table, "r"))
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(OSVSummaryTableName)+"`", source)
+	return c.Exec(ctx, query)
+}
+
+// OSVSummaryFilter selects and orders the rows of the govulncheck-osvs
+// table.
+type OSVSummaryFilter struct {
+	// Sort is a sort order of OSVsQueryParams.
+	Sort string
+	// Since and FirstSince, if not zero, are lower bounds on the last
+	// and first observation times.
+	Since, FirstSince time.Time
+	// Limit, if positive, is the maximum number of rows.
+	Limit int
+}
+
+// ParseOSVSummaryFilter returns the filter described by p.
+func ParseOSVSummaryFilter(p *OSVsQueryParams) (*OSVSummaryFilter, error) {
+	f := &OSVSummaryFilter{Sort: p.Sort, Limit: p.Limit}
+	if f.Sort == "" {
+		f.Sort = "affected"
+	}
+	if _, ok := osvSummaryOrders[f.Sort]; !ok {
+		return nil, fmt.Errorf("unknown sort order %q", p.Sort)
+	}
+	if f.Limit < 0 {
+		return nil, fmt.Errorf("negative limit %d", p.Limit)
+	}
+	var err error
+	if p.Since != "" {
+		if f.Since, err = time.Parse(time.DateOnly, p.Since); err != nil {
+			return nil, fmt.Errorf("since: %v", err)
+		}
+	}
+	if p.FirstSince != "" {
+		if f.FirstSince, err = time.Parse(time.DateOnly, p.FirstSince); err != nil {
+			return nil, fmt.Errorf("firstsince: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// clauses returns the WHERE, ORDER BY and LIMIT clauses of a query for
+// the rows that f selects.
+func (f *OSVSummaryFilter) clauses() string {
+	var conds []string
+	if !f.Since.IsZero() {
+		conds = append(conds, fmt.Sprintf(`last_observed >= TIMESTAMP("%s")`, f.Since.UTC().Format(time.RFC3339)))
+	}
+	if !f.FirstSince.IsZero() {
+		conds = append(conds, fmt.Sprintf(`first_observed >= TIMESTAMP("%s")`, f.FirstSince.UTC().Format(time.RFC3339)))
+	}
+	var b strings.Builder
+	if len(conds) > 0 {
+		fmt.Fprintf(&b, "WHERE %s ", strings.Join(conds, " AND "))
+	}
+	fmt.Fprintf(&b, "ORDER BY %s", osvSummaryOrders[f.Sort])
+	if f.Limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %d", f.Limit)
+	}
+	return b.String()
+}
+
+// ReadOSVSummaries returns the rows of the govulncheck-osvs table that f
+// selects, in its order.
+func ReadOSVSummaries(ctx context.Context, c *bigquery.Client, f *OSVSummaryFilter) (_ []*OSVSummary, err error) {
+	defer derrors.Wrap(&err, "ReadOSVSummaries")
+
+	query := fmt.Sprintf("SELECT * FROM %s %s", "`"+c.FullTableName(OSVSummaryTableName)+"`", f.clauses())
+	return bigquery.Query[OSVSummary](ctx, c, query)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOSVSummaryFilter(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		params  OSVsQueryParams
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "default",
+			params: OSVsQueryParams{},
+			want:   "ORDER BY affected_modules DESC, osv_id",
+		},
+		{
+			name:   "by id",
+			params: OSVsQueryParams{Sort: "id", Limit: 10},
+			want:   "ORDER BY osv_id LIMIT 10",
+		},
+		{
+			name:   "dates",
+			params: OSVsQueryParams{Sort: "modules", Since: "2023-06-01", FirstSince: "2023-05-01"},
+			want: `WHERE last_observed >= TIMESTAMP("2023-06-01T00:00:00Z") AND first_observed >= TIMESTAMP("2023-05-01T00:00:00Z") ` +
+				"ORDER BY modules DESC, osv_id",
+		},
+		{
+			name:    "bad sort",
+			params:  OSVsQueryParams{Sort: "affected_modules; DROP"},
+			wantErr: "unknown sort order",
+		},
+		{
+			name:    "bad limit",
+			params:  OSVsQueryParams{Limit: -1},
+			wantErr: "negative limit",
+		},
+		{
+			name:    "bad date",
+			params:  OSVsQueryParams{FirstSince: "2023-06-01T00:00:00Z"},
+			wantErr: "firstsince:",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := ParseOSVSummaryFilter(&test.params)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.clauses(); got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
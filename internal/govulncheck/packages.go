@@ -0,0 +1,147 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+// A PackageError is a package of a module that could not be scanned,
+// when the module's packages are scanned one at a time.
+type PackageError struct {
+	Package string `bigquery:"package"`
+	Error   string `bigquery:"error"`
+}
+
+// ListPackages returns the import paths of the packages of the module
+// in moduleDir that match pattern, including ones that don't build. opts
+// may be nil.
+func ListPackages(ctx context.Context, pattern, moduleDir string, opts *RunOptions) (_ []string, err error) {
+	defer derrors.Wrap(&err, "ListPackages(%q, %q)", pattern, moduleDir)
+	if opts == nil {
+		opts = &RunOptions{}
+	}
+	cmd := exec.CommandContext(ctx, "go", "list", "-e", "-f", "{{.ImportPath}}", pattern)
+	cmd.Dir = moduleDir
+	opts.setEnv(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(derrors.IncludeStderr(err))
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// RunGovulncheckPerPackage is like RunGovulncheckCmd, but scans each of
+// the packages of the module in moduleDir that match pattern with its own
+// run of govulncheck, so that packages that don't build don't stop the
+// others from being scanned. It returns the findings of all the packages that could be
+// scanned, one per OSV like RunGovulncheckCmd, and sets stats.PackageErrors to the
+// packages that could not.
+//
+// It returns an error if the packages can't be listed, if none of them
+// could be scanned, or if ctx is done. opts.Timeout applies to all the
+// runs together. The stats of the runs are added up.
+func RunGovulncheckPerPackage(ctx context.Context, govulncheckPath, modeFlag, pattern, moduleDir string, vulndbDirs []string, opts *RunOptions, stats *ScanStats) (_ []*govulncheckapi.Finding, _ []*osv.Entry, err error) {
+	defer derrors.Wrap(&err, "RunGovulncheckPerPackage(%q, %q)", pattern, moduleDir)
+	if opts == nil {
+		opts = &RunOptions{}
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+		o := *opts
+		o.Timeout = 0
+		opts = &o
+	}
+	pkgs, err := ListPackages(ctx, pattern, moduleDir, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, nil, errors.New("no packages")
+	}
+	var (
+		byOSV    = map[string]*govulncheckapi.Finding{}
+		osvs     []*osv.Entry
+		pkgErrs  []*PackageError
+		seenOSVs = map[string]bool{}
+		lastErr  error
+	)
+	for _, pkg := range pkgs {
+		var pstats ScanStats
+		fs, es, err := RunGovulncheckCmd(ctx, govulncheckPath, modeFlag, pkg, moduleDir, vulndbDirs, opts, &pstats)
+		addStats(stats, &pstats)
+		if ctx.Err() != nil || errors.Is(err, derrors.ScanModuleTimeout) {
+			return nil, nil, err
+		}
+		if err != nil {
+			lastErr = err
+			msg := err.Error()
+			if len(msg) > maxErrorRecordMessage {
+				msg = msg[:maxErrorRecordMessage]
+			}
+			pkgErrs = append(pkgErrs, &PackageError{Package: pkg, Error: msg})
+			continue
+		}
+		stats.PackagesWithErrors += pstats.PackagesWithErrors
+		// Keep one finding per OSV, preferring one that is called,
+		// as MetricsHandler does within a run.
+		for _, f := range fs {
+			if g, ok := byOSV[f.OSV]; !ok || (g.Trace[0].Function == "" && f.Trace[0].Function != "") {
+				byOSV[f.OSV] = f
+			}
+		}
+		for _, e := range es {
+			if !seenOSVs[e.ID] {
+				seenOSVs[e.ID] = true
+				osvs = append(osvs, e)
+			}
+		}
+	}
+	if len(pkgErrs) == len(pkgs) {
+		stats.PackageErrors = pkgErrs
+		return nil, nil, fmt.Errorf("none of %d packages could be scanned; last error: %w", len(pkgs), lastErr)
+	}
+	stats.PackagesWithErrors += len(pkgErrs)
+	stats.PackageErrors = pkgErrs
+	findings := maps.Values(byOSV)
+	sort.Slice(findings, func(i, j int) bool { return findings[i].OSV < findings[j].OSV })
+	sort.Slice(osvs, func(i, j int) bool { return osvs[i].ID < osvs[j].ID })
+	return findings, osvs, nil
+}
+
+// addStats adds the stats of a run of govulncheck to the stats of a
+// scan made of several runs. Packages with errors are counted by the
+// caller, since a run that fails has its package counted as a whole.
+func addStats(total, run *ScanStats) {
+	if total.StartedAt.IsZero() {
+		total.StartedAt = run.StartedAt
+		total.Config = run.Config
+	}
+	if !run.FinishedAt.IsZero() {
+		total.FinishedAt = run.FinishedAt
+	}
+	total.ScanSeconds += run.ScanSeconds
+	total.UserCPUSeconds += run.UserCPUSeconds
+	total.SystemCPUSeconds += run.SystemCPUSeconds
+	if run.ScanMemory > total.ScanMemory {
+		total.ScanMemory = run.ScanMemory
+	}
+	if run.MaxRSS > total.MaxRSS {
+		total.MaxRSS = run.MaxRSS
+	}
+	total.FindingsCapped = total.FindingsCapped || run.FindingsCapped
+}
@@ -0,0 +1,40 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultPattern is the package pattern that is scanned unless a scan
+// request selects another: all the packages of the module.
+const DefaultPattern = "./..."
+
+// ValidatePattern returns an error if pattern does not select packages of
+// the module with path modulePath. It must be a pattern relative to the
+// module's root, like "./cmd/..." or ".", or an import path pattern in
+// the module, like modulePath+"/cmd/...". The empty string means
+// DefaultPattern.
+func ValidatePattern(modulePath, pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if strings.HasPrefix(pattern, "-") || strings.ContainsAny(pattern, " \t\n\\") {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+	switch {
+	case pattern == "." || strings.HasPrefix(pattern, "./"):
+	case pattern == modulePath || strings.HasPrefix(pattern, modulePath+"/"):
+	default:
+		return fmt.Errorf("pattern %q is neither relative nor in module %s", pattern, modulePath)
+	}
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == ".." {
+			return fmt.Errorf("pattern %q leaves the module", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import "testing"
+
+func TestValidatePattern(t *testing.T) {
+	const mod = "example.com/mono"
+	for _, p := range []string{"", ".", "./...", "./cmd/...", "./internal/x", mod, mod + "/...", mod + "/cmd/tool"} {
+		if err := ValidatePattern(mod, p); err != nil {
+			t.Errorf("%q: %v", p, err)
+		}
+	}
+	for _, p := range []string{
+		"...",
+		"all",
+		"std",
+		"cmd/...",
+		"example.com/monorepo/...",
+		"./../other",
+		mod + "/../x",
+		"-json",
+		"./a ./b",
+		"/abs/path",
+	} {
+		if err := ValidatePattern(mod, p); err == nil {
+			t.Errorf("%q: got nil, want error", p)
+		}
+	}
+}
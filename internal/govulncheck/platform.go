@@ -0,0 +1,56 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxPlatforms is the maximum number of platforms a scan request can
+// ask for, since each is a separate scan.
+const maxPlatforms = 8
+
+// ParsePlatforms parses a comma-separated list of platforms, each of
+// the form GOOS/GOARCH, like "linux/amd64,windows/amd64". It returns
+// the platforms in order, without duplicates. The empty string is an
+// empty list.
+//
+// Only the form of each platform is checked; govulncheck fails to load
+// packages for platforms that Go doesn't support.
+func ParsePlatforms(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ps []string
+	seen := map[string]bool{}
+	for _, p := range strings.Split(s, ",") {
+		goos, goarch, ok := strings.Cut(p, "/")
+		if !ok || !isPlatformWord(goos) || !isPlatformWord(goarch) {
+			return nil, fmt.Errorf("platform %q is not of the form GOOS/GOARCH", p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			ps = append(ps, p)
+		}
+	}
+	if len(ps) > maxPlatforms {
+		return nil, fmt.Errorf("%d platforms; at most %d can be scanned", len(ps), maxPlatforms)
+	}
+	return ps, nil
+}
+
+// isPlatformWord reports whether s looks like a GOOS or GOARCH value.
+func isPlatformWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !('a' <= r && r <= 'z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
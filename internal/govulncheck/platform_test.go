@@ -0,0 +1,45 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"linux/amd64", []string{"linux/amd64"}},
+		{"darwin/arm64,windows/amd64,darwin/arm64", []string{"darwin/arm64", "windows/amd64"}},
+	} {
+		got, err := ParsePlatforms(test.in)
+		if err != nil {
+			t.Fatalf("%q: %v", test.in, err)
+		}
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.in, got, test.want)
+		}
+	}
+	var many []string
+	for i := 0; i <= maxPlatforms; i++ {
+		many = append(many, fmt.Sprintf("linux/arch%d", i))
+	}
+	for _, in := range []string{
+		"linux", "linux/", "/amd64", "linux/amd64/v2", "linux/amd64,",
+		"Linux/amd64", "linux/amd64 ", "linux/amd64;GOFLAGS=x",
+		strings.Join(many, ","),
+	} {
+		if _, err := ParsePlatforms(in); err == nil {
+			t.Errorf("%q: got nil error", in)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// ValidateGoPrivate checks that s is a comma-separated list of module
+// path patterns, as in GOPRIVATE. The empty string is valid.
+//
+// Patterns may not contain characters that would let them set other
+// environment variables or go command flags.
+func ValidateGoPrivate(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, p := range strings.Split(s, ",") {
+		if p == "" {
+			return fmt.Errorf("GOPRIVATE %q has an empty pattern", s)
+		}
+		if strings.ContainsAny(p, " \t\n=") {
+			return fmt.Errorf("GOPRIVATE pattern %q contains invalid characters", p)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("GOPRIVATE pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// IsPrivate reports whether modulePath matches the GOPRIVATE patterns
+// in goPrivate, as the go command would decide.
+func IsPrivate(goPrivate, modulePath string) bool {
+	return goPrivate != "" && module.MatchPrefixPatterns(goPrivate, modulePath)
+}
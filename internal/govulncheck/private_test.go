@@ -0,0 +1,38 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import "testing"
+
+func TestValidateGoPrivate(t *testing.T) {
+	for _, s := range []string{"", "example.com", "*.corp.example.com,rsc.io/private"} {
+		if err := ValidateGoPrivate(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range []string{",", "a,,b", "a b", "a=b", "GOPROXY=x", "[a"} {
+		if err := ValidateGoPrivate(s); err == nil {
+			t.Errorf("%q: got nil, want error", s)
+		}
+	}
+}
+
+func TestIsPrivate(t *testing.T) {
+	const goPrivate = "*.corp.example.com,rsc.io/private"
+	for _, test := range []struct {
+		goPrivate, modulePath string
+		want                  bool
+	}{
+		{goPrivate, "git.corp.example.com/a/b", true},
+		{goPrivate, "rsc.io/private", true},
+		{goPrivate, "rsc.io/private/v2", true},
+		{goPrivate, "rsc.io/quote", false},
+		{"", "rsc.io/private", false},
+	} {
+		if got := IsPrivate(test.goPrivate, test.modulePath); got != test.want {
+			t.Errorf("IsPrivate(%q, %q) = %t, want %t", test.goPrivate, test.modulePath, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// RetryQueryParams are the query params of the
+// /govulncheck/enqueue-errored endpoint.
+type RetryQueryParams struct {
+	Source   string // suffix of the run whose errored modules are re-enqueued
+	Suffix   string // suffix of the new run
+	Category string // if non-empty, only re-enqueue errors with this category
+	// Quiet is the number of minutes the source run must have written
+	// no rows for it to be considered finished.
+	Quiet  int
+	DryRun bool // if true, report the modules to enqueue but don't enqueue them
+}
+
+// ValidateCategory checks that category looks like an error category
+// returned by derrors.CategorizeError.
+func ValidateCategory(category string) error {
+	for _, r := range category {
+		switch {
+		case 'A' <= r && r <= 'Z', r == ' ', r == '-', r == '.':
+		default:
+			return fmt.Errorf("category %q contains invalid character %q", category, r)
+		}
+	}
+	return nil
+}
+
+// An ErroredModule is a module version whose scan in some mode errored.
+type ErroredModule struct {
+	ModulePath    string `bigquery:"module_path"`
+	Version       string `bigquery:"version"`
+	ScanMode      string `bigquery:"scan_mode"`
+	ImportedBy    int    `bigquery:"imported_by"`
+	ErrorCategory string `bigquery:"error_category"`
+	// The remaining fields record the params of the scan.
+	RequestedModulePath bq.NullString `bigquery:"requested_module_path"`
+	IgnoredVendor       bq.NullBool   `bigquery:"ignored_vendor"`
+	ReplacedDropped     bq.NullBool   `bigquery:"replaced_dropped"`
+}
+
+// ReadErroredModules returns the module versions of the run with the given
+// suffix whose latest row in some scan mode has an error, optionally only
+// those with the given error category. Rows for the standard library and
+// rows with scrubbed module paths, which can't be scanned again, are
+// omitted.
+func ReadErroredModules(ctx context.Context, c *bigquery.Client, suffix, category string) (_ []*ErroredModule, err error) {
+	defer derrors.Wrap(&err, "ReadErroredModules(%q, %q)", suffix, category)
+
+	const qf = `
+		SELECT module_path, version, scan_mode, imported_by, error_category,
+			requested_module_path, ignored_vendor, replaced_dropped
+		FROM (
+			SELECT *, ROW_NUMBER() OVER (
+				PARTITION BY module_path, version, scan_mode
+				ORDER BY created_at DESC
+			) AS rownum
+			FROM %s
+			WHERE suffix = "%s" AND module_path != "stdlib" AND NOT IFNULL(scrubbed, FALSE)
+		)
+		WHERE rownum = 1 AND error_category != ""%s
+		ORDER BY module_path, version, scan_mode
+	`
+	var cond string
+	if category != "" {
+		cond = fmt.Sprintf(` AND error_category = "%s"`, category)
+	}
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`", suffix, cond)
+	return bigquery.Query[ErroredModule](ctx, c, query)
+}
+
+// ReadLastWrite returns the time of the most recent row of the run with
+// the given suffix, or the zero time if the run has no rows.
+func ReadLastWrite(ctx context.Context, c *bigquery.Client, suffix string) (_ time.Time, err error) {
+	defer derrors.Wrap(&err, "ReadLastWrite(%q)", suffix)
+
+	const qf = `SELECT MAX(created_at) AS last FROM %s WHERE suffix = "%s"`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`", suffix)
+	var last time.Time
+	err = bigquery.ForEach(ctx, c, query, nil, func(r *lastWrite) bool {
+		last = r.Last.Timestamp
+		return false
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return last, nil
+}
+
+type lastWrite struct {
+	Last bq.NullTimestamp `bigquery:"last"`
+}
+
+// RequestMode returns the mode of the scan request that produced a row
+// with the given scan mode, or "" if rows with that scan mode are not
+// produced by scan requests. IMPORTS rows are also written by GOVULNCHECK
+// scans, which can only be told apart by their GOVULNCHECK rows.
+func RequestMode(scanMode string) string {
+	switch {
+	case scanMode == ModeGovulncheck, scanMode == ModeImports:
+		return scanMode
+	case strings.HasPrefix(scanMode, "COMPARE"):
+		return "COMPARE"
+	default:
+		return ""
+	}
+}
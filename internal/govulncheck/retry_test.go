@@ -0,0 +1,37 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import "testing"
+
+func TestValidateCategory(t *testing.T) {
+	for _, c := range []string{"", "LOAD", "VULNCHECK - MISC", "LOAD - NO GO.MOD"} {
+		if err := ValidateCategory(c); err != nil {
+			t.Errorf("%q: %v", c, err)
+		}
+	}
+	for _, c := range []string{`LOAD" OR TRUE`, "load", "LOAD\\"} {
+		if err := ValidateCategory(c); err == nil {
+			t.Errorf("%q: got nil, want error", c)
+		}
+	}
+}
+
+func TestRequestMode(t *testing.T) {
+	for _, test := range []struct {
+		scanMode, want string
+	}{
+		{ModeGovulncheck, ModeGovulncheck},
+		{"IMPORTS", ModeImports},
+		{"COMPARE - SOURCE", "COMPARE"},
+		{"COMPARE - BINARY", "COMPARE"},
+		{"STDLIB", ""},
+		{ModeTombstone, ""},
+	} {
+		if got := RequestMode(test.scanMode); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.scanMode, got, test.want)
+		}
+	}
+}
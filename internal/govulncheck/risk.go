@@ -0,0 +1,165 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// RiskWeights are the weights of the module risk score; see RiskScore.
+type RiskWeights struct {
+	// Called is the weight of each called vulnerability.
+	Called float64
+	// Unfixed multiplies the weight of a called vulnerability that has
+	// no fixed version.
+	Unfixed float64
+	// ImportedBy is the weight of the base-10 logarithm of the module's
+	// imported-by count.
+	ImportedBy float64
+}
+
+// DefaultRiskWeights are the weights used if none are configured.
+var DefaultRiskWeights = RiskWeights{Called: 1, Unfixed: 2, ImportedBy: 1}
+
+// ParseRiskWeights parses a comma-separated list of name=value pairs,
+// where name is one of "called", "unfixed" and "importedby". Weights
+// that are not in the list have their default value.
+func ParseRiskWeights(spec string) (RiskWeights, error) {
+	w := DefaultRiskWeights
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			return RiskWeights{}, fmt.Errorf("risk weight %q: missing '='", kv)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || !(f >= 0) || math.IsInf(f, 0) {
+			return RiskWeights{}, fmt.Errorf("risk weight %q: need a non-negative number", kv)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "called":
+			w.Called = f
+		case "unfixed":
+			w.Unfixed = f
+		case "importedby":
+			w.ImportedBy = f
+		default:
+			return RiskWeights{}, fmt.Errorf("unknown risk weight %q", name)
+		}
+	}
+	return w, nil
+}
+
+// String returns w in the form accepted by ParseRiskWeights.
+func (w RiskWeights) String() string {
+	return fmt.Sprintf("called=%g,unfixed=%g,importedby=%g", w.Called, w.Unfixed, w.ImportedBy)
+}
+
+// RiskScore returns the risk score of a module with the given called vulns
+// and imported-by count:
+//
+//	(Σ Called × (Unfixed if the vuln has no fix, else 1)) × (1 + ImportedBy × log10(1 + importedBy))
+//
+// where the sum is over distinct OSV IDs, ignoring vulns whose entries were
+// withdrawn or missing. A vuln has no fix if any of its findings lacks a
+// fixed version. The Go vulnerability database doesn't record severities,
+// so all vulns count the same.
+//
+// RiskScore must agree with riskScoreExpr, which computes the score of
+// stored rows.
+func RiskScore(called []*Vuln, importedBy int, w RiskWeights) float64 {
+	unfixed := map[string]bool{}
+	for _, v := range called {
+		if v.WithdrawnOrMissing.Valid && v.WithdrawnOrMissing.Bool {
+			continue
+		}
+		unfixed[v.ID] = unfixed[v.ID] || !v.FixedVersion.Valid
+	}
+	var sum float64
+	for _, u := range unfixed {
+		if u {
+			sum += w.Called * w.Unfixed
+		} else {
+			sum += w.Called
+		}
+	}
+	return sum * (1 + w.ImportedBy*math.Log10(1+float64(importedBy)))
+}
+
+// riskScoreExpr returns a SQL expression for the risk score of a
+// GOVULNCHECK row, whose vulns are all called; see RiskScore.
+func riskScoreExpr(w RiskWeights) string {
+	return fmt.Sprintf(`(
+			SELECT IFNULL(SUM(weight), 0) FROM (
+				SELECT MAX(IF(v.fixed_version IS NULL, %[1]g * %[2]g, %[1]g)) AS weight
+				FROM UNNEST(vulns) AS v
+				WHERE NOT IFNULL(v.withdrawn_or_missing, FALSE)
+				GROUP BY v.id
+			)
+		) * (1 + %[3]g * LOG10(1 + imported_by))`, w.Called, w.Unfixed, w.ImportedBy)
+}
+
+// RecomputeRiskScores rewrites the risk scores of the successful
+// GOVULNCHECK rows with the given weights, or only of those of the run
+// with the given suffix if it is non-empty.
+func RecomputeRiskScores(ctx context.Context, c *bigquery.Client, suffix string, w RiskWeights) (err error) {
+	defer derrors.Wrap(&err, "RecomputeRiskScores(%q, %s)", suffix, w)
+
+	const qf = `
+		UPDATE %s
+		SET risk_score = %s
+		WHERE scan_mode = "GOVULNCHECK" AND error = "" %s
+	`
+	cond := ""
+	if suffix != "" {
+		cond = fmt.Sprintf(`AND suffix = "%s"`, suffix)
+	}
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`", riskScoreExpr(w), cond)
+	return c.Exec(ctx, query)
+}
+
+// A ModuleRisk is the risk score of a module version.
+type ModuleRisk struct {
+	ModulePath string         `bigquery:"module_path"`
+	Version    string         `bigquery:"version"`
+	ImportedBy int            `bigquery:"imported_by"`
+	RiskScore  bq.NullFloat64 `bigquery:"risk_score"`
+}
+
+// ReadTopRisks returns the n modules with the highest risk scores, using
+// the latest GOVULNCHECK row of each module that is not part of an ad hoc
+// scan. Modules removed from the corpus are omitted.
+func ReadTopRisks(ctx context.Context, c *bigquery.Client, n int) (_ []*ModuleRisk, err error) {
+	defer derrors.Wrap(&err, "ReadTopRisks(%d)", n)
+
+	const qf = `
+		WITH latest AS (
+			SELECT module_path, version, imported_by, risk_score, created_at
+			FROM %s AS r
+			WHERE scan_mode = "GOVULNCHECK" AND NOT STARTS_WITH(suffix, "%s") AND %s
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY module_path ORDER BY created_at DESC) = 1
+		)
+		SELECT module_path, version, imported_by, risk_score
+		FROM latest
+		WHERE risk_score IS NOT NULL AND %s
+		ORDER BY risk_score DESC, module_path
+		LIMIT %d
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, AdHocSuffixPrefix, notInvalidatedCondition(table, "r"),
+		notRemovedCondition(table, "latest"), n)
+	return bigquery.Query[ModuleRisk](ctx, c, query)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestParseRiskWeights(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want RiskWeights
+	}{
+		{"", DefaultRiskWeights},
+		{"called=3", RiskWeights{Called: 3, Unfixed: 2, ImportedBy: 1}},
+		{" Unfixed = 1.5 , importedby=0", RiskWeights{Called: 1, Unfixed: 1.5, ImportedBy: 0}},
+	} {
+		got, err := ParseRiskWeights(test.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", test.spec, err)
+		}
+		if got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.spec, got, test.want)
+		}
+		// String round-trips.
+		if got2, err := ParseRiskWeights(got.String()); err != nil || got2 != got {
+			t.Errorf("%q: round trip: got %+v, %v", got.String(), got2, err)
+		}
+	}
+	for _, spec := range []string{"called", "called=x", "called=-1", "severity=1", "called=Inf", "unfixed=NaN"} {
+		if _, err := ParseRiskWeights(spec); err == nil {
+			t.Errorf("%q: got nil, want error", spec)
+		}
+	}
+}
+
+func TestRiskScore(t *testing.T) {
+	w := RiskWeights{Called: 1, Unfixed: 2, ImportedBy: 1}
+	vulns := []*Vuln{
+		{ID: "GO-1", FixedVersion: bigquery.NullString("v1.2.0")},
+		// Two findings of the same vuln, one without a fix.
+		{ID: "GO-2", FixedVersion: bigquery.NullString("v0.3.0")},
+		{ID: "GO-2"},
+		// Withdrawn vulns don't count.
+		{ID: "GO-3", WithdrawnOrMissing: bigquery.NullBool(true)},
+	}
+	for _, test := range []struct {
+		vulns      []*Vuln
+		importedBy int
+		want       float64
+	}{
+		{nil, 1000, 0},
+		{vulns, 0, 3},
+		{vulns, 9, 6},
+		{vulns[:1], 99, 3},
+	} {
+		got := RiskScore(test.vulns, test.importedBy, w)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%d vulns, imported by %d: got %g, want %g", len(test.vulns), test.importedBy, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// RunTableName is the name of the table of runs.
+const RunTableName = "govulncheck-runs"
+
+// DefaultDeferRefreshMinutes is how often the deferral threshold of a run
+// is recomputed, unless its Run says otherwise.
+const DefaultDeferRefreshMinutes = 10
+
+// A Run is a row in the BigQuery govulncheck-runs table. It records the
+// configuration of a run when its modules are enqueued. If a run is
+// enqueued more than once, its latest row applies.
+type Run struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	Suffix    string    `bigquery:"suffix"`
+	// Modules is the number of modules enqueued for the run.
+	Modules    int    `bigquery:"modules"`
+	CorpusHash string `bigquery:"corpus_hash"`
+	// Deadline is the soft deadline of the run. It is null if the run
+	// has none, in which case scans are never deferred.
+	Deadline bq.NullTimestamp `bigquery:"deadline"`
+	// DeferBelow is the highest imported-by threshold below which the
+	// scans of a run that is behind schedule are deferred. Scans are
+	// never deferred if it is null or not positive.
+	DeferBelow bq.NullInt64 `bigquery:"defer_below"`
+	// DeferRefreshMinutes is how often the deferral threshold is
+	// recomputed. If it is null, DefaultDeferRefreshMinutes is used.
+	DeferRefreshMinutes bq.NullInt64 `bigquery:"defer_refresh_minutes"`
+}
+
+func (r *Run) SetUploadTime(t time.Time) { r.CreatedAt = t }
+
+func init() {
+	s, err := bigquery.InferSchema(Run{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(RunTableName, s)
+}
+
+// Defers reports whether scans of the run may be deferred.
+func (r *Run) Defers() bool {
+	return r != nil && r.Deadline.Valid && r.DeferBelow.Valid && r.DeferBelow.Int64 > 0
+}
+
+// DeferRefresh returns how often the deferral threshold of the run is
+// recomputed.
+func (r *Run) DeferRefresh() time.Duration {
+	m := int64(DefaultDeferRefreshMinutes)
+	if r != nil && r.DeferRefreshMinutes.Valid && r.DeferRefreshMinutes.Int64 > 0 {
+		m = r.DeferRefreshMinutes.Int64
+	}
+	return time.Duration(m) * time.Minute
+}
+
+// ReadRun returns the latest row of the run with the given suffix,
+// or nil if there is none.
+func ReadRun(ctx context.Context, c *bigquery.Client, suffix string) (_ *Run, err error) {
+	defer derrors.Wrap(&err, "ReadRun(%q)", suffix)
+
+	const qf = `SELECT * FROM %s WHERE suffix = "%s" ORDER BY created_at DESC LIMIT 1`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(RunTableName)+"`", suffix)
+	var run *Run
+	err = bigquery.ForEach(ctx, c, query, nil, func(r *Run) bool {
+		run = r
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// RunProgress is the progress of a run.
+type RunProgress struct {
+	// Modules is the number of modules with a row in the run.
+	Modules int `bigquery:"modules"`
+	// Scanned is the number of those modules with a row that was not
+	// deferred.
+	Scanned int `bigquery:"scanned"`
+}
+
+// ReadRunProgress returns the progress of the run with the given suffix.
+// IMPORTS rows written by GOVULNCHECK scans are ignored since they
+// duplicate the GOVULNCHECK rows without scanning.
+func ReadRunProgress(ctx context.Context, c *bigquery.Client, suffix string) (_ *RunProgress, err error) {
+	defer derrors.Wrap(&err, "ReadRunProgress(%q)", suffix)
+
+	const qf = `
+		SELECT
+			COUNT(DISTINCT module_path) AS modules,
+			COUNT(DISTINCT IF(error_category = "%s", NULL, module_path)) AS scanned
+		FROM %s AS r
+		WHERE suffix = "%s" AND %s
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, derrors.CategorizeError(derrors.ScanDeferred),
+		table, suffix, notImportsCopyCondition(table, "r"))
+	p := &RunProgress{}
+	err = bigquery.ForEach(ctx, c, query, nil, func(r *RunProgress) bool {
+		p = r
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DeferThreshold returns the imported-by count below which scans of run
+// should be deferred at time now, given its progress. It returns 0 if no
+// scans should be deferred: if the run doesn't defer scans, if nothing has
+// been scanned yet, or if the run is expected to finish by its deadline at
+// its current rate.
+//
+// Otherwise the threshold grows with the fraction of the remaining modules
+// that can't be scanned in time, up to run.DeferBelow once the deadline
+// has passed.
+func DeferThreshold(run *Run, p *RunProgress, now time.Time) int {
+	if !run.Defers() || p.Scanned == 0 {
+		return 0
+	}
+	remaining := run.Modules - p.Modules
+	if remaining <= 0 {
+		return 0
+	}
+	max := float64(run.DeferBelow.Int64)
+	left := run.Deadline.Timestamp.Sub(now)
+	if left <= 0 {
+		return int(max)
+	}
+	elapsed := now.Sub(run.CreatedAt)
+	if elapsed <= 0 {
+		return 0
+	}
+	// The number of modules that can be scanned before the deadline
+	// at the current rate.
+	canScan := float64(p.Scanned) / elapsed.Seconds() * left.Seconds()
+	if canScan >= float64(remaining) {
+		return 0
+	}
+	behind := 1 - canScan/float64(remaining)
+	return int(math.Ceil(max * behind))
+}
+
+// notImportsCopyCondition returns a SQL condition that holds for rows of
+// the table alias unless they are IMPORTS rows written by a GOVULNCHECK
+// scan of the same module version in the same run.
+func notImportsCopyCondition(table, alias string) string {
+	return fmt.Sprintf(`NOT (%[2]s.scan_mode = "%[3]s" AND EXISTS (
+			SELECT 1 FROM %[1]s AS g
+			WHERE g.scan_mode = "%[4]s" AND g.module_path = %[2]s.module_path
+				AND g.version = %[2]s.version AND g.suffix = %[2]s.suffix
+		))`, table, alias, ModeImports, ModeGovulncheck)
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestDeferThreshold(t *testing.T) {
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	run := &Run{
+		CreatedAt:  start,
+		Modules:    1000,
+		Deadline:   bigquery.NullTimestamp(start.Add(10 * time.Hour)),
+		DeferBelow: bigquery.NullInt(100),
+	}
+	for _, test := range []struct {
+		name     string
+		run      *Run
+		progress RunProgress
+		now      time.Time
+		want     int
+	}{
+		{"off", &Run{CreatedAt: start, Modules: 1000}, RunProgress{10, 10}, start.Add(5 * time.Hour), 0},
+		{"nothing scanned", run, RunProgress{0, 0}, start.Add(5 * time.Hour), 0},
+		{"on track", run, RunProgress{500, 500}, start.Add(5 * time.Hour), 0},
+		{"done", run, RunProgress{1000, 900}, start.Add(9 * time.Hour), 0},
+		// 100 modules in 5 hours: 100 more can be scanned, 900 remain.
+		{"behind", run, RunProgress{100, 100}, start.Add(5 * time.Hour), 89},
+		// Deferred modules count as done, but not towards the rate.
+		{"deferred", run, RunProgress{550, 100}, start.Add(5 * time.Hour), 78},
+		{"past deadline", run, RunProgress{900, 900}, start.Add(11 * time.Hour), 100},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DeferThreshold(test.run, &test.progress, test.now); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRunDeferRefresh(t *testing.T) {
+	var r *Run
+	if got, want := r.DeferRefresh(), DefaultDeferRefreshMinutes*time.Minute; got != want {
+		t.Errorf("nil run: got %s, want %s", got, want)
+	}
+	r = &Run{DeferRefreshMinutes: bigquery.NullInt(3)}
+	if got, want := r.DeferRefresh(), 3*time.Minute; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
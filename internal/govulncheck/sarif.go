@@ -0,0 +1,302 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Formats of served results; see QueryParams.Format.
+const (
+	// FormatJSON serves the rows as JSON. It is the default.
+	FormatJSON = "json"
+	// FormatSARIF serves the findings of the rows as a SARIF 2.1.0 log;
+	// see ToSARIF.
+	FormatSARIF = "sarif"
+)
+
+// ValidateFormat returns an error if f is not a format of served results.
+// The empty string means FormatJSON.
+func ValidateFormat(f string) error {
+	switch f {
+	case "", FormatJSON, FormatSARIF:
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q; want %q or %q", f, FormatJSON, FormatSARIF)
+	}
+}
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	// sarifSrcRoot is the base of artifact URIs, which are relative to
+	// the root of the scanned module.
+	sarifSrcRoot = "%SRCROOT%"
+)
+
+// A SARIFLog is a SARIF 2.1.0 log, with only the properties that ToSARIF
+// sets.
+type SARIFLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []SARIFRun `json:"runs"`
+}
+
+type SARIFRun struct {
+	Tool    SARIFTool     `json:"tool"`
+	Results []SARIFResult `json:"results"`
+}
+
+type SARIFTool struct {
+	Driver SARIFDriver `json:"driver"`
+}
+
+type SARIFDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"semanticVersion,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []SARIFRule `json:"rules"`
+}
+
+// A SARIFRule describes a vulnerability. Its ID is the ID of the
+// vulnerability's OSV entry.
+type SARIFRule struct {
+	ID               string       `json:"id"`
+	ShortDescription SARIFMessage `json:"shortDescription"`
+	HelpURI          string       `json:"helpUri"`
+	Properties       struct {
+		Tags []string `json:"tags,omitempty"`
+	} `json:"properties"`
+}
+
+type SARIFResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   SARIFMessage    `json:"message"`
+	Locations []SARIFLocation `json:"locations"`
+	CodeFlows []SARIFCodeFlow `json:"codeFlows,omitempty"`
+}
+
+type SARIFMessage struct {
+	Text string `json:"text"`
+}
+
+type SARIFLocation struct {
+	PhysicalLocation *SARIFPhysicalLocation `json:"physicalLocation,omitempty"`
+	Message          *SARIFMessage          `json:"message,omitempty"`
+}
+
+type SARIFPhysicalLocation struct {
+	ArtifactLocation SARIFArtifactLocation `json:"artifactLocation"`
+	Region           *SARIFRegion          `json:"region,omitempty"`
+}
+
+type SARIFArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+type SARIFRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type SARIFCodeFlow struct {
+	ThreadFlows []SARIFThreadFlow `json:"threadFlows"`
+}
+
+type SARIFThreadFlow struct {
+	Locations []SARIFThreadFlowLocation `json:"locations"`
+}
+
+type SARIFThreadFlowLocation struct {
+	Location SARIFLocation `json:"location"`
+}
+
+// sarifLevels are the SARIF levels of results at each finding level.
+var sarifLevels = map[string]string{
+	LevelSymbol:  "error",
+	LevelPackage: "warning",
+	LevelModule:  "note",
+}
+
+// levelRank ranks finding levels by precision; the lowest is the most
+// precise.
+var levelRank = map[string]int{LevelSymbol: 0, LevelPackage: 1, LevelModule: 2}
+
+// ToSARIF converts the vulns of rows, whose traces must be stored in
+// columns, to a SARIF log with a single run.
+//
+// Rows of the same module version, like the GOVULNCHECK and IMPORTS rows
+// of a scan, hold findings of the same vulnerabilities, so each
+// vulnerability has one result per module version, at the most precise
+// level at which it was found. The result is located at the call in the
+// module that is closest to the vulnerable symbol, or else at the go.mod
+// file, and has a code flow for each trace at that level.
+func ToSARIF(rows []*Result) *SARIFLog {
+	driver := SARIFDriver{
+		Name:           "govulncheck",
+		InformationURI: "https://golang.org/x/vuln",
+		Rules:          []SARIFRule{},
+	}
+	type key struct{ module, version, id string }
+	var (
+		keys  []key
+		vulns = map[key][]*Vuln{}
+		rules = map[string]*SARIFRule{}
+	)
+	for _, r := range rows {
+		if driver.Version == "" && r.ScannerConfig != nil {
+			driver.Version = strings.TrimPrefix(r.ScannerConfig.ScannerVersion, "v")
+		}
+		for _, v := range r.Vulns {
+			k := key{r.ModulePath, r.Version, v.ID}
+			if _, ok := vulns[k]; !ok {
+				keys = append(keys, k)
+			}
+			vulns[k] = append(vulns[k], v)
+			if rules[v.ID] == nil {
+				rule := &SARIFRule{
+					ID:               v.ID,
+					ShortDescription: SARIFMessage{Text: v.ID},
+					HelpURI:          "https://pkg.go.dev/vuln/" + v.ID,
+				}
+				if v.Summary.Valid {
+					rule.ShortDescription.Text = v.Summary.StringVal
+				}
+				rule.Properties.Tags = v.Aliases
+				rules[v.ID] = rule
+			}
+		}
+	}
+	ids := make([]string, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		driver.Rules = append(driver.Rules, *rules[id])
+	}
+
+	results := []SARIFResult{}
+	for _, k := range keys {
+		results = append(results, sarifResult(k.module, k.version, mostPrecise(vulns[k])))
+	}
+	return &SARIFLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs:    []SARIFRun{{Tool: SARIFTool{Driver: driver}, Results: results}},
+	}
+}
+
+// mostPrecise returns the vulns of vs found at the most precise level.
+// Vulns without a level count as module-level ones.
+func mostPrecise(vs []*Vuln) []*Vuln {
+	rank := func(v *Vuln) int {
+		if r, ok := levelRank[v.Level.StringVal]; ok {
+			return r
+		}
+		return levelRank[LevelModule]
+	}
+	best := rank(vs[0])
+	for _, v := range vs[1:] {
+		if r := rank(v); r < best {
+			best = r
+		}
+	}
+	var ps []*Vuln
+	for _, v := range vs {
+		if rank(v) == best {
+			ps = append(ps, v)
+		}
+	}
+	return ps
+}
+
+// sarifResult returns the result for vs, the vulns of one vulnerability
+// found at the same level in modulePath@version.
+func sarifResult(modulePath, version string, vs []*Vuln) SARIFResult {
+	v := vs[0]
+	level := v.Level.StringVal
+	if level == "" {
+		level = LevelModule
+	}
+	var text string
+	switch level {
+	case LevelSymbol:
+		text = fmt.Sprintf("%s: vulnerable symbols of package %s in %s@%s are called", v.ID, v.PackagePath, v.ModulePath, v.Version)
+	case LevelPackage:
+		text = fmt.Sprintf("%s: vulnerable package %s in %s@%s is imported", v.ID, v.PackagePath, v.ModulePath, v.Version)
+	default:
+		text = fmt.Sprintf("%s: vulnerable module %s@%s is required", v.ID, v.ModulePath, v.Version)
+	}
+	if v.FixedVersion.Valid {
+		text += fmt.Sprintf(" (fixed in %s)", v.FixedVersion.StringVal)
+	}
+	res := SARIFResult{
+		RuleID:  v.ID,
+		Level:   sarifLevels[level],
+		Message: SARIFMessage{Text: text},
+	}
+	for _, v := range vs {
+		if len(v.Trace) == 0 {
+			continue
+		}
+		if res.Locations == nil {
+			// Trace[0] is the vulnerable symbol, and the last frame
+			// the entry point.
+			for _, f := range v.Trace {
+				if f.Module == modulePath && f.Position != nil {
+					res.Locations = []SARIFLocation{{PhysicalLocation: sarifPhysicalLocation(modulePath, version, f.Position)}}
+					break
+				}
+			}
+		}
+		var tf SARIFThreadFlow
+		for i := len(v.Trace) - 1; i >= 0; i-- {
+			f := v.Trace[i]
+			loc := SARIFLocation{Message: &SARIFMessage{Text: frameName(f)}}
+			if f.Position != nil {
+				loc.PhysicalLocation = sarifPhysicalLocation(modulePath, version, f.Position)
+			}
+			tf.Locations = append(tf.Locations, SARIFThreadFlowLocation{Location: loc})
+		}
+		res.CodeFlows = append(res.CodeFlows, SARIFCodeFlow{ThreadFlows: []SARIFThreadFlow{tf}})
+	}
+	if res.Locations == nil {
+		res.Locations = []SARIFLocation{{PhysicalLocation: &SARIFPhysicalLocation{
+			ArtifactLocation: SARIFArtifactLocation{URI: "go.mod", URIBaseID: sarifSrcRoot},
+		}}}
+	}
+	return res
+}
+
+// sarifPhysicalLocation returns the location of p, relative to the root of
+// modulePath@version if it is in that module's directory.
+func sarifPhysicalLocation(modulePath, version string, p *TracePosition) *SARIFPhysicalLocation {
+	uri := strings.ReplaceAll(p.Filename, "\\", "/")
+	if _, rest, ok := strings.Cut(uri, modulePath+"@"+version+"/"); ok {
+		uri = rest
+	}
+	loc := &SARIFPhysicalLocation{ArtifactLocation: SARIFArtifactLocation{URI: uri, URIBaseID: sarifSrcRoot}}
+	if p.Line > 0 {
+		loc.Region = &SARIFRegion{StartLine: p.Line, StartColumn: p.Column}
+	}
+	return loc
+}
+
+// frameName returns the qualified name of the function of f.
+func frameName(f *TraceFrame) string {
+	switch {
+	case f.Function == "":
+		return f.Package
+	case f.Receiver != "":
+		return fmt.Sprintf("%s.%s.%s", f.Package, strings.TrimPrefix(f.Receiver, "*"), f.Function)
+	default:
+		return f.Package + "." + f.Function
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestToSARIF(t *testing.T) {
+	trace := []*TraceFrame{
+		{Module: "golang.org/x/text", Version: "v0.3.0", Package: "golang.org/x/text/language", Function: "Parse"},
+		{Module: "example.com/m", Version: "v1.0.0", Package: "example.com/m/p", Function: "F", Receiver: "*T",
+			Position: &TracePosition{Filename: "/tmp/modules/example.com/m@v1.0.0/p/p.go", Line: 10, Column: 2}},
+		{Module: "example.com/m", Version: "v1.0.0", Package: "example.com/m", Function: "main",
+			Position: &TracePosition{Filename: "/tmp/modules/example.com/m@v1.0.0/main.go", Line: 5, Column: 3}},
+	}
+	text := func(level string) *Vuln {
+		return &Vuln{
+			ID: "GO-2021-0113", ModulePath: "golang.org/x/text", Version: "v0.3.0",
+			PackagePath:  "golang.org/x/text/language",
+			Level:        bigquery.NullString(level),
+			Summary:      bigquery.NullString("Out-of-bounds read in golang.org/x/text/language"),
+			Aliases:      []string{"CVE-2021-38561"},
+			FixedVersion: bigquery.NullString("v0.3.7"),
+		}
+	}
+	called := text(LevelSymbol)
+	called.Trace = trace
+	net := &Vuln{ID: "GO-2022-0236", ModulePath: "golang.org/x/net", Version: "v0.0.1", Level: bigquery.NullString(LevelModule)}
+	rows := []*Result{
+		{ModulePath: "example.com/m", Version: "v1.0.0", ScanMode: ModeGovulncheck, Vulns: []*Vuln{called},
+			ScannerConfig: &ScannerConfig{ScannerVersion: "v1.0.1"}},
+		{ModulePath: "example.com/m", Version: "v1.0.0", ScanMode: ModeImports, Vulns: []*Vuln{text(LevelPackage), called, net}},
+	}
+	log := ToSARIF(rows)
+
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("got version %q and %d runs, want 2.1.0 and 1", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	if got := run.Tool.Driver.Version; got != "1.0.1" {
+		t.Errorf("driver version: got %q, want 1.0.1", got)
+	}
+	var ruleIDs []string
+	for _, r := range run.Tool.Driver.Rules {
+		ruleIDs = append(ruleIDs, r.ID)
+	}
+	if want := []string{"GO-2021-0113", "GO-2022-0236"}; !cmp.Equal(ruleIDs, want) {
+		t.Errorf("rules: got %v, want %v", ruleIDs, want)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(run.Results))
+	}
+
+	// The symbol-level finding is reported at the call in the module
+	// closest to the vulnerable symbol, once for its two rows.
+	res := run.Results[0]
+	if res.RuleID != "GO-2021-0113" || res.Level != "error" {
+		t.Errorf("got rule %s at level %s, want GO-2021-0113 at error", res.RuleID, res.Level)
+	}
+	wantLoc := &SARIFPhysicalLocation{
+		ArtifactLocation: SARIFArtifactLocation{URI: "p/p.go", URIBaseID: "%SRCROOT%"},
+		Region:           &SARIFRegion{StartLine: 10, StartColumn: 2},
+	}
+	if diff := cmp.Diff(wantLoc, res.Locations[0].PhysicalLocation); diff != "" {
+		t.Errorf("location mismatch (-want, +got):\n%s", diff)
+	}
+	if len(res.CodeFlows) != 2 {
+		t.Fatalf("got %d code flows, want 2", len(res.CodeFlows))
+	}
+	var steps []string
+	for _, l := range res.CodeFlows[0].ThreadFlows[0].Locations {
+		steps = append(steps, l.Location.Message.Text)
+	}
+	if want := []string{"example.com/m.main", "example.com/m/p.T.F", "golang.org/x/text/language.Parse"}; !cmp.Equal(steps, want) {
+		t.Errorf("code flow: got %v, want %v", steps, want)
+	}
+
+	// The module-level finding is reported at go.mod.
+	res = run.Results[1]
+	if res.Level != "note" || res.Locations[0].PhysicalLocation.ArtifactLocation.URI != "go.mod" {
+		t.Errorf("got level %s at %+v, want note at go.mod", res.Level, res.Locations[0].PhysicalLocation)
+	}
+
+	if _, err := json.Marshal(log); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	for _, f := range []string{"", FormatJSON, FormatSARIF} {
+		if err := ValidateFormat(f); err != nil {
+			t.Errorf("%q: %v", f, err)
+		}
+	}
+	if err := ValidateFormat("xml"); err == nil {
+		t.Error("xml: got nil, want error")
+	}
+}
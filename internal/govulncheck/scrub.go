@@ -0,0 +1,133 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+// Fields of a Result that a Scrubber can scrub.
+const (
+	// ScrubModulePath scrubs the module paths of the result and its vulns.
+	ScrubModulePath = "module_path"
+	// ScrubPackagePath scrubs the package paths of the vulns.
+	ScrubPackagePath = "package_path"
+)
+
+// DefaultScrubFields is the list of fields scrubbed when none are configured.
+const DefaultScrubFields = ScrubModulePath + "," + ScrubPackagePath
+
+// A Scrubber replaces the values of some Result fields with a keyed HMAC of
+// those values, so that scrubbed rows can still be joined on those fields
+// but not read.
+type Scrubber struct {
+	key    []byte
+	fields map[string]bool
+}
+
+// NewScrubber returns a Scrubber that hashes with key the fields in the
+// comma-separated list fields. If fields is empty, DefaultScrubFields
+// are scrubbed.
+func NewScrubber(key []byte, fields string) (*Scrubber, error) {
+	if len(key) < 16 {
+		return nil, errors.New("HMAC secret must be at least 16 bytes")
+	}
+	if strings.TrimSpace(fields) == "" {
+		fields = DefaultScrubFields
+	}
+	s := &Scrubber{key: key, fields: map[string]bool{}}
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case ScrubModulePath, ScrubPackagePath:
+			s.fields[f] = true
+		case "":
+		default:
+			return nil, fmt.Errorf("cannot scrub unknown field %q", f)
+		}
+	}
+	return s, nil
+}
+
+// Hash returns the keyed HMAC of v as a hex string.
+// The empty string hashes to itself.
+func (s *Scrubber) Hash(v string) string {
+	if v == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.key)
+	io.WriteString(mac, v)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ModulePath returns the stored form of the module path p:
+// its hash if module paths are scrubbed, p otherwise.
+// s may be nil.
+func (s *Scrubber) ModulePath(p string) string {
+	if s == nil || !s.fields[ScrubModulePath] {
+		return p
+	}
+	return s.Hash(p)
+}
+
+// Scrub replaces the configured fields of r with their hashes and
+// marks r as scrubbed. If s is nil, Scrub does nothing.
+func (s *Scrubber) Scrub(r *Result) {
+	if s == nil {
+		return
+	}
+	if s.fields[ScrubModulePath] {
+		r.ModulePath = s.Hash(r.ModulePath)
+		if r.RequestedModulePath.Valid {
+			r.RequestedModulePath.StringVal = s.Hash(r.RequestedModulePath.StringVal)
+		}
+	}
+	// Vulns may be shared between rows, so scrub copies.
+	var vulns []*Vuln
+	for _, v := range r.Vulns {
+		v2 := *v
+		if s.fields[ScrubModulePath] {
+			v2.ModulePath = s.Hash(v2.ModulePath)
+		}
+		if s.fields[ScrubPackagePath] {
+			v2.PackagePath = s.Hash(v2.PackagePath)
+		}
+		if len(v2.Trace) > 0 && (s.fields[ScrubModulePath] || s.fields[ScrubPackagePath]) {
+			v2.Trace = s.scrubTrace(v2.Trace)
+		}
+		if v2.Fingerprint.Valid && (s.fields[ScrubModulePath] || s.fields[ScrubPackagePath]) {
+			// The fingerprint is computed from the paths and the entry
+			// function, so it must not be guessable from them.
+			v2.Fingerprint.StringVal = s.Hash(v2.Fingerprint.StringVal)
+		}
+		vulns = append(vulns, &v2)
+	}
+	r.Vulns = vulns
+	r.Scrubbed = bigquery.NullBool(true)
+}
+
+// scrubTrace returns a copy of trace with the configured paths hashed.
+func (s *Scrubber) scrubTrace(trace []*TraceFrame) []*TraceFrame {
+	var tfs []*TraceFrame
+	for _, f := range trace {
+		f2 := *f
+		if s.fields[ScrubModulePath] {
+			f2.Module = s.Hash(f2.Module)
+		}
+		if s.fields[ScrubPackagePath] {
+			f2.Package = s.Hash(f2.Package)
+		}
+		tfs = append(tfs, &f2)
+	}
+	return tfs
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestScrubber(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	if _, err := NewScrubber(key[:8], ""); err == nil {
+		t.Error("short key: got nil error")
+	}
+	if _, err := NewScrubber(key, "module_path,position"); err == nil {
+		t.Error("unknown field: got nil error")
+	}
+
+	s, err := NewScrubber(key, "module_path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Result{
+		ModulePath: "github.com/someone/mod",
+		Vulns: []*Vuln{{
+			ModulePath:  "github.com/someone/dep",
+			PackagePath: "github.com/someone/dep/pkg",
+			Fingerprint: bigquery.NullString("fp"),
+			Trace:       []*TraceFrame{{Module: "github.com/someone/dep", Package: "github.com/someone/dep/pkg", Function: "F"}},
+		}},
+	}
+	trace := r.Vulns[0].Trace
+	s.Scrub(r)
+	if got, want := r.ModulePath, s.Hash("github.com/someone/mod"); got != want {
+		t.Errorf("module path: got %q, want %q", got, want)
+	}
+	if got, want := r.Vulns[0].ModulePath, s.Hash("github.com/someone/dep"); got != want {
+		t.Errorf("vuln module path: got %q, want %q", got, want)
+	}
+	if got, want := r.Vulns[0].Fingerprint.StringVal, s.Hash("fp"); got != want {
+		t.Errorf("vuln fingerprint: got %q, want %q", got, want)
+	}
+	if got, want := r.Vulns[0].Trace[0].Module, s.Hash("github.com/someone/dep"); got != want {
+		t.Errorf("trace module path: got %q, want %q", got, want)
+	}
+	if got, want := trace[0].Module, "github.com/someone/dep"; got != want {
+		t.Errorf("original trace module path: got %q, want %q", got, want)
+	}
+	// Package paths are not scrubbed with this configuration.
+	if got, want := r.Vulns[0].PackagePath, "github.com/someone/dep/pkg"; got != want {
+		t.Errorf("vuln package path: got %q, want %q", got, want)
+	}
+	if got, want := r.Vulns[0].Trace[0].Package, "github.com/someone/dep/pkg"; got != want {
+		t.Errorf("trace package path: got %q, want %q", got, want)
+	}
+	if !r.Scrubbed.Valid || !r.Scrubbed.Bool {
+		t.Error("result not marked as scrubbed")
+	}
+	if got, want := s.ModulePath("github.com/someone/mod"), r.ModulePath; got != want {
+		t.Errorf("ModulePath: got %q, want %q", got, want)
+	}
+
+	// A nil Scrubber changes nothing.
+	var ns *Scrubber
+	r2 := &Result{ModulePath: "m"}
+	ns.Scrub(r2)
+	if r2.ModulePath != "m" || r2.Scrubbed.Valid {
+		t.Errorf("nil Scrubber modified result: %+v", r2)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+const ShadowTableName = "govulncheck-shadow"
+
+// ShadowDiff is a row in the BigQuery govulncheck-shadow table.
+// It records how the row produced by a shadow scan of a module
+// differs from the row stored for it.
+type ShadowDiff struct {
+	CreatedAt  time.Time `bigquery:"created_at"`
+	ModulePath string    `bigquery:"module_path"`
+	Version    string    `bigquery:"version"`
+	ScanMode   string    `bigquery:"scan_mode"`
+	// Suffix identifies the shadow run.
+	Suffix string `bigquery:"suffix"`
+	// StoredWorkerVersion is the worker version of the stored row.
+	StoredWorkerVersion string `bigquery:"stored_worker_version"`
+	WorkerVersion       string `bigquery:"worker_version"`
+	// Fields are the columns whose values differ; see DiffResults.
+	Fields []string `bigquery:"fields"`
+	// AddedFindings and RemovedFindings are the fingerprints of the
+	// findings that only the shadow row and only the stored row have;
+	// see DiffFingerprints.
+	AddedFindings   []string `bigquery:"added_findings"`
+	RemovedFindings []string `bigquery:"removed_findings"`
+}
+
+func (d *ShadowDiff) SetUploadTime(t time.Time) { d.CreatedAt = t }
+
+func init() {
+	s, err := bigquery.InferSchema(ShadowDiff{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(ShadowTableName, s)
+}
+
+// shadowIgnored are the columns of Result that are expected to differ
+// between two scans of the same module by different workers.
+var shadowIgnored = map[string]bool{
+	"created_at":       true,
+	"suffix":           true,
+	"corpus_hash":      true,
+	"scan_seconds":     true,
+	"build_seconds":    true,
+	"scan_memory":      true,
+	"scan_started_at":  true,
+	"scan_finished_at": true,
+	// The module cache depends on the worker's configuration and load.
+	"modcache_wait_seconds": true,
+	"isolated_modcache":     true,
+	"worker_version":        true,
+	"schema_version":        true,
+	// Compare summaries include build and scan times.
+	"compare_summary": true,
+}
+
+// DiffResults returns the names of the columns whose values differ
+// between stored and current, ignoring timestamps, performance numbers
+// and the worker and schema versions. The order of vulns doesn't matter.
+func DiffResults(stored, current *Result) []string {
+	var fields []string
+	var diff func(v1, v2 reflect.Value)
+	diff = func(v1, v2 reflect.Value) {
+		t := v1.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous {
+				diff(v1.Field(i), v2.Field(i))
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("bigquery"), ",")
+			name = strings.TrimSpace(name)
+			if name == "" || name == "-" || shadowIgnored[name] {
+				continue
+			}
+			x, y := v1.Field(i).Interface(), v2.Field(i).Interface()
+			if name == "vulns" {
+				x, y = sortedVulns(stored.Vulns), sortedVulns(current.Vulns)
+			}
+			if t1, ok := x.(time.Time); ok {
+				// Times read from BigQuery lose their location.
+				if !t1.Equal(y.(time.Time)) {
+					fields = append(fields, name)
+				}
+			} else if !reflect.DeepEqual(x, y) {
+				fields = append(fields, name)
+			}
+		}
+	}
+	diff(reflect.ValueOf(stored).Elem(), reflect.ValueOf(current).Elem())
+	return fields
+}
+
+// sortedVulns returns a sorted copy of vulns. The Called field, which
+// is not stored, is cleared, as are the fingerprint fields, whose
+// differences DiffFingerprints reports by finding.
+func sortedVulns(vulns []*Vuln) []Vuln {
+	vs := make([]Vuln, len(vulns))
+	for i, v := range vulns {
+		vs[i] = *v
+		vs[i].Called = false
+		vs[i].Fingerprint = bq.NullString{}
+		vs[i].FingerprintVersion = bq.NullInt64{}
+	}
+	sort.Slice(vs, func(i, j int) bool {
+		if vs[i].ID != vs[j].ID {
+			return vs[i].ID < vs[j].ID
+		}
+		if vs[i].ModulePath != vs[j].ModulePath {
+			return vs[i].ModulePath < vs[j].ModulePath
+		}
+		return vs[i].PackagePath < vs[j].PackagePath
+	})
+	return vs
+}
+
+// ReadResult returns the most recent row for the module version in the
+// given scan mode, or nil if there is none.
+func ReadResult(ctx context.Context, c *bigquery.Client, modulePath, version, mode string) (_ *Result, err error) {
+	defer derrors.Wrap(&err, "ReadResult(%q, %q, %q)", modulePath, version, mode)
+
+	const qf = `
+		SELECT * FROM %s AS r
+		WHERE module_path=@module_path AND version=@version AND scan_mode=@scan_mode AND %s
+		ORDER BY created_at DESC LIMIT 1
+	`
+	table := "`" + c.FullTableName(TableName) + "`"
+	query := fmt.Sprintf(qf, table, notInvalidatedCondition(table, "r"))
+	var res *Result
+	params := []bq.QueryParameter{
+		bigquery.Param("module_path", modulePath),
+		bigquery.Param("version", version),
+		bigquery.Param("scan_mode", mode),
+	}
+	err = bigquery.ForEach(ctx, c, query, params, func(r *Result) bool {
+		res = r
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ShadowStats summarizes the shadow scans of a run.
+type ShadowStats struct {
+	Suffix string
+	// Rows is the number of rows compared.
+	Rows int
+	// Differing is the number of rows with at least one differing column.
+	Differing int
+	// Fields is the number of differing rows for each column.
+	Fields map[string]int
+}
+
+// ReadShadowStats reads the rows of the shadow run with the given suffix
+// and summarizes them.
+func ReadShadowStats(ctx context.Context, c *bigquery.Client, suffix string) (_ *ShadowStats, err error) {
+	defer derrors.Wrap(&err, "ReadShadowStats(%q)", suffix)
+
+	const qf = `SELECT * FROM %s WHERE suffix = @suffix`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(ShadowTableName)+"`")
+	params := []bq.QueryParameter{bigquery.Param("suffix", suffix)}
+	stats := &ShadowStats{Suffix: suffix, Fields: map[string]int{}}
+	err = bigquery.ForEach(ctx, c, query, params, func(d *ShadowDiff) bool {
+		stats.Rows++
+		if len(d.Fields) > 0 {
+			stats.Differing++
+		}
+		for _, f := range d.Fields {
+			stats.Fields[f]++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestDiffResults(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	stored := &Result{
+		CreatedAt:   now,
+		ModulePath:  "example.com/m",
+		Version:     "v1.0.0",
+		ScanSeconds: 10,
+		CommitTime:  now,
+		WorkVersion: WorkVersion{WorkerVersion: "old", GoVersion: "go1.20"},
+		Vulns: []*Vuln{
+			{ID: "GO-1", PackagePath: "p"},
+			{ID: "GO-2", PackagePath: "q"},
+		},
+	}
+
+	// Timestamps, performance, worker version, vuln order and
+	// fingerprints are ignored.
+	same := *stored
+	same.CreatedAt = now.Add(time.Hour)
+	same.CommitTime = now.In(time.FixedZone("X", 3600))
+	same.ScanSeconds = 20
+	same.WorkVersion.WorkerVersion = "new"
+	same.Vulns = []*Vuln{
+		{ID: "GO-2", PackagePath: "q", Called: true},
+		{ID: "GO-1", PackagePath: "p", Fingerprint: bigquery.NullString("fp"), FingerprintVersion: bigquery.NullInt(FingerprintVersion)},
+	}
+	if got := DiffResults(stored, &same); len(got) != 0 {
+		t.Errorf("got differences %v, want none", got)
+	}
+
+	diff := same
+	diff.GoVersion = "go1.21"
+	diff.ErrorCategory = "LOAD"
+	diff.FindingsCapped = bigquery.NullBool(true)
+	diff.Vulns = diff.Vulns[:1]
+	want := []string{"error_category", "findings_capped", "go_version", "vulns"}
+	if got := DiffResults(stored, &diff); !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
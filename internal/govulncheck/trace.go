@@ -0,0 +1,164 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+// Trace storage strategies, set by config.Config.TraceStorage.
+const (
+	// TraceStorageNone does not store traces.
+	TraceStorageNone = "none"
+	// TraceStorageJSON stores each vuln's trace in its trace_json column.
+	TraceStorageJSON = "json"
+	// TraceStorageColumn stores each vuln's trace in its repeated trace
+	// column, which can be queried frame by frame.
+	TraceStorageColumn = "column"
+)
+
+// ValidateTraceStorage returns an error if s is not a trace storage
+// strategy. The empty string means TraceStorageNone.
+func ValidateTraceStorage(s string) error {
+	switch s {
+	case "", TraceStorageNone, TraceStorageJSON, TraceStorageColumn:
+		return nil
+	default:
+		return fmt.Errorf("unknown trace storage %q; want %q, %q or %q", s,
+			TraceStorageNone, TraceStorageJSON, TraceStorageColumn)
+	}
+}
+
+// StoreTrace stores trace in v according to the trace storage
+// strategy storage.
+func (v *Vuln) StoreTrace(trace []*govulncheckapi.Frame, storage string) error {
+	switch storage {
+	case TraceStorageJSON:
+		return v.SetTrace(trace)
+	case TraceStorageColumn:
+		v.Trace = ConvertTrace(trace)
+	}
+	return nil
+}
+
+// A TraceFrame is a frame of the trace of a finding, from the vulnerable
+// symbol to the entry point; see govulncheckapi.Frame.
+type TraceFrame struct {
+	Module   string `bigquery:"module"`
+	Version  string `bigquery:"version"`
+	Package  string `bigquery:"package"`
+	Function string `bigquery:"function"`
+	Receiver string `bigquery:"receiver"`
+	// Position is null if govulncheck reported no position, as for
+	// frames of binary-mode traces.
+	Position *TracePosition `bigquery:"position,nullable"`
+}
+
+// A TracePosition is the position of a call in a TraceFrame.
+type TracePosition struct {
+	Filename string `bigquery:"filename"`
+	Line     int    `bigquery:"line"`
+	Column   int    `bigquery:"column"`
+}
+
+// ConvertTrace converts a govulncheck trace to TraceFrames.
+func ConvertTrace(trace []*govulncheckapi.Frame) []*TraceFrame {
+	var tfs []*TraceFrame
+	for _, f := range trace {
+		tf := &TraceFrame{
+			Module:   f.Module,
+			Version:  f.Version,
+			Package:  f.Package,
+			Function: f.Function,
+			Receiver: f.Receiver,
+		}
+		if p := f.Position; p != nil {
+			tf.Position = &TracePosition{Filename: p.Filename, Line: p.Line, Column: p.Column}
+		}
+		tfs = append(tfs, tf)
+	}
+	return tfs
+}
+
+// traceGzipThreshold is the size of the JSON encoding of a trace above
+// which it is compressed.
+const traceGzipThreshold = 4 << 10
+
+// gzipPrefix marks a compressed trace. JSON never starts with it.
+const gzipPrefix = "gz:"
+
+// EncodeTrace encodes trace as compact JSON. If that is larger than
+// traceGzipThreshold, it is gzipped and base64-encoded, with a "gz:"
+// prefix. DecodeTrace reverses the encoding.
+func EncodeTrace(trace []*govulncheckapi.Frame) (string, error) {
+	data, err := json.Marshal(trace)
+	if err != nil {
+		return "", err
+	}
+	if len(data) <= traceGzipThreshold {
+		return string(data), nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return gzipPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeTrace decodes a trace encoded by EncodeTrace.
+func DecodeTrace(s string) ([]*govulncheckapi.Frame, error) {
+	data := []byte(s)
+	if rest, ok := strings.CutPrefix(s, gzipPrefix); ok {
+		z, err := base64.StdEncoding.DecodeString(rest)
+		if err != nil {
+			return nil, fmt.Errorf("decoding trace: %v", err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(z))
+		if err != nil {
+			return nil, fmt.Errorf("decoding trace: %v", err)
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("decoding trace: %v", err)
+		}
+	}
+	var trace []*govulncheckapi.Frame
+	if err := json.Unmarshal(data, &trace); err != nil {
+		return nil, fmt.Errorf("decoding trace: %v", err)
+	}
+	return trace, nil
+}
+
+// SetTrace stores trace in v.TraceJSON.
+func (v *Vuln) SetTrace(trace []*govulncheckapi.Frame) error {
+	s, err := EncodeTrace(trace)
+	if err != nil {
+		return err
+	}
+	v.TraceJSON = bigquery.NullString(s)
+	return nil
+}
+
+// DecodeTrace returns the trace stored in v.TraceJSON, or nil if
+// there is none.
+func (v *Vuln) DecodeTrace() ([]*govulncheckapi.Frame, error) {
+	if !v.TraceJSON.Valid {
+		return nil, nil
+	}
+	return DecodeTrace(v.TraceJSON.StringVal)
+}
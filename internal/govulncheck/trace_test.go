@@ -0,0 +1,169 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+)
+
+func TestEncodeTrace(t *testing.T) {
+	frame := &govulncheckapi.Frame{
+		Module:   "golang.org/x/text",
+		Version:  "v0.3.0",
+		Package:  "golang.org/x/text/language",
+		Function: "Parse",
+		Position: &govulncheckapi.Position{Filename: "language/parse.go", Line: 228, Column: 2},
+	}
+	for _, test := range []struct {
+		name     string
+		trace    []*govulncheckapi.Frame
+		wantGzip bool
+	}{
+		{"empty", nil, false},
+		{"short", []*govulncheckapi.Frame{frame}, false},
+		{"long", repeatFrame(frame, 200), true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := EncodeTrace(test.trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.HasPrefix(s, gzipPrefix); got != test.wantGzip {
+				t.Errorf("compressed = %t, want %t", got, test.wantGzip)
+			}
+			v := &Vuln{}
+			if err := v.SetTrace(test.trace); err != nil {
+				t.Fatal(err)
+			}
+			got, err := v.DecodeTrace()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.trace, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeTraceNull(t *testing.T) {
+	got, err := (&Vuln{}).DecodeTrace()
+	if err != nil || got != nil {
+		t.Errorf("got (%v, %v), want (nil, nil)", got, err)
+	}
+}
+
+func TestStoreTrace(t *testing.T) {
+	trace := []*govulncheckapi.Frame{
+		{
+			Module:   "golang.org/x/text",
+			Version:  "v0.3.0",
+			Package:  "golang.org/x/text/language",
+			Function: "Parse",
+			Position: &govulncheckapi.Position{Filename: "language/parse.go", Offset: 100, Line: 228, Column: 2},
+		},
+		{Module: "example.com/m", Package: "example.com/m", Function: "Run", Receiver: "*T"},
+	}
+	want := []*TraceFrame{
+		{
+			Module:   "golang.org/x/text",
+			Version:  "v0.3.0",
+			Package:  "golang.org/x/text/language",
+			Function: "Parse",
+			Position: &TracePosition{Filename: "language/parse.go", Line: 228, Column: 2},
+		},
+		{Module: "example.com/m", Package: "example.com/m", Function: "Run", Receiver: "*T"},
+	}
+	for _, test := range []struct {
+		storage   string
+		wantJSON  bool
+		wantTrace []*TraceFrame
+	}{
+		{"", false, nil},
+		{TraceStorageNone, false, nil},
+		{TraceStorageJSON, true, nil},
+		{TraceStorageColumn, false, want},
+	} {
+		v := &Vuln{}
+		if err := v.StoreTrace(trace, test.storage); err != nil {
+			t.Fatal(err)
+		}
+		if v.TraceJSON.Valid != test.wantJSON {
+			t.Errorf("%q: trace_json set = %t, want %t", test.storage, v.TraceJSON.Valid, test.wantJSON)
+		}
+		if diff := cmp.Diff(test.wantTrace, v.Trace); diff != "" {
+			t.Errorf("%q: trace mismatch (-want, +got):\n%s", test.storage, diff)
+		}
+		if err := ValidateTraceStorage(test.storage); err != nil {
+			t.Errorf("%q: %v", test.storage, err)
+		}
+	}
+	if err := ValidateTraceStorage("proto"); err == nil {
+		t.Error("unknown storage: got nil error")
+	}
+}
+
+func repeatFrame(f *govulncheckapi.Frame, n int) []*govulncheckapi.Frame {
+	var fs []*govulncheckapi.Frame
+	for i := 0; i < n; i++ {
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+func FuzzEncodeTrace(f *testing.F) {
+	f.Add("golang.org/x/text", "v0.3.0", "golang.org/x/text/language", "Parse", "", 228, 10)
+	f.Add("stdlib", "", "net/http", "Serve", "*Server", 0, 1)
+	f.Fuzz(func(t *testing.T, module, version, pkg, fn, recv string, line, n int) {
+		frame := &govulncheckapi.Frame{
+			Module:   module,
+			Version:  version,
+			Package:  pkg,
+			Function: fn,
+			Receiver: recv,
+		}
+		if line > 0 {
+			frame.Position = &govulncheckapi.Position{Line: line}
+		}
+		trace := repeatFrame(frame, n%500)
+		s, err := EncodeTrace(trace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeTrace(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Invalid UTF-8 is replaced when encoding, so only compare
+		// the number of frames.
+		if len(got) != len(trace) {
+			t.Errorf("got %d frames, want %d", len(got), len(trace))
+		}
+	})
+}
+
+func FuzzDecodeTrace(f *testing.F) {
+	f.Add(`[{"module":"m"}]`)
+	f.Add(gzipPrefix + "H4sIAAAAAAAA/w==")
+	f.Add("null")
+	f.Fuzz(func(t *testing.T, s string) {
+		trace, err := DecodeTrace(s)
+		if err != nil {
+			return
+		}
+		// Anything that decodes must survive a round trip.
+		enc, err := EncodeTrace(trace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecodeTrace(enc); err != nil {
+			t.Fatalf("decoding re-encoded trace %q: %v", enc, err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+// UpsertKey are the columns that identify a scan for Upsert: the module
+// version, the scan mode, the options it was scanned with and the work
+// version.
+var UpsertKey = []string{
+	"module_path", "version", "scan_mode",
+	"platform", "build_tags", "goflags", "include_tests", "pattern",
+	"go_version", "worker_version", "schema_version", "vulndb_last_modified",
+	"osv_filter_hash", "govulncheck_version", "vulndbs_hash",
+}
+
+// Upsertable reports whether r can be written with Upsert. The rows of
+// compare mode, of which a scan has many, and invalidations and
+// tombstones, which are not scans, are not told apart by UpsertKey.
+func (r *Result) Upsertable() bool {
+	return !r.RowType.Valid && r.ScanMode != ModeInvalidation && r.ScanMode != ModeTombstone
+}
+
+// Upsert writes rows, which must be Upsertable, to the govulncheck table.
+// A row replaces the rows of the same scan, by UpsertKey, so that scanning
+// a module version again with the same work version, as retries and
+// duplicate tasks do, updates its row instead of adding another.
+func Upsert(ctx context.Context, c *bigquery.Client, rows []*Result) error {
+	return bigquery.Upsert(ctx, c, TableName, UpsertKey, rows)
+}
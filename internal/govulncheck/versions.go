@@ -0,0 +1,73 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/version"
+)
+
+// canonicalVersionRegexp matches the canonical module versions; see
+// version.Canonical. It is written for BigQuery, whose regexps, like Go's,
+// are RE2.
+var canonicalVersionRegexp = func() string {
+	const (
+		num   = `(0|[1-9][0-9]*)`
+		ident = `(0|[1-9][0-9]*|[0-9]*[A-Za-z-][0-9A-Za-z-]*)`
+		rest  = `\.` + num + `\.` + num + `(-` + ident + `(\.` + ident + `)*)?`
+	)
+	// Only major versions 2 and above can be incompatible.
+	return `^v([01]` + rest + `|([2-9]|[1-9][0-9]+)` + rest + `(\+incompatible)?)$`
+}()
+
+// A NonCanonicalVersion describes the rows of the govulncheck table for
+// a module version that is not canonical.
+type NonCanonicalVersion struct {
+	ModulePath   string    `bigquery:"module_path"`
+	Version      string    `bigquery:"version"`
+	NumRows      int       `bigquery:"num_rows"`
+	FirstCreated time.Time `bigquery:"first_created"`
+	LastCreated  time.Time `bigquery:"last_created"`
+	// Canonical is the canonical form of Version, or the empty string
+	// if it has none.
+	Canonical string `bigquery:"-"`
+}
+
+// ReadNonCanonicalVersions returns the module versions of the rows of
+// the govulncheck table that are not canonical, most frequent first.
+// It is meant to measure how many rows were stored before versions were
+// canonicalized; it doesn't change them. Stdlib rows, whose versions are
+// Go versions, and rows with version "latest", written before the
+// version was resolved, are ignored, as are correction rows.
+func ReadNonCanonicalVersions(ctx context.Context, c *bigquery.Client) (_ []*NonCanonicalVersion, err error) {
+	defer derrors.Wrap(&err, "ReadNonCanonicalVersions")
+
+	const qf = `
+		SELECT
+			module_path, version, COUNT(*) AS num_rows,
+			MIN(created_at) AS first_created, MAX(created_at) AS last_created
+		FROM %s
+		WHERE module_path != "stdlib" AND version NOT IN ("", "%s")
+			AND scan_mode NOT IN ("%s", "%s")
+			AND NOT REGEXP_CONTAINS(version, r"%s")
+		GROUP BY module_path, version
+		ORDER BY num_rows DESC, module_path, version
+	`
+	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`", version.Latest,
+		ModeTombstone, ModeInvalidation, canonicalVersionRegexp)
+	vs, err := bigquery.Query[NonCanonicalVersion](ctx, c, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vs {
+		v.Canonical, _ = version.Canonical(v.Version)
+	}
+	return vs, nil
+}
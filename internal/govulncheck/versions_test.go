@@ -0,0 +1,33 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"regexp"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/version"
+)
+
+func TestCanonicalVersionRegexp(t *testing.T) {
+	re := regexp.MustCompile(canonicalVersionRegexp)
+	// The regexp matches a version if and only if it is its own
+	// canonical form.
+	for _, v := range []string{
+		"v1.2.3", "1.2.3", "v1.2", "v1.2.3+meta",
+		"v0.1.0+incompatible", "v1.2.3+incompatible",
+		"v2.0.0+incompatible", "v10.0.1+incompatible", "v2.0.0",
+		"v1.2.3-rc.1", "v1.2.3-rc.01", "v1.2.3-0a", "v01.2.3",
+		"v0.0.0-20230101000000-abcdefabcdef",
+		"v2.0.1-0.20230101000000-abcdefabcdef+incompatible",
+		"vmaster", "",
+	} {
+		c, err := version.Canonical(v)
+		want := err == nil && c == v
+		if got := re.MatchString(v); got != want {
+			t.Errorf("%q: matched %t, want %t", v, got, want)
+		}
+	}
+}
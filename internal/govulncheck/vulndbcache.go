@@ -0,0 +1,174 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// IsVulnDBURL reports whether the vuln DB db is served over HTTPS,
+// rather than being a local directory.
+func IsVulnDBURL(db string) bool {
+	return strings.HasPrefix(db, "https://")
+}
+
+// ReadVulnDBFile returns the contents of the file with the given
+// slash-separated name, like "index/db.json", in the vuln DB db, which is
+// either a local directory or an HTTPS URL.
+func ReadVulnDBFile(db, name string) (_ []byte, err error) {
+	defer derrors.Wrap(&err, "ReadVulnDBFile(%q, %q)", db, name)
+	if !IsVulnDBURL(db) {
+		return os.ReadFile(filepath.Join(db, filepath.FromSlash(name)))
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(db, "/") + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// vulnDBCacheTTL is how long a VulnDBCache serves a response before
+// fetching it again.
+const vulnDBCacheTTL = time.Hour
+
+// A VulnDBCache is a caching proxy for a vuln DB served over HTTPS.
+// It serves the DB on a local address, so that govulncheck runs don't
+// each fetch the index and entries they need from the remote server, and
+// the DB doesn't have to be copied to local disk before scanning.
+//
+// Successful responses are kept for an hour. Other responses are passed
+// through and not kept.
+//
+// A VulnDBCache is safe for concurrent use.
+type VulnDBCache struct {
+	remote string
+	ttl    time.Duration
+	now    func() time.Time
+	client *http.Client
+	server *http.Server
+	url    string // local URL; set by Start
+
+	mu        sync.Mutex
+	responses map[string]*cachedResponse // by request path
+}
+
+type cachedResponse struct {
+	body        []byte
+	contentType string
+	fetched     time.Time
+}
+
+// NewVulnDBCache returns a cache for the vuln DB at the HTTPS URL remote.
+// now returns the current time.
+func NewVulnDBCache(remote string, now func() time.Time) *VulnDBCache {
+	return &VulnDBCache{
+		remote:    strings.TrimSuffix(remote, "/"),
+		ttl:       vulnDBCacheTTL,
+		now:       now,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		responses: map[string]*cachedResponse{},
+	}
+}
+
+// Start starts serving the cache on a loopback address.
+func (c *VulnDBCache) Start() (err error) {
+	defer derrors.Wrap(&err, "VulnDBCache.Start(%q)", c.remote)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	c.server = &http.Server{Handler: c, ReadHeaderTimeout: 10 * time.Second}
+	c.url = "http://" + ln.Addr().String()
+	go c.server.Serve(ln)
+	return nil
+}
+
+// Close stops serving the cache.
+func (c *VulnDBCache) Close() error {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Close()
+}
+
+// Remote returns the URL of the vuln DB that c caches.
+func (c *VulnDBCache) Remote() string {
+	return c.remote
+}
+
+// URL returns the local URL at which c serves the vuln DB.
+// It is empty until c is started.
+func (c *VulnDBCache) URL() string {
+	return c.url
+}
+
+// ServeHTTP serves the vuln DB file at the request path, from the cache
+// if it has a fresh copy.
+func (c *VulnDBCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := path.Clean("/" + r.URL.Path)
+	c.mu.Lock()
+	cr := c.responses[p]
+	c.mu.Unlock()
+	if cr == nil || c.now().Sub(cr.fetched) >= c.ttl {
+		var (
+			status int
+			err    error
+		)
+		cr, status, err = c.fetch(r, p)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		c.mu.Lock()
+		c.responses[p] = cr
+		c.mu.Unlock()
+	}
+	if cr.contentType != "" {
+		w.Header().Set("Content-Type", cr.contentType)
+	}
+	w.Write(cr.body)
+}
+
+// fetch fetches the file at path p from the remote vuln DB. If that
+// fails, it returns the status to respond with.
+func (c *VulnDBCache) fetch(r *http.Request, p string) (_ *cachedResponse, status int, err error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, c.remote+p, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("%s: %s", c.remote+p, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	return &cachedResponse{body: body, contentType: resp.Header.Get("Content-Type"), fetched: c.now()}, 0, nil
+}
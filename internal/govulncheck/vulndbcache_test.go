@@ -0,0 +1,113 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/clock"
+)
+
+func TestVulnDBCache(t *testing.T) {
+	var fetches atomic.Int32
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.URL.Path != "/index/db.json.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Write([]byte("db"))
+	}))
+	defer remote.Close()
+
+	clk := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	c := NewVulnDBCache(remote.URL+"/", clk.Now)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(c.URL() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(b)
+	}
+	checkFetches := func(want int32) {
+		t.Helper()
+		if got := fetches.Load(); got != want {
+			t.Errorf("got %d fetches, want %d", got, want)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if status, body := get("/index/db.json.gz"); status != http.StatusOK || body != "db" {
+			t.Fatalf("got %d %q, want 200 \"db\"", status, body)
+		}
+	}
+	checkFetches(1)
+
+	clk.Advance(vulnDBCacheTTL)
+	get("/index/db.json.gz")
+	checkFetches(2)
+
+	// Missing files are not cached.
+	for i := 0; i < 2; i++ {
+		if status, _ := get("/ID/GO-0000-0000.json.gz"); status != http.StatusNotFound {
+			t.Errorf("got %d, want 404", status)
+		}
+	}
+	checkFetches(4)
+}
+
+func TestRunOptionsVulnDBs(t *testing.T) {
+	c := NewVulnDBCache("https://vuln.go.dev", time.Now)
+	c.url = "http://127.0.0.1:1234"
+	opts := &RunOptions{VulnDBCaches: []*VulnDBCache{c}}
+	got := opts.vulnDBs([]string{"/tmp/vulndb", "https://vuln.go.dev/", "https://private.example.com"})
+	want := []string{"/tmp/vulndb", "http://127.0.0.1:1234", "https://private.example.com"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestReadVulnDBFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "index"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index", "db.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadVulnDBFile(dir, "index/db.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "{}" {
+		t.Errorf("got %q, want %q", got, "{}")
+	}
+	if IsVulnDBURL(dir) {
+		t.Errorf("IsVulnDBURL(%q) = true, want false", dir)
+	}
+	if !IsVulnDBURL(VulnDBURL) {
+		t.Errorf("IsVulnDBURL(%q) = false, want true", VulnDBURL)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+// SplitVulnDBDirs splits a comma-separated list of vuln DB directories,
+// ignoring empty elements and surrounding space.
+func SplitVulnDBDirs(s string) []string {
+	var dirs []string
+	for _, d := range strings.Split(s, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+// JoinVulnDBDirs joins vuln DB directories into a list that
+// SplitVulnDBDirs splits. It is how the directories are passed to the
+// sandbox programs.
+func JoinVulnDBDirs(dirs []string) string {
+	return strings.Join(dirs, ",")
+}
+
+// VulnDBsHash returns a hash of the given vuln DB directories and their
+// last-modified times, which must have the same length. The order of the
+// DBs matters, since entries are taken from the first DB that has them.
+func VulnDBsHash(dirs []string, modified []time.Time) string {
+	h := sha256.New()
+	for i, d := range dirs {
+		fmt.Fprintf(h, "%s\x00%s\n", d, modified[i].UTC().Format(time.RFC3339Nano))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SetSourceDBs sets the SourceDB of each of vulns to the first of dirs
+// whose vuln DB has the vuln's entry. It does nothing unless there is more
+// than one DB.
+func SetSourceDBs(vulns []*Vuln, dirs []string) {
+	if len(dirs) < 2 {
+		return
+	}
+	sources := map[string]string{}
+	for _, v := range vulns {
+		src, ok := sources[v.ID]
+		if !ok && ValidateOSVID(v.ID) == nil {
+			for _, d := range dirs {
+				if _, err := os.Stat(filepath.Join(d, "ID", v.ID+".json")); err == nil {
+					src = d
+					break
+				}
+			}
+			sources[v.ID] = src
+		}
+		if src != "" {
+			v.SourceDB = bigquery.NullString(src)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitVulnDBDirs(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"/a", []string{"/a"}},
+		{" /a, ,/b ,", []string{"/a", "/b"}},
+	} {
+		got := SplitVulnDBDirs(test.in)
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("SplitVulnDBDirs(%q) = %v, want %v", test.in, got, test.want)
+		}
+		if test.want != nil && SplitVulnDBDirs(JoinVulnDBDirs(got)) == nil {
+			t.Errorf("JoinVulnDBDirs(%v) does not round-trip", got)
+		}
+	}
+}
+
+func TestVulnDBsHash(t *testing.T) {
+	t1 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	h := VulnDBsHash([]string{"/a", "/b"}, []time.Time{t1, t2})
+	if h2 := VulnDBsHash([]string{"/b", "/a"}, []time.Time{t2, t1}); h == h2 {
+		t.Error("hash does not depend on DB order")
+	}
+	if h2 := VulnDBsHash([]string{"/a", "/b"}, []time.Time{t1, t1}); h == h2 {
+		t.Error("hash does not depend on last-modified times")
+	}
+}
+
+func TestSetSourceDBs(t *testing.T) {
+	dir := t.TempDir()
+	db1 := filepath.Join(dir, "db1")
+	db2 := filepath.Join(dir, "db2")
+	for db, ids := range map[string][]string{
+		db1: {"GO-2023-0001"},
+		db2: {"GO-2023-0001", "GO-2023-0002"},
+	} {
+		if err := os.MkdirAll(filepath.Join(db, "ID"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range ids {
+			if err := os.WriteFile(filepath.Join(db, "ID", id+".json"), []byte("{}"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	vulns := []*Vuln{{ID: "GO-2023-0001"}, {ID: "GO-2023-0002"}, {ID: "GO-2023-0003"}, {ID: "GO-2023-0001"}}
+	SetSourceDBs(vulns, []string{db1})
+	for _, v := range vulns {
+		if v.SourceDB.Valid {
+			t.Errorf("%s: source DB set with one DB", v.ID)
+		}
+	}
+
+	SetSourceDBs(vulns, []string{db1, db2})
+	var got []string
+	for _, v := range vulns {
+		got = append(got, v.SourceDB.StringVal)
+	}
+	want := []string{db1, db2, "", db1}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
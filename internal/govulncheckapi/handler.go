@@ -9,6 +9,7 @@ package govulncheckapi
 import (
 	"encoding/json"
 	"io"
+	"sync/atomic"
 
 	"golang.org/x/pkgsite-metrics/internal/osv"
 )
@@ -29,9 +30,53 @@ type Handler interface {
 	Finding(finding *Finding) error
 }
 
+// An ExtendedHandler also handles the messages that newer versions of
+// govulncheck can emit. HandleJSON skips those messages if its handler
+// is not an ExtendedHandler.
+type ExtendedHandler interface {
+	Handler
+
+	// SARIF is called for each SARIF document in the stream.
+	SARIF(doc json.RawMessage) error
+
+	// OpenVEX is called for each OpenVEX document in the stream.
+	OpenVEX(doc json.RawMessage) error
+}
+
+// SkippedMessages implements the methods of ExtendedHandler that are not
+// in Handler by counting and skipping the messages. It is meant to be
+// embedded in handlers. It is safe for concurrent use.
+type SkippedMessages struct {
+	sarif, openVEX atomic.Int64
+}
+
+func (s *SkippedMessages) SARIF(json.RawMessage) error {
+	s.sarif.Add(1)
+	return nil
+}
+
+func (s *SkippedMessages) OpenVEX(json.RawMessage) error {
+	s.openVEX.Add(1)
+	return nil
+}
+
+// Skipped returns the number of skipped messages of each kind, keyed
+// by the kind's JSON field name. Kinds with no messages are omitted.
+func (s *SkippedMessages) Skipped() map[string]int {
+	m := map[string]int{}
+	if n := s.sarif.Load(); n > 0 {
+		m["sarif"] = int(n)
+	}
+	if n := s.openVEX.Load(); n > 0 {
+		m["openvex"] = int(n)
+	}
+	return m
+}
+
 // HandleJSON reads the json from the supplied stream and hands the decoded
 // output to the handler.
 func HandleJSON(from io.Reader, to Handler) error {
+	ext, _ := to.(ExtendedHandler)
 	dec := json.NewDecoder(from)
 	for dec.More() {
 		msg := Message{}
@@ -53,6 +98,12 @@ func HandleJSON(from io.Reader, to Handler) error {
 		if msg.Finding != nil {
 			err = to.Finding(msg.Finding)
 		}
+		if msg.SARIF != nil && ext != nil {
+			err = ext.SARIF(msg.SARIF)
+		}
+		if msg.OpenVEX != nil && ext != nil {
+			err = ext.OpenVEX(msg.OpenVEX)
+		}
 		if err != nil {
 			return err
 		}
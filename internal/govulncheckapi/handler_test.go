@@ -0,0 +1,103 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheckapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+// countHandler counts the messages of each kind.
+type countHandler struct {
+	counts map[string]int
+}
+
+func (h *countHandler) Config(*Config) error     { h.counts["config"]++; return nil }
+func (h *countHandler) Progress(*Progress) error { h.counts["progress"]++; return nil }
+func (h *countHandler) OSV(*osv.Entry) error     { h.counts["osv"]++; return nil }
+func (h *countHandler) Finding(*Finding) error   { h.counts["finding"]++; return nil }
+
+// docHandler also records the documents of the extended kinds.
+type docHandler struct {
+	countHandler
+	docs []string
+}
+
+func (h *docHandler) SARIF(doc json.RawMessage) error {
+	h.counts["sarif"]++
+	h.docs = append(h.docs, string(doc))
+	return nil
+}
+
+func (h *docHandler) OpenVEX(doc json.RawMessage) error {
+	h.counts["openvex"]++
+	h.docs = append(h.docs, string(doc))
+	return nil
+}
+
+func TestHandleJSONExtended(t *testing.T) {
+	for _, test := range []struct {
+		file string
+		want map[string]int // for a docHandler
+	}{
+		{"sarif.json", map[string]int{"config": 1, "progress": 1, "osv": 1, "finding": 1, "sarif": 1}},
+		{"openvex.json", map[string]int{"config": 1, "osv": 1, "finding": 1, "openvex": 2}},
+	} {
+		t.Run(test.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A Handler skips the extended kinds.
+			h := &countHandler{counts: map[string]int{}}
+			if err := HandleJSON(bytes.NewReader(data), h); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]int{}
+			for k, n := range test.want {
+				if k != "sarif" && k != "openvex" {
+					want[k] = n
+				}
+			}
+			if !reflect.DeepEqual(h.counts, want) {
+				t.Errorf("Handler: got %v, want %v", h.counts, want)
+			}
+
+			dh := &docHandler{countHandler: countHandler{counts: map[string]int{}}}
+			if err := HandleJSON(bytes.NewReader(data), dh); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dh.counts, test.want) {
+				t.Errorf("ExtendedHandler: got %v, want %v", dh.counts, test.want)
+			}
+			for _, d := range dh.docs {
+				if !json.Valid([]byte(d)) {
+					t.Errorf("invalid document %q", d)
+				}
+			}
+		})
+	}
+}
+
+func TestSkippedMessages(t *testing.T) {
+	var s SkippedMessages
+	if got := s.Skipped(); len(got) != 0 {
+		t.Errorf("got %v, want none", got)
+	}
+	s.SARIF(nil)
+	s.OpenVEX(nil)
+	s.OpenVEX(nil)
+	want := map[string]int{"sarif": 1, "openvex": 2}
+	if got := s.Skipped(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -7,6 +7,7 @@
 package govulncheckapi
 
 import (
+	"encoding/json"
 	"time"
 
 	"golang.org/x/pkgsite-metrics/internal/osv"
@@ -19,6 +20,12 @@ type Message struct {
 	Progress *Progress  `json:"progress,omitempty"`
 	OSV      *osv.Entry `json:"osv,omitempty"`
 	Finding  *Finding   `json:"finding,omitempty"`
+
+	// SARIF and OpenVEX hold the SARIF and OpenVEX documents that newer
+	// versions of govulncheck can emit, depending on their flags. The
+	// documents are not decoded.
+	SARIF   json.RawMessage `json:"sarif,omitempty"`
+	OpenVEX json.RawMessage `json:"openvex,omitempty"`
 }
 
 // ProtocolVersion is the current protocol version this file implements
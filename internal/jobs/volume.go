@@ -0,0 +1,78 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobs
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const insertVolumeCollection = "InsertVolumes"
+
+// insertVolume is the Firestore document recording the number of
+// bytes inserted into BigQuery by a run.
+type insertVolume struct {
+	Bytes int64
+}
+
+// AddInsertVolume adds n bytes to the insert volume of the run
+// identified by suffix, and returns the new total.
+func (d *DB) AddInsertVolume(ctx context.Context, suffix string, n int64) (total int64, err error) {
+	defer derrors.Wrap(&err, "job.DB.AddInsertVolume(%q, %d)", suffix, n)
+	err = d.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docref := d.insertVolumeRef(suffix)
+		var v insertVolume
+		docsnap, err := tx.Get(docref)
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			return err
+		default:
+			if err := docsnap.DataTo(&v); err != nil {
+				return err
+			}
+		}
+		v.Bytes += n
+		total = v.Bytes
+		return tx.Set(docref, &v)
+	},
+		firestore.MaxAttempts(firestore.DefaultTransactionMaxAttempts*5))
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// InsertVolume returns the insert volume of the run identified by suffix.
+// It returns 0 if nothing has been recorded for the run.
+func (d *DB) InsertVolume(ctx context.Context, suffix string) (_ int64, err error) {
+	defer derrors.Wrap(&err, "job.DB.InsertVolume(%q)", suffix)
+	docsnap, err := d.insertVolumeRef(suffix).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var v insertVolume
+	if err := docsnap.DataTo(&v); err != nil {
+		return 0, err
+	}
+	return v.Bytes, nil
+}
+
+// insertVolumeRef returns the DocumentRef for the insert volume of a run.
+// The empty suffix is stored under a fixed name, because Firestore
+// document IDs cannot be empty.
+func (d *DB) insertVolumeRef(suffix string) *firestore.DocumentRef {
+	if suffix == "" {
+		suffix = "-"
+	}
+	return d.nsDoc.Collection(insertVolumeCollection).Doc(suffix)
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package notify sends notifications about noteworthy events,
+// such as anomalous runs, to an external service.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context/ctxhttp"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// A Notifier delivers notifications.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// A Notification is a single message to deliver.
+type Notification struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Webhook is a Notifier that posts each notification as JSON to a URL.
+type Webhook struct {
+	URL        string
+	HTTPClient *http.Client // if nil, http.DefaultClient is used
+}
+
+// Notify implements Notifier.
+func (w *Webhook) Notify(ctx context.Context, n *Notification) (err error) {
+	defer derrors.Wrap(&err, "Webhook.Notify(%q)", n.Subject)
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ctxhttp.Do(ctx, w.HTTPClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return nil
+}
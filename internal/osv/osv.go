@@ -197,8 +197,15 @@ type Entry struct {
 	// Aliases is a list of IDs for the same vulnerability in other
 	// databases.
 	Aliases []string `json:"aliases,omitempty"`
+	// Summary gives a one-line, English textual summary of the
+	// vulnerability.
+	Summary string `json:"summary,omitempty"`
 	// Details contains English textual details about the vulnerability.
 	Details string `json:"details"`
+	// Severity contains the severity scores of the vulnerability.
+	// The Go vulnerability database does not publish them, but other
+	// databases may.
+	Severity []Severity `json:"severity,omitempty"`
 	// Affected contains information on the modules and versions
 	// affected by the vulnerability.
 	Affected []Affected `json:"affected"`
@@ -213,6 +220,23 @@ type Entry struct {
 	DatabaseSpecific *DatabaseSpecific `json:"database_specific,omitempty"`
 }
 
+// Severity types.
+const (
+	// SeverityCVSSV3 is a CVSS v3.x vector string, such as
+	// "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H".
+	SeverityCVSSV3 = "CVSS_V3"
+)
+
+// Severity is a severity score of a vulnerability.
+//
+// See https://ossf.github.io/osv-schema/#severity-field.
+type Severity struct {
+	// Type is the scoring system, such as SeverityCVSSV3.
+	Type string `json:"type"`
+	// Score is the score in the format of Type.
+	Score string `json:"score"`
+}
+
 // Credit represents a credit for the discovery, confirmation, patch, or
 // other event in the life cycle of a vulnerability.
 //
@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,6 +38,9 @@ type Client struct {
 	// Whether fetch should be disabled.
 	disableFetch bool
 
+	// Credentials for HTTP basic authentication, if user is non-empty.
+	user, password string
+
 	cache *cache
 }
 
@@ -70,6 +74,15 @@ func (c *Client) WithFetchDisabled() *Client {
 	return &c2
 }
 
+// WithBasicAuth returns a new client that authenticates to the proxy
+// with the given user and password.
+func (c *Client) WithBasicAuth(user, password string) *Client {
+	c2 := *c
+	c2.user = user
+	c2.password = password
+	return &c2
+}
+
 // FetchDisabled reports whether proxy fetch is disabled.
 func (c *Client) FetchDisabled() bool {
 	return c.disableFetch
@@ -239,6 +252,64 @@ func (c *Client) Versions(ctx context.Context, modulePath string) (_ []string, e
 	return versions, nil
 }
 
+// maxMajorProbes bounds the number of major versions probed by LatestMajorPath.
+const maxMajorProbes = 20
+
+// LatestMajorPath returns the path of the highest major version of the module
+// modulePath that has a release version. It probes modulePath/v2,
+// modulePath/v3 and so on, stopping at the first major version the
+// proxy does not know about. If no higher major version has a release,
+// LatestMajorPath returns modulePath.
+//
+// gopkg.in paths are returned unchanged, because their major version
+// is part of the import path in a way that cannot be probed.
+func (c *Client) LatestMajorPath(ctx context.Context, modulePath string) (_ string, err error) {
+	defer derrors.Wrap(&err, "LatestMajorPath(ctx, %q)", modulePath)
+
+	if strings.HasPrefix(modulePath, "gopkg.in/") {
+		return modulePath, nil
+	}
+	prefix, pathMajor, ok := module.SplitPathVersion(modulePath)
+	if !ok {
+		return "", fmt.Errorf("invalid module path %q: %w", modulePath, derrors.InvalidArgument)
+	}
+	major := 1
+	if pathMajor != "" {
+		major, err = strconv.Atoi(strings.TrimPrefix(pathMajor, "/v"))
+		if err != nil {
+			return "", fmt.Errorf("invalid module path %q: %w", modulePath, derrors.InvalidArgument)
+		}
+	}
+	latest := modulePath
+	for n := major + 1; n <= major+maxMajorProbes; n++ {
+		candidate := fmt.Sprintf("%s/v%d", prefix, n)
+		versions, err := c.Versions(ctx, candidate)
+		if errors.Is(err, derrors.NotFound) || errors.Is(err, derrors.NotFetched) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(versions) == 0 {
+			break
+		}
+		if hasRelease(versions) {
+			latest = candidate
+		}
+	}
+	return latest, nil
+}
+
+// hasRelease reports whether versions contains a release version.
+func hasRelease(versions []string) bool {
+	for _, v := range versions {
+		if t, err := version.ParseType(v); err == nil && t == version.TypeRelease {
+			return true
+		}
+	}
+	return false
+}
+
 // executeRequest executes an HTTP GET request for u, then calls the bodyFunc
 // on the response body, if no error occurred.
 func (c *Client) executeRequest(ctx context.Context, u string, bodyFunc func(body io.Reader) error) (err error) {
@@ -256,6 +327,9 @@ func (c *Client) executeRequest(ctx context.Context, u string, bodyFunc func(bod
 	if c.disableFetch {
 		req.Header.Set(DisableFetchHeader, "true")
 	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
 	r, err := ctxhttp.Do(ctx, c.HTTPClient, req)
 	if err != nil {
 		return fmt.Errorf("ctxhttp.Do(ctx, client, %q): %v", u, err)
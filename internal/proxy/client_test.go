@@ -131,6 +131,37 @@ func TestListVersions(t *testing.T) {
 	}
 }
 
+func TestLatestMajorPath(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	testModules := []*proxytest.Module{
+		{ModulePath: "example.com/m", Version: "v1.0.0"},
+		{ModulePath: "example.com/m/v2", Version: "v2.1.0"},
+		{ModulePath: "example.com/m/v3", Version: "v3.0.0-pre"},
+		{ModulePath: "example.com/solo", Version: "v1.0.0"},
+	}
+	client, teardownProxy := proxytest.SetupTestClient(t, testModules)
+	defer teardownProxy()
+
+	for _, test := range []struct {
+		path, want string
+	}{
+		{"example.com/m", "example.com/m/v2"},
+		{"example.com/m/v2", "example.com/m/v2"},
+		{"example.com/solo", "example.com/solo"},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml.v2"},
+	} {
+		got, err := client.LatestMajorPath(ctx, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("LatestMajorPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
 func TestInfo(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
 	defer cancel()
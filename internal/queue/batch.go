@@ -0,0 +1,204 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// EnqueueRunTableName is the table of the progress records of batch
+// enqueues.
+const EnqueueRunTableName = "enqueue_runs"
+
+// An EnqueueRun records the progress of a batch enqueue. EnqueueBatch
+// reports one periodically and when it finishes, so the table has several
+// rows for a run: the latest, by CreatedAt, is its current state.
+type EnqueueRun struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	// RunID identifies the batch enqueue.
+	RunID     string    `bigquery:"run_id"`
+	Namespace string    `bigquery:"namespace"`
+	Suffix    string    `bigquery:"suffix"`
+	StartedAt time.Time `bigquery:"started_at"`
+	// Tasks is the number of tasks of the batch. Of those processed so
+	// far, Enqueued were added to the queue, Existing were already on it,
+	// and Failed could not be added. Retries is the number of failed
+	// attempts that were retried.
+	Tasks    int `bigquery:"tasks"`
+	Enqueued int `bigquery:"enqueued"`
+	Existing int `bigquery:"existing"`
+	Failed   int `bigquery:"failed"`
+	Retries  int `bigquery:"retries"`
+	// Done reports whether all tasks were processed.
+	Done bool `bigquery:"done"`
+}
+
+// SetUploadTime is used by Client.Upload.
+func (r *EnqueueRun) SetUploadTime(t time.Time) { r.CreatedAt = t }
+
+func init() {
+	s, err := bigquery.InferSchema(EnqueueRun{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(EnqueueRunTableName, s)
+}
+
+// Defaults of BatchOptions.
+const (
+	DefaultBatchConcurrency = 20
+	defaultBatchBackoff     = time.Second
+	defaultProgressEvery    = 1000
+)
+
+// BatchOptions configures EnqueueBatch.
+type BatchOptions struct {
+	// Concurrency is the number of tasks enqueued at once. If zero, it is
+	// DefaultBatchConcurrency.
+	Concurrency int
+	// Retries is the number of times a task that fails to be enqueued is
+	// tried again, after Backoff and then twice as long each time. If
+	// Backoff is zero, it is one second.
+	Retries int
+	Backoff time.Duration
+	// ScheduleTimes, if non-nil, holds the schedule time of each task,
+	// overriding that of the Options.
+	ScheduleTimes []time.Time
+	// Report, if non-nil, is called with the progress of the batch every
+	// ProgressEvery tasks, 1000 if zero, and when it finishes. It is not
+	// called concurrently. The EnqueueRun must not be retained.
+	Report        func(context.Context, *EnqueueRun)
+	ProgressEvery int
+}
+
+// EnqueueBatch enqueues tasks on q with opts, several at a time, and
+// returns the final counts. Tasks that fail to be enqueued are retried as
+// bopts says, then counted as failed: EnqueueBatch only returns an error
+// if ctx is done before all tasks are processed.
+func EnqueueBatch(ctx context.Context, q Queue, tasks []Task, opts *Options, bopts BatchOptions) (_ *EnqueueRun, err error) {
+	defer derrors.Wrap(&err, "EnqueueBatch(%d tasks)", len(tasks))
+	if opts == nil {
+		opts = &Options{}
+	}
+	if bopts.ScheduleTimes != nil && len(bopts.ScheduleTimes) != len(tasks) {
+		return nil, fmt.Errorf("%d schedule times for %d tasks", len(bopts.ScheduleTimes), len(tasks))
+	}
+	concurrency := bopts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	progressEvery := bopts.ProgressEvery
+	if progressEvery <= 0 {
+		progressEvery = defaultProgressEvery
+	}
+	start := time.Now()
+	run := &EnqueueRun{
+		RunID:     fmt.Sprintf("%s-%s-%d", opts.Namespace, opts.TaskNameSuffix, start.UnixNano()),
+		Namespace: opts.Namespace,
+		Suffix:    opts.TaskNameSuffix,
+		StartedAt: start,
+		Tasks:     len(tasks),
+	}
+	var (
+		mu       sync.Mutex // guards run
+		reportMu sync.Mutex // serializes calls to Report
+	)
+	report := func() {
+		if bopts.Report == nil {
+			return
+		}
+		mu.Lock()
+		r := *run
+		mu.Unlock()
+		reportMu.Lock()
+		defer reportMu.Unlock()
+		bopts.Report(ctx, &r)
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				topts := opts
+				if bopts.ScheduleTimes != nil {
+					o := *opts
+					o.ScheduleTime = bopts.ScheduleTimes[i]
+					topts = &o
+				}
+				enqueued, retries, err := enqueueWithRetries(ctx, q, tasks[i], topts, bopts)
+				mu.Lock()
+				run.Retries += retries
+				switch {
+				case err != nil:
+					log.Errorf(ctx, err, "enqueuing %s?%s", tasks[i].Path(), tasks[i].Params())
+					run.Failed++
+				case enqueued:
+					run.Enqueued++
+				default:
+					run.Existing++
+				}
+				n := run.Enqueued + run.Existing + run.Failed
+				mu.Unlock()
+				if n%progressEvery == 0 && n < len(tasks) {
+					report()
+				}
+			}
+		}()
+	}
+loop:
+	for i := range tasks {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		// The context of Report is done too, so there is no final report.
+		log.Errorf(ctx, err, "enqueue run %s: stopped after %d of %d tasks",
+			run.RunID, run.Enqueued+run.Existing+run.Failed, run.Tasks)
+		return run, err
+	}
+	run.Done = true
+	report()
+	log.Infof(ctx, "enqueue run %s: %d tasks enqueued, %d already enqueued, %d failed, %d retries in %s",
+		run.RunID, run.Enqueued, run.Existing, run.Failed, run.Retries, time.Since(start).Round(time.Second))
+	return run, nil
+}
+
+// enqueueWithRetries enqueues task on q, retrying as bopts says. It
+// returns the result of the last attempt and the number of retries.
+func enqueueWithRetries(ctx context.Context, q Queue, task Task, opts *Options, bopts BatchOptions) (enqueued bool, retries int, err error) {
+	backoff := bopts.Backoff
+	if backoff <= 0 {
+		backoff = defaultBatchBackoff
+	}
+	for {
+		enqueued, err = q.EnqueueScan(ctx, task, opts)
+		if err == nil || retries >= bopts.Retries {
+			return enqueued, retries, err
+		}
+		log.Warnf(ctx, "enqueuing %s?%s, will retry: %v", task.Path(), task.Params(), err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false, retries, err
+		}
+		retries++
+		backoff *= 2
+	}
+}
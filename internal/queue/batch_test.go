@@ -0,0 +1,100 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyQueue is a Queue that fails to enqueue each task the number of
+// times in fails, and reports tasks in existing as already enqueued.
+type flakyQueue struct {
+	mu       sync.Mutex
+	fails    map[string]int
+	existing map[string]bool
+	times    map[string]time.Time
+}
+
+func (q *flakyQueue) EnqueueScan(_ context.Context, task Task, opts *Options) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.fails[task.Name()] > 0 {
+		q.fails[task.Name()]--
+		return false, errors.New("unavailable")
+	}
+	q.times[task.Name()] = opts.ScheduleTime
+	return !q.existing[task.Name()], nil
+}
+
+func TestEnqueueBatch(t *testing.T) {
+	var tasks []Task
+	var times []time.Time
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, &testTask{name: fmt.Sprint(i), path: fmt.Sprintf("m%d@v1.0.0", i)})
+		times = append(times, start.Add(time.Duration(i)*time.Minute))
+	}
+	q := &flakyQueue{
+		fails:    map[string]int{"1": 1, "2": 5},
+		existing: map[string]bool{"3": true},
+		times:    map[string]time.Time{},
+	}
+	var reports []EnqueueRun
+	run, err := EnqueueBatch(context.Background(), q, tasks, &Options{Namespace: "test", TaskNameSuffix: "suf"}, BatchOptions{
+		Concurrency:   3,
+		Retries:       2,
+		Backoff:       time.Millisecond,
+		ScheduleTimes: times,
+		Report: func(_ context.Context, r *EnqueueRun) {
+			reports = append(reports, *r)
+		},
+		ProgressEvery: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Task 1 succeeds on its first retry; task 2 fails all three attempts.
+	want := EnqueueRun{Namespace: "test", Suffix: "suf", Tasks: 10, Enqueued: 8, Existing: 1, Failed: 1, Retries: 3, Done: true}
+	got := *run
+	got.RunID, got.StartedAt = "", time.Time{}
+	if got != want {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	// Reports after 4 and 8 tasks, and at the end.
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want 3", len(reports))
+	}
+	for i, r := range reports[:2] {
+		if n := r.Enqueued + r.Existing + r.Failed; n != 4*(i+1) || r.Done {
+			t.Errorf("report %d: %d tasks processed, done %t; want %d, false", i, n, r.Done, 4*(i+1))
+		}
+	}
+	if reports[2] != *run {
+		t.Errorf("last report %+v, want %+v", reports[2], *run)
+	}
+	for i, task := range tasks {
+		if i == 2 {
+			continue
+		}
+		if got := q.times[task.Name()]; !got.Equal(times[i]) {
+			t.Errorf("task %d: got schedule time %s, want %s", i, got, times[i])
+		}
+	}
+}
+
+func TestEnqueueBatchCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tasks := []Task{&testTask{name: "a", path: "a@v1.0.0"}}
+	q := &flakyQueue{times: map[string]time.Time{}}
+	if _, err := EnqueueBatch(ctx, q, tasks, nil, BatchOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A Canceler is a Queue that can cancel the tasks it has not dispatched.
+type Canceler interface {
+	Queue
+	// CancelTasks cancels the pending tasks that were enqueued with the
+	// TaskNameSuffix suffix, and returns their number. If dryRun is true,
+	// it only counts them. Tasks that are running are not canceled.
+	CancelTasks(ctx context.Context, suffix string, dryRun bool) (int, error)
+}
+
+var (
+	_ Canceler = (*GCP)(nil)
+	_ Canceler = (*InMemory)(nil)
+)
+
+// concurrentDeletes is the number of tasks that GCP.CancelTasks deletes
+// at once.
+const concurrentDeletes = 20
+
+// CancelTasks implements Canceler. It deletes the tasks from all the
+// queues that q enqueues on.
+func (q *GCP) CancelTasks(ctx context.Context, suffix string, dryRun bool) (n int, err error) {
+	defer derrors.Wrap(&err, "queue.GCP.CancelTasks(%q, %t)", suffix, dryRun)
+	if suffix == "" {
+		return 0, errors.New("empty suffix")
+	}
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrentDeletes)
+	for _, queueName := range q.queueNames() {
+		it := q.client.ListTasks(ctx, &taskspb.ListTasksRequest{Parent: queueName})
+		for {
+			t, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				wg.Wait()
+				return n, err
+			}
+			if !hasTaskNameSuffix(t.Name, suffix) && taskURLSuffix(t) != suffix {
+				continue
+			}
+			if dryRun {
+				n++
+				continue
+			}
+			name := t.Name
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				err := q.client.DeleteTask(ctx, &taskspb.DeleteTaskRequest{Name: name})
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil:
+					n++
+				case status.Code(err) == codes.NotFound:
+					// The task ran or was deleted since it was listed.
+				case firstErr == nil:
+					firstErr = err
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return n, firstErr
+}
+
+// queueNames returns the full names of the queues of q.
+func (q *GCP) queueNames() []string {
+	names := []string{q.queueName}
+	seen := map[string]bool{q.queueName: true}
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	add(q.priorityQueueName)
+	var modes []string
+	for mode := range q.modeQueueNames {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	for _, mode := range modes {
+		add(q.modeQueueNames[mode])
+	}
+	return names
+}
+
+// hasTaskNameSuffix reports whether the task with the full name name was
+// enqueued with the TaskNameSuffix suffix. Task names end with the hash
+// of newTaskID, followed by the suffix; see GCP.newTaskRequest.
+func hasTaskNameSuffix(name, suffix string) bool {
+	id, ok := strings.CutSuffix(name, "-"+suffix)
+	if !ok {
+		return false
+	}
+	const hashLen = 8
+	if len(id) < hashLen+1 || id[len(id)-hashLen-1] != '-' {
+		return false
+	}
+	for _, r := range id[len(id)-hashLen:] {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// taskURLSuffix returns the suffix query param of the URL of t, which
+// identifies the run of tasks whose names don't end with their
+// TaskNameSuffix, such as those of IdempotentTasks.
+func taskURLSuffix(t *taskspb.Task) string {
+	u, err := url.Parse(t.GetHttpRequest().GetUrl())
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("suffix")
+}
+
+// CancelTasks implements Canceler.
+func (q *InMemory) CancelTasks(ctx context.Context, suffix string, dryRun bool) (int, error) {
+	if suffix == "" {
+		return 0, errors.New("queue.InMemory.CancelTasks: empty suffix")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.pending[suffix])
+	if !dryRun {
+		for t := range q.pending[suffix] {
+			t.canceled = true
+		}
+		delete(q.pending, suffix)
+	}
+	return n, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// PushPath is the path of the worker endpoint that the push subscription
+// of a PubSub queue delivers tasks to.
+const PushPath = "/queue/push"
+
+// Attributes of the messages of a PubSub queue.
+const (
+	uriAttr          = "uri"
+	taskIDAttr       = "task_id"
+	scheduleTimeAttr = "schedule_time"
+)
+
+// PubSub is a Queue implementation that publishes tasks to a Google Cloud
+// Pub/Sub topic, which has higher throughput than a Cloud Tasks queue. A
+// push subscription of the topic delivers the tasks to PushPath on the
+// worker, which serves them like the requests of Cloud Tasks.
+//
+// Unlike Cloud Tasks, Pub/Sub doesn't de-duplicate tasks, so EnqueueScan
+// always reports that the task was added. Nor does it delay tasks: those
+// with a ScheduleTime are delivered at once, and redelivered with the
+// backoff of the subscription until the push endpoint accepts them; see
+// ParsePush. Task priorities are ignored.
+type PubSub struct {
+	topics *pubsub.ProjectsTopicsService
+	topic  string // full name of the topic
+}
+
+// newPubSub returns a new Queue that publishes tasks to the topic of cfg.
+func newPubSub(ctx context.Context, cfg *config.Config) (_ *PubSub, err error) {
+	defer derrors.Wrap(&err, "newPubSub(%q)", cfg.PubSubTopic)
+	if cfg.PubSubTopic == "" {
+		return nil, errors.New("empty PubSubTopic")
+	}
+	if cfg.ProjectID == "" {
+		return nil, errors.New("empty ProjectID")
+	}
+	svc, err := pubsub.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &PubSub{
+		topics: svc.Projects.Topics,
+		topic:  fmt.Sprintf("projects/%s/topics/%s", cfg.ProjectID, cfg.PubSubTopic),
+	}, nil
+}
+
+// EnqueueScan publishes a scan task to the topic of q.
+func (q *PubSub) EnqueueScan(ctx context.Context, task Task, opts *Options) (_ bool, err error) {
+	defer derrors.WrapStack(&err, "queue.PubSub.EnqueueScan(%s, %s, %v)", task.Path(), task.Params(), opts)
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := checkScheduleTime(opts.ScheduleTime, time.Now()); err != nil {
+		return false, err
+	}
+	msg, err := newMessage(task, opts)
+	if err != nil {
+		return false, err
+	}
+	req := &pubsub.PublishRequest{Messages: []*pubsub.PubsubMessage{msg}}
+	if _, err := q.topics.Publish(q.topic, req).Context(ctx).Do(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// newMessage returns the message that describes task.
+func newMessage(task Task, opts *Options) (*pubsub.PubsubMessage, error) {
+	uri, err := TaskURI(task, opts)
+	if err != nil {
+		return nil, err
+	}
+	id := newTaskName(opts, task)
+	msg := &pubsub.PubsubMessage{Attributes: map[string]string{
+		uriAttr:    uri,
+		taskIDAttr: id,
+	}}
+	if !opts.ScheduleTime.IsZero() {
+		msg.Attributes[scheduleTimeAttr] = opts.ScheduleTime.UTC().Format(time.RFC3339)
+	}
+	return msg, nil
+}
+
+// A PushedTask is a task delivered by the push subscription of a PubSub
+// queue.
+type PushedTask struct {
+	// URI is the path and query of the worker request that runs the task.
+	URI string
+	// ID identifies the task, like the name of a Cloud Tasks task.
+	ID string
+	// MessageID is the ID of the Pub/Sub message.
+	MessageID string
+	// ScheduleTime is the earliest time the task should run, or zero.
+	ScheduleTime time.Time
+}
+
+// pushEnvelope is the body of the requests of a push subscription.
+type pushEnvelope struct {
+	Message      *pubsub.PubsubMessage `json:"message"`
+	Subscription string                `json:"subscription"`
+}
+
+// ParsePush reads the task that a push subscription of a PubSub queue
+// delivered in the body of r.
+//
+// The endpoint should respond with a status that is not a success if the
+// ScheduleTime of the task is in the future, so that the task is
+// delivered again later.
+func ParsePush(r *http.Request) (_ *PushedTask, err error) {
+	defer derrors.Wrap(&err, "ParsePush")
+	var env pushEnvelope
+	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if env.Message == nil {
+		return nil, fmt.Errorf("%w: no message", derrors.InvalidArgument)
+	}
+	attrs := env.Message.Attributes
+	t := &PushedTask{
+		URI:       attrs[uriAttr],
+		ID:        attrs[taskIDAttr],
+		MessageID: env.Message.MessageId,
+	}
+	// Only scan requests are enqueued.
+	if !strings.HasPrefix(t.URI, "/") || !strings.Contains(t.URI, "/scan/") {
+		return nil, fmt.Errorf("%w: message %s: bad task URI %q", derrors.InvalidArgument, t.MessageID, t.URI)
+	}
+	if st := attrs[scheduleTimeAttr]; st != "" {
+		t.ScheduleTime, err = time.Parse(time.RFC3339, st)
+		if err != nil {
+			return nil, fmt.Errorf("%w: message %s: %v", derrors.InvalidArgument, t.MessageID, err)
+		}
+	}
+	return t, nil
+}
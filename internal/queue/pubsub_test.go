@@ -0,0 +1,60 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+func TestPushRoundTrip(t *testing.T) {
+	task := &testTask{"name", "mod@v1.2.3", "importedby=1&mode=test"}
+	schedule := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	opts := &Options{Namespace: "test", TaskNameSuffix: "suf", DisableProxyFetch: true, ScheduleTime: schedule}
+	msg, err := newMessage(task, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.MessageId = "1"
+	body, err := json.Marshal(pushEnvelope{Message: msg, Subscription: "projects/p/subscriptions/s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParsePush(httptest.NewRequest("POST", PushPath, bytes.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PushedTask{
+		URI:          "/test/scan/mod@v1.2.3?importedby=1&mode=test&proxyfetch=off",
+		ID:           newTaskID("test", task) + "-suf",
+		MessageID:    "1",
+		ScheduleTime: schedule,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestParsePushErrors(t *testing.T) {
+	for _, msg := range []*pubsub.PubsubMessage{
+		nil,
+		{Attributes: map[string]string{uriAttr: "/govulncheck/enqueueall"}},
+		{Attributes: map[string]string{uriAttr: "/test/scan/m@v1", scheduleTimeAttr: "soon"}},
+	} {
+		body, err := json.Marshal(pushEnvelope{Message: msg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParsePush(httptest.NewRequest("POST", PushPath, bytes.NewReader(body))); err == nil {
+			t.Errorf("%+v: got nil, want error", msg)
+		}
+	}
+}
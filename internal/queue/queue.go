@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
@@ -24,6 +25,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // A Task can produce information needed for Cloud Tasks.
@@ -33,6 +35,70 @@ type Task interface {
 	Params() string // URL query params
 }
 
+// A PriorityTask is a Task with a priority hint: queues may run tasks of
+// higher priority first. Scan tasks use the imported-by count of their
+// module, so that the modules that the ecosystem depends on most are
+// scanned first, as when a new vuln DB entry lands.
+type PriorityTask interface {
+	Task
+	Priority() int
+}
+
+// priority returns the priority hint of task, or 0 if it has none.
+func priority(task Task) int {
+	if pt, ok := task.(PriorityTask); ok {
+		return pt.Priority()
+	}
+	return 0
+}
+
+// An IdempotentTask is a Task with an idempotency key, such as a hash of
+// its module version, scan mode and the work version of the worker that
+// enqueued it. Tasks with a non-empty key are named by the key alone,
+// without their params or the TaskNameSuffix, so the queue rejects
+// duplicates that are enqueued within its de-duplication window, even
+// by different runs.
+type IdempotentTask interface {
+	Task
+	IdempotencyKey() string
+}
+
+// idempotencyKey returns the idempotency key of task, or "" if it has none.
+func idempotencyKey(task Task) string {
+	if it, ok := task.(IdempotentTask); ok {
+		return it.IdempotencyKey()
+	}
+	return ""
+}
+
+// A ModeTask is a Task with a scan mode, such as "COMPARE", by which
+// queues may route it.
+type ModeTask interface {
+	Task
+	ScanMode() string
+}
+
+// ParseModeQueues parses spec, a comma-separated list of MODE=QUEUE
+// entries naming the queue of the tasks of each scan mode.
+func ParseModeQueues(spec string) (map[string]string, error) {
+	queues := map[string]string{}
+	if spec == "" {
+		return queues, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		mode, name, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || mode == "" || name == "" {
+			return nil, fmt.Errorf("bad mode queue %q: want MODE=QUEUE", entry)
+		}
+		mode = strings.ToUpper(mode)
+		if _, ok := queues[mode]; ok {
+			return nil, fmt.Errorf("mode %s has more than one queue", mode)
+		}
+		queues[mode] = name
+	}
+	return queues, nil
+}
+
 // A Queue provides an interface for asynchronous scheduling of fetch actions.
 type Queue interface {
 	// EnqueueScan enqueues a scan request.
@@ -41,11 +107,27 @@ type Queue interface {
 }
 
 // New creates a new Queue with name queueName based on the configuration
-// in cfg. When running locally, Queue uses numWorkers concurrent workers.
+// in cfg. When running locally, or without a QueueURL for Cloud Tasks, it
+// is an InMemory queue that runs tasks with processFunc, on
+// cfg.LocalQueueWorkers concurrent workers. Otherwise it is backed by the
+// service of cfg.QueueService: Cloud Tasks, by default, or Pub/Sub.
 func New(ctx context.Context, cfg *config.Config, processFunc inMemoryProcessFunc) (Queue, error) {
-	if !config.OnCloudRun() {
+	if !config.OnCloudRun() || cfg.QueueURL == "" && cfg.QueueService != "pubsub" {
+		log.Infof(ctx, "processing tasks in memory with %d workers", cfg.LocalQueueWorkers)
 		return NewInMemory(ctx, cfg.LocalQueueWorkers, processFunc), nil
 	}
+	switch cfg.QueueService {
+	case "", "cloudtasks":
+	case "pubsub":
+		p, err := newPubSub(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof(ctx, "enqueuing at %s", p.topic)
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown queue service %q; want \"cloudtasks\" or \"pubsub\"", cfg.QueueService)
+	}
 	client, err := cloudtasks.NewClient(ctx)
 	if err != nil {
 		return nil, err
@@ -55,6 +137,12 @@ func New(ctx context.Context, cfg *config.Config, processFunc inMemoryProcessFun
 		return nil, err
 	}
 	log.Infof(ctx, "enqueuing at %s with queueURL=%q", g.queueName, g.queueURL)
+	if g.priorityQueueName != "" {
+		log.Infof(ctx, "enqueuing tasks of priority %d or more at %s", g.minPriority, g.priorityQueueName)
+	}
+	for mode, name := range g.modeQueueNames {
+		log.Infof(ctx, "enqueuing %s tasks at %s", mode, name)
+	}
 	return g, nil
 }
 
@@ -63,6 +151,16 @@ type GCP struct {
 	client    *cloudtasks.Client
 	queueName string // full GCP name of the queue
 	queueURL  string // non-AppEngine URL to post tasks to
+	// priorityQueueName, if non-empty, is the full GCP name of the queue
+	// of the tasks of priority at least minPriority, which dispatches
+	// tasks at a higher rate.
+	priorityQueueName string
+	minPriority       int
+	// modeQueueNames are the full GCP names of the queues of the tasks
+	// of some scan modes, by mode. They take precedence over the
+	// priority queue, so that the tasks of a mode have the rate limits
+	// of its queue.
+	modeQueueNames map[string]string
 	// token holds information that lets the task queue construct an authorized request to the worker.
 	// Since the worker sits behind the IAP, the queue needs an identity token that includes the
 	// identity of a service account that has access, and the client ID for the IAP.
@@ -90,7 +188,7 @@ func newGCP(cfg *config.Config, client *cloudtasks.Client, queueID string) (_ *G
 	if cfg.ServiceAccount == "" {
 		return nil, errors.New("empty ServiceAccount")
 	}
-	return &GCP{
+	g := &GCP{
 		client:    client,
 		queueName: fmt.Sprintf("projects/%s/locations/%s/queues/%s", cfg.ProjectID, cfg.LocationID, queueID),
 		queueURL:  cfg.QueueURL,
@@ -99,7 +197,22 @@ func newGCP(cfg *config.Config, client *cloudtasks.Client, queueID string) (_ *G
 				ServiceAccountEmail: cfg.ServiceAccount,
 			},
 		},
-	}, nil
+	}
+	if cfg.PriorityQueueName != "" {
+		g.priorityQueueName = fmt.Sprintf("projects/%s/locations/%s/queues/%s", cfg.ProjectID, cfg.LocationID, cfg.PriorityQueueName)
+		g.minPriority = cfg.PriorityImportedBy
+	}
+	modeQueues, err := ParseModeQueues(cfg.ModeQueues)
+	if err != nil {
+		return nil, err
+	}
+	if len(modeQueues) > 0 {
+		g.modeQueueNames = map[string]string{}
+		for mode, id := range modeQueues {
+			g.modeQueueNames[mode] = fmt.Sprintf("projects/%s/locations/%s/queues/%s", cfg.ProjectID, cfg.LocationID, id)
+		}
+	}
+	return g, nil
 }
 
 // EnqueueScan enqueues a scan task on GCP.
@@ -144,8 +257,28 @@ type Options struct {
 	DisableProxyFetch bool
 
 	// TaskNameSuffix is appended to the task name to force reprocessing of
-	// tasks that would normally be de-duplicated.
+	// tasks that would normally be de-duplicated. It is not appended to the
+	// names of tasks with an idempotency key; see IdempotentTask.
 	TaskNameSuffix string
+
+	// ScheduleTime is the earliest time the task should be dispatched.
+	// If zero, the task is dispatched as soon as possible. It may be at
+	// most MaxScheduleDelay in the future.
+	ScheduleTime time.Time
+}
+
+// MaxScheduleDelay is how far in the future tasks can be scheduled.
+// See https://cloud.google.com/tasks/docs/reference/rest/v2/projects.locations.queues.tasks#Task.
+const MaxScheduleDelay = 30 * 24 * time.Hour
+
+// checkScheduleTime returns an error if t is more than MaxScheduleDelay
+// after now.
+func checkScheduleTime(t, now time.Time) error {
+	if t.Sub(now) > MaxScheduleDelay {
+		return fmt.Errorf("%w: schedule time %s is more than %s in the future",
+			derrors.InvalidArgument, t.Format(time.RFC3339), MaxScheduleDelay)
+	}
+	return nil
 }
 
 // maxCloudTasksTimeout is the maximum timeout for HTTP tasks.
@@ -154,9 +287,11 @@ const maxCloudTasksTimeout = 30 * time.Minute
 
 const disableProxyFetchParam = "proxyfetch=off"
 
-func (q *GCP) newTaskRequest(task Task, opts *Options) (*taskspb.CreateTaskRequest, error) {
+// TaskURI returns the path and query of the worker request that runs
+// task.
+func TaskURI(task Task, opts *Options) (string, error) {
 	if opts.Namespace == "" {
-		return nil, errors.New("Options.Namespace cannot be empty")
+		return "", errors.New("Options.Namespace cannot be empty")
 	}
 	relativeURI := fmt.Sprintf("/%s/scan/%s", opts.Namespace, task.Path())
 	params := task.Params()
@@ -170,10 +305,22 @@ func (q *GCP) newTaskRequest(task Task, opts *Options) (*taskspb.CreateTaskReque
 	if params != "" {
 		relativeURI += "?" + params
 	}
+	return relativeURI, nil
+}
 
-	taskID := newTaskID(opts.Namespace, task)
+func (q *GCP) newTaskRequest(task Task, opts *Options) (*taskspb.CreateTaskRequest, error) {
+	relativeURI, err := TaskURI(task, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScheduleTime(opts.ScheduleTime, time.Now()); err != nil {
+		return nil, err
+	}
+
+	queueName := q.queueFor(task)
+	taskID := newTaskName(opts, task)
 	taskpb := &taskspb.Task{
-		Name:             fmt.Sprintf("%s/tasks/%s", q.queueName, taskID),
+		Name:             fmt.Sprintf("%s/tasks/%s", queueName, taskID),
 		DispatchDeadline: durationpb.New(maxCloudTasksTimeout),
 		MessageType: &taskspb.Task_HttpRequest{
 			HttpRequest: &taskspb.HttpRequest{
@@ -183,16 +330,44 @@ func (q *GCP) newTaskRequest(task Task, opts *Options) (*taskspb.CreateTaskReque
 			},
 		},
 	}
-	req := &taskspb.CreateTaskRequest{
-		Parent: q.queueName,
+	if !opts.ScheduleTime.IsZero() {
+		taskpb.ScheduleTime = timestamppb.New(opts.ScheduleTime)
+	}
+	return &taskspb.CreateTaskRequest{
+		Parent: queueName,
 		Task:   taskpb,
+	}, nil
+}
+
+// queueFor returns the full name of the queue of task: the queue of its
+// scan mode, if there is one, or else the priority queue if its priority
+// is high enough, or else the default queue.
+func (q *GCP) queueFor(task Task) string {
+	if mt, ok := task.(ModeTask); ok {
+		if name, ok := q.modeQueueNames[mt.ScanMode()]; ok {
+			return name
+		}
+	}
+	if q.priorityQueueName != "" && priority(task) >= q.minPriority {
+		return q.priorityQueueName
 	}
+	return q.queueName
+}
+
+// newTaskName returns the name of task within its queue. It is the ID of
+// the task, followed by the TaskNameSuffix of opts, if any, unless the
+// task has an idempotency key.
+func newTaskName(opts *Options, task Task) string {
+	if key := idempotencyKey(task); key != "" {
+		return taskIDWithHash(opts.Namespace, task, key)
+	}
+	id := newTaskID(opts.Namespace, task)
 	// If suffix is non-empty, append it to the task name.
 	// This lets us force reprocessing of tasks that would normally be de-duplicated.
 	if opts.TaskNameSuffix != "" {
-		req.Task.Name += "-" + opts.TaskNameSuffix
+		id += "-" + opts.TaskNameSuffix
 	}
-	return req, nil
+	return id
 }
 
 // newTaskID creates a task ID for the given task.
@@ -200,13 +375,19 @@ func (q *GCP) newTaskRequest(task Task, opts *Options) (*taskspb.CreateTaskReque
 // See https://cloud.google.com/tasks/docs/reference/rpc/google.cloud.tasks.v2#createtaskrequest
 // under "Task De-duplication".
 func newTaskID(namespace string, task Task) string {
-	name := task.Name()
 	// Hash the path and params of the task.
+	return taskIDWithHash(namespace, task, task.Path(), task.Params())
+}
+
+// taskIDWithHash returns the ID of task, with the first 8 hex digits of
+// the hash of parts.
+func taskIDWithHash(namespace string, task Task, parts ...string) string {
 	hasher := sha256.New()
-	io.WriteString(hasher, task.Path())
-	io.WriteString(hasher, task.Params())
+	for _, p := range parts {
+		io.WriteString(hasher, p)
+	}
 	hash := hex.EncodeToString(hasher.Sum(nil))
-	return escapeTaskID(fmt.Sprintf("%s-%s-%s", name, namespace, hash[:8]))
+	return escapeTaskID(fmt.Sprintf("%s-%s-%s", task.Name(), namespace, hash[:8]))
 }
 
 // escapeTaskID escapes s so it contains only valid characters for a Cloud Tasks name.
@@ -239,19 +420,40 @@ func escapeTaskID(s string) string {
 //
 // This should only be used for local development.
 type InMemory struct {
-	queue chan Task
+	queue chan *inMemoryTask
 	done  chan struct{}
+	// stop is closed when the context of the queue is done. Delayed
+	// tasks that are waiting for their schedule time are dropped then.
+	stop    <-chan struct{}
+	delayed sync.WaitGroup
+
+	mu sync.Mutex
+	// pending holds the tasks that have not been dispatched, by
+	// TaskNameSuffix, so that they can be canceled.
+	pending map[string]map[*inMemoryTask]bool
+}
+
+// An inMemoryTask is a task of an InMemory queue, with the options it was
+// enqueued with.
+type inMemoryTask struct {
+	task     Task
+	opts     *Options
+	canceled bool // guarded by InMemory.mu
 }
 
-type inMemoryProcessFunc func(context.Context, Task) (int, error)
+// An inMemoryProcessFunc runs a task of an InMemory queue, and returns the
+// HTTP status of the request that the task stands for.
+type inMemoryProcessFunc func(context.Context, Task, *Options) (int, error)
 
 // NewInMemory creates a new InMemory that asynchronously fetches
 // from proxyClient and stores in db. It uses workerCount parallelism to
 // execute these fetches.
 func NewInMemory(ctx context.Context, workerCount int, processFunc inMemoryProcessFunc) *InMemory {
 	q := &InMemory{
-		queue: make(chan Task, 1000),
-		done:  make(chan struct{}),
+		queue:   make(chan *inMemoryTask, 1000),
+		done:    make(chan struct{}),
+		stop:    ctx.Done(),
+		pending: map[string]map[*inMemoryTask]bool{},
 	}
 	sem := make(chan struct{}, workerCount)
 	go func() {
@@ -261,19 +463,25 @@ func NewInMemory(ctx context.Context, workerCount int, processFunc inMemoryProce
 				return
 			case sem <- struct{}{}:
 			}
+			if !q.dispatch(v) {
+				<-sem
+				log.Infof(ctx, "Fetch canceled: %v", v.task)
+				continue
+			}
 
 			// If a worker is available, make a request to the fetch service inside a
 			// goroutine and wait for it to finish.
-			go func(t Task) {
+			go func(t *inMemoryTask) {
 				defer func() { <-sem }()
 
-				log.Infof(ctx, "Fetch requested: %v (workerCount = %d)", t, cap(sem))
+				log.Infof(ctx, "Fetch requested: %v (workerCount = %d)", t.task, cap(sem))
 
-				fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+				// Allow as much time as Cloud Tasks does.
+				fetchCtx, cancel := context.WithTimeout(ctx, maxCloudTasksTimeout)
 				defer cancel()
 
-				if _, err := processFunc(fetchCtx, t); err != nil {
-					log.Errorf(fetchCtx, err, "processFunc(%v)", t)
+				if _, err := processFunc(fetchCtx, t.task, t.opts); err != nil {
+					log.Errorf(fetchCtx, err, "processFunc(%v)", t.task)
 				}
 			}(v)
 		}
@@ -290,15 +498,57 @@ func NewInMemory(ctx context.Context, workerCount int, processFunc inMemoryProce
 }
 
 // EnqueueScan pushes a scan task into the local queue to be processed
-// asynchronously.
-func (q *InMemory) EnqueueScan(ctx context.Context, task Task, _ *Options) (bool, error) {
-	q.queue <- task
+// asynchronously. A task with a ScheduleTime in the future is pushed at
+// that time.
+func (q *InMemory) EnqueueScan(ctx context.Context, task Task, opts *Options) (bool, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := checkScheduleTime(opts.ScheduleTime, time.Now()); err != nil {
+		return false, err
+	}
+	t := &inMemoryTask{task: task, opts: opts}
+	q.mu.Lock()
+	if q.pending[opts.TaskNameSuffix] == nil {
+		q.pending[opts.TaskNameSuffix] = map[*inMemoryTask]bool{}
+	}
+	q.pending[opts.TaskNameSuffix][t] = true
+	q.mu.Unlock()
+	delay := time.Until(opts.ScheduleTime)
+	if delay <= 0 {
+		q.queue <- t
+		return true, nil
+	}
+	q.delayed.Add(1)
+	go func() {
+		defer q.delayed.Done()
+		select {
+		case <-time.After(delay):
+			q.queue <- t
+		case <-q.stop:
+			log.Warnf(ctx, "InMemory queue stopped: dropping %v scheduled at %s", task, opts.ScheduleTime)
+		}
+	}()
 	return true, nil
 }
 
-// WaitForTesting waits for all queued requests to finish. It should only be
-// used by test code.
+// dispatch removes t from the pending tasks of q, and reports whether it
+// should run, that is, whether it was not canceled.
+func (q *InMemory) dispatch(t *inMemoryTask) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	suffix := t.opts.TaskNameSuffix
+	delete(q.pending[suffix], t)
+	if len(q.pending[suffix]) == 0 {
+		delete(q.pending, suffix)
+	}
+	return !t.canceled
+}
+
+// WaitForTesting waits for all queued requests, including those scheduled
+// for later, to finish. It should only be used by test code.
 func (q *InMemory) WaitForTesting(ctx context.Context) {
+	q.delayed.Wait()
 	close(q.queue)
 	<-q.done
 }
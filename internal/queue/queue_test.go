@@ -5,7 +5,12 @@
 package queue
 
 import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
 	"github.com/google/go-cmp/cmp"
@@ -103,3 +108,279 @@ func TestNewTaskRequest(t *testing.T) {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
 }
+
+type testPriorityTask struct {
+	testTask
+	priority int
+}
+
+func (t *testPriorityTask) Priority() int { return t.priority }
+
+func TestNewTaskRequestPriority(t *testing.T) {
+	cfg := config.Config{
+		ProjectID:          "Project",
+		LocationID:         "us-central1",
+		QueueURL:           "http://1.2.3.4:8000",
+		ServiceAccount:     "sa",
+		PriorityQueueName:  "priorityID",
+		PriorityImportedBy: 100,
+	}
+	gcp, err := newGCP(&cfg, nil, "queueID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		normalQueue   = "projects/Project/locations/us-central1/queues/queueID"
+		priorityQueue = "projects/Project/locations/us-central1/queues/priorityID"
+	)
+	for _, test := range []struct {
+		task Task
+		want string
+	}{
+		{&testTask{"name", "mod@v1.2.3", ""}, normalQueue},
+		{&testPriorityTask{testTask{"name", "mod@v1.2.3", ""}, 99}, normalQueue},
+		{&testPriorityTask{testTask{"name", "mod@v1.2.3", ""}, 100}, priorityQueue},
+	} {
+		got, err := gcp.newTaskRequest(test.task, &Options{Namespace: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Parent != test.want {
+			t.Errorf("priority %d: got parent %s, want %s", priority(test.task), got.Parent, test.want)
+		}
+		if !strings.HasPrefix(got.Task.Name, test.want+"/tasks/") {
+			t.Errorf("priority %d: got name %s, want it in %s", priority(test.task), got.Task.Name, test.want)
+		}
+	}
+}
+
+func TestInMemory(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		uris []string
+	)
+	q := NewInMemory(context.Background(), 2, func(ctx context.Context, task Task, opts *Options) (int, error) {
+		uri, err := TaskURI(task, opts)
+		if err != nil {
+			return 0, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		uris = append(uris, uri)
+		return 200, nil
+	})
+	for _, path := range []string{"a@v1", "b@v1"} {
+		if _, err := q.EnqueueScan(context.Background(), &testTask{path, path, "mode=m"}, &Options{Namespace: "ns"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.WaitForTesting(context.Background())
+	sort.Strings(uris)
+	want := []string{"/ns/scan/a@v1?mode=m", "/ns/scan/b@v1?mode=m"}
+	if diff := cmp.Diff(want, uris); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestInMemoryScheduleTime(t *testing.T) {
+	start := time.Now()
+	var ran time.Time
+	q := NewInMemory(context.Background(), 1, func(context.Context, Task, *Options) (int, error) {
+		ran = time.Now()
+		return 200, nil
+	})
+	const delay = 50 * time.Millisecond
+	opts := &Options{Namespace: "ns", ScheduleTime: start.Add(delay)}
+	if _, err := q.EnqueueScan(context.Background(), &testTask{"a", "a@v1", ""}, opts); err != nil {
+		t.Fatal(err)
+	}
+	q.WaitForTesting(context.Background())
+	if ran.Sub(start) < delay {
+		t.Errorf("task ran after %s, want at least %s", ran.Sub(start), delay)
+	}
+
+	opts.ScheduleTime = start.Add(MaxScheduleDelay + time.Hour)
+	if _, err := NewInMemory(context.Background(), 1, nil).EnqueueScan(context.Background(), &testTask{"a", "a@v1", ""}, opts); err == nil {
+		t.Error("got nil error for a schedule time too far in the future")
+	}
+}
+
+func TestInMemoryCancel(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		ran   []string
+		block = make(chan struct{})
+	)
+	started := make(chan struct{})
+	q := NewInMemory(context.Background(), 1, func(ctx context.Context, task Task, opts *Options) (int, error) {
+		if task.Name() == "a" {
+			close(started)
+			<-block
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, task.Name())
+		return 200, nil
+	})
+	ctx := context.Background()
+	enqueue := func(name, suffix string) {
+		t.Helper()
+		if _, err := q.EnqueueScan(ctx, &testTask{name, name + "@v1", ""}, &Options{Namespace: "ns", TaskNameSuffix: suffix}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enqueue("a", "bad")
+	<-started // a is running, so it isn't canceled
+	enqueue("b", "bad")
+	enqueue("c", "good")
+	enqueue("d", "bad")
+	for _, dryRun := range []bool{true, false} {
+		n, err := q.CancelTasks(ctx, "bad", dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("dry run %t: canceled %d tasks, want 2", dryRun, n)
+		}
+	}
+	close(block)
+	q.WaitForTesting(ctx)
+	sort.Strings(ran)
+	if want := []string{"a", "c"}; !cmp.Equal(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestHasTaskNameSuffix(t *testing.T) {
+	const name = "projects/p/locations/l/queues/q/tasks/m_v1-govulncheck-0123abcd"
+	for _, test := range []struct {
+		name, suffix string
+		want         bool
+	}{
+		{name + "-run1", "run1", true},
+		{name + "-a-run1", "a-run1", true},
+		{name + "-a-run1", "run1", false},
+		{name, "0123abcd", false},
+		{name + "-run1", "run", false},
+		{name + "-run1", "1", false},
+	} {
+		if got := hasTaskNameSuffix(test.name, test.suffix); got != test.want {
+			t.Errorf("hasTaskNameSuffix(%q, %q) = %t, want %t", test.name, test.suffix, got, test.want)
+		}
+	}
+}
+
+func TestTaskURLSuffix(t *testing.T) {
+	for _, test := range []struct {
+		url, want string
+	}{
+		{"http://1.2.3.4:8000/govulncheck/scan/m@v1.0.0?importedby=1&suffix=run1", "run1"},
+		{"http://1.2.3.4:8000/govulncheck/scan/m@v1.0.0?importedby=1", ""},
+		{"", ""},
+	} {
+		task := &taskspb.Task{MessageType: &taskspb.Task_HttpRequest{
+			HttpRequest: &taskspb.HttpRequest{Url: test.url},
+		}}
+		if got := taskURLSuffix(task); got != test.want {
+			t.Errorf("taskURLSuffix(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+type testIdempotentTask struct {
+	testTask
+	key string
+}
+
+func (t *testIdempotentTask) IdempotencyKey() string { return t.key }
+
+func TestNewTaskName(t *testing.T) {
+	task := &testTask{"m@v1.2", "path", "params&suffix=run1"}
+	if got, want := newTaskName(&Options{Namespace: "ns", TaskNameSuffix: "run1"}, task), newTaskID("ns", task)+"-run1"; got != want {
+		t.Errorf("without a key: got %s, want %s", got, want)
+	}
+
+	// Tasks with the same key have the same name, whatever their params
+	// and suffix.
+	run1 := &testIdempotentTask{testTask{"m@v1.2", "path", "params&suffix=run1"}, "key"}
+	run2 := &testIdempotentTask{testTask{"m@v1.2", "path", "params&suffix=run2"}, "key"}
+	name1 := newTaskName(&Options{Namespace: "ns", TaskNameSuffix: "run1"}, run1)
+	name2 := newTaskName(&Options{Namespace: "ns", TaskNameSuffix: "run2"}, run2)
+	if name1 != name2 {
+		t.Errorf("same key: got different names %s and %s", name1, name2)
+	}
+	if want := "m_v1_2-ns-"; !strings.HasPrefix(name1, want) || strings.HasSuffix(name1, "run1") {
+		t.Errorf("got %s, want a name that starts with %s and has no suffix", name1, want)
+	}
+	run2.key = "other"
+	if name := newTaskName(&Options{Namespace: "ns", TaskNameSuffix: "run2"}, run2); name == name1 {
+		t.Errorf("different keys: got the same name %s", name)
+	}
+	// An empty key means no key.
+	run2.key = ""
+	if got, want := newTaskName(&Options{Namespace: "ns", TaskNameSuffix: "run2"}, run2), newTaskID("ns", run2)+"-run2"; got != want {
+		t.Errorf("empty key: got %s, want %s", got, want)
+	}
+}
+
+type testModeTask struct {
+	testPriorityTask
+	mode string
+}
+
+func (t *testModeTask) ScanMode() string { return t.mode }
+
+func TestQueueFor(t *testing.T) {
+	cfg := config.Config{
+		ProjectID:          "Project",
+		LocationID:         "us-central1",
+		QueueURL:           "http://1.2.3.4:8000",
+		ServiceAccount:     "sa",
+		PriorityQueueName:  "priorityID",
+		PriorityImportedBy: 100,
+		ModeQueues:         "COMPARE=compareID, analysis=analysisID",
+	}
+	gcp, err := newGCP(&cfg, nil, "queueID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const prefix = "projects/Project/locations/us-central1/queues/"
+	task := func(mode string, priority int) Task {
+		return &testModeTask{testPriorityTask{testTask{"name", "mod@v1.2.3", ""}, priority}, mode}
+	}
+	for _, test := range []struct {
+		task Task
+		want string
+	}{
+		{&testTask{"name", "mod@v1.2.3", ""}, "queueID"},
+		{task("GOVULNCHECK", 0), "queueID"},
+		{task("GOVULNCHECK", 100), "priorityID"},
+		{task("COMPARE", 0), "compareID"},
+		{task("COMPARE", 100), "compareID"},
+		{task("ANALYSIS", 0), "analysisID"},
+	} {
+		if got := gcp.queueFor(test.task); got != prefix+test.want {
+			t.Errorf("%+v: got %s, want %s", test.task, got, prefix+test.want)
+		}
+	}
+	wantNames := []string{prefix + "queueID", prefix + "priorityID", prefix + "analysisID", prefix + "compareID"}
+	if diff := cmp.Diff(wantNames, gcp.queueNames()); diff != "" {
+		t.Errorf("queueNames mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestParseModeQueues(t *testing.T) {
+	got, err := ParseModeQueues("compare=c, EXTRACT=e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"COMPARE": "c", "EXTRACT": "e"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	for _, spec := range []string{"COMPARE", "COMPARE=", "=q", "COMPARE=a,compare=b"} {
+		if _, err := ParseModeQueues(spec); err == nil {
+			t.Errorf("%q: got nil error", spec)
+		}
+	}
+}
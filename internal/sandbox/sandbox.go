@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 )
@@ -72,6 +73,10 @@ type Cmd struct {
 	Dir string
 }
 
+// pipeWaitDelay is how long Cmd.Output waits for the pipes to runsc to
+// close after runsc exits.
+const pipeWaitDelay = 10 * time.Second
+
 // Command creates a *Cmd to run path in the sandbox.
 // It behaves like [os/exec.Command].
 func (s *Sandbox) Command(path string, arg ...string) *Cmd {
@@ -92,6 +97,9 @@ func (c *Cmd) Output() (_ []byte, err error) {
 	// cannot set up cgroup for root: configuring cgroup: write /sys/fs/cgroup/cgroup.subtree_control: device or resource busy
 	cmd := exec.Command(c.sb.Runsc, "-ignore-cgroups", "-network=none", "-platform=systrap", "-dcache=500", "run", "sandbox")
 	cmd.Dir = c.sb.bundleDir
+	// Don't wait forever for the output pipes to close if a process in
+	// the sandbox outlives runsc.
+	cmd.WaitDelay = pipeWaitDelay
 	stdinPipe, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, err
@@ -100,6 +108,9 @@ func (c *Cmd) Output() (_ []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
+	// The channel is buffered so the goroutine can exit even if we
+	// return before receiving from it. The write fails once runsc exits,
+	// if runsc hasn't read all of stdin.
 	ch := make(chan error, 1)
 	go func() {
 		_, err := stdinPipe.Write(stdin)
@@ -0,0 +1,56 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// Kinds of RequestError.
+var (
+	// ErrBadModulePath means the URL path does not describe a module and version.
+	ErrBadModulePath = errors.New("bad module path")
+	// ErrMissingParam means a required query param is missing.
+	ErrMissingParam = errors.New("missing param")
+	// ErrBadParam means a query param could not be parsed or is out of range.
+	ErrBadParam = errors.New("bad param")
+	// ErrUnknownMode means the requested scan mode is not supported.
+	ErrUnknownMode = errors.New("unknown mode")
+)
+
+// A RequestError describes a malformed request. It matches both its Kind
+// and derrors.InvalidArgument with errors.Is.
+type RequestError struct {
+	Kind    error  // one of the Err variables above
+	Param   string // name of the offending parameter, if any
+	Detail  string // human-readable description of the problem
+	Example string // an example of a correct request, if known
+}
+
+func (e *RequestError) Error() string {
+	return e.Detail
+}
+
+func (e *RequestError) Unwrap() []error {
+	return []error{e.Kind, derrors.InvalidArgument}
+}
+
+// NewRequestError returns a RequestError with the given kind and param,
+// whose detail is formatted from format and args.
+func NewRequestError(kind error, param, format string, args ...any) *RequestError {
+	return &RequestError{Kind: kind, Param: param, Detail: fmt.Sprintf(format, args...)}
+}
+
+// SetExample sets the Example of the RequestError in err's chain,
+// if there is one and it does not already have an example.
+func SetExample(err error, example string) {
+	var rerr *RequestError
+	if errors.As(err, &rerr) && rerr.Example == "" {
+		rerr.Example = example
+	}
+}
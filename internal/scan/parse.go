@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -111,22 +112,22 @@ func ParseModuleURLPath(requestPath string) (_ ModuleURLPath, err error) {
 	p := strings.TrimPrefix(requestPath, "/")
 	modulePath, versionAndSuffix, found := strings.Cut(p, "@")
 	if !found {
-		return ModuleURLPath{}, fmt.Errorf("invalid path %q: missing '@'", requestPath)
+		return ModuleURLPath{}, NewRequestError(ErrBadModulePath, "", "invalid path %q: missing '@'", requestPath)
 	}
 	modulePath = strings.TrimSuffix(modulePath, "/")
 	if modulePath == "" {
-		return ModuleURLPath{}, fmt.Errorf("invalid path %q: missing module", requestPath)
+		return ModuleURLPath{}, NewRequestError(ErrBadModulePath, "", "invalid path %q: missing module", requestPath)
 	}
 	versionAndSuffix = strings.TrimPrefix(versionAndSuffix, "v/")
 	// Now versionAndSuffix begins with a version.
-	version, suffix, _ := strings.Cut(versionAndSuffix, "/")
-	if version == "" {
-		return ModuleURLPath{}, fmt.Errorf("invalid path %q: missing version", requestPath)
+	vers, suffix, _ := strings.Cut(versionAndSuffix, "/")
+	if vers == "" {
+		return ModuleURLPath{}, NewRequestError(ErrBadModulePath, "", "invalid path %q: missing version", requestPath)
 	}
-	if version[0] != 'v' {
-		version = "v" + version
+	if vers[0] != 'v' && vers != version.Latest {
+		vers = "v" + vers
 	}
-	return ModuleURLPath{modulePath, version, suffix}, nil
+	return ModuleURLPath{modulePath, vers, suffix}, nil
 }
 
 // Path reconstructs a URL path from m.
@@ -171,7 +172,7 @@ func ParseParams(r *http.Request, pstruct any) (err error) {
 		}
 		pval, err := parseParam(paramValue, f.Type.Kind())
 		if err != nil {
-			return fmt.Errorf("param %s: %v", paramName, err)
+			return NewRequestError(ErrBadParam, paramName, "param %s: %v", paramName, err)
 		}
 		v.Field(i).Set(reflect.ValueOf(pval))
 	}
@@ -212,3 +213,63 @@ func FormatParams(s any) string {
 	}
 	return strings.Join(params, "&")
 }
+
+// ParamsVersionName is the query param that holds the version of the
+// parameters of a task, as passed to FormatParamsVersion.
+const ParamsVersionName = "params_version"
+
+// FormatParamsVersion is like FormatParams, but adds the params_version
+// query param with the given version after the fields of s.
+//
+// Tasks can stay in a queue across deployments, so a worker may receive
+// parameters formatted by an older or newer enqueuer. The version lets
+// the worker apply the defaults of the version that formatted them.
+func FormatParamsVersion(s any, version int) string {
+	return fmt.Sprintf("%s&%s=%d", FormatParams(s), ParamsVersionName, version)
+}
+
+// ParseParamsVersion returns the value of the params_version query param
+// of r, or 0 if there is none.
+func ParseParamsVersion(r *http.Request) (int, error) {
+	s := r.FormValue(ParamsVersionName)
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, NewRequestError(ErrBadParam, ParamsVersionName, "param %s: invalid version %q", ParamsVersionName, s)
+	}
+	return v, nil
+}
+
+// UnknownParams returns the sorted names of the query and form parameters
+// of r that ParseParams would not assign to a field of pstruct, which
+// must be a struct or struct pointer. The params_version parameter is
+// not unknown.
+//
+// ParseParams ignores unknown parameters, so that a worker can handle
+// tasks that were formatted by a newer enqueuer.
+func UnknownParams(r *http.Request, pstruct any) []string {
+	t := reflect.TypeOf(pstruct)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("need struct or struct pointer, got %T", pstruct))
+	}
+	known := map[string]bool{ParamsVersionName: true}
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.ToLower(t.Field(i).Name)] = true
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+	var unknown []string
+	for name := range r.Form {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -5,12 +5,14 @@
 package scan
 
 import (
+	"errors"
 	"net/http"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
@@ -39,6 +41,10 @@ func TestParseModuleURLPath(t *testing.T) {
 				Suffix:  "a/b/c",
 			},
 		},
+		{
+			"/module/@latest",
+			ModuleURLPath{Module: "module", Version: "latest"},
+		},
 	} {
 		got, err := ParseModuleURLPath(test.path)
 		if err != nil {
@@ -87,6 +93,9 @@ func TestModuleURLPathError(t *testing.T) {
 				if got := err.Error(); !strings.HasSuffix(got, test.want) {
 					t.Fatalf("\ngot  %s\nwant suffix %s", got, test.want)
 				}
+				if !errors.Is(err, ErrBadModulePath) || !errors.Is(err, derrors.InvalidArgument) {
+					t.Errorf("got %v, want ErrBadModulePath and InvalidArgument", err)
+				}
 			} else {
 				t.Fatalf("error = nil; want = (%v)", test.want)
 			}
@@ -192,3 +201,42 @@ func TestFormatParams(t *testing.T) {
 		t.Errorf("got %q, want %q", got, want)
 	}
 }
+
+func TestParamsVersion(t *testing.T) {
+	s := FormatParamsVersion(params{Str: "x", Int: 1}, 2)
+	if want := "str=x&int=1&bool=false&params_version=2"; s != want {
+		t.Fatalf("got %q, want %q", s, want)
+	}
+	for _, test := range []struct {
+		params      string
+		wantVersion int
+		wantUnknown []string
+	}{
+		{"str=x&int=1", 0, nil},
+		{s, 2, nil},
+		{s + "&new=1&also=x", 2, []string{"also", "new"}},
+	} {
+		r, err := http.NewRequest("GET", "https://path?"+test.params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseParamsVersion(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.wantVersion {
+			t.Errorf("%q: got version %d, want %d", test.params, got, test.wantVersion)
+		}
+		if got := UnknownParams(r, &params{}); !reflect.DeepEqual(got, test.wantUnknown) {
+			t.Errorf("%q: got unknown %v, want %v", test.params, got, test.wantUnknown)
+		}
+	}
+
+	r, err := http.NewRequest("GET", "https://path?params_version=x", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseParamsVersion(r); err == nil {
+		t.Error("got nil error for bad version")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Policy lists module path prefixes that must never be downloaded or
+// scanned. A nil *Policy denies nothing.
+type Policy struct {
+	denied []string // sorted
+}
+
+// ParsePolicy parses a policy specification: one denied module path prefix
+// per line. Blank lines and lines beginning with "#" are ignored.
+// It returns nil if the specification denies nothing.
+func ParsePolicy(spec string) (*Policy, error) {
+	seen := map[string]bool{}
+	for _, line := range strings.Split(spec, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ContainsAny(line, " \t@") {
+			return nil, fmt.Errorf("invalid policy entry %q", line)
+		}
+		seen[strings.TrimSuffix(line, "/")] = true
+	}
+	if len(seen) == 0 {
+		return nil, nil
+	}
+	p := &Policy{}
+	for prefix := range seen {
+		p.denied = append(p.denied, prefix)
+	}
+	sort.Strings(p.denied)
+	return p, nil
+}
+
+// Denies reports whether modulePath is denied by p. A prefix denies the
+// module path equal to it and every module path below it, but not other
+// paths that merely share its characters: "example.com/a" denies
+// "example.com/a/b" but not "example.com/ab".
+func (p *Policy) Denies(modulePath string) bool {
+	if p == nil {
+		return false
+	}
+	for _, prefix := range p.denied {
+		if modulePath == prefix || strings.HasPrefix(modulePath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash returns a hash of p that identifies its contents.
+// The hash of a nil *Policy is the empty string.
+func (p *Policy) Hash() string {
+	if p == nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, prefix := range p.denied {
+		fmt.Fprintln(h, prefix)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scan
+
+import "testing"
+
+func TestPolicy(t *testing.T) {
+	p, err := ParsePolicy(`
+# comment
+example.com/a
+example.com/b/
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path string
+		want bool
+	}{
+		{"example.com/a", true},
+		{"example.com/a/v2", true},
+		{"example.com/ab", false},
+		{"example.com/b", true},
+		{"example.com", false},
+		{"", false},
+	} {
+		if got := p.Denies(test.path); got != test.want {
+			t.Errorf("Denies(%q) = %t, want %t", test.path, got, test.want)
+		}
+	}
+
+	p2, err := ParsePolicy("example.com/b\nexample.com/a\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Hash() != p2.Hash() {
+		t.Error("equivalent policies have different hashes")
+	}
+
+	var nilPolicy *Policy
+	if nilPolicy.Denies("example.com/a") || nilPolicy.Hash() != "" {
+		t.Error("nil policy should deny nothing and have an empty hash")
+	}
+	if p, err := ParsePolicy("# nothing\n"); p != nil || err != nil {
+		t.Errorf("got (%v, %v), want (nil, nil)", p, err)
+	}
+	if _, err := ParsePolicy("example.com/a@v1.0.0"); err == nil {
+		t.Error("got nil error for entry with version")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+// JSONL is a ResultSink that appends rows as JSON lines to a file per
+// table in a local directory. It needs no cloud project, so the worker
+// can run end to end in development mode, but every ReadWorkState reads
+// the whole govulncheck file.
+type JSONL struct {
+	dir string
+	now func() time.Time
+
+	mu sync.Mutex // serializes appends
+}
+
+// NewJSONL returns a ResultSink that stores the rows of each table in
+// dir/<table ID>.jsonl, with the upload times that now returns. If now is
+// nil, time.Now is used.
+func NewJSONL(dir string, now func() time.Time) *JSONL {
+	if now == nil {
+		now = time.Now
+	}
+	return &JSONL{dir: dir, now: now}
+}
+
+// path returns the path of the file of the table with tableID.
+func (s *JSONL) path(tableID string) string {
+	return filepath.Join(s.dir, tableID+".jsonl")
+}
+
+func (s *JSONL) CreateOrUpdateTables(ctx context.Context, tableIDs ...string) (err error) {
+	defer derrors.Wrap(&err, "JSONL.CreateOrUpdateTables(%q)", s.dir)
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	for _, id := range tableIDs {
+		if bigquery.TableSchema(id) == nil {
+			return fmt.Errorf("no schema registered for table %q", id)
+		}
+		f, err := os.OpenFile(s.path(id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *JSONL) Upload(ctx context.Context, tableID string, rows []bigquery.Row) (err error) {
+	defer derrors.Wrap(&err, "JSONL.Upload(%q, %d rows)", tableID, len(rows))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path(tableID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	enc := json.NewEncoder(f)
+	for _, r := range rows {
+		r.SetUploadTime(now)
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func (s *JSONL) ReadWorkState(ctx context.Context, modulePath, version string) (_ *govulncheck.WorkState, err error) {
+	defer derrors.Wrap(&err, "JSONL.ReadWorkState(%q, %q)", modulePath, version)
+	f, err := os.Open(s.path(govulncheck.TableName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// As in govulncheck.ReadWorkState, correction rows and the rows they
+	// invalidate are ignored.
+	type rowKey struct {
+		suffix, scanMode string
+		createdAt        int64
+	}
+	var (
+		rows        []*govulncheck.Result
+		invalidated = map[rowKey]bool{}
+	)
+	dec := json.NewDecoder(f)
+	for {
+		var r govulncheck.Result
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.ModulePath != modulePath || r.Version != version {
+			continue
+		}
+		if r.ScanMode == govulncheck.ModeInvalidation {
+			if i := r.Invalidates; i != nil {
+				invalidated[rowKey{i.Suffix, i.ScanMode, i.CreatedAt.UnixNano()}] = true
+			}
+			continue
+		}
+		rows = append(rows, &r)
+	}
+	var latest *govulncheck.Result
+	for _, r := range rows {
+		if invalidated[rowKey{r.Suffix, r.ScanMode, r.CreatedAt.UnixNano()}] {
+			continue
+		}
+		// Rows are appended in upload order, so the last of equal
+		// times is the most recent.
+		if latest == nil || !r.CreatedAt.Before(latest.CreatedAt) {
+			latest = r
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	wv := latest.WorkVersion
+	return &govulncheck.WorkState{WorkVersion: &wv, ErrorCategory: latest.ErrorCategory}, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestJSONL(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "results")
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	s := NewJSONL(dir, func() time.Time { return now })
+
+	ws, err := s.ReadWorkState(ctx, "example.com/m", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws != nil {
+		t.Fatalf("got %+v before the table was created, want nil", ws)
+	}
+	if err := s.CreateOrUpdateTables(ctx, govulncheck.TableName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, govulncheck.TableName+".jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateOrUpdateTables(ctx, "no-such-table"); err == nil {
+		t.Error("got nil for a table without a schema, want error")
+	}
+
+	upload := func(rows ...bigquery.Row) {
+		t.Helper()
+		if err := s.Upload(ctx, govulncheck.TableName, rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload(
+		&govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", Suffix: "a", ScanMode: govulncheck.ModeGovulncheck,
+			WorkVersion: govulncheck.WorkVersion{WorkerVersion: "1"}},
+		&govulncheck.Result{ModulePath: "example.com/other", Version: "v1.0.0", Suffix: "a", ScanMode: govulncheck.ModeGovulncheck,
+			WorkVersion: govulncheck.WorkVersion{WorkerVersion: "9"}})
+	now = now.Add(time.Hour)
+	upload(&govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", Suffix: "b", ScanMode: govulncheck.ModeGovulncheck,
+		ErrorCategory: "LOAD", WorkVersion: govulncheck.WorkVersion{WorkerVersion: "2"}})
+
+	ws, err = s.ReadWorkState(ctx, "example.com/m", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws == nil || ws.WorkVersion.WorkerVersion != "2" || ws.ErrorCategory != "LOAD" {
+		t.Fatalf("got %+v, want the work state of the latest row", ws)
+	}
+
+	// Invalidating the latest row makes the one before it current.
+	now = now.Add(time.Hour)
+	upload(&govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", ScanMode: govulncheck.ModeInvalidation,
+		Invalidated: bigquery.NullBool(true),
+		Invalidates: &govulncheck.Invalidation{Suffix: "b", ScanMode: govulncheck.ModeGovulncheck, CreatedAt: now.Add(-time.Hour)}})
+	ws, err = s.ReadWorkState(ctx, "example.com/m", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws == nil || ws.WorkVersion.WorkerVersion != "1" {
+		t.Errorf("got %+v, want the work state of the row before the invalidated one", ws)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !plan9
+
+package sink
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+
+	"golang.org/x/pkgsite-metrics/internal"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// OpenPostgres connects to the results database of cfg.
+func OpenPostgres(ctx context.Context, cfg *config.Config) (_ *sql.DB, err error) {
+	defer derrors.Wrap(&err, "OpenPostgres(%q)", cfg.ResultsDBSecret)
+	connString, err := internal.GetSecret(ctx, cfg.ResultsDBSecret)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", connString)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,21 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build plan9
+
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"golang.org/x/pkgsite-metrics/internal/config"
+)
+
+var errDoesNotCompile = errors.New("github.com/lib/pq does not compile on plan9")
+
+func OpenPostgres(ctx context.Context, cfg *config.Config) (_ *sql.DB, err error) {
+	return nil, errDoesNotCompile
+}
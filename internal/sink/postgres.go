@@ -0,0 +1,174 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+// Postgres is a ResultSink that stores rows in a PostgreSQL database.
+//
+// Each table holds its rows as JSON documents in a row column, next to
+// the created_at, module_path, version and scan_mode columns that rows
+// are looked up by. Since the documents have no fixed schema, tables
+// don't change when a schema does: rows written before a field was added
+// lack it, like the nulls of BigQuery rows.
+type Postgres struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewPostgres returns a ResultSink that stores rows in db, with the upload
+// times that now returns. If now is nil, time.Now is used.
+func NewPostgres(db *sql.DB, now func() time.Time) *Postgres {
+	if now == nil {
+		now = time.Now
+	}
+	return &Postgres{db: db, now: now}
+}
+
+// Close closes the database.
+func (s *Postgres) Close() error {
+	return s.db.Close()
+}
+
+func (s *Postgres) CreateOrUpdateTables(ctx context.Context, tableIDs ...string) (err error) {
+	defer derrors.Wrap(&err, "Postgres.CreateOrUpdateTables")
+	for _, id := range tableIDs {
+		if bigquery.TableSchema(id) == nil {
+			return fmt.Errorf("no schema registered for table %q", id)
+		}
+		for _, stmt := range createTableStatements(id) {
+			if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("table %s: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// createTableStatements returns the statements that create the table with
+// tableID and its index, unless they exist.
+func createTableStatements(tableID string) []string {
+	t := quoteIdentifier(tableID)
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			created_at  timestamptz NOT NULL,
+			module_path text NOT NULL DEFAULT '',
+			version     text NOT NULL DEFAULT '',
+			scan_mode   text NOT NULL DEFAULT '',
+			row         jsonb NOT NULL
+		)`, t),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (module_path, version, created_at)`,
+			quoteIdentifier(tableID+"_module_version_idx"), t),
+	}
+}
+
+func (s *Postgres) Upload(ctx context.Context, tableID string, rows []bigquery.Row) (err error) {
+	defer derrors.Wrap(&err, "Postgres.Upload(%q, %d rows)", tableID, len(rows))
+	// Postgres stores microseconds; truncate so that the created_at
+	// column and the one in the document agree.
+	now := s.now().Truncate(time.Microsecond)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, tx.Rollback())
+		}
+	}()
+	stmt := fmt.Sprintf(`INSERT INTO %s (created_at, module_path, version, scan_mode, row) VALUES ($1, $2, $3, $4, $5)`,
+		quoteIdentifier(tableID))
+	for _, r := range rows {
+		r.SetUploadTime(now)
+		doc, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		k, err := keyColumns(doc)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, stmt, now, k.modulePath, k.version, k.scanMode, doc); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// keys are the values of the key columns of a row.
+type keys struct {
+	modulePath, version, scanMode string
+}
+
+// keyColumns returns the values of the key columns of the row encoded
+// in doc. Rows without a key field, or whose field is not a string, have
+// the empty string in its column.
+func keyColumns(doc []byte) (keys, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &m); err != nil {
+		return keys{}, err
+	}
+	str := func(name string) string {
+		var s string
+		if err := json.Unmarshal(m[name], &s); err != nil {
+			return ""
+		}
+		return s
+	}
+	return keys{str("ModulePath"), str("Version"), str("ScanMode")}, nil
+}
+
+func (s *Postgres) ReadWorkState(ctx context.Context, modulePath, version string) (_ *govulncheck.WorkState, err error) {
+	defer derrors.Wrap(&err, "Postgres.ReadWorkState(%q, %q)", modulePath, version)
+	// As in govulncheck.ReadWorkState, correction rows and the rows they
+	// invalidate are ignored.
+	const qf = `
+		SELECT r.row FROM %[1]s AS r
+		WHERE r.module_path = $1 AND r.version = $2 AND r.scan_mode <> $3 AND NOT EXISTS (
+			SELECT 1 FROM %[1]s AS i
+			WHERE i.scan_mode = $3 AND i.module_path = r.module_path AND i.version = r.version
+				AND i.row->'Invalidates'->>'Suffix' = r.row->>'Suffix'
+				AND i.row->'Invalidates'->>'ScanMode' = r.scan_mode
+				AND (i.row->'Invalidates'->>'CreatedAt')::timestamptz = r.created_at
+		)
+		ORDER BY r.created_at DESC LIMIT 1`
+	query := fmt.Sprintf(qf, quoteIdentifier(govulncheck.TableName))
+	var doc []byte
+	err = s.db.QueryRowContext(ctx, query, modulePath, version, govulncheck.ModeInvalidation).Scan(&doc)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return workStateOf(doc)
+}
+
+// workStateOf returns the work state of the govulncheck row encoded in doc.
+func workStateOf(doc []byte) (*govulncheck.WorkState, error) {
+	var r govulncheck.Result
+	if err := json.Unmarshal(doc, &r); err != nil {
+		return nil, err
+	}
+	wv := r.WorkVersion
+	return &govulncheck.WorkState{WorkVersion: &wv, ErrorCategory: r.ErrorCategory}, nil
+}
+
+// quoteIdentifier quotes name for use as an identifier in SQL.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
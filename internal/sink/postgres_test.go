@@ -0,0 +1,135 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !plan9
+
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+// dbConn is the -db flag used to test against a scratch database.
+var dbConn = flag.String("db", "",
+	"connection string of a scratch database for testing, like 'postgres://user:pw@127.0.0.1/name?sslmode=disable'")
+
+func TestKeyColumns(t *testing.T) {
+	row := &govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", ScanMode: govulncheck.ModeImports}
+	doc, err := json.Marshal(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := keyColumns(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (keys{"example.com/m", "v1.0.0", govulncheck.ModeImports}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Rows of other tables may lack the key fields.
+	got, err = keyColumns([]byte(`{"Version": 3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (keys{}) {
+		t.Errorf("got %+v, want no keys", got)
+	}
+}
+
+func TestWorkStateOf(t *testing.T) {
+	wv := govulncheck.WorkVersion{
+		GoVersion:          "go1.21.3",
+		WorkerVersion:      "1",
+		SchemaVersion:      "s",
+		VulnDBLastModified: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC),
+		GovulncheckVersion: bigquery.NullString("v1.0.1"),
+	}
+	doc, err := json.Marshal(&govulncheck.Result{ModulePath: "example.com/m", ErrorCategory: "LOAD", WorkVersion: wv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := workStateOf(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ws.WorkVersion.Equal(&wv) || ws.ErrorCategory != "LOAD" {
+		t.Errorf("got %+v, %q; want %+v, LOAD", ws.WorkVersion, ws.ErrorCategory, wv)
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	if *dbConn == "" {
+		t.Skip("missing -db")
+	}
+	ctx := context.Background()
+	db, err := sql.Open("postgres", *dbConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+quoteIdentifier(govulncheck.TableName)); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	s := NewPostgres(db, func() time.Time { return now })
+	if err := s.CreateOrUpdateTables(ctx, govulncheck.TableName); err != nil {
+		t.Fatal(err)
+	}
+	// Creating existing tables is a no-op.
+	if err := s.CreateOrUpdateTables(ctx, govulncheck.TableName); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := s.ReadWorkState(ctx, "example.com/m", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws != nil {
+		t.Fatalf("got %+v for a module without rows, want nil", ws)
+	}
+
+	upload := func(r *govulncheck.Result) {
+		t.Helper()
+		if err := s.Upload(ctx, govulncheck.TableName, []bigquery.Row{r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := &govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", Suffix: "a", ScanMode: govulncheck.ModeGovulncheck,
+		WorkVersion: govulncheck.WorkVersion{WorkerVersion: "1"}}
+	upload(old)
+	now = now.Add(time.Hour)
+	upload(&govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", Suffix: "b", ScanMode: govulncheck.ModeGovulncheck,
+		ErrorCategory: "LOAD", WorkVersion: govulncheck.WorkVersion{WorkerVersion: "2"}})
+	ws, err = s.ReadWorkState(ctx, "example.com/m", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws == nil || ws.WorkVersion.WorkerVersion != "2" || ws.ErrorCategory != "LOAD" {
+		t.Fatalf("got %+v, want the work state of the latest row", ws)
+	}
+
+	// Invalidating the latest row makes the one before it current.
+	now = now.Add(time.Hour)
+	upload(&govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", ScanMode: govulncheck.ModeInvalidation,
+		Invalidated: bigquery.NullBool(true),
+		Invalidates: &govulncheck.Invalidation{Suffix: "b", ScanMode: govulncheck.ModeGovulncheck, CreatedAt: now.Add(-time.Hour)}})
+	ws, err = s.ReadWorkState(ctx, "example.com/m", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws == nil || ws.WorkVersion.WorkerVersion != "1" {
+		t.Errorf("got %+v, want the work state of the row before the invalidated one", ws)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sink provides the stores that the worker writes scan results to.
+package sink
+
+import (
+	"context"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// A ResultSink stores the rows of scans, and reads back the work states
+// that decide whether a module version must be scanned again.
+//
+// Tables are identified by the IDs their schemas are registered with;
+// see bigquery.AddTable.
+type ResultSink interface {
+	// Upload inserts rows into the table, setting their upload time.
+	Upload(ctx context.Context, tableID string, rows []bigquery.Row) error
+	// ReadWorkState returns the work state of the most recent row of
+	// modulePath@version in the govulncheck table that is not
+	// invalidated, or nil if there is none; see govulncheck.ReadWorkState.
+	ReadWorkState(ctx context.Context, modulePath, version string) (*govulncheck.WorkState, error)
+	// CreateOrUpdateTables creates the tables that don't exist, and
+	// updates the others to their registered schemas.
+	CreateOrUpdateTables(ctx context.Context, tableIDs ...string) error
+}
+
+// BigQuery is a ResultSink that stores rows in the dataset of a BigQuery
+// client.
+type BigQuery struct {
+	Client *bigquery.Client
+	// Upsert makes Upload replace the rows of earlier scans with the same
+	// work version in the govulncheck table; see govulncheck.Upsert.
+	Upsert bool
+}
+
+// NewBigQuery returns a ResultSink that stores rows with c.
+func NewBigQuery(c *bigquery.Client) *BigQuery {
+	return &BigQuery{Client: c}
+}
+
+func (s *BigQuery) Upload(ctx context.Context, tableID string, rows []bigquery.Row) error {
+	if s.Upsert && tableID == govulncheck.TableName {
+		return s.upsert(ctx, rows)
+	}
+	return bigquery.UploadMany(ctx, s.Client, tableID, rows, 0)
+}
+
+// upsert writes the upsertable rows of the govulncheck table with
+// govulncheck.Upsert, and appends the others.
+func (s *BigQuery) upsert(ctx context.Context, rows []bigquery.Row) error {
+	var results []*govulncheck.Result
+	var others []bigquery.Row
+	for _, r := range rows {
+		if res, ok := r.(*govulncheck.Result); ok && res.Upsertable() {
+			results = append(results, res)
+		} else {
+			others = append(others, r)
+		}
+	}
+	if err := govulncheck.Upsert(ctx, s.Client, results); err != nil {
+		return err
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return bigquery.UploadMany(ctx, s.Client, govulncheck.TableName, others, 0)
+}
+
+func (s *BigQuery) ReadWorkState(ctx context.Context, modulePath, version string) (*govulncheck.WorkState, error) {
+	return govulncheck.ReadWorkState(ctx, s.Client, modulePath, version)
+}
+
+func (s *BigQuery) CreateOrUpdateTables(ctx context.Context, tableIDs ...string) (err error) {
+	defer derrors.Wrap(&err, "BigQuery.CreateOrUpdateTables")
+	for _, id := range tableIDs {
+		created, err := s.Client.CreateOrUpdateTable(ctx, id)
+		if err != nil {
+			return err
+		}
+		verb := "updated"
+		if created {
+			verb = "created"
+		}
+		log.Infof(ctx, "%s table %s", verb, id)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestBigQuery(t *testing.T) {
+	ctx := context.Background()
+	f := bigquery.NewFake()
+	c := f.Client()
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	c.SetClock(func() time.Time { return now })
+	s := NewBigQuery(c)
+
+	if err := s.CreateOrUpdateTables(ctx, govulncheck.TableName); err != nil {
+		t.Fatal(err)
+	}
+	row := &govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", ScanMode: govulncheck.ModeGovulncheck,
+		ErrorCategory: "LOAD", WorkVersion: govulncheck.WorkVersion{WorkerVersion: "1", VulnDBLastModified: now}}
+	if err := s.Upload(ctx, govulncheck.TableName, []bigquery.Row{row}); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Rows(govulncheck.TableName); len(got) != 1 || got[0] != row {
+		t.Fatalf("got rows %v, want the uploaded row", got)
+	}
+	if !row.CreatedAt.Equal(now) {
+		t.Errorf("got upload time %s, want %s", row.CreatedAt, now)
+	}
+
+	// Answer the work state query with the latest row of the module
+	// version.
+	f.HandleQuery("FROM `fake.govulncheck`", func(q string, params []bq.QueryParameter) (any, error) {
+		var latest []*govulncheck.Result
+		for _, r := range f.Rows(govulncheck.TableName) {
+			r := r.(*govulncheck.Result)
+			if r.ModulePath == params[0].Value && r.Version == params[1].Value {
+				latest = []*govulncheck.Result{r}
+			}
+		}
+		return latest, nil
+	})
+	ws, err := s.ReadWorkState(ctx, "example.com/m", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws == nil || !ws.WorkVersion.Equal(&row.WorkVersion) || ws.ErrorCategory != "LOAD" {
+		t.Errorf("got %+v, want the work state of the uploaded row", ws)
+	}
+	ws, err = s.ReadWorkState(ctx, "example.com/other", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws != nil {
+		t.Errorf("got %+v for a module without rows, want nil", ws)
+	}
+}
+
+func TestBigQueryUpsert(t *testing.T) {
+	ctx := context.Background()
+	f := bigquery.NewFake()
+	s := NewBigQuery(f.Client())
+	s.Upsert = true
+	if err := s.CreateOrUpdateTables(ctx, govulncheck.TableName); err != nil {
+		t.Fatal(err)
+	}
+	wv := govulncheck.WorkVersion{WorkerVersion: "1"}
+	scan := func(errorCategory string, wv govulncheck.WorkVersion) *govulncheck.Result {
+		return &govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", ScanMode: govulncheck.ModeGovulncheck,
+			ErrorCategory: errorCategory, WorkVersion: wv}
+	}
+	upload := func(rows ...bigquery.Row) {
+		t.Helper()
+		if err := s.Upload(ctx, govulncheck.TableName, rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload(scan("LOAD", wv))
+	// A retry with the same work version replaces the row, along with
+	// the pair rows of compare mode, which are appended.
+	pair := &govulncheck.Result{ModulePath: "example.com/m", Version: "v1.0.0", ScanMode: "COMPARE - BINARY",
+		RowType: bigquery.NullString(govulncheck.RowTypePair), WorkVersion: wv}
+	upload(scan("", wv), pair, pair)
+	// A scan with another work version adds a row.
+	upload(scan("", govulncheck.WorkVersion{WorkerVersion: "2"}))
+
+	var got []string
+	for _, r := range f.Rows(govulncheck.TableName) {
+		r := r.(*govulncheck.Result)
+		got = append(got, r.ScanMode+"/"+r.WorkerVersion+"/"+r.ErrorCategory)
+	}
+	want := []string{"GOVULNCHECK/1/", "COMPARE - BINARY/1/", "COMPARE - BINARY/1/", "GOVULNCHECK/2/"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got rows %v, want %v", got, want)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"strings"
 
+	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
 )
 
@@ -177,6 +178,27 @@ func appendNumericPrefix(dst []byte, n int) []byte {
 	return dst
 }
 
+// Canonical returns the canonical form of the module version v, the
+// one the go command and the module proxy use. It adds a missing "v",
+// completes shorthands like "v1.2" and removes build metadata. It keeps
+// "+incompatible" only for major versions 2 and above, where it is
+// meaningful, so that "v1.2.3+incompatible" and "v1.2.3" are the same
+// version. It returns an error if v is not a semantic version.
+func Canonical(v string) (string, error) {
+	c := v
+	if !strings.HasPrefix(c, "v") {
+		c = "v" + c
+	}
+	c = module.CanonicalVersion(c)
+	if c == "" {
+		return "", fmt.Errorf("version %q is not a semantic version", v)
+	}
+	if m := semver.Major(c); IsIncompatible(c) && (m == "v0" || m == "v1") {
+		c = strings.TrimSuffix(c, "+incompatible")
+	}
+	return c, nil
+}
+
 // Later reports whether v1 is later than v2, using semver but preferring
 // release versions to pre-release versions, and both to pseudo-versions.
 func Later(v1, v2 string) bool {
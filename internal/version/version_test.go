@@ -256,3 +256,33 @@ func TestLatest(t *testing.T) {
 		})
 	}
 }
+
+func TestCanonical(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"v1.2.3", "v1.2.3"},
+		{"1.2.3", "v1.2.3"},
+		{"v1.2", "v1.2.0"},
+		{"v1.2.3+meta", "v1.2.3"},
+		{"v1.2.3+incompatible", "v1.2.3"},
+		{"v0.1.0+incompatible", "v0.1.0"},
+		{"v2.0.0+incompatible", "v2.0.0+incompatible"},
+		{"v1.2.3-rc.1", "v1.2.3-rc.1"},
+		{"v0.0.0-20230101000000-abcdefabcdef", "v0.0.0-20230101000000-abcdefabcdef"},
+	} {
+		got, err := Canonical(test.in)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+	for _, in := range []string{"", "latest", "vmaster", "v1.2.3-01", "v1.2.3.4"} {
+		if got, err := Canonical(in); err == nil {
+			t.Errorf("%q: got %q, want error", in, got)
+		}
+	}
+}
@@ -127,11 +127,7 @@ func ReadMostRecentDB(ctx context.Context, c *bigquery.Client) (entries []*Entry
 		PartitionOn: "ID",
 		OrderBy:     "modified_time DESC",
 	}.String()
-	iter, err := c.Query(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	err = bigquery.ForEachRow(iter, func(e *Entry) bool {
+	err = bigquery.ForEach(ctx, c, query, nil, func(e *Entry) bool {
 		entries = append(entries, e)
 		return true
 	})
@@ -79,9 +79,5 @@ func ReadRequestCountsFromBigQuery(ctx context.Context, client *bigquery.Client)
 		PartitionOn: "date",
 		OrderBy:     "created_at DESC",
 	})
-	iter, err := client.Query(ctx, q)
-	if err != nil {
-		return nil, err
-	}
-	return bigquery.All[RequestCount](iter)
+	return bigquery.Query[RequestCount](ctx, client, q)
 }
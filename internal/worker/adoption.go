@@ -0,0 +1,157 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// handleAdoptionLag serves, for each OSV entry with a fixed version, how
+// long the modules of the corpus it affected took to stop being affected
+// after the fix was available.
+// It is triggered by path /govulncheck/adoption-lag?params.
+//
+// See govulncheck.AdoptionLagQueryParams for the query params.
+func (h *GovulncheckServer) handleAdoptionLag(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleAdoptionLag")
+
+	ctx := r.Context()
+	params := &govulncheck.AdoptionLagQueryParams{Window: govulncheck.DefaultAdoptionWindowDays, Format: "json"}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.OSV != "" {
+		if err := govulncheck.ValidateOSVID(params.OSV); err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
+	}
+	var since time.Time
+	if params.Since != "" {
+		since, err = time.Parse(time.DateOnly, params.Since)
+		if err != nil {
+			return fmt.Errorf("%w: since: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if params.Window <= 0 {
+		return fmt.Errorf("%w: window must be positive", derrors.InvalidArgument)
+	}
+	if params.Format != "json" && params.Format != "csv" {
+		return fmt.Errorf("%w: unknown format %q", derrors.InvalidArgument, params.Format)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	if h.osvCache == nil {
+		return errors.New("no OSV cache")
+	}
+	rems, err := govulncheck.ReadRemediations(ctx, h.bqClient, params.OSV, since)
+	if err != nil {
+		return err
+	}
+	info := func(ctx context.Context, modulePath, version string) (time.Time, error) {
+		vi, err := h.proxyClient.Info(ctx, modulePath, version)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return vi.Time, nil
+	}
+	fixes := map[string]time.Time{}
+	releases := map[string]time.Time{} // by module@version, shared by entries
+	for _, rem := range rems {
+		if _, ok := fixes[rem.OSV]; ok {
+			continue
+		}
+		e, err := h.osvCache.Get(ctx, rem.OSV)
+		if err != nil {
+			log.Warnf(ctx, "adoption lag: omitting %s: %v", rem.OSV, err)
+			fixes[rem.OSV] = time.Time{}
+			continue
+		}
+		fixes[rem.OSV] = fixAvailable(ctx, e, releases, info)
+	}
+	for id, t := range fixes {
+		if t.IsZero() {
+			delete(fixes, id)
+		}
+	}
+	lags := govulncheck.SummarizeAdoptionLag(rems, fixes, time.Duration(params.Window)*24*time.Hour, h.now())
+	if params.Format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		return writeAdoptionLagCSV(w, lags)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if lags == nil {
+		lags = []*govulncheck.AdoptionLag{} // an empty array, not null
+	}
+	return writeJSON(w, lags)
+}
+
+// fixAvailable returns when a fix for the vulnerability of e was first
+// available: the later of the publication of e and the release of its
+// earliest fixed version, according to info. It returns the zero time if
+// e has no fixed versions or none of their release times are known.
+// Release times are cached in releases.
+func fixAvailable(ctx context.Context, e *osv.Entry, releases map[string]time.Time, info func(context.Context, string, string) (time.Time, error)) time.Time {
+	var first time.Time
+	for _, mv := range govulncheck.FixedVersions(e) {
+		t, ok := releases[mv]
+		if !ok {
+			modulePath, version, _ := strings.Cut(mv, "@")
+			var err error
+			t, err = info(ctx, modulePath, version)
+			if err != nil {
+				log.Warnf(ctx, "adoption lag: release time of %s: %v", mv, err)
+			}
+			releases[mv] = t
+		}
+		if !t.IsZero() && (first.IsZero() || t.Before(first)) {
+			first = t
+		}
+	}
+	if first.IsZero() {
+		return time.Time{}
+	}
+	if e.Published.After(first) {
+		return e.Published
+	}
+	return first
+}
+
+// writeAdoptionLagCSV writes lags to w as CSV, with a header row. The
+// unremediated modules of an entry are separated by spaces.
+func writeAdoptionLagCSV(w io.Writer, lags []*govulncheck.AdoptionLag) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"osv", "fix_available", "remediated", "p50_days", "p90_days", "max_days", "mean_days", "pending", "unremediated"})
+	days := func(d float64) string { return strconv.FormatFloat(d, 'f', 1, 64) }
+	for _, l := range lags {
+		cw.Write([]string{
+			l.OSV,
+			l.FixAvailable.UTC().Format(time.RFC3339),
+			strconv.Itoa(l.Remediated),
+			days(l.P50Days),
+			days(l.P90Days),
+			days(l.MaxDays),
+			days(l.MeanDays),
+			strconv.Itoa(l.Pending),
+			strings.Join(l.Unremediated, " "),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
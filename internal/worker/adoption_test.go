@@ -0,0 +1,89 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+func TestFixAvailable(t *testing.T) {
+	ctx := context.Background()
+	published := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	release := map[string]time.Time{
+		"example.com/a@v1.2.3": time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+		"example.com/a@v1.3.1": time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC),
+		"example.com/b@v2.0.0": time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	var calls int
+	info := func(_ context.Context, modulePath, version string) (time.Time, error) {
+		calls++
+		t, ok := release[modulePath+"@"+version]
+		if !ok {
+			return time.Time{}, errors.New("not found")
+		}
+		return t, nil
+	}
+	entry := func(mod string, fixed ...string) *osv.Entry {
+		var evs []osv.RangeEvent
+		for _, f := range fixed {
+			evs = append(evs, osv.RangeEvent{Fixed: f})
+		}
+		return &osv.Entry{
+			Published: published,
+			Affected:  []osv.Affected{{Module: osv.Module{Path: mod}, Ranges: []osv.Range{{Events: evs}}}},
+		}
+	}
+
+	releases := map[string]time.Time{}
+	for _, test := range []struct {
+		name  string
+		entry *osv.Entry
+		want  time.Time
+	}{
+		{"published after fix", entry("example.com/a", "1.2.3", "1.3.1"), published},
+		{"fix after published", entry("example.com/b", "2.0.0"), release["example.com/b@v2.0.0"]},
+		{"unknown release", entry("example.com/c", "1.0.0"), time.Time{}},
+		{"no fix", entry("example.com/a"), time.Time{}},
+	} {
+		if got := fixAvailable(ctx, test.entry, releases, info); !got.Equal(test.want) {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+	// Release times are looked up once.
+	fixAvailable(ctx, entry("example.com/a", "1.2.3"), releases, info)
+	if calls != 4 {
+		t.Errorf("got %d lookups, want 4", calls)
+	}
+}
+
+func TestWriteAdoptionLagCSV(t *testing.T) {
+	lags := []*govulncheck.AdoptionLag{{
+		OSV:          "GO-2023-0001",
+		FixAvailable: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Remediated:   2,
+		P50Days:      1.5,
+		P90Days:      3,
+		MaxDays:      3,
+		MeanDays:     2.3,
+		Unremediated: []string{"example.com/a", "example.com/b"},
+		Pending:      1,
+	}}
+	var sb strings.Builder
+	if err := writeAdoptionLagCSV(&sb, lags); err != nil {
+		t.Fatal(err)
+	}
+	want := "osv,fix_available,remediated,p50_days,p90_days,max_days,mean_days,pending,unremediated\n" +
+		"GO-2023-0001,2023-01-01T00:00:00Z,2,1.5,3.0,3.0,2.3,1,example.com/a example.com/b\n"
+	if got := sb.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -20,7 +20,6 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	bq "cloud.google.com/go/bigquery"
 	"cloud.google.com/go/storage"
@@ -64,7 +63,7 @@ func (s *analysisServer) handleScan(w http.ResponseWriter, r *http.Request) (err
 
 	req, err := analysis.ParseScanRequest(r, "/analysis/scan")
 	if err != nil {
-		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		return err
 	}
 
 	// If there is a job and it's canceled, return immediately.
@@ -140,7 +139,7 @@ func (s *analysisServer) handleScan(w http.ResponseWriter, r *http.Request) (err
 	}
 
 	row := s.scan(ctx, req, localBinaryPath, wv)
-	if err := writeResult(ctx, req.Serve, w, s.bqClient, analysis.TableName, row); err != nil {
+	if err := writeResult(ctx, req.Serve, w, s.resultSink, analysis.TableName, row); err != nil {
 		return err
 	}
 	if row.Error != "" {
@@ -179,7 +178,7 @@ func (s *analysisServer) scan(ctx context.Context, req *analysis.ScanRequest, lo
 		WorkVersion: wv,
 	}
 	hasGoMod := true
-	err := doScan(ctx, req.Module, req.Version, req.Insecure, func() (err error) {
+	err := doScan(ctx, req.Module, req.Version, req.Insecure, nil, func() (err error) {
 		// Create a module directory. scanInternal will write the module contents there,
 		// and both the analysis binary and addSource will read them.
 		mdir := moduleDir(req.Module, req.Version)
@@ -241,7 +240,7 @@ func (s *analysisServer) scan(ctx context.Context, req *analysis.ScanRequest, lo
 
 func (s *analysisServer) scanInternal(ctx context.Context, req *analysis.ScanRequest, binaryPath, moduleDir string) (jt analysis.JSONTree, err error) {
 	const init = true
-	if err := prepareModule(ctx, req.Module, req.Version, moduleDir, s.proxyClient, req.Insecure, init); err != nil {
+	if _, err := prepareModule(ctx, req.Module, req.Version, moduleDir, s.proxyClient, req.Insecure, init, false, "", nil); err != nil {
 		return nil, err
 	}
 	var sbox *sandbox.Sandbox
@@ -432,7 +431,7 @@ func (s *analysisServer) handleEnqueue(w http.ResponseWriter, r *http.Request) (
 	var jobID string
 	sj := ""
 	if params.User != "" {
-		job := jobs.NewJob(params.User, time.Now(), r.URL.String(), params.Binary, binaryHash, params.Args)
+		job := jobs.NewJob(params.User, s.now(), r.URL.String(), params.Binary, binaryHash, params.Args)
 		jobID = job.ID()
 		if err := s.jobDB.CreateJob(ctx, job); err != nil {
 			sj = fmt.Sprintf(", but could not create job: %v", err)
@@ -442,8 +441,8 @@ func (s *analysisServer) handleEnqueue(w http.ResponseWriter, r *http.Request) (
 	}
 
 	tasks := createAnalysisQueueTasks(params, jobID, binaryHash, mods)
-	err = enqueueTasks(ctx, tasks, s.queue,
-		&queue.Options{Namespace: "analysis", TaskNameSuffix: params.Suffix})
+	err = s.enqueueTasks(ctx, tasks,
+		&queue.Options{Namespace: "analysis", TaskNameSuffix: params.Suffix}, nil)
 	if err != nil {
 		if err := s.jobDB.DeleteJob(ctx, jobID); err != nil {
 			log.Errorf(ctx, err, "failed to delete job upon unsuccessful enqueuing")
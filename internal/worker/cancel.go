@@ -0,0 +1,69 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// cancelQueryParams are the query params of the /queue/cancel endpoint.
+type cancelQueryParams struct {
+	Suffix string // suffix of the enqueue run whose tasks are canceled
+	DryRun bool   // if true, count the tasks but don't cancel them
+}
+
+// cancelReport is the response of handleCancel.
+type cancelReport struct {
+	Suffix string
+	// Canceled is the number of tasks canceled, or that would be in a
+	// dry run.
+	Canceled int
+	DryRun   bool
+}
+
+// handleCancel cancels the pending tasks that were enqueued with a suffix,
+// to stop a bad enqueue run. Tasks that are running finish.
+//
+// It is triggered by path /queue/cancel?suffix=SUFFIX&dryrun=BOOL.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleCancel")
+
+	ctx := r.Context()
+	params := &cancelQueryParams{}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Suffix == "" {
+		return fmt.Errorf("%w: need suffix query param", derrors.InvalidArgument)
+	}
+	if err := govulncheck.ValidateSuffix(params.Suffix); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	c, ok := s.queue.(queue.Canceler)
+	if !ok {
+		return fmt.Errorf("%w: a %T queue can't cancel tasks", derrors.InvalidArgument, s.queue)
+	}
+	n, err := c.CancelTasks(ctx, params.Suffix, params.DryRun)
+	if err != nil {
+		return err
+	}
+	log.Infof(ctx, "canceled %d tasks of run %q (dry run: %t)", n, params.Suffix, params.DryRun)
+	if !params.DryRun {
+		l := newRunEventLog(s.bqClient)
+		l.add(ctx, govulncheck.NewEvent(params.Suffix, govulncheck.EventCanceled, govulncheck.SeverityWarning,
+			fmt.Sprintf("canceled %d pending tasks", n), map[string]any{"tasks": n}))
+		if err := l.flush(ctx); err != nil {
+			log.Errorf(ctx, err, "recording cancellation of run %q", params.Suffix)
+		}
+	}
+	return writeJSON(w, &cancelReport{Suffix: params.Suffix, Canceled: n, DryRun: params.DryRun})
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+func TestHandleCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	block := make(chan struct{})
+	q := queue.NewInMemory(ctx, 1, func(context.Context, queue.Task, *queue.Options) (int, error) {
+		<-block
+		return 200, nil
+	})
+	s := &Server{queue: q}
+	for _, suffix := range []string{"good", "bad", "bad"} {
+		task := &testQueueTask{name: suffix}
+		if _, err := q.EnqueueScan(ctx, task, &queue.Options{Namespace: "ns", TaskNameSuffix: suffix}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	if err := s.handleCancel(w, httptest.NewRequest("POST", "/queue/cancel?suffix=bad", nil)); err != nil {
+		t.Fatal(err)
+	}
+	close(block)
+	var got cancelReport
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Suffix != "bad" || got.Canceled != 2 || got.DryRun {
+		t.Errorf("got %+v, want 2 tasks of run bad canceled", got)
+	}
+
+	for _, url := range []string{"/queue/cancel", "/queue/cancel?suffix=a/b"} {
+		err := s.handleCancel(httptest.NewRecorder(), httptest.NewRequest("POST", url, nil))
+		if !errors.Is(err, derrors.InvalidArgument) {
+			t.Errorf("%s: got %v, want InvalidArgument", url, err)
+		}
+	}
+	err := (&Server{}).handleCancel(httptest.NewRecorder(), httptest.NewRequest("POST", "/queue/cancel?suffix=bad", nil))
+	if !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("queue without cancellation: got %v, want InvalidArgument", err)
+	}
+}
+
+type testQueueTask struct{ name string }
+
+func (t *testQueueTask) Name() string   { return t.name }
+func (t *testQueueTask) Path() string   { return t.name + "@v1.0.0" }
+func (t *testQueueTask) Params() string { return "" }
@@ -0,0 +1,77 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// taskCheckpoints reads and records the checkpoints of the attempts of a
+// scan task; see govulncheck.Checkpoint. A nil *taskCheckpoints has no
+// checkpoints and records none.
+type taskCheckpoints struct {
+	suffix  string
+	task    string
+	lineage string
+	read    func(ctx context.Context, suffix, task, lineage string) ([]*govulncheck.Checkpoint, error)
+	// write must write the event before returning, so that an attempt
+	// that dies right after still leaves it behind.
+	write func(context.Context, *govulncheck.Event) error
+}
+
+// newTaskCheckpoints returns the checkpoints of the task with the given
+// name for sreq, or nil if there is no BigQuery client.
+func (h *GovulncheckServer) newTaskCheckpoints(sreq *govulncheck.Request, taskName string) *taskCheckpoints {
+	if h.bqClient == nil {
+		return nil
+	}
+	return &taskCheckpoints{
+		suffix:  sreq.QueryParams.Suffix,
+		task:    taskName,
+		lineage: govulncheck.Lineage(taskName, sreq),
+		read: func(ctx context.Context, suffix, task, lineage string) ([]*govulncheck.Checkpoint, error) {
+			return govulncheck.ReadCheckpoints(ctx, h.bqClient, suffix, task, lineage)
+		},
+		write: func(ctx context.Context, e *govulncheck.Event) error {
+			return bigquery.UploadMany(ctx, h.bqClient, govulncheck.EventTableName, []*govulncheck.Event{e}, 0)
+		},
+	}
+}
+
+// completed returns the sub-scans that earlier attempts of the task did
+// with work version wv.
+func (c *taskCheckpoints) completed(ctx context.Context, wv *govulncheck.WorkVersion) (map[string]*govulncheck.Checkpoint, error) {
+	if c == nil {
+		return nil, nil
+	}
+	cps, err := c.read(ctx, c.suffix, c.task, c.lineage)
+	if err != nil {
+		return nil, err
+	}
+	done := govulncheck.CompletedSubScans(cps, c.lineage, wv)
+	if len(done) > 0 {
+		log.Infof(ctx, "task %s: resuming after %d sub-scans done by earlier attempts", c.task, len(done))
+	}
+	return done, nil
+}
+
+// record records that the sub-scan was done with work version wv.
+// The rows of the sub-scan must already be written.
+func (c *taskCheckpoints) record(ctx context.Context, wv *govulncheck.WorkVersion, subScan string, pair *govulncheck.PairSummary) error {
+	if c == nil {
+		return nil
+	}
+	return c.write(ctx, govulncheck.NewCheckpointEvent(c.suffix, &govulncheck.Checkpoint{
+		Task:        c.task,
+		Lineage:     c.lineage,
+		WorkVersion: wv,
+		SubScan:     subScan,
+		Pair:        pair,
+	}))
+}
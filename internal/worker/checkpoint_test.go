@@ -0,0 +1,158 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+func TestComparePackagesResume(t *testing.T) {
+	ctx := context.Background()
+	finding := func(id string) *govulncheckapi.Finding {
+		return &govulncheckapi.Finding{OSV: id, Trace: []*govulncheckapi.Frame{{Module: "dep", Function: "F"}}}
+	}
+	results := map[string]*govulncheck.ComparePair{
+		"m/a": {
+			BinaryResults: govulncheck.SandboxResponse{
+				Findings: []*govulncheckapi.Finding{finding("GO-1")},
+				Stats:    govulncheck.ScanStats{ScanSeconds: 0.3, BuildTime: 1100 * time.Millisecond},
+			},
+			SourceResults: govulncheck.SandboxResponse{
+				Findings: []*govulncheckapi.Finding{finding("GO-1")},
+				Stats:    govulncheck.ScanStats{ScanSeconds: 0.7},
+			},
+		},
+		"m/b": {
+			BinaryResults: govulncheck.SandboxResponse{
+				Findings: []*govulncheckapi.Finding{finding("GO-2")},
+				Stats:    govulncheck.ScanStats{ScanSeconds: 0.1, BuildTime: 900 * time.Millisecond},
+			},
+			SourceResults: govulncheck.SandboxResponse{Stats: govulncheck.ScanStats{ScanSeconds: 0.2}},
+		},
+		"m/c": {Error: "build failed"},
+		"m/d": {
+			BinaryResults: govulncheck.SandboxResponse{Stats: govulncheck.ScanStats{ScanSeconds: 0.4}},
+			SourceResults: govulncheck.SandboxResponse{
+				Findings: []*govulncheckapi.Finding{finding("GO-3")},
+				Stats:    govulncheck.ScanStats{ScanSeconds: 0.5},
+			},
+		},
+	}
+	pkgs := []string{"m/a", "m/b", "m/c", "m/d"}
+	wv := &govulncheck.WorkVersion{WorkerVersion: "1", SchemaVersion: "s"}
+	sreq := &govulncheck.Request{
+		ModuleURLPath: scan.ModuleURLPath{Module: "m", Version: "v1.0.0"},
+		QueryParams:   govulncheck.QueryParams{Mode: ModeCompare, Suffix: "run"},
+	}
+	baseRow := &govulncheck.Result{ModulePath: "m", Version: "v1.0.0", Suffix: "run", WorkVersion: *wv}
+
+	// The checkpoints of the task, stored as events are.
+	var events []*govulncheck.Event
+	newCheckpoints := func() *taskCheckpoints {
+		return &taskCheckpoints{
+			suffix:  "run",
+			task:    "task",
+			lineage: govulncheck.Lineage("task", sreq),
+			read: func(_ context.Context, suffix, task, lineage string) ([]*govulncheck.Checkpoint, error) {
+				var cps []*govulncheck.Checkpoint
+				for _, e := range events {
+					var c govulncheck.Checkpoint
+					if err := json.Unmarshal([]byte(e.Payload.JSONVal), &c); err != nil {
+						return nil, err
+					}
+					if e.Suffix == suffix && e.Type == govulncheck.EventCheckpoint && c.Task == task && c.Lineage == lineage {
+						cps = append(cps, &c)
+					}
+				}
+				return cps, nil
+			},
+			write: func(_ context.Context, e *govulncheck.Event) error {
+				events = append(events, e)
+				return nil
+			},
+		}
+	}
+	errKilled := errors.New("killed")
+	// attempt runs one attempt of the task with the given checkpoints and
+	// work version, which dies when it gets to the package kill, if any.
+	// It returns the packages it compared and the rows it wrote.
+	attempt := func(cps *taskCheckpoints, wv *govulncheck.WorkVersion, kill string) (compared []string, rows []bigquery.Row, err error) {
+		s := &scanner{
+			workVersion: wv,
+			checkpoints: cps,
+			uploadRows: func(_ context.Context, _ string, rs []bigquery.Row) error {
+				rows = append(rows, rs...)
+				return nil
+			},
+		}
+		done, err := s.checkpoints.completed(ctx, wv)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = s.comparePackages(ctx, nil, sreq, baseRow, pkgs, done, func(pkg string) (*govulncheck.ComparePair, error) {
+			if pkg == kill {
+				return nil, errKilled
+			}
+			compared = append(compared, pkg)
+			return results[pkg], nil
+		})
+		return compared, rows, err
+	}
+
+	_, want, err := attempt(nil, wv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two rows for each package without errors, and the summary.
+	if len(want) != 7 {
+		t.Fatalf("uninterrupted run wrote %d rows, want 7", len(want))
+	}
+
+	// Kill the task between packages, then retry it.
+	_, rows1, err := attempt(newCheckpoints(), wv, "m/d")
+	if !errors.Is(err, errKilled) {
+		t.Fatalf("got %v, want killed", err)
+	}
+	compared, rows2, err := attempt(newCheckpoints(), wv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"m/d"}; !cmp.Equal(compared, want) {
+		t.Errorf("retry compared %v, want %v", compared, want)
+	}
+	if diff := cmp.Diff(want, append(rows1, rows2...)); diff != "" {
+		t.Errorf("rows mismatch (-uninterrupted, +resumed):\n%s", diff)
+	}
+
+	// Once the summary is written, the task is done.
+	done, err := newCheckpoints().completed(ctx, wv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done[govulncheck.SubScanSummary] == nil {
+		t.Error("no summary checkpoint")
+	}
+
+	// Checkpoints of another work version don't count.
+	newer := *wv
+	newer.WorkerVersion = "2"
+	compared, _, err = attempt(newCheckpoints(), &newer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(compared, pkgs) {
+		t.Errorf("new work version: compared %v, want %v", compared, pkgs)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+// handleCompareSummary summarizes the compare-mode scans of a run, using
+// only the summary row of each module.
+//
+// It is triggered by path /govulncheck/compare-summary?suffix=S.
+func (h *GovulncheckServer) handleCompareSummary(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleCompareSummary")
+	suffix := r.FormValue("suffix")
+	if suffix == "" {
+		return fmt.Errorf("%w: need suffix query param", derrors.InvalidArgument)
+	}
+	if err := govulncheck.ValidateSuffix(suffix); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	stats, err := govulncheck.ReadCompareStats(r.Context(), h.bqClient, suffix)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, stats)
+}
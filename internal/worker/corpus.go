@@ -0,0 +1,90 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// corpusReport is the response of handleReconcileCorpus.
+type corpusReport struct {
+	// Corpus is the number of modules in the corpus.
+	Corpus int
+	// Live is the number of modules with rows that were not tombstoned.
+	Live int
+	// Tombstoned is the number of modules tombstoned, or that would be
+	// in a dry run.
+	Tombstoned int
+	DryRun     bool
+	// Modules are the modules tombstoned.
+	Modules []string
+}
+
+// handleReconcileCorpus writes a tombstone row for each module that has
+// rows in the govulncheck table but is no longer in the corpus, so that
+// queries for the latest results can ignore it. The corpus is read the
+// same way as for enqueuing.
+//
+// It is triggered by path /govulncheck/reconcile-corpus?params.
+// See govulncheck.CorpusQueryParams for the query params.
+func (h *GovulncheckServer) handleReconcileCorpus(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleReconcileCorpus")
+
+	ctx := r.Context()
+	params := &govulncheck.CorpusQueryParams{Min: defaultMinImportedByCount}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	modspecs, err := readModules(ctx, h.cfg, params.File, params.Min)
+	if err != nil {
+		return err
+	}
+	if len(modspecs) == 0 {
+		// Tombstoning every module is almost certainly a mistake.
+		return errors.New("corpus is empty")
+	}
+	corpus := map[string]bool{}
+	for _, ms := range modspecs {
+		corpus[ms.Path] = true
+	}
+	live, err := govulncheck.ReadLiveModules(ctx, h.bqClient)
+	if err != nil {
+		return err
+	}
+	removed := govulncheck.RemovedModules(live, corpus)
+	report := &corpusReport{
+		Corpus:     len(corpus),
+		Live:       len(live) - len(removed),
+		Tombstoned: len(removed),
+		DryRun:     params.DryRun,
+		Modules:    removed,
+	}
+	log.Infof(ctx, "reconcile corpus: %d modules in corpus, %d with rows no longer in it (dry run: %t)",
+		len(corpus), len(removed), params.DryRun)
+	if !params.DryRun && len(removed) > 0 {
+		var rows []bigquery.Row
+		for _, m := range removed {
+			rows = append(rows, govulncheck.Tombstone(m))
+		}
+		if err := bigquery.UploadMany(ctx, h.bqClient, govulncheck.TableName, rows, 0); err != nil {
+			return err
+		}
+		defer h.flushRunEvents(ctx)
+		h.runEvents.add(ctx, govulncheck.NewEvent(govulncheck.MaintenanceSuffix, govulncheck.EventCorpusReconciled, govulncheck.SeverityInfo,
+			fmt.Sprintf("tombstoned %d modules no longer in the corpus", len(removed)), report))
+	}
+	return writeJSON(w, report)
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// handleUpdateDaily writes the summaries of the rows of the govulncheck
+// table created on a day to the govulncheck_daily table. The day is
+// yesterday, in UTC, unless the date query param (YYYY-MM-DD) says
+// otherwise. It is meant to be called daily by a scheduler, after the day
+// is over.
+//
+// It is triggered by path /govulncheck/update-daily?date=D.
+func (h *GovulncheckServer) handleUpdateDaily(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleUpdateDaily")
+	day := civil.DateOf(h.now().UTC()).AddDays(-1)
+	if d := r.FormValue("date"); d != "" {
+		day, err = civil.ParseDate(d)
+		if err != nil {
+			return fmt.Errorf("%w: date: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	start := time.Now()
+	if err := govulncheck.UpdateDaily(r.Context(), h.bqClient, day); err != nil {
+		return err
+	}
+	log.Infof(r.Context(), "updated daily summaries of %s in %s", day, time.Since(start))
+	fmt.Fprintf(w, "updated %s\n", day)
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// govulncheckScanPrefix is the path prefix of govulncheck scan requests.
+const govulncheckScanPrefix = "/govulncheck/scan"
+
+// deadLetter handles err, the error of the scan task of r. If it was the
+// last attempt of the task that the queue makes, as the
+// X-CloudTasks-TaskRetryCount header and the MaxTaskAttempts of the
+// configuration tell, it records the task in the dead_letters table and
+// returns nil, so that the task is acknowledged instead of failing
+// silently. Otherwise, or if the task can't be recorded, it returns err.
+func (h *GovulncheckServer) deadLetter(ctx context.Context, r *http.Request, err error) error {
+	attempts := taskAttempts(r)
+	if h.bqClient == nil || h.cfg.MaxTaskAttempts <= 0 || attempts < h.cfg.MaxTaskAttempts {
+		return err
+	}
+	d := govulncheck.NewDeadLetter(r, govulncheckScanPrefix, attempts, err)
+	if uerr := h.bqClient.Upload(ctx, govulncheck.DeadLetterTableName, d); uerr != nil {
+		log.Errorf(ctx, uerr, "recording dead letter for %s", d.URI)
+		return err
+	}
+	log.Errorf(ctx, err, "%s: giving up after %d attempts; recorded in %s", d.URI, attempts, govulncheck.DeadLetterTableName)
+	return nil
+}
+
+// taskAttempts returns the number of the attempt of the Cloud Tasks task
+// of r, counting from 1, or 0 if r is not a Cloud Tasks request.
+func taskAttempts(r *http.Request) int {
+	n, err := strconv.Atoi(r.Header.Get("X-CloudTasks-TaskRetryCount"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n + 1
+}
+
+// requeueReport is the response of handleRequeueFailed.
+type requeueReport struct {
+	Category string
+	Suffix   string
+	Since    string
+	// Selected is the number of tasks enqueued, or that would be in a
+	// dry run.
+	Selected int
+	// Invalid is the number of dead letters whose URIs are not valid
+	// scan requests.
+	Invalid int
+	DryRun  bool
+}
+
+// handleRequeueFailed enqueues again the scan tasks in the dead_letters
+// table, such as after the fix of their error ships. Their tasks are
+// enqueued with their original params, in the run of the given suffix.
+//
+// It is triggered by path /govulncheck/requeue-failed?params.
+// See govulncheck.RequeueQueryParams for the query params.
+func (h *GovulncheckServer) handleRequeueFailed(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleRequeueFailed")
+
+	ctx := r.Context()
+	params := &govulncheck.RequeueQueryParams{}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Suffix == "" {
+		return fmt.Errorf("%w: need suffix query param", derrors.InvalidArgument)
+	}
+	if err := govulncheck.ValidateSuffix(params.Suffix); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if err := govulncheck.ValidateCategory(params.Category); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	var since time.Time
+	if params.Since != "" {
+		since, err = time.Parse(time.RFC3339, params.Since)
+		if err != nil {
+			return fmt.Errorf("%w: since: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	letters, err := govulncheck.ReadDeadLetters(ctx, h.bqClient, params.Category, since)
+	if err != nil {
+		return err
+	}
+	tasks, invalid := requeueTasks(ctx, letters, params.Suffix)
+	log.Infof(ctx, "requeue failed: %d tasks from %d dead letters (category %q, since %q, dry run: %t)",
+		len(tasks), len(letters), params.Category, params.Since, params.DryRun)
+	if !params.DryRun && len(tasks) > 0 {
+		err := h.enqueueTasks(ctx, tasks,
+			&queue.Options{Namespace: "govulncheck", TaskNameSuffix: params.Suffix}, nil)
+		if err != nil {
+			return err
+		}
+		defer h.flushRunEvents(ctx)
+		h.runEvents.add(ctx, govulncheck.NewEvent(params.Suffix, govulncheck.EventRequeued, govulncheck.SeverityInfo,
+			fmt.Sprintf("enqueued %d dead-lettered tasks", len(tasks)),
+			map[string]any{"tasks": len(tasks), "category": params.Category, "since": params.Since}))
+	}
+	return writeJSON(w, &requeueReport{
+		Category: params.Category,
+		Suffix:   params.Suffix,
+		Since:    params.Since,
+		Selected: len(tasks),
+		Invalid:  invalid,
+		DryRun:   params.DryRun,
+	})
+}
+
+// requeueTasks returns the scan tasks of letters in the run of the given
+// suffix, from their first attempt, and the number of letters whose URIs
+// are not valid scan requests.
+func requeueTasks(ctx context.Context, letters []*govulncheck.DeadLetter, suffix string) (_ []queue.Task, invalid int) {
+	var tasks []queue.Task
+	for _, d := range letters {
+		sreq, err := d.Request(govulncheckScanPrefix)
+		if err != nil {
+			log.Warnf(ctx, "dead letter %s: %v", d.URI, err)
+			invalid++
+			continue
+		}
+		sreq.QueryParams.Suffix = suffix
+		sreq.Attempt = 0
+		tasks = append(tasks, sreq)
+	}
+	return tasks, invalid
+}
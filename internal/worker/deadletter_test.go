@@ -0,0 +1,105 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	fake := bigquery.NewFake()
+	client := fake.Client()
+	if _, err := client.CreateOrUpdateTable(ctx, govulncheck.DeadLetterTableName); err != nil {
+		t.Fatal(err)
+	}
+	h := &GovulncheckServer{Server: &Server{
+		cfg:      &config.Config{MaxTaskAttempts: 3},
+		bqClient: client,
+	}}
+	const uri = "/govulncheck/scan/golang.org/x/text@v0.3.0?importedby=10&mode=GOVULNCHECK&suffix=run1"
+	scanErr := fmt.Errorf("scanning: %w", derrors.ProxyError)
+	for _, test := range []struct {
+		retryCount string // of the X-CloudTasks-TaskRetryCount header
+		wantErr    bool
+	}{
+		{"", true}, // not a Cloud Tasks request
+		{"0", true},
+		{"1", true},
+		{"2", false}, // the third and last attempt
+	} {
+		r := httptest.NewRequest("POST", uri, nil)
+		if test.retryCount != "" {
+			r.Header.Set("X-CloudTasks-TaskRetryCount", test.retryCount)
+			r.Header.Set("X-CloudTasks-TaskName", "task")
+		}
+		err := h.deadLetter(ctx, r, scanErr)
+		if got := err != nil; got != test.wantErr {
+			t.Errorf("retry count %q: got error %v, want error: %t", test.retryCount, err, test.wantErr)
+		}
+	}
+	rows := fake.Rows(govulncheck.DeadLetterTableName)
+	if len(rows) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(rows))
+	}
+	got := *rows[0].(*govulncheck.DeadLetter)
+	got.CreatedAt = time.Time{}
+	want := govulncheck.DeadLetter{
+		URI:           uri,
+		TaskName:      "task",
+		ModulePath:    "golang.org/x/text",
+		Version:       "v0.3.0",
+		ScanMode:      "GOVULNCHECK",
+		Suffix:        "run1",
+		Attempts:      3,
+		ErrorCategory: derrors.CategorizeError(derrors.ProxyError),
+		Error:         scanErr.Error(),
+	}
+	if got != want {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	// Dead letters are not recorded if MaxTaskAttempts is zero.
+	h.cfg.MaxTaskAttempts = 0
+	r := httptest.NewRequest("POST", uri, nil)
+	r.Header.Set("X-CloudTasks-TaskRetryCount", "100")
+	if err := h.deadLetter(ctx, r, scanErr); !errors.Is(err, derrors.ProxyError) {
+		t.Errorf("got %v, want the scan error", err)
+	}
+}
+
+func TestRequeueTasks(t *testing.T) {
+	letters := []*govulncheck.DeadLetter{
+		{URI: "/govulncheck/scan/golang.org/x/text@v0.3.0?importedby=10&mode=COMPARE&suffix=run1&attempt=3&tags=a,b"},
+		{URI: "/govulncheck/scan/golang.org/x/text@v0.3.0"}, // no importedby
+		{URI: "/govulncheck/scan/example.com/m@v1.0.0?importedby=2&suffix=run2"},
+	}
+	tasks, invalid := requeueTasks(context.Background(), letters, "requeue")
+	if invalid != 1 {
+		t.Errorf("got %d invalid dead letters, want 1", invalid)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(tasks))
+	}
+	for _, task := range tasks {
+		sreq := task.(*govulncheck.Request)
+		if sreq.QueryParams.Suffix != "requeue" || sreq.Attempt != 0 {
+			t.Errorf("%s: got suffix %q, attempt %d; want %q, 0", sreq.Name(), sreq.QueryParams.Suffix, sreq.Attempt, "requeue")
+		}
+	}
+	if got := tasks[0].(*govulncheck.Request); got.Mode != "COMPARE" || got.Tags != "a,b" || got.ImportedBy != 10 {
+		t.Errorf("got mode %q, tags %q, imported by %d; want the params of the dead letter", got.Mode, got.Tags, got.ImportedBy)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// A deferralScheduler decides which scans of a run to defer because the
+// run is behind schedule. It caches the deferral threshold of each run,
+// recomputing it from the run's configuration and progress every refresh
+// interval of the run.
+//
+// A nil *deferralScheduler defers nothing.
+type deferralScheduler struct {
+	readRun      func(context.Context, string) (*govulncheck.Run, error)
+	readProgress func(context.Context, string) (*govulncheck.RunProgress, error)
+	now          func() time.Time
+
+	mu   sync.Mutex
+	runs map[string]*runDeferral // by suffix
+}
+
+type runDeferral struct {
+	run        *govulncheck.Run // nil if the run has no row
+	threshold  int
+	computedAt time.Time
+}
+
+// newDeferralScheduler returns a deferralScheduler that reads runs with
+// client and gets the current time from now, or nil if client is nil.
+func newDeferralScheduler(client *bigquery.Client, now func() time.Time) *deferralScheduler {
+	if client == nil {
+		return nil
+	}
+	return &deferralScheduler{
+		readRun: func(ctx context.Context, suffix string) (*govulncheck.Run, error) {
+			return govulncheck.ReadRun(ctx, client, suffix)
+		},
+		readProgress: func(ctx context.Context, suffix string) (*govulncheck.RunProgress, error) {
+			return govulncheck.ReadRunProgress(ctx, client, suffix)
+		},
+		now:  now,
+		runs: map[string]*runDeferral{},
+	}
+}
+
+// threshold returns the imported-by count below which scans of the run
+// with the given suffix are deferred, or 0 if none are.
+// If the threshold can't be recomputed, the previous one is kept.
+func (d *deferralScheduler) threshold(ctx context.Context, suffix string) int {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	rd := d.runs[suffix]
+	if rd != nil && now.Sub(rd.computedAt) < rd.run.DeferRefresh() {
+		return rd.threshold
+	}
+	if rd == nil {
+		rd = &runDeferral{}
+		d.runs[suffix] = rd
+	}
+	// Don't retry until the next refresh, even on error.
+	rd.computedAt = now
+	run, err := d.readRun(ctx, suffix)
+	if err != nil {
+		log.Errorf(ctx, err, "run %q: reading run; keeping deferral threshold %d", suffix, rd.threshold)
+		return rd.threshold
+	}
+	rd.run = run
+	if !run.Defers() {
+		rd.threshold = 0
+		return 0
+	}
+	p, err := d.readProgress(ctx, suffix)
+	if err != nil {
+		log.Errorf(ctx, err, "run %q: reading progress; keeping deferral threshold %d", suffix, rd.threshold)
+		return rd.threshold
+	}
+	t := govulncheck.DeferThreshold(run, p, now)
+	if t != rd.threshold {
+		log.Infof(ctx, "run %q: deferral threshold changed from %d to %d (%d of %d modules done)",
+			suffix, rd.threshold, t, p.Modules, run.Modules)
+	}
+	rd.threshold = t
+	return t
+}
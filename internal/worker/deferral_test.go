@@ -0,0 +1,82 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/clock"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestDeferralScheduler(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start.Add(5 * time.Hour))
+	run := &govulncheck.Run{
+		CreatedAt:           start,
+		Suffix:              "r",
+		Modules:             1000,
+		Deadline:            bigquery.NullTimestamp(start.Add(10 * time.Hour)),
+		DeferBelow:          bigquery.NullInt(100),
+		DeferRefreshMinutes: bigquery.NullInt(5),
+	}
+	progress := &govulncheck.RunProgress{Modules: 100, Scanned: 100}
+	var reads int
+	var readErr error
+	d := &deferralScheduler{
+		readRun: func(_ context.Context, suffix string) (*govulncheck.Run, error) {
+			reads++
+			if readErr != nil {
+				return nil, readErr
+			}
+			if suffix != run.Suffix {
+				return nil, nil
+			}
+			return run, nil
+		},
+		readProgress: func(context.Context, string) (*govulncheck.RunProgress, error) {
+			return progress, nil
+		},
+		now:  clk.Now,
+		runs: map[string]*runDeferral{},
+	}
+
+	check := func(suffix string, want, wantReads int) {
+		t.Helper()
+		if got := d.threshold(ctx, suffix); got != want {
+			t.Errorf("threshold(%q) = %d, want %d", suffix, got, want)
+		}
+		if reads != wantReads {
+			t.Errorf("got %d reads, want %d", reads, wantReads)
+		}
+	}
+
+	check("r", 89, 1)
+	// The threshold is cached until the refresh interval passes.
+	progress = &govulncheck.RunProgress{Modules: 600, Scanned: 600}
+	clk.Advance(time.Minute)
+	check("r", 89, 1)
+	clk.Advance(5 * time.Minute)
+	check("r", 0, 2)
+	// A run without a row defers nothing.
+	check("other", 0, 3)
+	// A failed read keeps the previous threshold.
+	progress = &govulncheck.RunProgress{Modules: 100, Scanned: 100}
+	clk.Advance(5 * time.Minute)
+	check("r", 90, 4)
+	readErr = errors.New("bad")
+	clk.Advance(5 * time.Minute)
+	check("r", 90, 5)
+
+	var nilScheduler *deferralScheduler
+	if got := nilScheduler.threshold(ctx, "r"); got != 0 {
+		t.Errorf("nil scheduler: got %d, want 0", got)
+	}
+}
@@ -6,14 +6,23 @@ package worker
 
 import (
 	"context"
-	"sync"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"time"
 
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
 	"golang.org/x/pkgsite-metrics/internal/pkgsitedb"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/scan"
+	"golang.org/x/pkgsite-metrics/internal/version"
+	"google.golang.org/api/iterator"
 )
 
 const defaultMinImportedByCount = 10
@@ -23,10 +32,111 @@ func readModules(ctx context.Context, cfg *config.Config, file string, minImpCou
 		log.Infof(ctx, "reading modules from file %s", file)
 		return scan.ParseCorpusFile(file, minImpCount)
 	}
+	if cfg.ModulesQuery != "" || cfg.ModulesTable != "" {
+		log.Infof(ctx, "reading modules from BigQuery")
+		return readFromBigQuery(ctx, cfg, minImpCount)
+	}
 	log.Infof(ctx, "reading modules from DB %s", cfg.PkgsiteDBName)
 	return readFromDB(ctx, cfg, minImpCount)
 }
 
+// readFromBigQuery reads the modules with at least minImportedByCount importers
+// from the results of cfg.ModulesQuery, or from cfg.ModulesTable if there
+// is no query.
+func readFromBigQuery(ctx context.Context, cfg *config.Config, minImportedByCount int) (_ []scan.ModuleSpec, err error) {
+	defer derrors.Wrap(&err, "readFromBigQuery")
+	if cfg.ProjectID == "" || strings.EqualFold(cfg.BigQueryDataset, "disable") {
+		return nil, errors.New("reading modules from BigQuery requires a project and dataset")
+	}
+	client, err := bigquery.NewClientCreate(ctx, cfg.ProjectID, cfg.BigQueryDataset)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	query := cfg.ModulesQuery
+	if query == "" {
+		query = "SELECT * FROM `" + cfg.ModulesTable + "`"
+	}
+	iter, err := client.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := moduleSpecsFromRows(iter, minImportedByCount)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof(ctx, "read %d modules from BigQuery", len(specs))
+	return specs, nil
+}
+
+// moduleSpecsFromRows reads module specs from iter, keeping those
+// with at least minImportedByCount importers.
+func moduleSpecsFromRows(iter *bq.RowIterator, minImportedByCount int) ([]scan.ModuleSpec, error) {
+	var specs []scan.ModuleSpec
+	first := true
+	for {
+		var row map[string]bq.Value
+		err := iter.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			if err := validateModulesSchema(iter.Schema); err != nil {
+				return nil, err
+			}
+			first = false
+		}
+		spec := scan.ModuleSpec{
+			Path:       row["module_path"].(string),
+			Version:    version.Latest,
+			ImportedBy: int(row["imported_by"].(int64)),
+		}
+		if v, ok := row["version"].(string); ok && v != "" {
+			spec.Version = v
+		}
+		if spec.ImportedBy >= minImportedByCount {
+			specs = append(specs, spec)
+		}
+	}
+	return specs, nil
+}
+
+// validateModulesSchema checks that schema has the columns
+// needed to construct module specs.
+func validateModulesSchema(schema bq.Schema) error {
+	columns := []struct {
+		name     string
+		typ      bq.FieldType
+		required bool
+	}{
+		{"module_path", bq.StringFieldType, true},
+		{"imported_by", bq.IntegerFieldType, true},
+		{"version", bq.StringFieldType, false},
+	}
+	for _, c := range columns {
+		var field *bq.FieldSchema
+		for _, f := range schema {
+			if f.Name == c.name {
+				field = f
+				break
+			}
+		}
+		if field == nil {
+			if c.required {
+				return fmt.Errorf("modules query is missing column %s", c.name)
+			}
+			continue
+		}
+		if field.Type != c.typ || field.Repeated {
+			return fmt.Errorf("modules query column %s has type %s, want %s", c.name, field.Type, c.typ)
+		}
+	}
+	return nil
+}
+
 func readFromDB(ctx context.Context, cfg *config.Config, minImportedByCount int) ([]scan.ModuleSpec, error) {
 	db, err := pkgsitedb.Open(ctx, cfg)
 	if err != nil {
@@ -36,38 +146,54 @@ func readFromDB(ctx context.Context, cfg *config.Config, minImportedByCount int)
 	return pkgsitedb.ModuleSpecs(ctx, db, minImportedByCount)
 }
 
-func enqueueTasks(ctx context.Context, tasks []queue.Task, q queue.Queue, opts *queue.Options) (err error) {
+// enqueueTasks enqueues tasks on the queue of s with opts, with the
+// concurrency and retries of the configuration of s. If scheduleTimes is
+// non-nil, it holds the schedule time of each task. The progress of the
+// enqueue is recorded in the enqueue_runs table if there is a BigQuery
+// client. Tasks that fail to be enqueued are logged, not returned as
+// errors.
+func (s *Server) enqueueTasks(ctx context.Context, tasks []queue.Task, opts *queue.Options, scheduleTimes []time.Time) (err error) {
 	defer derrors.Wrap(&err, "enqueueTasks")
 
-	// Enqueue concurrently, because sequentially takes a while.
-	const concurrentEnqueues = 20
-	var (
-		mu                 sync.Mutex
-		nEnqueued, nErrors int
-	)
-	sem := make(chan struct{}, concurrentEnqueues)
-
-	for _, sreq := range tasks {
-		log.Infof(ctx, "enqueuing: %s?%s", sreq.Path(), sreq.Params())
-		sreq := sreq
-		sem <- struct{}{}
-		go func() {
-			defer func() { <-sem }()
-			enqueued, err := q.EnqueueScan(ctx, sreq, opts)
-			mu.Lock()
-			if err != nil {
-				log.Errorf(ctx, err, "enqueuing")
-				nErrors++
-			} else if enqueued {
-				nEnqueued++
+	bopts := queue.BatchOptions{
+		Concurrency:   s.cfg.EnqueueConcurrency,
+		Retries:       s.cfg.EnqueueRetries,
+		ScheduleTimes: scheduleTimes,
+	}
+	if s.bqClient != nil {
+		bopts.Report = func(ctx context.Context, run *queue.EnqueueRun) {
+			if err := s.bqClient.Upload(ctx, queue.EnqueueRunTableName, run); err != nil {
+				log.Errorf(ctx, err, "recording progress of enqueue run %s", run.RunID)
 			}
-			mu.Unlock()
-		}()
+		}
 	}
-	// Wait for goroutines to finish.
-	for i := 0; i < concurrentEnqueues; i++ {
-		sem <- struct{}{}
+	_, err = queue.EnqueueBatch(ctx, s.queue, tasks, opts, bopts)
+	return err
+}
+
+// spreadTimes returns n times spread uniformly over the window that begins
+// at start. The ith time falls in the ith of n equal slots of the window,
+// offset within the slot by jitter(i) times the slot length. Jitter values
+// must be in [0, 1). If window is not positive, all times are start.
+func spreadTimes(start time.Time, window time.Duration, n int, jitter func(i int) float64) []time.Time {
+	times := make([]time.Time, n)
+	for i := range times {
+		if window <= 0 {
+			times[i] = start
+			continue
+		}
+		slot := float64(window) / float64(n)
+		offset := time.Duration((float64(i) + jitter(i)) * slot)
+		times[i] = start.Add(offset)
 	}
-	log.Infof(ctx, "Successfully scheduled modules to be fetched: %d modules enqueued, %d errors", nEnqueued, nErrors)
-	return nil
+	return times
+}
+
+// taskJitter returns a value in [0, 1) derived from the name and
+// parameters of t, so that the same task always gets the same jitter.
+func taskJitter(t queue.Task) float64 {
+	h := fnv.New32a()
+	io.WriteString(h, t.Path())
+	io.WriteString(h, t.Params())
+	return float64(h.Sum32()) / (1 << 32)
 }
@@ -0,0 +1,141 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+func TestValidateModulesSchema(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		schema bq.Schema
+		ok     bool
+	}{
+		{
+			name: "required",
+			schema: bq.Schema{
+				{Name: "module_path", Type: bq.StringFieldType},
+				{Name: "imported_by", Type: bq.IntegerFieldType},
+			},
+			ok: true,
+		},
+		{
+			name: "with version",
+			schema: bq.Schema{
+				{Name: "imported_by", Type: bq.IntegerFieldType},
+				{Name: "module_path", Type: bq.StringFieldType},
+				{Name: "version", Type: bq.StringFieldType},
+				{Name: "other", Type: bq.FloatFieldType},
+			},
+			ok: true,
+		},
+		{
+			name: "missing imported_by",
+			schema: bq.Schema{
+				{Name: "module_path", Type: bq.StringFieldType},
+			},
+		},
+		{
+			name: "wrong type",
+			schema: bq.Schema{
+				{Name: "module_path", Type: bq.StringFieldType},
+				{Name: "imported_by", Type: bq.FloatFieldType},
+			},
+		},
+		{
+			name: "repeated version",
+			schema: bq.Schema{
+				{Name: "module_path", Type: bq.StringFieldType},
+				{Name: "imported_by", Type: bq.IntegerFieldType},
+				{Name: "version", Type: bq.StringFieldType, Repeated: true},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateModulesSchema(test.schema)
+			if got := err == nil; got != test.ok {
+				t.Errorf("got error %v, want ok=%t", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestSpreadTimes(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	noJitter := func(int) float64 { return 0 }
+	maxJitter := func(int) float64 { return 0.999999 }
+
+	for _, test := range []struct {
+		name   string
+		window time.Duration
+		n      int
+		jitter func(int) float64
+		want   []time.Duration // offsets from start, if non-nil
+	}{
+		{"zero window", 0, 3, maxJitter, []time.Duration{0, 0, 0}},
+		{"negative window", -time.Minute, 2, maxJitter, []time.Duration{0, 0}},
+		{"no tasks", time.Minute, 0, noJitter, []time.Duration{}},
+		{"uniform", 4 * time.Minute, 4, noJitter, []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute}},
+		{"max jitter", time.Hour, 10, maxJitter, nil},
+		{"window shorter than tasks", 5 * time.Nanosecond, 100, maxJitter, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := spreadTimes(start, test.window, test.n, test.jitter)
+			if len(got) != test.n {
+				t.Fatalf("got %d times, want %d", len(got), test.n)
+			}
+			for i, tm := range got {
+				if test.want != nil {
+					if w := start.Add(test.want[i]); !tm.Equal(w) {
+						t.Errorf("#%d: got %s, want %s", i, tm, w)
+					}
+				}
+				if tm.Before(start) {
+					t.Errorf("#%d: %s is before start", i, tm)
+				}
+				if test.window > 0 && !tm.Before(start.Add(test.window)) {
+					t.Errorf("#%d: %s is not before the end of the window", i, tm)
+				}
+				if i > 0 && tm.Before(got[i-1]) {
+					t.Errorf("#%d: %s is before previous time %s", i, tm, got[i-1])
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleByImportedBy(t *testing.T) {
+	req := func(path string, importedBy int) *govulncheck.Request {
+		return &govulncheck.Request{
+			ModuleURLPath: scan.ModuleURLPath{Module: path, Version: "v1.0.0"},
+			QueryParams:   govulncheck.QueryParams{ImportedBy: importedBy},
+		}
+	}
+	tasks := []queue.Task{req("a", 1), req("b", 100), req("c", 10)}
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := scheduleByImportedBy(tasks, start, time.Hour)
+	var got []string
+	for _, t := range tasks {
+		got = append(got, t.(*govulncheck.Request).Module)
+	}
+	if want := []string{"b", "c", "a"}; !cmp.Equal(got, want) {
+		t.Errorf("got order %v, want %v", got, want)
+	}
+	// Scheduling is deterministic.
+	again := scheduleByImportedBy(tasks, start, time.Hour)
+	for i := range times {
+		if !times[i].Equal(again[i]) {
+			t.Errorf("#%d: got %s, then %s", i, times[i], again[i])
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// exportTables are the tables that handleExport exports.
+var exportTables = []string{govulncheck.TableName, analysis.TableName}
+
+// handleExport writes the rows of the result tables created on a day to
+// Parquet files under the configured export URI; see
+// bigquery.ExportURI. The day is yesterday, in UTC, unless the date query
+// param (YYYY-MM-DD) says otherwise. It is meant to be called daily by a
+// scheduler, after the day is over.
+//
+// It is triggered by path /export?date=D.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleExport")
+	day := civil.DateOf(s.now().UTC()).AddDays(-1)
+	if d := r.FormValue("date"); d != "" {
+		day, err = civil.ParseDate(d)
+		if err != nil {
+			return fmt.Errorf("%w: date: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if s.cfg.ExportURI == "" {
+		return errors.New("exports are disabled: GO_ECOSYSTEM_EXPORT_URI is not set")
+	}
+	if s.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	ctx := r.Context()
+	for _, t := range exportTables {
+		start := time.Now()
+		if err := s.bqClient.ExportDay(ctx, t, s.cfg.ExportURI, day); err != nil {
+			return err
+		}
+		log.Infof(ctx, "exported rows of %s from %s in %s", t, day, time.Since(start))
+	}
+	fmt.Fprintf(w, "exported %s\n", day)
+	return nil
+}
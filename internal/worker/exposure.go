@@ -0,0 +1,79 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// handleUpdateExposure recomputes the govulncheck-exposure table.
+// It is meant to be called periodically by a scheduler.
+//
+// It is triggered by path /govulncheck/update-exposure.
+func (h *GovulncheckServer) handleUpdateExposure(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleUpdateExposure")
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	start := time.Now()
+	if err := govulncheck.UpdateExposures(r.Context(), h.bqClient); err != nil {
+		return err
+	}
+	log.Infof(r.Context(), "updated exposures in %s", time.Since(start))
+	return nil
+}
+
+// exposureResponse is an exposure as served by handleExposure.
+type exposureResponse struct {
+	*govulncheck.Exposure
+	// Duration is the exposure duration, formatted.
+	Duration string
+	// Findings are the findings of the OSV entry, by fingerprint.
+	Findings []*govulncheck.FindingSighting `json:",omitempty"`
+}
+
+// handleExposure serves how long a module has been exposed to each OSV
+// entry that affects or affected it, and when each finding of the entry
+// was first and last seen.
+//
+// It is triggered by path /govulncheck/exposure?module=M.
+func (h *GovulncheckServer) handleExposure(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleExposure")
+	module := r.FormValue("module")
+	if module == "" {
+		return fmt.Errorf("%w: need module query param", derrors.InvalidArgument)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	exps, err := govulncheck.ReadExposures(r.Context(), h.bqClient, module)
+	if err != nil {
+		return err
+	}
+	sightings, err := govulncheck.ReadFindingSightings(r.Context(), h.bqClient, module)
+	if err != nil {
+		return err
+	}
+	type key struct{ mode, osv string }
+	findings := map[key][]*govulncheck.FindingSighting{}
+	for _, s := range sightings {
+		k := key{s.ScanMode, s.OSVID}
+		findings[k] = append(findings[k], s)
+	}
+	var res []exposureResponse
+	for _, e := range exps {
+		res = append(res, exposureResponse{e, (time.Duration(e.ExposureSeconds) * time.Second).String(),
+			findings[key{e.ScanMode, e.OSVID}]})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, res)
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+// extractBlobsBucketDir is the directory of the binary bucket holding the
+// blobs scanned in EXTRACT mode.
+const extractBlobsBucketDir = "extract-blobs"
+
+// extractSecondsKey is the key of the object metadata in which the
+// pipeline that extracted a blob records how long that took, in seconds.
+const extractSecondsKey = "extract-seconds"
+
+// extractSeconds returns the extraction time recorded in the metadata of
+// a blob's object, if any.
+func extractSeconds(metadata map[string]string) (float64, bool) {
+	v, ok := metadata[extractSecondsKey]
+	if !ok {
+		return 0, false
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return secs, true
+}
+
+// runScanBlob downloads the blob of sreq from the binary bucket and scans
+// it in binary mode. The binary is neither downloaded nor built. The time
+// it took to extract the blob, if recorded, is stored in row.
+func (s *scanner) runScanBlob(ctx context.Context, sreq *govulncheck.Request, row *govulncheck.Result, stats *govulncheck.ScanStats) (_ []*govulncheckapi.Finding, _ []*osv.Entry, err error) {
+	dir := moduleDir(sreq.Module, row.Version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	defer derrors.Cleanup(&err, func() error { return os.RemoveAll(dir) })
+
+	s.enterPhase(phaseDownloading)
+	name := path.Join(extractBlobsBucketDir, sreq.Blob)
+	attrs, err := s.gcsBucket.Object(name).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			err = fmt.Errorf("%s: %v: %w", name, err, derrors.ExtractBlobError)
+		}
+		return nil, nil, err
+	}
+	if secs, ok := extractSeconds(attrs.Metadata); ok {
+		row.ExtractSeconds = bigquery.NullFloat(secs)
+	}
+	blobPath := filepath.Join(dir, sreq.Blob)
+	if err := copyToLocalFile(blobPath, false, name, gcsOpenFileFunc(ctx, s.gcsBucket)); err != nil {
+		return nil, nil, err
+	}
+	ok, err := govulncheck.IsExtractBlob(blobPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: not written by govulncheck -mode=extract: %w", name, derrors.ExtractBlobError)
+	}
+
+	s.enterPhase(phaseScanning)
+	return s.runGovulncheckScan(ctx, blobPath, ModeExtract, false, stats)
+}
@@ -0,0 +1,27 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import "testing"
+
+func TestExtractSeconds(t *testing.T) {
+	for _, test := range []struct {
+		metadata map[string]string
+		want     float64
+		wantOK   bool
+	}{
+		{map[string]string{extractSecondsKey: "1.5"}, 1.5, true},
+		{map[string]string{extractSecondsKey: "0"}, 0, true},
+		{map[string]string{extractSecondsKey: "-1"}, 0, false},
+		{map[string]string{extractSecondsKey: "soon"}, 0, false},
+		{map[string]string{"other": "1"}, 0, false},
+		{nil, 0, false},
+	} {
+		got, ok := extractSeconds(test.metadata)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%v: got (%v, %t), want (%v, %t)", test.metadata, got, ok, test.want, test.wantOK)
+		}
+	}
+}
@@ -7,11 +7,19 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"golang.org/x/exp/slices"
 	"golang.org/x/pkgsite-metrics/internal"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/log"
@@ -21,13 +29,47 @@ type GovulncheckServer struct {
 	*Server
 	storedWorkStates map[[2]string]*govulncheck.WorkState
 	workVersion      *govulncheck.WorkVersion
+	osvFilter        *govulncheck.OSVFilter // set along with workVersion
+	scrubber         *govulncheck.Scrubber  // set along with workVersion
+	vulnDBEntryCount int                    // set along with workVersion
+	vulnDBDirs       []string               // set along with workVersion
+	// vulnDBCaches are the caches of the vuln DBs in vulnDBDirs that are
+	// served over HTTPS. Set along with workVersion.
+	vulnDBCaches []*govulncheck.VulnDBCache
+	// toolchains are the Go toolchains that modules can be scanned with,
+	// the first of which is the default. Set along with workVersion.
+	toolchains []goToolchain
+	majorPaths *majorPathResolver
+	// corpusHashes maps run suffixes to the corpus hash of the first
+	// task of the run that this instance handled. Guarded by mu.
+	corpusHashes map[string]string
+	// osvCache holds the entries of the vuln DB. It is nil if the
+	// server has no configuration.
+	osvCache *govulncheck.OSVCache
+	// runEvents records run events. It is nil if there is no BigQuery
+	// client.
+	runEvents *runEventLog
+	// deferrals decides which scans to defer. It is nil if there is no
+	// BigQuery client.
+	deferrals *deferralScheduler
 }
 
 func newGovulncheckServer(s *Server) *GovulncheckServer {
-	return &GovulncheckServer{
+	h := &GovulncheckServer{
 		Server:           s,
 		storedWorkStates: make(map[[2]string]*govulncheck.WorkState),
+		majorPaths:       newMajorPathResolver(s.proxyClient, resolutionTTL, s.now),
+		corpusHashes:     map[string]string{},
+		runEvents:        newRunEventLog(s.bqClient),
+		deferrals:        newDeferralScheduler(s.bqClient, s.now),
 	}
+	if s.cfg != nil {
+		dir := s.cfg.VulnDBDir
+		h.osvCache = govulncheck.NewOSVCache(dir, govulncheck.VulnDBURL, func() (time.Time, error) {
+			return dbLastModified(dir)
+		}, s.now)
+	}
+	return h
 }
 
 func (h *GovulncheckServer) getWorkVersion(ctx context.Context) (_ *govulncheck.WorkVersion, err error) {
@@ -36,32 +78,124 @@ func (h *GovulncheckServer) getWorkVersion(ctx context.Context) (_ *govulncheck.
 	defer h.mu.Unlock()
 
 	if h.workVersion == nil {
-		lmt, err := dbLastModified(h.cfg.VulnDBDir)
+		dirs := configuredVulnDBDirs(h.cfg)
+		lmt, dbsHash, n, err := readVulnDBs(dirs)
 		if err != nil {
 			return nil, err
 		}
+		caches, err := startVulnDBCaches(dirs, h.now)
+		if err != nil {
+			return nil, err
+		}
+		h.vulnDBDirs = dirs
+		h.vulnDBCaches = caches
+		h.vulnDBEntryCount = n
 		goEnv, err := internal.GoEnv()
 		if err != nil {
 			return nil, err
 		}
+		toolchains, err := installedToolchains(h.cfg.GoToolchains, goEnv["GOVERSION"])
+		if err != nil {
+			return nil, err
+		}
+		h.toolchains = toolchains
+		filter, err := readOSVFilter(ctx, h.cfg.OSVFilter)
+		if err != nil {
+			return nil, err
+		}
+		h.osvFilter = filter
+		scrubber, err := newScrubber(ctx, h.cfg)
+		if err != nil {
+			return nil, err
+		}
+		h.scrubber = scrubber
+		// Record the version of the default binary, so that rolling out
+		// a new govulncheck invalidates prior work.
+		toolVersion, err := govulncheckBinaryVersion(filepath.Join(h.cfg.BinaryDir, "govulncheck"))
+		if err != nil {
+			return nil, err
+		}
 		h.workVersion = &govulncheck.WorkVersion{
 			GoVersion:          goEnv["GOVERSION"],
 			VulnDBLastModified: lmt,
 			WorkerVersion:      h.cfg.VersionID,
 			SchemaVersion:      govulncheck.SchemaVersion,
+			OSVFilterHash:      osvFilterHash(filter),
+			VulnDBsHash:        dbsHash,
+		}
+		if toolVersion != "" {
+			h.workVersion.GovulncheckVersion = bigquery.NullString(toolVersion)
 		}
 		log.Infof(ctx, "govulncheck work version: %+v", h.workVersion)
 	}
 	return h.workVersion, nil
 }
 
+// configuredVulnDBDirs returns the directories of the configured vuln DBs,
+// in order.
+func configuredVulnDBDirs(cfg *config.Config) []string {
+	return append([]string{cfg.VulnDBDir}, govulncheck.SplitVulnDBDirs(cfg.ExtraVulnDBDirs)...)
+}
+
+// startVulnDBCaches starts a cache for each of the vuln DBs in dirs that
+// is served over HTTPS.
+func startVulnDBCaches(dirs []string, now func() time.Time) ([]*govulncheck.VulnDBCache, error) {
+	var caches []*govulncheck.VulnDBCache
+	for _, d := range dirs {
+		if !govulncheck.IsVulnDBURL(d) {
+			continue
+		}
+		c := govulncheck.NewVulnDBCache(d, now)
+		if err := c.Start(); err != nil {
+			for _, c := range caches {
+				c.Close()
+			}
+			return nil, err
+		}
+		caches = append(caches, c)
+	}
+	return caches, nil
+}
+
+// allowedVulnDBDir reports whether a scan request may use the vuln DB
+// in dir.
+func allowedVulnDBDir(cfg *config.Config, dir string) bool {
+	return slices.Contains(configuredVulnDBDirs(cfg), dir) ||
+		slices.Contains(govulncheck.SplitVulnDBDirs(cfg.AllowedVulnDBDirs), dir)
+}
+
+// readVulnDBs reads the vuln DBs in dirs. It returns the last-modified
+// time of the first, which is the one scans are compared by, a hash of all
+// of them for a WorkVersion if there is more than one, and their total
+// number of entries.
+func readVulnDBs(dirs []string) (lmt time.Time, hash bq.NullString, n int, err error) {
+	defer derrors.Wrap(&err, "readVulnDBs(%v)", dirs)
+	var mods []time.Time
+	for _, d := range dirs {
+		m, err := dbLastModified(d)
+		if err != nil {
+			return time.Time{}, bq.NullString{}, 0, err
+		}
+		c, err := dbEntryCount(d)
+		if err != nil {
+			return time.Time{}, bq.NullString{}, 0, err
+		}
+		mods = append(mods, m)
+		n += c
+	}
+	if len(dirs) > 1 {
+		hash = bigquery.NullString(govulncheck.VulnDBsHash(dirs, mods))
+	}
+	return mods[0], hash, n, nil
+}
+
 // dbLastModified computes the last modified time stamp of
-// vulnerability database rooted at vulnDB.
+// vulnerability database rooted at vulnDB, which is a local directory
+// or an HTTPS URL.
 //
 // Follows the logic of golang.org/x/internal/client/client.go:Client.LastModifiedTime.
 func dbLastModified(vulnDB string) (time.Time, error) {
-	dbFile := filepath.Join(vulnDB, "index/db.json")
-	b, err := os.ReadFile(dbFile)
+	b, err := govulncheck.ReadVulnDBFile(vulnDB, "index/db.json")
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -82,3 +216,98 @@ func dbLastModified(vulnDB string) (time.Time, error) {
 
 	return dbm.Modified, nil
 }
+
+// dbEntryCount returns the number of entries in the vulnerability
+// database rooted at vulnDB, according to its index.
+func dbEntryCount(vulnDB string) (int, error) {
+	b, err := govulncheck.ReadVulnDBFile(vulnDB, "index/vulns.json")
+	if err != nil {
+		return 0, err
+	}
+	var entries []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// readOSVFilter reads an OSV filter from loc, which is either a local
+// file or a GCS object of the form gs://BUCKET/OBJECT.
+// It returns nil if loc is empty.
+func readOSVFilter(ctx context.Context, loc string) (_ *govulncheck.OSVFilter, err error) {
+	defer derrors.Wrap(&err, "readOSVFilter(%q)", loc)
+	if loc == "" {
+		return nil, nil
+	}
+	data, err := readLocation(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	return govulncheck.ParseOSVFilter(string(data))
+}
+
+// readLocation reads the contents of loc, which is either a local
+// file or a GCS object of the form gs://BUCKET/OBJECT.
+func readLocation(ctx context.Context, loc string) (data []byte, err error) {
+	if bucketObj, ok := strings.CutPrefix(loc, "gs://"); ok {
+		bucket, object, _ := strings.Cut(bucketObj, "/")
+		c, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		r, err := c.Bucket(bucket).Object(object).NewReader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return os.ReadFile(loc)
+}
+
+// osvFilterHash returns the hash of f for a WorkVersion.
+func osvFilterHash(f *govulncheck.OSVFilter) bq.NullString {
+	if f == nil {
+		return bq.NullString{}
+	}
+	return bigquery.NullString(f.Hash())
+}
+
+// newScrubber returns the scrubber for results, or nil if
+// cfg.ScrubSecret is empty.
+func newScrubber(ctx context.Context, cfg *config.Config) (_ *govulncheck.Scrubber, err error) {
+	defer derrors.Wrap(&err, "newScrubber")
+	if cfg.ScrubSecret == "" {
+		return nil, nil
+	}
+	key, err := internal.GetSecret(ctx, cfg.ScrubSecret)
+	if err != nil {
+		return nil, err
+	}
+	return govulncheck.NewScrubber([]byte(key), cfg.ScrubFields)
+}
+
+// checkCorpusHash logs an error if sreq's corpus hash differs from that of
+// the earlier tasks of its run handled by this instance. That happens if
+// a run is enqueued again from a different corpus, for example when an
+// interrupted enqueue is resumed after the module file changed.
+func (h *GovulncheckServer) checkCorpusHash(ctx context.Context, sreq *govulncheck.Request) {
+	if sreq.CorpusHash == "" {
+		return
+	}
+	h.mu.Lock()
+	prev, ok := h.corpusHashes[sreq.QueryParams.Suffix]
+	if !ok {
+		h.corpusHashes[sreq.QueryParams.Suffix] = sreq.CorpusHash
+	}
+	h.mu.Unlock()
+	if ok && prev != sreq.CorpusHash {
+		h.runEvents.add(ctx, govulncheck.NewEvent(sreq.QueryParams.Suffix, govulncheck.EventCorpusMismatch, govulncheck.SeverityError,
+			fmt.Sprintf("%s was enqueued from a different corpus: hash %s differs from the run's %s", sreq.Path(), sreq.CorpusHash, prev),
+			map[string]string{"module": sreq.Path(), "corpus_hash": sreq.CorpusHash, "run_corpus_hash": prev}))
+		h.flushRunEvents(ctx)
+	}
+}
@@ -11,12 +11,17 @@ import (
 	"net/http"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/scan"
+	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
 // handleEnqueue enqueues multiple modules for a single govulncheck mode.
@@ -35,21 +40,161 @@ func (h *GovulncheckServer) enqueue(r *http.Request, allModes bool) error {
 	if err := scan.ParseParams(r, params); err != nil {
 		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
 	}
+	if err := govulncheck.ValidateSuffix(params.Suffix); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
 	modes, err := listModes(params.Mode, allModes)
 	if err != nil {
 		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
 	}
-	tasks, err := createGovulncheckQueueTasks(ctx, h.cfg, params, modes)
+	run, err := newRun(params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	start, err := dispatchStart(params, h.now())
+	if err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if run.Defers() && h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	var resolver *majorPathResolver
+	if !params.NoMajor {
+		resolver = h.majorPaths
+	}
+	policy, err := h.policy.get(ctx)
 	if err != nil {
 		return err
 	}
-	return enqueueTasks(ctx, tasks, h.queue,
-		&queue.Options{Namespace: "govulncheck", TaskNameSuffix: params.Suffix})
+	tasks, err := createGovulncheckQueueTasks(ctx, h.cfg, resolver, policy, params, modes)
+	if err != nil {
+		return err
+	}
+	hash := setCorpusHash(tasks)
+	log.Infof(ctx, "run %q: corpus hash %s", params.Suffix, hash)
+	if params.DryRun {
+		log.Infof(ctx, "dry run: would enqueue %d tasks", len(tasks))
+		return nil
+	}
+	// Record the run before its scans need it.
+	run.Modules = countModules(tasks)
+	run.CorpusHash = hash
+	if h.bqClient != nil {
+		if err := h.bqClient.Upload(ctx, govulncheck.RunTableName, run); err != nil {
+			return err
+		}
+	}
+	if err := h.prefetchWorkStates(ctx, tasks); err != nil {
+		// Scans read their own work state if it isn't cached.
+		log.Errorf(ctx, err, "prefetching work states for run %q", params.Suffix)
+	}
+	if wv, err := h.getWorkVersion(ctx); err != nil {
+		// Without idempotency keys, tasks are named by their params and
+		// suffix, as before.
+		log.Errorf(ctx, err, "getting work version for run %q", params.Suffix)
+	} else {
+		setWorkVersionHash(tasks, wv.Hash())
+	}
+	var scheduleTimes []time.Time
+	if params.Spread > 0 || params.Start != "" {
+		scheduleTimes = scheduleByImportedBy(tasks, start, time.Duration(params.Spread)*time.Minute)
+		log.Infof(ctx, "spreading %d tasks over %d minutes from %s", len(tasks), params.Spread, start.Format(time.RFC3339))
+	}
+	err = h.enqueueTasks(ctx, tasks,
+		&queue.Options{Namespace: "govulncheck", TaskNameSuffix: params.Suffix}, scheduleTimes)
+	if err != nil {
+		return err
+	}
+	defer h.flushRunEvents(ctx)
+	h.runEvents.add(ctx, govulncheck.NewEvent(params.Suffix, govulncheck.EventEnqueued, govulncheck.SeverityInfo,
+		fmt.Sprintf("enqueued %d tasks in modes %v", len(tasks), modes),
+		map[string]any{"tasks": len(tasks), "modes": modes, "corpus_hash": hash, "file": params.File, "min": params.Min}))
+	return nil
+}
+
+// newRun returns the row of the govulncheck-runs table for an enqueue
+// request with params, without the fields that depend on its tasks.
+func newRun(params *govulncheck.EnqueueQueryParams) (*govulncheck.Run, error) {
+	run := &govulncheck.Run{Suffix: params.Suffix}
+	if params.Deadline != "" {
+		d, err := time.Parse(time.RFC3339, params.Deadline)
+		if err != nil {
+			return nil, fmt.Errorf("deadline: %v", err)
+		}
+		run.Deadline = bigquery.NullTimestamp(d)
+	}
+	if params.DeferBelow < 0 || params.DeferRefresh < 0 {
+		return nil, errors.New("deferbelow and deferrefresh must not be negative")
+	}
+	if params.DeferBelow > 0 {
+		if params.Deadline == "" {
+			return nil, errors.New("deferbelow requires a deadline")
+		}
+		run.DeferBelow = bigquery.NullInt(params.DeferBelow)
+	}
+	if params.DeferRefresh > 0 {
+		run.DeferRefreshMinutes = bigquery.NullInt(params.DeferRefresh)
+	}
+	return run, nil
+}
+
+// countModules returns the number of distinct modules in tasks.
+func countModules(tasks []queue.Task) int {
+	mods := map[string]bool{}
+	for _, t := range tasks {
+		if r, ok := t.(*govulncheck.Request); ok {
+			mods[r.Module] = true
+		}
+	}
+	return len(mods)
+}
+
+// dispatchStart returns the time from which the tasks of an enqueue
+// request with params are dispatched: its Start, or now. It returns an
+// error if the last task would be scheduled too far in the future for
+// the queue.
+func dispatchStart(params *govulncheck.EnqueueQueryParams, now time.Time) (time.Time, error) {
+	if params.Spread < 0 {
+		return time.Time{}, errors.New("spread must not be negative")
+	}
+	start := now
+	if params.Start != "" {
+		t, err := time.Parse(time.RFC3339, params.Start)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("start: %v", err)
+		}
+		if t.After(now) {
+			start = t
+		}
+	}
+	end := start.Add(time.Duration(params.Spread) * time.Minute)
+	if end.Sub(now) > queue.MaxScheduleDelay {
+		return time.Time{}, fmt.Errorf("tasks would be scheduled until %s, more than %s from now",
+			end.Format(time.RFC3339), queue.MaxScheduleDelay)
+	}
+	return start, nil
+}
+
+// scheduleByImportedBy sorts tasks so that the most imported modules come
+// first, and returns schedule times for them spread over window.
+func scheduleByImportedBy(tasks []queue.Task, start time.Time, window time.Duration) []time.Time {
+	importedBy := func(t queue.Task) int {
+		if r, ok := t.(*govulncheck.Request); ok {
+			return r.ImportedBy
+		}
+		return 0
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return importedBy(tasks[i]) > importedBy(tasks[j])
+	})
+	return spreadTimes(start, window, len(tasks), func(i int) float64 {
+		return taskJitter(tasks[i])
+	})
 }
 
 // listModes lists all applicable modes depending on who called it. If enqueue did (allModes=false),
 // returns only valid modeParam. If enqueueAll did (allModes=true), returns modes that enqueueAll
-// supports, which are modes/{ModeCompare}.
+// supports, which are modes/{ModeCompare, ModeImports, ModeExtract}.
 func listModes(modeParam string, allModes bool) ([]string, error) {
 	if allModes {
 		if modeParam != "" {
@@ -57,8 +202,10 @@ func listModes(modeParam string, allModes bool) ([]string, error) {
 		}
 		var ms []string
 		for k := range modes {
-			// Don't add ModeCompare to enqueueAll (it's something we only want to run occasionally)
-			if k != ModeCompare {
+			// Don't add ModeCompare to enqueueAll (it's something we only want to run occasionally),
+			// ModeImports, whose rows ModeGovulncheck already writes,
+			// or ModeExtract, which needs a blob for each module.
+			if k != ModeCompare && k != ModeImports && k != ModeExtract {
 				ms = append(ms, k)
 			}
 		}
@@ -72,11 +219,16 @@ func listModes(modeParam string, allModes bool) ([]string, error) {
 	return []string{mode}, nil
 }
 
-func createGovulncheckQueueTasks(ctx context.Context, cfg *config.Config, params *govulncheck.EnqueueQueryParams, modes []string) (_ []queue.Task, err error) {
+// createGovulncheckQueueTasks creates scan tasks for each mode.
+// If resolver is non-nil, modules at their latest version are
+// replaced by their highest major version.
+// Modules denied by policy are omitted.
+func createGovulncheckQueueTasks(ctx context.Context, cfg *config.Config, resolver *majorPathResolver, policy *scan.Policy, params *govulncheck.EnqueueQueryParams, modes []string) (_ []queue.Task, err error) {
 	defer derrors.Wrap(&err, "createGovulncheckQueueTasks(%v)", modes)
 	var (
-		tasks    []queue.Task
-		modspecs []scan.ModuleSpec
+		tasks      []queue.Task
+		modspecs   []scan.ModuleSpec
+		majorPaths map[string]string
 	)
 	for _, mode := range modes {
 		if modspecs == nil {
@@ -84,18 +236,73 @@ func createGovulncheckQueueTasks(ctx context.Context, cfg *config.Config, params
 			if err != nil {
 				return nil, err
 			}
+			if resolver != nil {
+				majorPaths = latestMajorPaths(ctx, resolver, modspecs)
+			}
 		}
-		reqs := moduleSpecsToGovulncheckScanRequests(modspecs, mode)
+		reqs := moduleSpecsToGovulncheckScanRequests(modspecs, mode, params.Suffix)
+		ndenied := 0
 		for _, req := range reqs {
-			if req.Module != "std" { // ignore the standard library
-				tasks = append(tasks, req)
+			if req.Module == "std" { // ignore the standard library
+				continue
+			}
+			if p, ok := majorPaths[req.Module]; ok {
+				req.BasePath = req.Module
+				req.Module = p
 			}
+			if policy.Denies(req.Module) || policy.Denies(req.BasePath) {
+				ndenied++
+				continue
+			}
+			tasks = append(tasks, req)
+		}
+		if ndenied > 0 {
+			log.Infof(ctx, "mode %s: omitted %d modules denied by policy %s", mode, ndenied, policy.Hash())
 		}
 	}
 	return tasks, nil
 }
 
-func moduleSpecsToGovulncheckScanRequests(modspecs []scan.ModuleSpec, mode string) []*govulncheck.Request {
+// latestMajorPaths resolves higher major versions of the modules
+// in modspecs that are requested at their latest version. It returns a map
+// from module path to the path of its highest major version, for the modules
+// where the two differ. Probing errors are logged and the module is left
+// unchanged.
+func latestMajorPaths(ctx context.Context, resolver *majorPathResolver, modspecs []scan.ModuleSpec) map[string]string {
+	const concurrentProbes = 20
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		paths = map[string]string{}
+	)
+	sem := make(chan struct{}, concurrentProbes)
+	for _, ms := range modspecs {
+		if ms.Version != version.Latest || ms.Path == "std" {
+			continue
+		}
+		ms := ms
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			p, err := resolver.resolve(ctx, ms.Path)
+			if err != nil {
+				log.Errorf(ctx, err, "probing major versions of %s", ms.Path)
+				return
+			}
+			if p != ms.Path {
+				mu.Lock()
+				paths[ms.Path] = p
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	log.Infof(ctx, "found %d modules with a higher major version", len(paths))
+	return paths
+}
+
+func moduleSpecsToGovulncheckScanRequests(modspecs []scan.ModuleSpec, mode, suffix string) []*govulncheck.Request {
 	var sreqs []*govulncheck.Request
 	for _, ms := range modspecs {
 		sreqs = append(sreqs, &govulncheck.Request{
@@ -106,6 +313,7 @@ func moduleSpecsToGovulncheckScanRequests(modspecs []scan.ModuleSpec, mode strin
 			QueryParams: govulncheck.QueryParams{
 				ImportedBy: ms.ImportedBy,
 				Mode:       mode,
+				Suffix:     suffix,
 			},
 		})
 	}
@@ -121,5 +329,182 @@ func govulncheckMode(mode string) (string, error) {
 	if _, ok := modes[mode]; !ok {
 		return "", fmt.Errorf("unsupported mode: %v", mode)
 	}
+	if mode == ModeExtract {
+		return "", fmt.Errorf("%s mode scans one blob at a time and can't be enqueued", ModeExtract)
+	}
 	return mode, nil
 }
+
+// retryReport is the response of handleEnqueueErrored.
+type retryReport struct {
+	Source   string
+	Suffix   string
+	Category string
+	// Selected is the number of tasks enqueued, or that would be in a
+	// dry run.
+	Selected int
+	DryRun   bool
+}
+
+// defaultRetryQuiet is the default number of minutes a run must have
+// written no rows before its errored modules can be enqueued again.
+const defaultRetryQuiet = 30
+
+// handleEnqueueErrored enqueues the module versions that errored in a
+// previous run, with the mode and params recorded on their rows.
+// It refuses to enqueue while the previous run may still be in progress,
+// that is, if it wrote rows in the last Quiet minutes.
+//
+// It is triggered by path /govulncheck/enqueue-errored?params.
+// See govulncheck.RetryQueryParams for the query params.
+func (h *GovulncheckServer) handleEnqueueErrored(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleEnqueueErrored")
+
+	ctx := r.Context()
+	params := &govulncheck.RetryQueryParams{Quiet: defaultRetryQuiet}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Source == "" || params.Suffix == "" {
+		return fmt.Errorf("%w: need source and suffix query params", derrors.InvalidArgument)
+	}
+	if params.Source == params.Suffix {
+		return fmt.Errorf("%w: suffix must differ from source", derrors.InvalidArgument)
+	}
+	for _, s := range []string{params.Source, params.Suffix} {
+		if err := govulncheck.ValidateSuffix(s); err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if err := govulncheck.ValidateCategory(params.Category); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	last, err := govulncheck.ReadLastWrite(ctx, h.bqClient, params.Source)
+	if err != nil {
+		return err
+	}
+	if last.IsZero() {
+		return fmt.Errorf("%w: run %q has no rows", derrors.NotFound, params.Source)
+	}
+	if err := checkQuiet(params.Source, last, h.now(), time.Duration(params.Quiet)*time.Minute); err != nil {
+		return err
+	}
+	mods, err := govulncheck.ReadErroredModules(ctx, h.bqClient, params.Source, params.Category)
+	if err != nil {
+		return err
+	}
+	tasks := retryTasks(mods, params.Suffix)
+	hash := setCorpusHash(tasks)
+	log.Infof(ctx, "run %q: corpus hash %s", params.Suffix, hash)
+	log.Infof(ctx, "enqueue errored: %d tasks from %d errored rows of run %q (category %q, dry run: %t)",
+		len(tasks), len(mods), params.Source, params.Category, params.DryRun)
+	if !params.DryRun && len(tasks) > 0 {
+		err := h.enqueueTasks(ctx, tasks,
+			&queue.Options{Namespace: "govulncheck", TaskNameSuffix: params.Suffix}, nil)
+		if err != nil {
+			return err
+		}
+		defer h.flushRunEvents(ctx)
+		h.runEvents.add(ctx, govulncheck.NewEvent(params.Suffix, govulncheck.EventRetryEnqueued, govulncheck.SeverityInfo,
+			fmt.Sprintf("enqueued %d errored tasks of run %q", len(tasks), params.Source),
+			map[string]any{"tasks": len(tasks), "source": params.Source, "category": params.Category, "corpus_hash": hash}))
+	}
+	return writeJSON(w, &retryReport{
+		Source:   params.Source,
+		Suffix:   params.Suffix,
+		Category: params.Category,
+		Selected: len(tasks),
+		DryRun:   params.DryRun,
+	})
+}
+
+// checkQuiet returns an error if the run with the given suffix, which last
+// wrote a row at last, wrote it less than quiet before now, since the run
+// may still be in progress.
+func checkQuiet(suffix string, last, now time.Time, quiet time.Duration) error {
+	if since := now.Sub(last); since < quiet {
+		return fmt.Errorf("%w: run %q wrote a row %s ago and may still be in progress",
+			derrors.InvalidArgument, suffix, since.Round(time.Second))
+	}
+	return nil
+}
+
+// setCorpusHash sets the corpus hash of each of tasks to the hash of the
+// module versions of all of them, and returns it.
+func setCorpusHash(tasks []queue.Task) string {
+	var modules []string
+	seen := map[string]bool{}
+	for _, t := range tasks {
+		if !seen[t.Name()] {
+			seen[t.Name()] = true
+			modules = append(modules, t.Name())
+		}
+	}
+	hash := govulncheck.CorpusHash(modules)
+	for _, t := range tasks {
+		if r, ok := t.(*govulncheck.Request); ok {
+			r.CorpusHash = hash
+		}
+	}
+	return hash
+}
+
+// setWorkVersionHash sets the WorkVersionHash of the scan requests in
+// tasks to hash, giving them idempotency keys, so that the queue rejects
+// the tasks of the same work that another run enqueued recently instead
+// of scanning the modules and writing their rows twice. Retries and
+// requeues of failed tasks don't have keys: their work was attempted
+// already.
+func setWorkVersionHash(tasks []queue.Task, hash string) {
+	for _, t := range tasks {
+		if r, ok := t.(*govulncheck.Request); ok {
+			r.WorkVersionHash = hash
+		}
+	}
+}
+
+// retryTasks returns a scan task for each module version and request mode
+// in mods, with the given suffix. The module is not probed for higher
+// major versions again, since the row already records the result of
+// probing. IMPORTS rows of a module version that also has a GOVULNCHECK
+// row were written by the GOVULNCHECK scan, so they don't get a task of
+// their own.
+func retryTasks(mods []*govulncheck.ErroredModule, suffix string) []queue.Task {
+	seen := map[string]bool{}
+	for _, m := range mods {
+		if m.ScanMode == ModeGovulncheck {
+			seen[m.ModulePath+"@"+m.Version+" "+ModeImports] = true
+		}
+	}
+	var tasks []queue.Task
+	for _, m := range mods {
+		mode := govulncheck.RequestMode(m.ScanMode)
+		if mode == "" {
+			continue
+		}
+		key := m.ModulePath + "@" + m.Version + " " + mode
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		tasks = append(tasks, &govulncheck.Request{
+			ModuleURLPath: scan.ModuleURLPath{
+				Module:  m.ModulePath,
+				Version: m.Version,
+			},
+			QueryParams: govulncheck.QueryParams{
+				ImportedBy:   m.ImportedBy,
+				Mode:         mode,
+				Suffix:       suffix,
+				NoMajor:      true,
+				BasePath:     m.RequestedModulePath.StringVal,
+				NoVendor:     m.IgnoredVendor.Valid && m.IgnoredVendor.Bool,
+				DropReplaces: m.ReplacedDropped.Valid && m.ReplacedDropped.Bool,
+			},
+		})
+	}
+	return tasks
+}
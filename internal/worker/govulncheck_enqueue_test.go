@@ -6,11 +6,16 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/clock"
 	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/scan"
@@ -25,7 +30,7 @@ func TestCreateQueueTasks(t *testing.T) {
 	}
 
 	params := &govulncheck.EnqueueQueryParams{Min: 8, File: "testdata/modules.txt"}
-	gotTasks, err := createGovulncheckQueueTasks(context.Background(), &config.Config{}, params, []string{ModeGovulncheck})
+	gotTasks, err := createGovulncheckQueueTasks(context.Background(), &config.Config{}, nil, nil, params, []string{ModeGovulncheck})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -42,7 +47,7 @@ func TestCreateQueueTasks(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, params, allModes)
+	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, nil, nil, params, allModes)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,6 +60,21 @@ func TestCreateQueueTasks(t *testing.T) {
 	if diff := cmp.Diff(wantTasks, gotTasks, cmp.AllowUnexported(govulncheck.Request{})); diff != "" {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
+
+	policy, err := scan.ParsePolicy("# denied\ngithub.com/pkg\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, nil, policy, params, []string{ModeGovulncheck})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTasks = []queue.Task{
+		vreq("golang.org/x/net", "v0.4.0", ModeGovulncheck, 20),
+	}
+	if diff := cmp.Diff(wantTasks, gotTasks, cmp.AllowUnexported(govulncheck.Request{})); diff != "" {
+		t.Errorf("with policy: mismatch (-want, +got):\n%s", diff)
+	}
 }
 
 func TestListModes(t *testing.T) {
@@ -65,8 +85,10 @@ func TestListModes(t *testing.T) {
 		wantErr bool
 	}{
 		{"", true, []string{ModeGovulncheck}, false},
+		{"imports", false, []string{ModeImports}, false},
 		{"", false, []string{ModeGovulncheck}, false},
 		{"imports", true, nil, true},
+		{"extract", false, nil, true},
 	} {
 		t.Run(fmt.Sprintf("%q,%t", test.param, test.all), func(t *testing.T) {
 			got, err := listModes(test.param, test.all)
@@ -79,3 +101,143 @@ func TestListModes(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryTasks(t *testing.T) {
+	mods := []*govulncheck.ErroredModule{
+		{ModulePath: "example.com/a", Version: "v1.0.0", ScanMode: "GOVULNCHECK", ImportedBy: 5},
+		// Written by the same scan as the row above.
+		{ModulePath: "example.com/a", Version: "v1.0.0", ScanMode: "IMPORTS", ImportedBy: 5},
+		{ModulePath: "example.com/b/v2", Version: "v2.1.0", ScanMode: "GOVULNCHECK",
+			RequestedModulePath: bigquery.NullString("example.com/b"),
+			IgnoredVendor:       bigquery.NullBool(true),
+			ReplacedDropped:     bigquery.NullBool(true)},
+		{ModulePath: "example.com/c", Version: "v0.1.0", ScanMode: "COMPARE - BINARY"},
+		{ModulePath: "example.com/d", Version: "v0.1.0", ScanMode: govulncheck.ModeTombstone},
+		// Written by an imports-only scan.
+		{ModulePath: "example.com/e", Version: "v1.2.0", ScanMode: "IMPORTS", ImportedBy: 3},
+	}
+	req := func(path, version, mode string, qp govulncheck.QueryParams) *govulncheck.Request {
+		qp.Mode = mode
+		qp.Suffix = "retry"
+		qp.NoMajor = true
+		return &govulncheck.Request{
+			ModuleURLPath: scan.ModuleURLPath{Module: path, Version: version},
+			QueryParams:   qp,
+		}
+	}
+	want := []queue.Task{
+		req("example.com/a", "v1.0.0", ModeGovulncheck, govulncheck.QueryParams{ImportedBy: 5}),
+		req("example.com/b/v2", "v2.1.0", ModeGovulncheck, govulncheck.QueryParams{
+			BasePath:     "example.com/b",
+			NoVendor:     true,
+			DropReplaces: true,
+		}),
+		req("example.com/c", "v0.1.0", ModeCompare, govulncheck.QueryParams{}),
+		req("example.com/e", "v1.2.0", ModeImports, govulncheck.QueryParams{ImportedBy: 3}),
+	}
+	got := retryTasks(mods, "retry")
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(govulncheck.Request{})); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCheckQuiet(t *testing.T) {
+	last := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(last)
+	const quiet = 30 * time.Minute
+	for _, test := range []struct {
+		advance time.Duration
+		wantErr bool
+	}{
+		{0, true},
+		{29 * time.Minute, true},
+		{time.Minute, false}, // exactly quiet since the last write
+		{time.Hour, false},
+	} {
+		clk.Advance(test.advance)
+		err := checkQuiet("run", last, clk.Now(), quiet)
+		if got := err != nil; got != test.wantErr {
+			t.Errorf("%s after last write: got error %v, want error: %t", clk.Now().Sub(last), err, test.wantErr)
+		}
+		if err != nil && !errors.Is(err, derrors.InvalidArgument) {
+			t.Errorf("got %v, want InvalidArgument", err)
+		}
+	}
+}
+
+func TestSetCorpusHash(t *testing.T) {
+	req := func(path, mode string) *govulncheck.Request {
+		return &govulncheck.Request{
+			ModuleURLPath: scan.ModuleURLPath{Module: path, Version: "v1.0.0"},
+			QueryParams:   govulncheck.QueryParams{Mode: mode},
+		}
+	}
+	tasks := []queue.Task{req("a", ModeGovulncheck), req("b", ModeGovulncheck), req("a", ModeCompare)}
+	got := setCorpusHash(tasks)
+	if want := govulncheck.CorpusHash([]string{"a@v1.0.0", "b@v1.0.0"}); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	for _, task := range tasks {
+		if h := task.(*govulncheck.Request).CorpusHash; h != got {
+			t.Errorf("%s: got corpus hash %q, want %q", task.Name(), h, got)
+		}
+	}
+}
+
+func TestNewRun(t *testing.T) {
+	for _, test := range []struct {
+		params      govulncheck.EnqueueQueryParams
+		wantDefers  bool
+		wantRefresh time.Duration
+		wantErr     bool
+	}{
+		{govulncheck.EnqueueQueryParams{}, false, govulncheck.DefaultDeferRefreshMinutes * time.Minute, false},
+		{govulncheck.EnqueueQueryParams{Deadline: "2023-06-02T00:00:00Z"}, false, govulncheck.DefaultDeferRefreshMinutes * time.Minute, false},
+		{govulncheck.EnqueueQueryParams{Deadline: "2023-06-02T00:00:00Z", DeferBelow: 50, DeferRefresh: 3}, true, 3 * time.Minute, false},
+		{govulncheck.EnqueueQueryParams{DeferBelow: 50}, false, 0, true},
+		{govulncheck.EnqueueQueryParams{Deadline: "tomorrow"}, false, 0, true},
+		{govulncheck.EnqueueQueryParams{Deadline: "2023-06-02T00:00:00Z", DeferBelow: -1}, false, 0, true},
+	} {
+		run, err := newRun(&test.params)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%+v: got error %v, want error: %t", test.params, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := run.Defers(); got != test.wantDefers {
+			t.Errorf("%+v: Defers() = %t, want %t", test.params, got, test.wantDefers)
+		}
+		if got := run.DeferRefresh(); got != test.wantRefresh {
+			t.Errorf("%+v: DeferRefresh() = %s, want %s", test.params, got, test.wantRefresh)
+		}
+	}
+}
+
+func TestDispatchStart(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		params  govulncheck.EnqueueQueryParams
+		want    time.Time
+		wantErr bool
+	}{
+		{govulncheck.EnqueueQueryParams{}, now, false},
+		{govulncheck.EnqueueQueryParams{Spread: 3 * 24 * 60}, now, false},
+		{govulncheck.EnqueueQueryParams{Start: "2023-06-02T00:00:00Z", Spread: 60}, now.Add(12 * time.Hour), false},
+		{govulncheck.EnqueueQueryParams{Start: "2023-05-01T00:00:00Z"}, now, false}, // past starts are now
+		{govulncheck.EnqueueQueryParams{Start: "tomorrow"}, time.Time{}, true},
+		{govulncheck.EnqueueQueryParams{Spread: -1}, time.Time{}, true},
+		{govulncheck.EnqueueQueryParams{Spread: 31 * 24 * 60}, time.Time{}, true},
+		{govulncheck.EnqueueQueryParams{Start: "2023-06-25T00:00:00Z", Spread: 7 * 24 * 60}, time.Time{}, true},
+	} {
+		got, err := dispatchStart(&test.params, now)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%+v: got error %v, want error: %t", test.params, err, test.wantErr)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%+v: got %s, want %s", test.params, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/notify"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// defaultHealthHours is the default window, in hours, of recent rows
+// of an active run that are compared against the previous run.
+const defaultHealthHours = 6
+
+// handleCheckHealth compares the recent health of an active run to that of
+// a previous, completed run and sends a notification for each anomaly that
+// has not already been reported. It is meant to be called periodically by
+// a scheduler while a run is in progress.
+//
+// It is triggered by path /govulncheck/check-health?suffix=S&previous=P[&hours=N].
+func (h *GovulncheckServer) handleCheckHealth(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleCheckHealth")
+
+	ctx := r.Context()
+	params := &govulncheck.HealthQueryParams{Hours: defaultHealthHours}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Suffix == "" || params.Previous == "" {
+		return fmt.Errorf("%w: need suffix and previous query params", derrors.InvalidArgument)
+	}
+	for _, s := range []string{params.Suffix, params.Previous} {
+		if err := govulncheck.ValidateSuffix(s); err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if params.Hours <= 0 {
+		return fmt.Errorf("%w: hours must be positive", derrors.InvalidArgument)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	if _, err := h.bqClient.CreateOrUpdateTable(ctx, govulncheck.AlertTableName); err != nil {
+		return err
+	}
+
+	cur, err := govulncheck.ReadRunHealth(ctx, h.bqClient, params.Suffix, h.now().Add(-time.Duration(params.Hours)*time.Hour))
+	if err != nil {
+		return err
+	}
+	prev, err := govulncheck.ReadRunHealth(ctx, h.bqClient, params.Previous, time.Time{})
+	if err != nil {
+		return err
+	}
+	alerted, err := govulncheck.ReadAlertedMetrics(ctx, h.bqClient, params.Suffix)
+	if err != nil {
+		return err
+	}
+	th := govulncheck.Thresholds{
+		ErrorRateIncrease: h.cfg.AlertErrorRateIncrease,
+		ScanTimeRatio:     h.cfg.AlertScanTimeRatio,
+		OOMRateIncrease:   h.cfg.AlertOOMRateIncrease,
+	}
+	var alerts []*govulncheck.Alert
+	for _, a := range cur.Anomalies(prev, th) {
+		if alerted[a.Metric] {
+			continue
+		}
+		a.Suffix = params.Suffix
+		a.Previous = params.Previous
+		alerts = append(alerts, a)
+	}
+	defer h.flushRunEvents(ctx)
+	for _, a := range alerts {
+		h.runEvents.add(ctx, govulncheck.NewEvent(a.Suffix, govulncheck.EventAnomaly, govulncheck.SeverityWarning,
+			fmt.Sprintf("anomalous run health: %s", a), a))
+		if h.notifier != nil {
+			n := &notify.Notification{
+				Subject: fmt.Sprintf("govulncheck run %s: anomalous %s", a.Suffix, a.Metric),
+				Body:    a.String(),
+			}
+			if err := h.notifier.Notify(ctx, n); err != nil {
+				return err
+			}
+		}
+	}
+	if len(alerts) > 0 {
+		if err := bigquery.UploadMany(ctx, h.bqClient, govulncheck.AlertTableName, alerts, 0); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "run %s: %d rows in the last %d hours, %d new alerts\n", params.Suffix, cur.NumRows, params.Hours, len(alerts))
+	versions, err := installedGovulncheckVersions(h.cfg.BinaryDir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "installed govulncheck versions: %v (default %q)\n", versions, h.cfg.GovulncheckVersion)
+	return nil
+}
+
+// defaultDBGrowthDays is the default number of days reported by
+// handleDBGrowth.
+const defaultDBGrowthDays = 90
+
+// handleDBGrowth serves, as JSON, the daily rate of modules with called
+// vulnerabilities along with the size of the vuln DB, so that the two can
+// be plotted against each other.
+//
+// It is triggered by path /govulncheck/db-growth[?days=N].
+func (h *GovulncheckServer) handleDBGrowth(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleDBGrowth")
+
+	params := &struct{ Days int }{Days: defaultDBGrowthDays}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Days <= 0 {
+		return fmt.Errorf("%w: days must be positive", derrors.InvalidArgument)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	points, err := govulncheck.ReadDBGrowth(r.Context(), h.bqClient, h.now().AddDate(0, 0, -params.Days))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, points)
+}
+
+// confidenceRate is a govulncheck.ConfidenceRate as served by
+// handleConfidence.
+type confidenceRate struct {
+	*govulncheck.ConfidenceRate
+	CalledRate float64
+}
+
+// handleConfidence serves, as JSON, the rate of modules with called
+// vulnerabilities in a run for each analysis confidence, so that noise
+// from partially analyzed modules is visible.
+//
+// It is triggered by path /govulncheck/confidence?suffix=S.
+func (h *GovulncheckServer) handleConfidence(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleConfidence")
+
+	suffix := r.FormValue("suffix")
+	if suffix == "" {
+		return fmt.Errorf("%w: need suffix query param", derrors.InvalidArgument)
+	}
+	if err := govulncheck.ValidateSuffix(suffix); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	rates, err := govulncheck.ReadConfidenceRates(r.Context(), h.bqClient, suffix)
+	if err != nil {
+		return err
+	}
+	var res []confidenceRate
+	for _, r := range rates {
+		res = append(res, confidenceRate{r, r.CalledRate()})
+	}
+	return writeJSON(w, res)
+}
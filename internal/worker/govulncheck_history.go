@@ -0,0 +1,106 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// handleHistory serves the scan history of a module, newest first.
+// It is triggered by path /govulncheck/history/MODULE?params.
+//
+// See govulncheck.HistoryQueryParams for the query params. If there are
+// more rows, the response has a Link header with the URL of the next page.
+func (h *GovulncheckServer) handleHistory(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleHistory")
+
+	modulePath := strings.TrimPrefix(r.URL.Path, "/govulncheck/history/")
+	if modulePath != stdlibModulePath {
+		if err := module.CheckPath(modulePath); err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
+	}
+	params := &govulncheck.HistoryQueryParams{Limit: govulncheck.DefaultHistoryLimit, Format: "json"}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Limit <= 0 || params.Limit > govulncheck.MaxHistoryLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", derrors.InvalidArgument, govulncheck.MaxHistoryLimit)
+	}
+	if params.Format != "json" && params.Format != "csv" {
+		return fmt.Errorf("%w: unknown format %q", derrors.InvalidArgument, params.Format)
+	}
+	var since, until, before time.Time
+	if params.Since != "" {
+		since, err = time.Parse(time.DateOnly, params.Since)
+		if err != nil {
+			return fmt.Errorf("%w: since: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if params.Until != "" {
+		until, err = time.Parse(time.DateOnly, params.Until)
+		if err != nil {
+			return fmt.Errorf("%w: until: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if params.Before != "" {
+		before, err = time.Parse(time.RFC3339Nano, params.Before)
+		if err != nil {
+			return fmt.Errorf("%w: before: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	// Stored rows hold the scrubbed module path, if scrubbing is enabled.
+	rows, next, err := govulncheck.ReadHistory(r.Context(), h.bqClient, h.scrubber.ModulePath(modulePath),
+		since, until, before, params.Limit)
+	if err != nil {
+		return err
+	}
+	if !next.IsZero() {
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", historyNextURL(r.URL, next)))
+	}
+	if params.Format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		return writeHistoryCSV(w, rows)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if rows == nil {
+		rows = []*govulncheck.HistoryRow{} // an empty array, not null
+	}
+	return writeJSON(w, rows)
+}
+
+// historyNextURL returns u with its before param set to next.
+func historyNextURL(u *url.URL, next time.Time) string {
+	q := u.Query()
+	q.Set("before", next.UTC().Format(time.RFC3339Nano))
+	return (&url.URL{Path: u.Path, RawQuery: q.Encode()}).String()
+}
+
+// writeHistoryCSV writes rows to w as CSV, with a header row. The called
+// OSV IDs of a row are separated by spaces.
+func writeHistoryCSV(w io.Writer, rows []*govulncheck.HistoryRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"version", "created_at", "scan_mode", "error_category", "called_osvs"})
+	for _, r := range rows {
+		cw.Write([]string{r.Version, r.CreatedAt.UTC().Format(time.RFC3339Nano), r.ScanMode, r.ErrorCategory, strings.Join(r.CalledOSVs, " ")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
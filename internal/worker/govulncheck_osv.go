@@ -0,0 +1,85 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// handleOSV serves the module versions affected by an OSV entry.
+// It is triggered by path /govulncheck/osv/ID?params.
+// The response has a Link header with the URL of the list of all OSV
+// entries; see handleOSVs.
+//
+// See govulncheck.OSVQueryParams for the query params.
+func (h *GovulncheckServer) handleOSV(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleOSV")
+
+	ctx := r.Context()
+	id := strings.TrimPrefix(r.URL.Path, "/govulncheck/osv/")
+	if err := govulncheck.ValidateOSVID(id); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	params := &govulncheck.OSVQueryParams{Format: "json"}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	var since time.Time
+	if params.Since != "" {
+		since, err = time.Parse(time.DateOnly, params.Since)
+		if err != nil {
+			return fmt.Errorf("%w: since: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if params.Format != "json" && params.Format != "csv" {
+		return fmt.Errorf("%w: unknown format %q", derrors.InvalidArgument, params.Format)
+	}
+	if h.osvCache != nil {
+		// Distinguish an unknown ID from one that affects no modules.
+		if _, err := h.osvCache.Get(ctx, id); err != nil {
+			if errors.Is(err, derrors.NotFound) {
+				return fmt.Errorf("%w: no OSV entry %s", derrors.NotFound, id)
+			}
+			log.Warnf(ctx, "looking up OSV entry %s: %v", id, err)
+		}
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	matches, err := govulncheck.ReadResultsByOSV(ctx, h.bqClient, id, params.Called, params.IncludeRemoved, since)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"collection\"", osvsPath))
+	if params.Format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		return writeOSVMatchesCSV(w, matches)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, matches)
+}
+
+// writeOSVMatchesCSV writes matches to w as CSV, with a header row.
+func writeOSVMatchesCSV(w io.Writer, matches []*govulncheck.OSVMatch) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"module_path", "version", "called", "fixed_version"})
+	for _, m := range matches {
+		cw.Write([]string{m.ModulePath, m.Version, strconv.FormatBool(m.Called), m.FixedVersion.StringVal})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -11,26 +11,34 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	bq "cloud.google.com/go/bigquery"
 	"cloud.google.com/go/storage"
 	"golang.org/x/exp/event"
+	"golang.org/x/exp/maps"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
 	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/osv"
 	"golang.org/x/pkgsite-metrics/internal/proxy"
+	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/sandbox"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+	"golang.org/x/pkgsite-metrics/internal/sink"
 	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
 const (
-	// modeImports is used to report results of vulnerability detection at
-	// imports level precision. It cannot be directly triggered by scan
-	// endpoints. Instead, ModeGovulncheck mode reports its results to show
-	// difference in precision of vulnerability detection.
-	modeImports string = "IMPORTS"
+	// ModeImports runs the govulncheck binary at the level of imported
+	// packages, without call graph analysis. ModeGovulncheck also reports
+	// its results in this mode, to show the difference in precision of
+	// vulnerability detection.
+	ModeImports = govulncheck.ModeImports
 
 	// ModeGovulncheck runs the govulncheck binary in default (source) mode.
 	ModeGovulncheck = "GOVULNCHECK"
@@ -39,10 +47,19 @@ const (
 	// and binary mode.
 	ModeCompare = "COMPARE"
 
+	// ModeExtract runs the govulncheck binary in binary mode on a blob
+	// extracted from a prebuilt binary of the module with
+	// "govulncheck -mode=extract"; see runScanBlob.
+	ModeExtract = "EXTRACT"
+
 	// modeBinary is only used by ModeCompare for reporting results. It cannot
 	// be directly triggered by scan endpoints.
 	modeBinary string = "BINARY"
 
+	// modeStdlib is used to report the vulnerabilities of a Go
+	// distribution's standard library; see scanStdlib.
+	modeStdlib string = "STDLIB"
+
 	// sandboxGoCache is the location of the Go cache inside the sandbox. The
 	// user is root and their $HOME directory is /root. The Go cache resides
 	// in its default location, $HOME/.cache/go-build.
@@ -53,12 +70,16 @@ const (
 var modes = map[string]bool{
 	ModeGovulncheck: true,
 	ModeCompare:     true,
+	ModeImports:     true,
+	ModeExtract:     true,
 }
 
 func modeToGovulncheckFlag(mode string) string {
 	switch mode {
-	case modeBinary: // for sanity
+	case modeBinary, ModeExtract:
 		return govulncheck.FlagBinary
+	case ModeImports:
+		return govulncheck.FlagImports
 	default:
 		return govulncheck.FlagSource
 	}
@@ -73,49 +94,294 @@ var scanCounter = event.NewCounter("scans", &event.MetricOptions{Namespace: metr
 func (h *GovulncheckServer) handleScan(w http.ResponseWriter, r *http.Request) (err error) {
 	defer derrors.Wrap(&err, "handleScan")
 
+	defer func() {
+		if err != nil {
+			err = h.deadLetter(r.Context(), r, err)
+		}
+	}()
 	defer func() {
 		scanCounter.Record(r.Context(), 1, event.Bool("success", err == nil))
 	}()
 
 	ctx := r.Context()
-	sreq, err := govulncheck.ParseRequest(r, "/govulncheck/scan")
+	sreq, err := govulncheck.ParseRequest(r, govulncheckScanPrefix)
 	if err != nil {
-		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		return err
 	}
+	if len(sreq.UnknownParams) > 0 {
+		log.Warnf(ctx, "%s: ignoring unknown query params %v (params version %d, want %d)",
+			sreq.Path(), sreq.UnknownParams, sreq.ParamsVersion, govulncheck.ParamsVersion)
+	}
+	h.checkCorpusHash(ctx, sreq)
 	if sreq.Mode == "" {
 		sreq.Mode = ModeGovulncheck
 	}
+	sreq.Mode = strings.ToUpper(sreq.Mode)
+	if !modes[sreq.Mode] {
+		rerr := scan.NewRequestError(scan.ErrUnknownMode, "mode", "unsupported mode %q", sreq.Mode)
+		rerr.Example = "/govulncheck/scan/golang.org/x/text@v0.3.0?importedby=10&mode=" + ModeGovulncheck
+		return rerr
+	}
+	platforms, err := govulncheck.ParsePlatforms(sreq.Platforms)
+	if err != nil {
+		return scan.NewRequestError(scan.ErrBadParam, "platforms", "%v", err)
+	}
+	if len(platforms) > 0 {
+		switch {
+		case sreq.Mode == ModeCompare:
+			return scan.NewRequestError(scan.ErrBadParam, "platforms", "platforms can't be scanned in %s mode", ModeCompare)
+		case sreq.Serve || sreq.Shadow:
+			return scan.NewRequestError(scan.ErrBadParam, "platforms", "platforms can't be combined with serve or shadow")
+		case isStdlibRequest(sreq):
+			return scan.NewRequestError(scan.ErrBadParam, "platforms", "the standard library is scanned per Go toolchain, not per platform")
+		}
+	}
+	if sreq.IncludeTests {
+		switch {
+		case sreq.Mode == ModeCompare:
+			return scan.NewRequestError(scan.ErrBadParam, "includetests", "%s mode compares binaries, which have no tests", ModeCompare)
+		case isStdlibRequest(sreq):
+			return scan.NewRequestError(scan.ErrBadParam, "includetests", "the standard library is scanned without its tests")
+		}
+	}
+	if sreq.Mode == ModeExtract || sreq.Blob != "" {
+		switch {
+		case sreq.Mode != ModeExtract:
+			return scan.NewRequestError(scan.ErrBadParam, "blob", "blobs are only scanned in %s mode", ModeExtract)
+		case sreq.Blob == "":
+			return scan.NewRequestError(scan.ErrMissingParam, "blob", "%s mode needs a blob", ModeExtract)
+		case sreq.Blob != filepath.Base(sreq.Blob):
+			return scan.NewRequestError(scan.ErrBadParam, "blob", "blob name %q is not a basename", sreq.Blob)
+		case isStdlibRequest(sreq):
+			return scan.NewRequestError(scan.ErrBadParam, "blob", "the standard library is scanned from source")
+		case len(platforms) > 0 || sreq.PerPackage || sreq.IncludeTests || sreq.Tags != "" || sreq.GoFlags != "" || sreq.GoVersion != "":
+			return scan.NewRequestError(scan.ErrBadParam, "mode", "%s mode scans prebuilt binaries, whose platform, packages, tests, build flags and Go version are fixed", ModeExtract)
+		}
+	}
+	if sreq.Format != "" && !sreq.Serve {
+		return scan.NewRequestError(scan.ErrBadParam, "format", "format only applies to served results")
+	}
+	if sreq.GoVersion != "" {
+		switch {
+		case sreq.Mode == ModeCompare:
+			return scan.NewRequestError(scan.ErrBadParam, "goversion", "%s mode builds with the worker's toolchain", ModeCompare)
+		case isStdlibRequest(sreq):
+			return scan.NewRequestError(scan.ErrBadParam, "goversion", "the standard library is scanned for every installed toolchain")
+		}
+	}
+	if sreq.Pattern != "" {
+		switch {
+		case sreq.Mode == ModeCompare:
+			return scan.NewRequestError(scan.ErrBadParam, "pattern", "%s mode builds the module's main packages", ModeCompare)
+		case sreq.Mode == ModeExtract:
+			return scan.NewRequestError(scan.ErrBadParam, "pattern", "%s mode scans a prebuilt binary", ModeExtract)
+		case isStdlibRequest(sreq):
+			return scan.NewRequestError(scan.ErrBadParam, "pattern", "the standard library is scanned as a whole")
+		}
+	}
+	if sreq.PerPackage {
+		switch {
+		case sreq.Mode == ModeCompare:
+			return scan.NewRequestError(scan.ErrBadParam, "perpackage", "%s mode already scans packages one at a time", ModeCompare)
+		case isStdlibRequest(sreq):
+			return scan.NewRequestError(scan.ErrBadParam, "perpackage", "the standard library can't be scanned per package")
+		}
+	}
+	// Keep scans requested outside of a run apart from run results.
+	if sreq.QueryParams.Suffix == "" && r.Header.Get("X-CloudTasks-QueueName") == "" {
+		sreq.QueryParams.Suffix = govulncheck.AdHocSuffix(h.now())
+	}
+	// Scan the highest major version of a module, unless that was
+	// already decided at enqueue time or the caller asked for the
+	// requested path. The public proxy is not asked about private modules,
+	// a blob belongs to the module it was named with, and a pattern may
+	// name packages of the requested path.
+	if sreq.Version == version.Latest && !sreq.NoMajor && sreq.BasePath == "" && !isStdlibRequest(sreq) &&
+		!govulncheck.IsPrivate(sreq.GoPrivate, sreq.Module) && sreq.Mode != ModeExtract && sreq.Pattern == "" {
+		p, err := h.majorPaths.resolve(ctx, sreq.Module)
+		if err != nil {
+			log.Errorf(ctx, err, "probing major versions of %s", sreq.Module)
+		} else if p != sreq.Module {
+			log.Infof(ctx, "scanning %s instead of %s", p, sreq.Module)
+			sreq.BasePath = sreq.Module
+			sreq.Module = p
+		}
+	}
 	scanner, err := newScanner(ctx, h)
 	if err != nil {
 		return err
 	}
+	if sreq.Mode == ModeExtract && scanner.gcsBucket == nil {
+		return fmt.Errorf("%w: %s mode needs a binary bucket (define GO_ECOSYSTEM_BINARY_BUCKET)", derrors.InvalidArgument, ModeExtract)
+	}
 	// An explicit "insecure" query param overrides the default.
 	if sreq.Insecure {
 		scanner.insecure = sreq.Insecure
 	}
-	skip, err := h.canSkip(ctx, sreq, scanner)
+	if sreq.MaxFindings > 0 {
+		scanner.maxFindings = sreq.MaxFindings
+	}
+	scanner.ignoreVendor = sreq.NoVendor
+	scanner.vendorCompare = sreq.VendorCompare
+	scanner.dropReplaces = sreq.DropReplaces
+	scanner.perPackage = sreq.PerPackage
+	scanner.tags = sreq.Tags
+	scanner.goFlags = sreq.GoFlags
+	scanner.tests = sreq.IncludeTests
+	scanner.pattern = sreq.Pattern
+	if sreq.Format == govulncheck.FormatSARIF {
+		// SARIF results are located by the positions in traces.
+		scanner.traceStorage = govulncheck.TraceStorageColumn
+	}
+	if sreq.GoVersion != "" {
+		tc, err := findToolchain(scanner.toolchains, sreq.GoVersion)
+		if err != nil {
+			return scan.NewRequestError(scan.ErrBadParam, "goversion", "%v", err)
+		}
+		scanner.goVersion = sreq.GoVersion
+		scanner.toolchain = tc
+		scanner.pinToolchain = true
+	}
+	scanner.private, err = newPrivateModules(ctx, h.cfg, sreq)
 	if err != nil {
 		return err
 	}
+	defer scanner.private.cleanup()
+	scanner.policy, err = h.policy.get(ctx)
+	if err != nil {
+		return err
+	}
+	// An explicit "osv" query param overrides the configured filter.
+	if sreq.OSV != "" {
+		filter, err := govulncheck.ParseOSVFilter(sreq.OSV)
+		if err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
+		scanner.osvFilter = filter
+		wv := *scanner.workVersion
+		wv.OSVFilterHash = osvFilterHash(filter)
+		scanner.workVersion = &wv
+	}
+	// An explicit "toolversion" query param overrides the configured version.
+	toolVersion := sreq.ToolVersion
+	if toolVersion == "" {
+		toolVersion = h.cfg.GovulncheckVersion
+	}
+	if toolVersion != "" {
+		path, err := govulncheckVersionPath(h.cfg.BinaryDir, toolVersion)
+		if err != nil {
+			return scan.NewRequestError(scan.ErrBadParam, "toolversion", "%v", err)
+		}
+		scanner.govulncheckPath = path
+		wv := *scanner.workVersion
+		wv.GovulncheckVersion = bigquery.NullString(toolVersion)
+		scanner.workVersion = &wv
+	}
+	// An explicit "vulndbs" query param overrides the configured vuln DBs.
+	if sreq.VulnDBs != "" {
+		dirs := govulncheck.SplitVulnDBDirs(sreq.VulnDBs)
+		for _, d := range dirs {
+			if !allowedVulnDBDir(h.cfg, d) {
+				return scan.NewRequestError(scan.ErrBadParam, "vulndbs", "vuln DB %q is not allowed", d)
+			}
+		}
+		if len(dirs) == 0 {
+			return scan.NewRequestError(scan.ErrBadParam, "vulndbs", "no vuln DBs")
+		}
+		lmt, dbsHash, n, err := readVulnDBs(dirs)
+		if err != nil {
+			return err
+		}
+		scanner.vulnDBDirs = dirs
+		scanner.dbEntryCount = n
+		wv := *scanner.workVersion
+		wv.VulnDBLastModified = lmt
+		wv.VulnDBsHash = dbsHash
+		scanner.workVersion = &wv
+	}
+	// Don't bother scanning if the results would be rejected.
+	if !sreq.Serve {
+		if err := h.insertLimiter.check(ctx, sreq.QueryParams.Suffix); err != nil {
+			return err
+		}
+	}
+	// The standard library is scanned once for each Go toolchain,
+	// which keeps track of work versions itself.
+	if isStdlibRequest(sreq) {
+		return h.scanStdlib(ctx, w, sreq, scanner)
+	}
+	// Work states don't distinguish platforms, build flags, tests, blobs,
+	// patterns or requested Go versions, so such scans are never skipped.
+	// Scans with build flags are often retries of modules that failed to
+	// build.
+	skip := false
+	if !sreq.Shadow && len(platforms) == 0 && sreq.Tags == "" && sreq.GoFlags == "" && !sreq.IncludeTests &&
+		sreq.Mode != ModeExtract && sreq.GoVersion == "" && sreq.Pattern == "" {
+		skip, err = h.canSkip(ctx, sreq, scanner)
+		if err != nil {
+			return err
+		}
+	}
 	if skip {
 		log.Infof(ctx, "skipping (work version unchanged or unrecoverable error): %s@%s", sreq.Module, sreq.Version)
 		return nil
 	}
+	// Defer the scan if its run is behind schedule and the module is
+	// imported too little.
+	if !sreq.Serve && !sreq.Shadow && !govulncheck.IsAdHocSuffix(sreq.QueryParams.Suffix) {
+		if t := h.deferrals.threshold(ctx, sreq.QueryParams.Suffix); sreq.ImportedBy < t {
+			return scanner.deferModule(ctx, w, sreq, t)
+		}
+	}
+	// A compare-mode task writes the rows of each package as it goes,
+	// so that a retry can skip them.
+	if sreq.Mode == ModeCompare && !sreq.Serve && !sreq.Shadow {
+		if name := r.Header.Get("X-CloudTasks-TaskName"); name != "" {
+			scanner.checkpoints = h.newTaskCheckpoints(sreq, name)
+		}
+	}
 
+	if len(platforms) > 0 {
+		return scanner.scanPlatforms(ctx, w, sreq, platforms)
+	}
+	if sreq.Serve && sreq.Progress {
+		scanner.events = newEventWriter(w)
+		defer scanner.events.stop()
+		if err := scanner.ScanModule(ctx, w, sreq); err != nil {
+			// The response has started, so the error can only be sent as an event.
+			log.Errorf(ctx, err, "scanning %s with progress", sreq.Path())
+			scanner.events.stop()
+			scanner.events.event("error", map[string]string{"error": err.Error()})
+		}
+		return nil
+	}
 	return scanner.ScanModule(ctx, w, sreq)
 }
 
 func (h *GovulncheckServer) canSkip(ctx context.Context, sreq *govulncheck.Request, scanner *scanner) (bool, error) {
-	if err := h.readGovulncheckWorkState(ctx, sreq.Module, sreq.Version); err != nil {
+	wve, upToDate, err := h.workState(ctx, scanner, sreq.Module, sreq.Version, scanner.workVersion)
+	if err != nil {
 		return false, err
 	}
-	wve := h.storedWorkStates[[2]string{sreq.Module, sreq.Version}]
 	if wve == nil {
 		// sreq.Module@sreq.Version have not been analyzed before.
 		return false, nil
 	}
 
-	if scanner.workVersion.Equal(wve.WorkVersion) {
+	// A deferred module was not scanned.
+	if wve.ErrorCategory == derrors.CategorizeError(derrors.ScanDeferred) {
+		return false, nil
+	}
+	// A retry of a scan that failed with a transient error is not
+	// skipped, though the failed scan had the same work version.
+	if _, ok := retryPolicies[wve.ErrorCategory]; ok && sreq.Attempt > 1 {
+		return false, nil
+	}
+	// A module with local replaces can be scanned by dropping them.
+	if sreq.DropReplaces && wve.ErrorCategory == derrors.CategorizeError(derrors.LocalReplaceError) {
+		return false, nil
+	}
+	if upToDate {
 		// If the work version has not changed, skip analyzing the module
 		return true, nil
 	}
@@ -131,11 +397,32 @@ func unrecoverableError(errorCategory string) bool {
 	switch errorCategory {
 	case derrors.CategorizeError(derrors.LoadPackagesError): // We model build issues as a general load error.
 		return true
+	case derrors.CategorizeError(derrors.LocalReplaceError):
+		return true
 	default:
 		return false
 	}
 }
 
+// workState returns the stored work state of modulePath@version, or nil if
+// it has none, and whether it shows that modulePath@version was done with
+// work version wv. It is where work versions are compared to decide
+// whether to scan; callers decide what to do about errors.
+func (h *GovulncheckServer) workState(ctx context.Context, s *scanner, modulePath, version string, wv *govulncheck.WorkVersion) (_ *govulncheck.WorkState, upToDate bool, err error) {
+	// Stored rows hold the scrubbed module path, if scrubbing is enabled.
+	modulePath = s.scrubber.ModulePath(modulePath)
+	if err := h.readGovulncheckWorkState(ctx, modulePath, version); err != nil {
+		return nil, false, err
+	}
+	h.mu.Lock()
+	ws := h.storedWorkStates[[2]string{modulePath, version}]
+	h.mu.Unlock()
+	if ws == nil {
+		return nil, false, nil
+	}
+	return ws, wv.Equal(ws.WorkVersion), nil
+}
+
 func (h *GovulncheckServer) readGovulncheckWorkState(ctx context.Context, module_path, version string) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -143,10 +430,10 @@ func (h *GovulncheckServer) readGovulncheckWorkState(ctx context.Context, module
 	if _, ok := h.storedWorkStates[[2]string{module_path, version}]; ok {
 		return nil
 	}
-	if h.bqClient == nil {
+	if h.resultSink == nil {
 		return nil
 	}
-	ws, err := govulncheck.ReadWorkState(ctx, h.bqClient, module_path, version)
+	ws, err := h.resultSink.ReadWorkState(ctx, module_path, version)
 	if err != nil {
 		return err
 	}
@@ -157,18 +444,124 @@ func (h *GovulncheckServer) readGovulncheckWorkState(ctx context.Context, module
 	return nil
 }
 
+// prefetchWorkStates reads the work states of the module versions of
+// tasks with one bulk query and caches them, so that the scans this
+// instance handles don't each read their own. Tasks for the latest version
+// are skipped, since their work state depends on the resolved version.
+func (h *GovulncheckServer) prefetchWorkStates(ctx context.Context, tasks []queue.Task) (err error) {
+	defer derrors.Wrap(&err, "prefetchWorkStates")
+	if h.bqClient == nil {
+		return nil
+	}
+	// Stored rows hold the scrubbed module path, if scrubbing is enabled.
+	if _, err := h.getWorkVersion(ctx); err != nil {
+		return err
+	}
+	var modspecs []scan.ModuleSpec
+	for _, t := range tasks {
+		r, ok := t.(*govulncheck.Request)
+		if !ok || r.Version == version.Latest {
+			continue
+		}
+		modspecs = append(modspecs, scan.ModuleSpec{Path: h.scrubber.ModulePath(r.Module), Version: r.Version})
+	}
+	if len(modspecs) == 0 {
+		return nil
+	}
+	wss, err := govulncheck.ReadWorkStates(ctx, h.bqClient, modspecs)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ms := range modspecs {
+		if ws := wss[ms.Path+"@"+ms.Version]; ws != nil {
+			h.storedWorkStates[[2]string{ms.Path, ms.Version}] = ws
+		}
+	}
+	log.Infof(ctx, "prefetched %d work states for %d module versions", len(wss), len(modspecs))
+	return nil
+}
+
 // A scanner holds state for scanning modules.
 type scanner struct {
 	proxyClient *proxy.Client
 	bqClient    *bigquery.Client
+	resultSink  sink.ResultSink // where rows are stored; nil if nowhere
 	workVersion *govulncheck.WorkVersion
+	osvFilter   *govulncheck.OSVFilter
+	scrubber    *govulncheck.Scrubber
+	limiter     *insertLimiter
+	uploadRows  func(context.Context, string, []bigquery.Row) error
+	queue       queue.Queue // for retrying transient failures; see scheduleRetry
+	now         func() time.Time
+	events      *eventWriter // progress events for the client, if requested
+	phase       string       // current phase of the scan; see enterPhase
 	gcsBucket   *storage.BucketHandle
 	insecure    bool
+	maxFindings int // maximum number of findings processed per scan
+	// scanTimeout, if positive, is the maximum time each run of
+	// govulncheck may take.
+	scanTimeout time.Duration
+	policy      *scan.Policy
 	sbox        *sandbox.Sandbox
 	binaryDir   string
 
 	govulncheckPath string
-	vulnDBDir       string
+	vulnDBDirs      []string
+	vulnDBCaches    []*govulncheck.VulnDBCache // for vulnDBDirs served over HTTPS
+	dbEntryCount    int                        // number of entries in the vuln DB
+
+	// toolchains are the installed Go toolchains, the first of which is
+	// the default. goVersion is the Go version requested for the scan by
+	// the goversion param, which pins toolchain, or else by the module;
+	// toolchain is the one that scans it. See chooseToolchain.
+	toolchains   []goToolchain
+	goVersion    string
+	toolchain    goToolchain
+	pinToolchain bool
+
+	ignoreVendor  bool   // scan with -mod=mod
+	vendorCompare bool   // also scan vendored modules with -mod=mod
+	traceStorage  string // how to store the trace of each finding, if at all
+	dropReplaces  bool   // drop local replace directives instead of failing
+	perPackage    bool   // scan each package with its own run of govulncheck
+	tags          string // comma-separated build tags; see govulncheck.ParseTags
+	goFlags       string // other go command flags; see govulncheck.ParseGoFlags
+	tests         bool   // analyze the module's test files too
+	pattern       string // packages to scan, if not all; see packagePattern
+	platform      string // GOOS/GOARCH to scan for; if empty, the worker's
+
+	riskWeights govulncheck.RiskWeights
+	osvCache    *govulncheck.OSVCache // for entries missing from govulncheck's output
+
+	// private describes how to fetch private modules. It is nil unless
+	// the request has GOPRIVATE patterns.
+	private *privateModules
+
+	// isolateModCache gives each sandboxed scan its own module cache.
+	isolateModCache bool
+	// modCache is the module cache of the current scan, if it has its own.
+	modCache string
+	// checkpoints lets a compare-mode task resume where an earlier
+	// attempt died. It is nil for other scans.
+	checkpoints *taskCheckpoints
+}
+
+// proxyClientFor returns the proxy client that serves modulePath: the
+// private proxy's for private modules, and the public one's otherwise.
+func (s *scanner) proxyClientFor(modulePath string) *proxy.Client {
+	if s.private.matches(modulePath) {
+		return s.private.client
+	}
+	return s.proxyClient
+}
+
+// enterPhase records that the scan entered the given phase, so that errors
+// can be attributed to it, and reports the phase to the client.
+func (s *scanner) enterPhase(name string) {
+	s.phase = name
+	s.events.phase(name)
 }
 
 func newScanner(ctx context.Context, h *GovulncheckServer) (*scanner, error) {
@@ -184,18 +577,38 @@ func newScanner(ctx context.Context, h *GovulncheckServer) (*scanner, error) {
 		}
 		bucket = c.Bucket(h.cfg.BinaryBucket)
 	}
+	riskWeights, err := govulncheck.ParseRiskWeights(h.cfg.RiskWeights)
+	if err != nil {
+		return nil, err
+	}
 	sbox := sandbox.New("/bundle")
 	sbox.Runsc = "/usr/local/bin/runsc"
 	return &scanner{
 		proxyClient:     h.proxyClient,
 		bqClient:        h.bqClient,
+		resultSink:      h.resultSink,
 		workVersion:     workVersion,
+		osvFilter:       h.osvFilter,
+		scrubber:        h.scrubber,
+		limiter:         h.insertLimiter,
+		uploadRows:      h.uploadRows,
+		queue:           h.queue,
+		now:             h.now,
 		gcsBucket:       bucket,
 		insecure:        h.cfg.Insecure,
+		maxFindings:     h.cfg.MaxFindingsPerScan,
+		scanTimeout:     time.Duration(h.cfg.ScanTimeoutMinutes) * time.Minute,
+		dbEntryCount:    h.vulnDBEntryCount,
+		traceStorage:    h.cfg.TraceStorage,
+		isolateModCache: h.cfg.IsolateModCache,
+		riskWeights:     riskWeights,
+		osvCache:        h.osvCache,
 		sbox:            sbox,
 		binaryDir:       h.cfg.BinaryDir,
 		govulncheckPath: filepath.Join(h.cfg.BinaryDir, "govulncheck"),
-		vulnDBDir:       h.cfg.VulnDBDir,
+		vulnDBDirs:      h.vulnDBDirs,
+		vulnDBCaches:    h.vulnDBCaches,
+		toolchains:      h.toolchains,
 	}, nil
 }
 
@@ -216,13 +629,26 @@ func (s scanError) Unwrap() error {
 // It discards all results where there is a failure that is not specific to the comparison, i.e., failures
 // that appear in GOVULNCHECK or IMPORTS mode. Examples are situations where the module is malformed,
 // govulncheck fails, or it is not possible to build a found binary within the module.
+//
+// If s has checkpoints, each package is compared in its own sandbox run and
+// its rows are written right away, so that a retry of the task only
+// compares the packages that earlier attempts didn't finish.
 func (s *scanner) CompareModule(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, info *proxy.VersionInfo, baseRow *govulncheck.Result) (err error) {
 	defer derrors.Wrap(&err, "CompareModule")
-	err = doScan(ctx, baseRow.ModulePath, info.Version, s.insecure, func() (err error) {
+	done, err := s.checkpoints.completed(ctx, s.workVersion)
+	if err != nil {
+		return err
+	}
+	if done[govulncheck.SubScanSummary] != nil {
+		log.Infof(ctx, "%s: already compared by an earlier attempt", sreq.Path())
+		return nil
+	}
+	err = doScan(ctx, baseRow.ModulePath, info.Version, s.insecure, nil, func() (err error) {
 		inputPath := moduleDir(baseRow.ModulePath, info.Version)
 		defer derrors.Cleanup(&err, func() error { return os.RemoveAll(inputPath) })
 		const init = true
-		if err := prepareModule(ctx, baseRow.ModulePath, info.Version, inputPath, s.proxyClient, s.insecure, init); err != nil {
+		s.enterPhase(phaseDownloading)
+		if _, err := prepareModule(ctx, baseRow.ModulePath, info.Version, inputPath, s.proxyClientFor(baseRow.ModulePath), s.insecure, init, false, "", s.private); err != nil {
 			log.Errorf(ctx, err, "error trying to prepare module %s", baseRow.ModulePath)
 			return nil
 		}
@@ -231,36 +657,131 @@ func (s *scanner) CompareModule(ctx context.Context, w http.ResponseWriter, sreq
 		err = s.sbox.Validate()
 		log.Debugf(ctx, "sandbox Validate returned %v", err)
 
-		response, err := s.runGovulncheckCompareSandbox(ctx, smdir)
+		s.enterPhase(phaseBuilding)
+		var (
+			pkgs    []string
+			compare func(string) (*govulncheck.ComparePair, error)
+		)
+		if s.checkpoints == nil {
+			response, err := s.runGovulncheckCompareSandbox(ctx, smdir, nil)
+			if err != nil {
+				return err
+			}
+			for pkg := range response.FindingsForMod {
+				pkgs = append(pkgs, pkg)
+			}
+			sort.Strings(pkgs)
+			compare = func(pkg string) (*govulncheck.ComparePair, error) {
+				return response.FindingsForMod[pkg], nil
+			}
+		} else {
+			response, err := s.runGovulncheckCompareSandbox(ctx, smdir, nil, "-list")
+			if err != nil {
+				return err
+			}
+			pkgs = response.Packages
+			compare = func(pkg string) (*govulncheck.ComparePair, error) {
+				response, err := s.runGovulncheckCompareSandbox(ctx, smdir, []string{pkg})
+				if err != nil {
+					return nil, err
+				}
+				p := response.FindingsForMod[pkg]
+				if p == nil {
+					return nil, fmt.Errorf("no results for %s", pkg)
+				}
+				return p, nil
+			}
+		}
+		log.Infof(ctx, "scanner.runGovulncheckCompare found %d compilable binaries in %s:", len(pkgs), sreq.Path())
+		return s.comparePackages(ctx, w, sreq, baseRow, pkgs, done, compare)
+	})
+	return err
+}
+
+// comparePackages writes the rows of the results of compare for each of
+// pkgs, and then the summary row. The sub-scans in done, which were done
+// by earlier attempts of the task, are skipped.
+//
+// If s has checkpoints, the rows of each package are written as soon as
+// they are ready, and then its checkpoint is recorded. Otherwise all rows
+// are written together at the end. Either way, the rows are the same.
+func (s *scanner) comparePackages(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, baseRow *govulncheck.Result,
+	pkgs []string, done map[string]*govulncheck.Checkpoint, compare func(string) (*govulncheck.ComparePair, error)) error {
+	pairs := map[string]*govulncheck.PairSummary{}
+	var rows []bigquery.Row
+	for _, pkg := range pkgs {
+		subScan := govulncheck.PackageSubScan(pkg)
+		if c := done[subScan]; c != nil && c.Pair != nil {
+			pairs[pkg] = c.Pair
+			continue
+		}
+		results, err := compare(pkg)
 		if err != nil {
 			return err
 		}
-		log.Infof(ctx, "scanner.runGovulncheckCompare found %d compilable binaries in %s:", len(response.FindingsForMod), sreq.Path())
-
-		var rows []bigquery.Row
-		for pkg, results := range response.FindingsForMod {
-			if results.Error != "" {
-				// Just log error if binary failed to build or the analysis failed.
-				// TODO: should we save those rows? This would complicate clients, namely the dashboards.
-				log.Errorf(ctx, errors.New(results.Error), "building/analyzing binary failed: %s %s", pkg, sreq.Path())
-				continue
-			}
-
-			binRow := createComparisonRow(pkg, &results.BinaryResults, baseRow, modeBinary)
-			srcRow := createComparisonRow(pkg, &results.SourceResults, baseRow, ModeGovulncheck)
+		pairs[pkg] = results.Summarize(s.osvFilter)
+		var pkgRows []bigquery.Row
+		if results.Error != "" {
+			// Just log error if binary failed to build or the analysis failed.
+			// TODO: should we save those rows? This would complicate clients, namely the dashboards.
+			log.Errorf(ctx, errors.New(results.Error), "building/analyzing binary failed: %s %s", pkg, sreq.Path())
+		} else {
+			binRow := createComparisonRow(ctx, pkg, &results.BinaryResults, baseRow, modeBinary, s.osvFilter, s.osvCache, s.vulnDBDirs, s.traceStorage)
+			srcRow := createComparisonRow(ctx, pkg, &results.SourceResults, baseRow, ModeGovulncheck, s.osvFilter, s.osvCache, s.vulnDBDirs, s.traceStorage)
 			log.Infof(ctx, "found %d vulns in binary mode and %d vulns in source mode for package %s (module: %s)", len(binRow.Vulns), len(srcRow.Vulns), pkg, sreq.Path())
-			rows = append(rows, binRow, srcRow)
+			s.scrubber.Scrub(binRow)
+			s.scrubber.Scrub(srcRow)
+			pkgRows = []bigquery.Row{binRow, srcRow}
+		}
+		if s.checkpoints == nil {
+			rows = append(rows, pkgRows...)
+			continue
 		}
+		if len(pkgRows) > 0 {
+			if err := s.writeRows(ctx, w, sreq, pkgRows); err != nil {
+				return err
+			}
+		}
+		if err := s.checkpoints.record(ctx, s.workVersion, subScan, pairs[pkg]); err != nil {
+			return err
+		}
+	}
 
-		if len(rows) > 0 {
-			return writeResults(ctx, sreq.Serve, w, s.bqClient, govulncheck.TableName, rows)
+	if summary := govulncheck.SummarizePairs(pairs); summary.Packages > 0 {
+		sumRow := createCompareSummaryRow(summary, baseRow)
+		s.scrubber.Scrub(sumRow)
+		rows = append(rows, sumRow)
+	}
+	if len(rows) > 0 {
+		if err := s.writeRows(ctx, w, sreq, rows); err != nil {
+			return err
 		}
-		return nil
-	})
-	return err
+	}
+	return s.checkpoints.record(ctx, s.workVersion, govulncheck.SubScanSummary, nil)
+}
+
+// createCompareSummaryRow returns the row holding summary, the summary of
+// a compare-mode scan of the module of baseRow.
+func createCompareSummaryRow(summary *govulncheck.CompareSummary, baseRow *govulncheck.Result) *govulncheck.Result {
+	return &govulncheck.Result{
+		CreatedAt:   baseRow.CreatedAt,
+		Suffix:      baseRow.Suffix,
+		ModulePath:  baseRow.ModulePath,
+		Version:     baseRow.Version,
+		SortVersion: baseRow.SortVersion,
+		ImportedBy:  baseRow.ImportedBy,
+		CommitTime:  baseRow.CommitTime,
+		WorkVersion: baseRow.WorkVersion,
+		ScanMode:    govulncheck.ModeCompareSummary,
+
+		RequestedModulePath: baseRow.RequestedModulePath,
+		VulnDBEntryCount:    baseRow.VulnDBEntryCount,
+		RowType:             bigquery.NullString(govulncheck.RowTypeSummary),
+		CompareSummary:      summary,
+	}
 }
 
-func createComparisonRow(pkg string, result *govulncheck.SandboxResponse, baseRow *govulncheck.Result, mode string) (row *govulncheck.Result) {
+func createComparisonRow(ctx context.Context, pkg string, result *govulncheck.SandboxResponse, baseRow *govulncheck.Result, mode string, filter *govulncheck.OSVFilter, cache *govulncheck.OSVCache, vulnDBDirs []string, traceStorage string) (row *govulncheck.Result) {
 	row = &govulncheck.Result{
 		CreatedAt:   baseRow.CreatedAt,
 		Suffix:      pkg,
@@ -270,7 +791,11 @@ func createComparisonRow(pkg string, result *govulncheck.SandboxResponse, baseRo
 		ImportedBy:  baseRow.ImportedBy,
 		CommitTime:  baseRow.CommitTime,
 		WorkVersion: baseRow.WorkVersion,
+
+		RequestedModulePath: baseRow.RequestedModulePath,
+		VulnDBEntryCount:    baseRow.VulnDBEntryCount,
 	}
+	row.RowType = bigquery.NullString(govulncheck.RowTypePair)
 	if mode == modeBinary {
 		row.ScanMode = "COMPARE - BINARY"
 		row.BinaryBuildSeconds = bigquery.NullFloat(result.Stats.BuildTime.Seconds())
@@ -278,39 +803,68 @@ func createComparisonRow(pkg string, result *govulncheck.SandboxResponse, baseRo
 		row.ScanMode = "COMPARE - SOURCE"
 	}
 
+	findings, nfiltered := filter.Filter(result.Findings)
+	if filter != nil {
+		row.FindingsFiltered = bigquery.NullInt(nfiltered)
+	}
 	vulns := []*govulncheck.Vuln{}
-	for _, finding := range result.Findings {
-		vulns = append(vulns, govulncheck.ConvertGovulncheckFinding(finding))
+	for _, finding := range findings {
+		v := govulncheck.ConvertGovulncheckFinding(finding)
+		if err := v.StoreTrace(finding.Trace, traceStorage); err != nil {
+			log.Errorf(ctx, err, "%s: encoding trace for %s", pkg, finding.OSV)
+		}
+		vulns = append(vulns, v)
 	}
+	missing := govulncheck.EnrichVulns(vulns, result.OSVs)
+	if missing = cache.EnrichMissing(ctx, vulns, missing); len(missing) > 0 {
+		log.Warnf(ctx, "%s: OSV entries withdrawn or missing: %v", pkg, missing)
+	}
+	govulncheck.SetSourceDBs(vulns, vulnDBDirs)
+	row.SetVulnCounts(vulns)
 	row.Vulns = vulnsForMode(vulns, mode)
 
 	row.ScanMemory = int64(result.Stats.ScanMemory)
 	row.ScanSeconds = result.Stats.ScanSeconds
+	row.SetScanTimes(&result.Stats)
+	row.SetCPUUsage(&result.Stats)
+	row.SetScannerConfig(&result.Stats)
+	if result.Stats.FindingsCapped {
+		row.FindingsCapped = bigquery.NullBool(true)
+	}
 
 	return row
 }
 
 func (s *scanner) ScanModule(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request) error {
-	if sreq.Module == "std" {
-		return nil // ignore the standard library
+	if isStdlibRequest(sreq) {
+		return nil // see scanStdlib
 	}
-	row := &govulncheck.Result{
-		ModulePath:  sreq.Module,
-		Suffix:      sreq.Suffix,
-		WorkVersion: *s.workVersion,
-		ScanMode:    sreq.Mode,
-		ImportedBy:  sreq.ImportedBy,
+	row := s.newRow(sreq)
+
+	// Check the policy again in case it changed after the module was
+	// enqueued, or the scan was requested directly.
+	if s.policy.Denies(sreq.Module) || s.policy.Denies(sreq.BasePath) {
+		log.Warnf(ctx, "%s denied by module policy", sreq.Path())
+		row.Version = sreq.Version
+		row.PolicyHash = bigquery.NullString(s.policy.Hash())
+		row.AddPhaseError(phasePolicy, fmt.Errorf("%s: %w", sreq.Module, derrors.PolicyDenied))
+		s.scrubber.Scrub(row)
+		return s.writeRows(ctx, w, sreq, []bigquery.Row{row})
+	}
+
+	if s.private.matches(sreq.Module) {
+		row.Private = bigquery.NullBool(true)
 	}
-	row.VulnDBLastModified = s.workVersion.VulnDBLastModified
 
 	// Scan the version.
 	log.Debugf(ctx, "fetching proxy info: %s@%s", sreq.Path(), sreq.Version)
-	info, err := s.proxyClient.Info(ctx, sreq.Module, sreq.Version)
+	info, err := s.proxyClientFor(sreq.Module).Info(ctx, sreq.Module, sreq.Version)
 	if err != nil {
 		log.Infof(ctx, "proxy error: %s@%s %v", sreq.Path(), sreq.Version, err)
-		row.AddError(fmt.Errorf("%v: %w", err, derrors.ProxyError))
+		row.AddPhaseError(phaseDownloading, fmt.Errorf("%v: %w", err, derrors.ProxyError))
 		// TODO: should we also make a copy for imports mode?
-		return writeResult(ctx, sreq.Serve, w, s.bqClient, govulncheck.TableName, row)
+		s.scrubber.Scrub(row)
+		return s.writeRows(ctx, w, sreq, []bigquery.Row{row})
 	}
 	row.Version = info.Version
 	row.SortVersion = version.ForSorting(row.Version)
@@ -320,13 +874,68 @@ func (s *scanner) ScanModule(ctx context.Context, w http.ResponseWriter, sreq *g
 		return s.CompareModule(ctx, w, sreq, info, row)
 	}
 
-	log.Infof(ctx, "running scanner.runScanModule: %s@%s", sreq.Path(), sreq.Version)
 	stats := &govulncheck.ScanStats{}
-	vulns, err := s.runScanModule(ctx, sreq.Module, info.Version, sreq.Mode, stats)
+	var (
+		findings []*govulncheckapi.Finding
+		osvs     []*osv.Entry
+	)
+	if sreq.Mode == ModeExtract {
+		log.Infof(ctx, "running scanner.runScanBlob: %s@%s %s", sreq.Path(), sreq.Version, sreq.Blob)
+		findings, osvs, err = s.runScanBlob(ctx, sreq, row, stats)
+	} else {
+		log.Infof(ctx, "running scanner.runScanModule: %s@%s", sreq.Path(), sreq.Version)
+		findings, osvs, err = s.runScanModule(ctx, sreq.Module, info.Version, sreq.Mode, stats)
+		if s.goVersion != "" {
+			row.RequestedGoVersion = bigquery.NullString(s.goVersion)
+		}
+		if s.toolchain.version != "" {
+			row.EffectiveGoVersion = bigquery.NullString(s.toolchain.version)
+		}
+	}
 	row.ScanSeconds = stats.ScanSeconds
 	row.ScanMemory = int64(stats.ScanMemory)
+	row.SetScanTimes(stats)
+	row.SetCPUUsage(stats)
+	row.SetScannerConfig(stats)
+	if stats.FindingsCapped {
+		log.Warnf(ctx, "%s@%s: more than %d findings; some were dropped", sreq.Path(), sreq.Version, s.maxFindings)
+		row.FindingsCapped = bigquery.NullBool(true)
+	}
+	if stats.Vendored != nil {
+		row.Vendored = bigquery.NullBool(*stats.Vendored)
+	}
+	if s.ignoreVendor {
+		row.IgnoredVendor = bigquery.NullBool(true)
+	}
+	if stats.VendorFindingsDiffer != nil {
+		row.VendorFindingsDiffer = bigquery.NullBool(*stats.VendorFindingsDiffer)
+	}
+	if stats.ReplacesDropped {
+		row.ReplacedDropped = bigquery.NullBool(true)
+	}
+	if stats.Vendored != nil {
+		// The module was downloaded.
+		row.ModCacheWaitSeconds = bigquery.NullFloat(stats.ModCacheWait.Seconds())
+		row.IsolatedModCache = bigquery.NullBool(stats.IsolatedModCache)
+	}
+	row.PackageErrors = stats.PackageErrors
+	for _, e := range stats.Errors {
+		row.RecordError(phaseScanning, e)
+	}
+	var vulns []*govulncheck.Vuln
 	if err != nil {
 		switch {
+		case errors.Is(err, derrors.LocalReplaceError):
+			// Already categorized by prepareModule.
+		case errors.Is(err, derrors.ScanModuleTimeout):
+			// Already categorized by RunGovulncheckCmd.
+		case errors.Is(err, derrors.SandboxError):
+			// Already categorized by runGovulncheckScanSandbox.
+		case errors.Is(err, derrors.ExtractBlobError):
+			// Already categorized by runScanBlob.
+		case isTimeout(err):
+			// A timeout in the sandbox, which only reports the message.
+			err = fmt.Errorf("%v: %w", err, derrors.ScanModuleTimeout)
 		case isGovulncheckLoadError(err) || isBuildIssue(err):
 			err = fmt.Errorf("%v: %w", err, derrors.LoadPackagesError)
 		case isNoRequiredModule(err):
@@ -356,9 +965,43 @@ func (s *scanner) ScanModule(ctx context.Context, w http.ResponseWriter, sreq *g
 		default:
 			err = fmt.Errorf("%v: %w", err, derrors.ScanModuleGovulncheckError)
 		}
-		row.AddError(err)
+		row.AddPhaseError(s.phase, err)
+		if errors.Is(err, derrors.LoadPackagesError) {
+			// At least one package had errors, even if none could
+			// be found in the output.
+			n := govulncheck.CountPackageErrors(err.Error())
+			if n == 0 {
+				n = 1
+			}
+			row.PackagesWithErrors = bigquery.NullInt(n)
+			row.AnalysisConfidence = bigquery.NullString(govulncheck.AnalysisConfidence(n, true))
+		}
 	} else {
+		row.PackagesWithErrors = bigquery.NullInt(stats.PackagesWithErrors)
+		row.AnalysisConfidence = bigquery.NullString(govulncheck.AnalysisConfidence(stats.PackagesWithErrors, false))
+		s.enterPhase(phaseConverting)
+		var nfiltered int
+		findings, nfiltered = s.osvFilter.Filter(findings)
+		if s.osvFilter != nil {
+			row.FindingsFiltered = bigquery.NullInt(nfiltered)
+		}
+		for _, f := range findings {
+			v := govulncheck.ConvertGovulncheckFinding(f)
+			if err := v.StoreTrace(f.Trace, s.traceStorage); err != nil {
+				log.Errorf(ctx, err, "%s@%s: encoding trace for %s", sreq.Path(), sreq.Version, f.OSV)
+			}
+			vulns = append(vulns, v)
+		}
+		missing := govulncheck.EnrichVulns(vulns, osvs)
+		if missing = s.osvCache.EnrichMissing(ctx, vulns, missing); len(missing) > 0 {
+			log.Warnf(ctx, "%s@%s: OSV entries withdrawn or missing: %v", sreq.Path(), sreq.Version, missing)
+		}
+		govulncheck.SetSourceDBs(vulns, s.vulnDBDirs)
+		row.SetVulnCounts(vulns)
 		row.Vulns = vulnsForMode(vulns, sreq.Mode)
+		if sreq.Mode == ModeGovulncheck {
+			row.RiskScore = bigquery.NullFloat(govulncheck.RiskScore(row.Vulns, row.ImportedBy, s.riskWeights))
+		}
 	}
 	log.Infof(ctx, "scanner.runScanModule returned %d vulns for %s: row.Vulns=%d err=%v", len(vulns), sreq.Path(), len(row.Vulns), err)
 
@@ -370,26 +1013,184 @@ func (s *scanner) ScanModule(ctx context.Context, w http.ResponseWriter, sreq *g
 		// at the level of import chains. Also makes a copy if
 		// the original row has an error and no vulns.
 		impRow := *row
-		impRow.ScanMode = modeImports
+		impRow.ScanMode = ModeImports
 		impRow.ScanSeconds = 0
 		impRow.ScanMemory = 0
-		impRow.Vulns = vulnsForMode(vulns, modeImports)
+		impRow.ScanStartedAt = bq.NullTimestamp{}
+		impRow.ScanFinishedAt = bq.NullTimestamp{}
+		impRow.ScanUserCPUSeconds = bq.NullFloat64{}
+		impRow.ScanSystemCPUSeconds = bq.NullFloat64{}
+		impRow.ScanMaxRSS = bq.NullInt64{}
+		impRow.RiskScore = bq.NullFloat64{}
+		impRow.Vulns = vulnsForMode(vulns, ModeImports)
 		log.Infof(ctx, "scanner.runScanModule also storing imports vulns for %s: row.Vulns=%d", sreq.Path(), len(impRow.Vulns))
+		s.scrubber.Scrub(&impRow)
 		rows = append(rows, &impRow)
 	}
-	return writeResults(ctx, sreq.Serve, w, s.bqClient, govulncheck.TableName, rows)
+	s.scrubber.Scrub(row)
+	return s.writeRows(ctx, w, sreq, rows)
+}
+
+// scanPlatforms scans the module of sreq once for each of platforms, so
+// that each scan writes its own rows. A failed scan doesn't stop the
+// others; their errors are returned together.
+func (s *scanner) scanPlatforms(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, platforms []string) error {
+	var errs []error
+	for _, p := range platforms {
+		log.Infof(ctx, "scanning %s for %s", sreq.Path(), p)
+		s.platform = p
+		if err := s.ScanModule(ctx, w, sreq); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newRow returns a row for sreq with the fields that don't depend
+// on the scan.
+func (s *scanner) newRow(sreq *govulncheck.Request) *govulncheck.Result {
+	row := &govulncheck.Result{
+		ModulePath:  sreq.Module,
+		Suffix:      sreq.QueryParams.Suffix,
+		WorkVersion: *s.workVersion,
+		ScanMode:    sreq.Mode,
+		ImportedBy:  sreq.ImportedBy,
+	}
+	if sreq.BasePath != "" {
+		row.RequestedModulePath = bigquery.NullString(sreq.BasePath)
+	}
+	if s.platform != "" {
+		row.Platform = bigquery.NullString(s.platform)
+	}
+	if s.tags != "" {
+		row.BuildTags = bigquery.NullString(s.tags)
+	}
+	if s.goFlags != "" {
+		row.GoFlags = bigquery.NullString(s.goFlags)
+	}
+	if s.tests {
+		row.IncludeTests = bigquery.NullBool(true)
+	}
+	if s.pattern != "" {
+		row.Pattern = bigquery.NullString(s.pattern)
+	}
+	if sreq.Blob != "" {
+		row.Blob = bigquery.NullString(sreq.Blob)
+	}
+	row.VulnDBLastModified = s.workVersion.VulnDBLastModified
+	row.VulnDBEntryCount = bigquery.NullInt(s.dbEntryCount)
+	return row
+}
+
+// deferModule writes a row for sreq saying that its scan was deferred
+// because the imported-by count of the module is below threshold.
+func (s *scanner) deferModule(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, threshold int) error {
+	log.Infof(ctx, "%s deferred: imported by %d, below threshold %d", sreq.Path(), sreq.ImportedBy, threshold)
+	row := s.newRow(sreq)
+	row.Version = sreq.Version
+	row.DeferThreshold = bigquery.NullInt(threshold)
+	row.AddPhaseError(phaseScheduling, fmt.Errorf("%s: imported by %d, below %d: %w",
+		sreq.Module, sreq.ImportedBy, threshold, derrors.ScanDeferred))
+	s.scrubber.Scrub(row)
+	return s.writeRows(ctx, w, sreq, []bigquery.Row{row})
+}
+
+// writeRows writes rows for sreq, first charging them against the
+// insert volume of sreq's run if they are uploaded.
+func (s *scanner) writeRows(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, rows []bigquery.Row) error {
+	if err := canonicalizeVersions(ctx, rows); err != nil {
+		return err
+	}
+	if sreq.CorpusHash != "" {
+		for _, row := range rows {
+			if r, ok := row.(*govulncheck.Result); ok {
+				r.CorpusHash = bigquery.NullString(sreq.CorpusHash)
+			}
+		}
+	}
+	if sreq.Shadow && !sreq.Serve {
+		return s.writeShadowDiffs(ctx, w, sreq, rows)
+	}
+	if sreq.Serve {
+		var content any = rows
+		if sreq.Format == govulncheck.FormatSARIF {
+			var results []*govulncheck.Result
+			for _, row := range rows {
+				if r, ok := row.(*govulncheck.Result); ok {
+					results = append(results, r)
+				}
+			}
+			content = govulncheck.ToSARIF(results)
+		}
+		if s.events != nil {
+			// The scan is over; only the result remains to be sent.
+			s.events.stop()
+			return s.events.event("result", content)
+		}
+		if sreq.Format == govulncheck.FormatSARIF {
+			return serveJSON(ctx, content, w)
+		}
+		return writeResults(ctx, sreq.Serve, w, s.resultSink, govulncheck.TableName, rows)
+	}
+	policy, retry := recordAttempts(sreq, rows)
+	if s.resultSink != nil || s.uploadRows != nil {
+		if err := s.limiter.charge(ctx, sreq.QueryParams.Suffix, rows); err != nil {
+			return err
+		}
+	}
+	var err error
+	if s.uploadRows != nil {
+		err = s.uploadRows(ctx, govulncheck.TableName, rows)
+	} else {
+		err = writeResults(ctx, sreq.Serve, w, s.resultSink, govulncheck.TableName, rows)
+	}
+	if err != nil || !retry {
+		return err
+	}
+	// The rows are written, so failing to retry should not fail the task,
+	// which would then be retried in full.
+	if err := s.scheduleRetry(ctx, sreq, policy); err != nil {
+		log.Errorf(ctx, err, "%s@%s: scheduling retry", sreq.Path(), sreq.Version)
+	}
+	return nil
+}
+
+// canonicalizeVersions replaces the module versions of rows with their
+// canonical forms, in case one got past govulncheck.ParseRequest. It
+// returns an error if a version has none. The versions of stdlib rows,
+// which are Go versions, and "latest", the version of rows written
+// before the version was resolved, are left alone.
+func canonicalizeVersions(ctx context.Context, rows []bigquery.Row) error {
+	for _, row := range rows {
+		r, ok := row.(*govulncheck.Result)
+		if !ok || r.ModulePath == stdlibModulePath || r.Version == "" || r.Version == version.Latest {
+			continue
+		}
+		v, err := version.Canonical(r.Version)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", derrors.InvalidArgument, r.ModulePath, err)
+		}
+		if v != r.Version {
+			log.Warnf(ctx, "%s: canonicalizing version %s to %s", r.ModulePath, r.Version, v)
+			r.Version = v
+			if r.SortVersion != "" {
+				r.SortVersion = version.ForSorting(v)
+			}
+		}
+	}
+	return nil
 }
 
 // vulnsForMode returns vulns that make sense to report for
 // a particular mode.
 //
 // For ModeGovulncheck, these are all vulns that are actually
-// called. For modeImports, these are all vulns, called or just
-// imported. For modeBinary, these are exactly all the vulns
-// since binary analysis does not distinguish between called
-// and imported vulnerabilities.
+// called. For ModeImports, these are all vulns, called or just
+// imported. For modeBinary and ModeExtract, these are exactly
+// all the vulns since binary analysis does not distinguish
+// between called and imported vulnerabilities.
 func vulnsForMode(vulns []*govulncheck.Vuln, mode string) []*govulncheck.Vuln {
-	if mode == modeBinary {
+	if mode == modeBinary || mode == ModeExtract {
 		return vulns
 	}
 
@@ -400,7 +1201,7 @@ func vulnsForMode(vulns []*govulncheck.Vuln, mode string) []*govulncheck.Vuln {
 			if v.Called {
 				vs = append(vs, v)
 			}
-		} else if mode == modeImports {
+		} else if mode == ModeImports {
 			// For imports mode, return the vulnerability as it
 			// is imported, but not called.
 			nv := *v
@@ -415,50 +1216,114 @@ func vulnsForMode(vulns []*govulncheck.Vuln, mode string) []*govulncheck.Vuln {
 
 // runScanModule fetches the module version from the proxy, and analyzes its source
 // code for vulnerabilities. The analysis of binaries is done in CompareModules.
-func (s *scanner) runScanModule(ctx context.Context, modulePath, version, mode string, stats *govulncheck.ScanStats) (bvulns []*govulncheck.Vuln, err error) {
-	err = doScan(ctx, modulePath, version, s.insecure, func() (err error) {
+func (s *scanner) runScanModule(ctx context.Context, modulePath, version, mode string, stats *govulncheck.ScanStats) (findings []*govulncheckapi.Finding, osvs []*osv.Entry, err error) {
+	err = doScan(ctx, modulePath, version, s.insecure, &stats.ModCacheWait, func() (err error) {
 		// Download the module first.
 		inputPath := moduleDir(modulePath, version)
 		defer derrors.Cleanup(&err, func() error { return os.RemoveAll(inputPath) })
+		if s.isolateModCache && !s.insecure {
+			s.modCache, err = newScanModCache()
+			if err != nil {
+				return err
+			}
+			defer derrors.Cleanup(&err, func() error { return removeScanModCache(s.modCache) })
+			stats.IsolatedModCache = true
+		}
 		const init = true
-		if err := prepareModule(ctx, modulePath, version, inputPath, s.proxyClient, s.insecure, init); err != nil {
+		s.enterPhase(phaseDownloading)
+		stats.ReplacesDropped, err = prepareModule(ctx, modulePath, version, inputPath, s.proxyClientFor(modulePath), s.insecure, init, s.dropReplaces, s.modCache, s.private)
+		if err != nil {
 			return err
 		}
 
-		var findings []*govulncheckapi.Finding
-		if s.insecure {
-			findings, err = s.runGovulncheckScanInsecure(inputPath, mode, stats)
-		} else {
-			findings, err = s.runGovulncheckScanSandbox(ctx, inputPath, mode, stats)
-		}
+		vendored := fileExists(filepath.Join(inputPath, "vendor", "modules.txt"))
+		stats.Vendored = &vendored
+		s.chooseToolchain(inputPath)
+
+		s.enterPhase(phaseScanning)
+		findings, osvs, err = s.runGovulncheckScan(ctx, inputPath, mode, s.ignoreVendor, stats)
 		if err != nil {
 			return err
 		}
-		log.Debugf(ctx, "govulncheck stats: %dkb | %vs", stats.ScanMemory, stats.ScanSeconds)
+		if govulncheck.MemoryUsageAvailable() {
+			log.Debugf(ctx, "govulncheck stats: %dkb | %vs", stats.ScanMemory, stats.ScanSeconds)
+		} else {
+			log.Debugf(ctx, "govulncheck stats: %vs", stats.ScanSeconds)
+		}
 
-		for _, v := range findings {
-			bvulns = append(bvulns, govulncheck.ConvertGovulncheckFinding(v))
+		if vendored && s.vendorCompare && !s.ignoreVendor {
+			// Scan again, ignoring the vendor directory. The first scan's
+			// stats describe the row, so these are discarded.
+			modFindings, _, err := s.runGovulncheckScan(ctx, inputPath, mode, true, &govulncheck.ScanStats{})
+			if err != nil {
+				log.Errorf(ctx, err, "scanning %s@%s without its vendor directory", modulePath, version)
+				stats.Errors = append(stats.Errors, fmt.Errorf("scanning without vendor directory: %w", err))
+			} else {
+				differ := !sameOSVs(findings, modFindings)
+				stats.VendorFindingsDiffer = &differ
+			}
 		}
 		return nil
 	})
-	return bvulns, err
+	return findings, osvs, err
+}
+
+// runGovulncheckScan runs govulncheck on the module in inputPath,
+// in the sandbox unless s.insecure is set.
+func (s *scanner) runGovulncheckScan(ctx context.Context, inputPath, mode string, ignoreVendor bool, stats *govulncheck.ScanStats) ([]*govulncheckapi.Finding, []*osv.Entry, error) {
+	if s.insecure {
+		return s.runGovulncheckScanInsecure(ctx, inputPath, mode, ignoreVendor, stats)
+	}
+	return s.runGovulncheckScanSandbox(ctx, inputPath, mode, ignoreVendor, stats)
+}
+
+// sameOSVs reports whether the two lists of findings are for the same
+// set of vulnerabilities.
+func sameOSVs(f1, f2 []*govulncheckapi.Finding) bool {
+	ids := func(fs []*govulncheckapi.Finding) map[string]bool {
+		m := map[string]bool{}
+		for _, f := range fs {
+			m[f.OSV] = true
+		}
+		return m
+	}
+	return maps.Equal(ids(f1), ids(f2))
 }
 
-func (s *scanner) runGovulncheckScanSandbox(ctx context.Context, inputPath, mode string, stats *govulncheck.ScanStats) (_ []*govulncheckapi.Finding, err error) {
+func (s *scanner) runGovulncheckScanSandbox(ctx context.Context, inputPath, mode string, ignoreVendor bool, stats *govulncheck.ScanStats) (_ []*govulncheckapi.Finding, _ []*osv.Entry, err error) {
 	smdir := strings.TrimPrefix(inputPath, sandboxRoot)
-	err = s.sbox.Validate()
-	log.Debugf(ctx, "sandbox Validate returned %v", err)
+	if err := s.sbox.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("%v: %w", err, derrors.SandboxError)
+	}
 
-	response, err := s.runGovulncheckSandbox(ctx, modeToGovulncheckFlag(mode), smdir)
+	// Time the sandbox invocation here, since the sandbox's own
+	// times are not reported if it fails.
+	stats.StartedAt = time.Now()
+	response, err := s.runGovulncheckSandbox(ctx, mode, smdir, ignoreVendor)
+	stats.FinishedAt = time.Now()
 	if err != nil {
-		return nil, err
+		// The sandbox reports no stats if govulncheck failed.
+		stats.ScanSeconds = stats.FinishedAt.Sub(stats.StartedAt).Seconds()
+		return nil, nil, err
 	}
 	stats.ScanMemory = response.Stats.ScanMemory
 	stats.ScanSeconds = response.Stats.ScanSeconds
-	return response.Findings, nil
+	stats.UserCPUSeconds = response.Stats.UserCPUSeconds
+	stats.SystemCPUSeconds = response.Stats.SystemCPUSeconds
+	stats.MaxRSS = response.Stats.MaxRSS
+	stats.FindingsCapped = response.Stats.FindingsCapped
+	stats.PackagesWithErrors = response.Stats.PackagesWithErrors
+	stats.Config = response.Stats.Config
+	stats.PackageErrors = response.Stats.PackageErrors
+	// Prefer the sandbox's times, which exclude its startup.
+	if !response.Stats.StartedAt.IsZero() {
+		stats.StartedAt = response.Stats.StartedAt
+		stats.FinishedAt = response.Stats.FinishedAt
+	}
+	return response.Findings, response.OSVs, nil
 }
 
-func (s *scanner) runGovulncheckSandbox(ctx context.Context, mode, arg string) (*govulncheck.SandboxResponse, error) {
+func (s *scanner) runGovulncheckSandbox(ctx context.Context, mode, arg string, ignoreVendor bool) (*govulncheck.SandboxResponse, error) {
 	goOut, err := s.sbox.Command("/usr/local/go/bin/go", "version").Output()
 	if err != nil {
 		log.Debugf(ctx, "running go version error: %v", err)
@@ -466,7 +1331,18 @@ func (s *scanner) runGovulncheckSandbox(ctx context.Context, mode, arg string) (
 		log.Debugf(ctx, "Sandbox running %s", goOut)
 	}
 	log.Infof(ctx, "running govulncheck in sandbox: mode %s, arg %q", mode, arg)
-	cmd := s.sbox.Command(filepath.Join(s.binaryDir, "govulncheck_sandbox"), s.govulncheckPath, modeToGovulncheckFlag(mode), arg, s.vulnDBDir)
+	cmd := s.sbox.Command(filepath.Join(s.binaryDir, "govulncheck_sandbox"),
+		s.maxFindingsFlag(), s.timeoutFlag(), fmt.Sprintf("-ignore-vendor=%t", ignoreVendor), "-platform="+s.platform,
+		fmt.Sprintf("-perpackage=%t", s.perPackage), "-tags="+s.tags, "-goflags="+s.goFlags,
+		fmt.Sprintf("-test=%t", s.tests), "-pattern="+s.packagePattern(), "-goroot="+strings.TrimPrefix(s.toolchain.goroot, sandboxRoot), s.govulncheckPath, modeToGovulncheckFlag(mode), arg, govulncheck.JoinVulnDBDirs(s.vulnDBDirs))
+	if s.modCache != "" {
+		cmd.Env = []string{"GOMODCACHE=" + strings.TrimPrefix(s.modCache, sandboxRoot)}
+		cmd.AppendToEnv = true
+	}
+	if env := s.private.govulncheckEnv(); env != nil {
+		cmd.Env = append(cmd.Env, env...)
+		cmd.AppendToEnv = true
+	}
 	stdout, err := cmd.Output()
 	log.Infof(ctx, "govulncheck in sandbox finished with err=%v", err)
 	if err != nil {
@@ -475,9 +1351,18 @@ func (s *scanner) runGovulncheckSandbox(ctx context.Context, mode, arg string) (
 	return govulncheck.UnmarshalSandboxResponse(stdout)
 }
 
-func (s *scanner) runGovulncheckCompareSandbox(ctx context.Context, arg string) (*govulncheck.CompareResponse, error) {
-	cmd := s.sbox.Command(filepath.Join(s.binaryDir, "govulncheck_compare"), s.govulncheckPath, arg, s.vulnDBDir)
-	log.Infof(ctx, "running govulncheck_compare: arg %q", arg)
+// runGovulncheckCompareSandbox runs govulncheck_compare on the module in
+// arg, comparing only pkgs if there are any. Extra flags, like -list, are
+// passed along.
+func (s *scanner) runGovulncheckCompareSandbox(ctx context.Context, arg string, pkgs []string, flags ...string) (*govulncheck.CompareResponse, error) {
+	args := []string{s.maxFindingsFlag(), s.timeoutFlag()}
+	if len(pkgs) > 0 {
+		args = append(args, "-packages="+strings.Join(pkgs, ","))
+	}
+	args = append(args, flags...)
+	args = append(args, s.govulncheckPath, arg, govulncheck.JoinVulnDBDirs(s.vulnDBDirs))
+	cmd := s.sbox.Command(filepath.Join(s.binaryDir, "govulncheck_compare"), args...)
+	log.Infof(ctx, "running govulncheck_compare: args %q", args)
 	stdout, err := cmd.Output()
 	log.Infof(ctx, "govulncheck_compare in sandbox finished with err=%v", err)
 	if err != nil {
@@ -486,8 +1371,49 @@ func (s *scanner) runGovulncheckCompareSandbox(ctx context.Context, arg string)
 	return govulncheck.UnmarshalCompareResponse(stdout)
 }
 
-func (s *scanner) runGovulncheckScanInsecure(inputPath, mode string, stats *govulncheck.ScanStats) (_ []*govulncheckapi.Finding, err error) {
-	return govulncheck.RunGovulncheckCmd(s.govulncheckPath, modeToGovulncheckFlag(mode), "./...", inputPath, s.vulnDBDir, stats)
+func (s *scanner) runGovulncheckScanInsecure(ctx context.Context, inputPath, mode string, ignoreVendor bool, stats *govulncheck.ScanStats) (_ []*govulncheckapi.Finding, _ []*osv.Entry, err error) {
+	opts := &govulncheck.RunOptions{
+		MaxFindings:  s.maxFindings,
+		IgnoreVendor: ignoreVendor,
+		Timeout:      s.scanTimeout,
+		Platform:     s.platform,
+		Tags:         s.tags,
+		GoFlags:      s.goFlags,
+		Tests:        s.tests,
+		GoRoot:       s.toolchain.goroot,
+		Env:          s.private.govulncheckEnv(),
+		VulnDBCaches: s.vulnDBCaches,
+	}
+	if s.events != nil {
+		opts.Progress = func(p *govulncheckapi.Progress) { s.events.progress(p.Message) }
+	}
+	if mode == ModeExtract {
+		return govulncheck.RunGovulncheckCmd(ctx, s.govulncheckPath, govulncheck.FlagBinary, inputPath, "", s.vulnDBDirs, opts, stats)
+	}
+	if s.perPackage {
+		return govulncheck.RunGovulncheckPerPackage(ctx, s.govulncheckPath, modeToGovulncheckFlag(mode), s.packagePattern(), inputPath, s.vulnDBDirs, opts, stats)
+	}
+	return govulncheck.RunGovulncheckCmd(ctx, s.govulncheckPath, modeToGovulncheckFlag(mode), s.packagePattern(), inputPath, s.vulnDBDirs, opts, stats)
+}
+
+// packagePattern returns the pattern of the packages to scan.
+func (s *scanner) packagePattern() string {
+	if s.pattern == "" {
+		return govulncheck.DefaultPattern
+	}
+	return s.pattern
+}
+
+// maxFindingsFlag returns the flag that passes s.maxFindings
+// to the sandbox programs.
+func (s *scanner) maxFindingsFlag() string {
+	return fmt.Sprintf("-max-findings=%d", s.maxFindings)
+}
+
+// timeoutFlag returns the flag that passes s.scanTimeout
+// to the sandboxed programs.
+func (s *scanner) timeoutFlag() string {
+	return fmt.Sprintf("-timeout=%s", s.scanTimeout)
 }
 
 func isGovulncheckLoadError(err error) bool {
@@ -5,16 +5,23 @@
 package worker
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/buildtest"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
 func TestAsScanError(t *testing.T) {
@@ -46,7 +53,7 @@ func TestVulnsForMode(t *testing.T) {
 		mode string
 		want string
 	}{
-		{modeImports, "A:false, B:false, C:false"},
+		{ModeImports, "A:false, B:false, C:false"},
 		{ModeGovulncheck, "C:true"},
 		{modeBinary, "A:false, B:false, C:true"},
 	} {
@@ -60,6 +67,34 @@ func TestVulnsForMode(t *testing.T) {
 	}
 }
 
+func TestCanonicalizeVersions(t *testing.T) {
+	ctx := context.Background()
+	rows := []bigquery.Row{
+		&govulncheck.Result{ModulePath: "m", Version: "v1.2.3+incompatible", SortVersion: "1,2,3~"},
+		&govulncheck.Result{ModulePath: "m", Version: "1.2"},
+		&govulncheck.Result{ModulePath: "m", Version: "latest"},
+		&govulncheck.Result{ModulePath: stdlibModulePath, Version: "go1.21.0"},
+	}
+	if err := canonicalizeVersions(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, r.(*govulncheck.Result).Version)
+	}
+	if want := "v1.2.3 v1.2.0 latest go1.21.0"; strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	if r := rows[0].(*govulncheck.Result); r.SortVersion != version.ForSorting("v1.2.3") {
+		t.Errorf("got sort version %q, want that of v1.2.3", r.SortVersion)
+	}
+
+	bad := []bigquery.Row{&govulncheck.Result{ModulePath: "m", Version: "vmaster"}}
+	if err := canonicalizeVersions(ctx, bad); !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+}
+
 func TestUnrecoverableError(t *testing.T) {
 	for _, e := range []struct {
 		ec   string
@@ -75,6 +110,39 @@ func TestUnrecoverableError(t *testing.T) {
 	}
 }
 
+func TestDBEntryCount(t *testing.T) {
+	got, err := dbEntryCount("../testdata/vulndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2; got != want {
+		t.Errorf("got %d entries, want %d", got, want)
+	}
+}
+
+func TestSameOSVs(t *testing.T) {
+	fs := func(ids ...string) []*govulncheckapi.Finding {
+		var r []*govulncheckapi.Finding
+		for _, id := range ids {
+			r = append(r, &govulncheckapi.Finding{OSV: id})
+		}
+		return r
+	}
+	for _, test := range []struct {
+		f1, f2 []*govulncheckapi.Finding
+		want   bool
+	}{
+		{nil, nil, true},
+		{fs("A", "B", "A"), fs("B", "A"), true},
+		{fs("A"), fs("A", "B"), false},
+		{fs("A"), nil, false},
+	} {
+		if got := sameOSVs(test.f1, test.f2); got != test.want {
+			t.Errorf("sameOSVs(%d findings, %d findings) = %t, want %t", len(test.f1), len(test.f2), got, test.want)
+		}
+	}
+}
+
 // TODO: can we have a test for sandbox? We do test the sandbox
 // and unmarshalling in cmd/govulncheck_sandbox, so what would be
 // left here is checking that runsc is initiated properly. It is
@@ -94,10 +162,10 @@ func TestRunScanModuleInsecure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	s := &scanner{insecure: true, govulncheckPath: govulncheckPath, vulnDBDir: vulndb}
+	s := &scanner{insecure: true, govulncheckPath: govulncheckPath, vulnDBDirs: []string{vulndb}}
 
 	stats := &govulncheck.ScanStats{}
-	findings, err := s.runGovulncheckScanInsecure("../testdata/module", ModeGovulncheck, stats)
+	findings, _, err := s.runGovulncheckScanInsecure(context.Background(), "../testdata/module", ModeGovulncheck, false, stats)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -115,7 +183,55 @@ func TestRunScanModuleInsecure(t *testing.T) {
 	if got := stats.ScanSeconds; got <= 0 {
 		t.Errorf("scan time not collected or negative: %v", got)
 	}
-	if got := stats.ScanMemory; got <= 0 && runtime.GOOS == "linux" {
+	if got := stats.ScanMemory; got <= 0 && govulncheck.MemoryUsageAvailable() {
 		t.Errorf("scan memory not collected or negative: %v", got)
 	}
 }
+
+func TestWriteOSVMatchesCSV(t *testing.T) {
+	matches := []*govulncheck.OSVMatch{
+		{ModulePath: "example.com/a", Version: "v1.0.0", Called: true, FixedVersion: bigquery.NullString("v1.0.1")},
+		{ModulePath: "example.com/b", Version: "v2.0.0"},
+	}
+	var buf bytes.Buffer
+	if err := writeOSVMatchesCSV(&buf, matches); err != nil {
+		t.Fatal(err)
+	}
+	want := `module_path,version,called,fixed_version
+example.com/a,v1.0.0,true,v1.0.1
+example.com/b,v2.0.0,false,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteHistoryCSV(t *testing.T) {
+	rows := []*govulncheck.HistoryRow{
+		{Version: "v1.1.0", CreatedAt: time.Date(2023, 6, 2, 3, 4, 5, 0, time.UTC), ScanMode: "GOVULNCHECK", CalledOSVs: []string{"GO-2023-0001", "GO-2023-0002"}},
+		{Version: "v1.0.0", CreatedAt: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), ScanMode: "IMPORTS", ErrorCategory: "PROXY"},
+	}
+	var buf bytes.Buffer
+	if err := writeHistoryCSV(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	want := `version,created_at,scan_mode,error_category,called_osvs
+v1.1.0,2023-06-02T03:04:05Z,GOVULNCHECK,,GO-2023-0001 GO-2023-0002
+v1.0.0,2023-06-01T00:00:00Z,IMPORTS,PROXY,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistoryNextURL(t *testing.T) {
+	u, err := url.Parse("/govulncheck/history/example.com/m?limit=10&before=2023-07-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := historyNextURL(u, time.Date(2023, 6, 1, 0, 0, 0, 5, time.UTC))
+	want := "/govulncheck/history/example.com/m?before=2023-06-01T00%3A00%3A00.000000005Z&limit=10"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
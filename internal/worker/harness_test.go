@@ -0,0 +1,188 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/buildtest"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/proxy/proxytest"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+// A testHarness runs the govulncheck pipeline end to end without GCP:
+// modules are served by a fake proxy, vulnerabilities come from the
+// file-based vuln DB in ../testdata/vulndb, tasks go through the
+// in-memory queue, and rows are stored in memory instead of BigQuery.
+type testHarness struct {
+	t      *testing.T
+	server *GovulncheckServer
+	queue  *queue.InMemory
+
+	mu     sync.Mutex
+	tables map[string][]bigquery.Row // uploaded rows, by table
+	errs   []error                   // errors from scan handlers
+}
+
+// newTestHarness returns a harness whose proxy serves modules.
+// It builds govulncheck, so it needs a network connection.
+func newTestHarness(t *testing.T, modules []*proxytest.Module) *testHarness {
+	t.Helper()
+	binaryDir := t.TempDir()
+	if _, err := buildtest.BuildGovulncheck(binaryDir); err != nil {
+		t.Fatal(err)
+	}
+	vulnDBDir, err := filepath.Abs("../testdata/vulndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyClient, cleanup := proxytest.SetupTestClient(t, modules)
+	t.Cleanup(cleanup)
+
+	h := &testHarness{t: t, tables: map[string][]bigquery.Row{}}
+	s := &Server{
+		cfg: &config.Config{
+			Insecure:  true,
+			BinaryDir: binaryDir,
+			VulnDBDir: vulnDBDir,
+		},
+		proxyClient: proxyClient,
+		uploadRows:  h.upload,
+	}
+	h.server = newGovulncheckServer(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	h.queue = queue.NewInMemory(ctx, 2, h.process)
+	s.queue = h.queue
+	return h
+}
+
+// upload is the in-memory replacement for uploading rows to BigQuery.
+func (h *testHarness) upload(_ context.Context, table string, rows []bigquery.Row) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tables[table] = append(h.tables[table], rows...)
+	return nil
+}
+
+// process handles a task the way Cloud Tasks would: by sending
+// it to the scan endpoint.
+func (h *testHarness) process(ctx context.Context, task queue.Task, _ *queue.Options) (int, error) {
+	r := httptest.NewRequest("POST", "/govulncheck/scan/"+task.Path()+"?"+task.Params(), nil).WithContext(ctx)
+	r.Header.Set("X-CloudTasks-QueueName", "harness")
+	w := httptest.NewRecorder()
+	if err := h.server.handleScan(w, r); err != nil {
+		h.mu.Lock()
+		h.errs = append(h.errs, fmt.Errorf("%s: %w", task.Name(), err))
+		h.mu.Unlock()
+		return http.StatusInternalServerError, err
+	}
+	return w.Code, nil
+}
+
+// run enqueues the modules in corpus, which has the format of
+// a modules file (see scan.ParseCorpusFile), with the given enqueue
+// query params, and waits for all tasks to finish.
+func (h *testHarness) run(corpus, params string) {
+	h.t.Helper()
+	file := filepath.Join(h.t.TempDir(), "modules.txt")
+	if err := os.WriteFile(file, []byte(corpus), 0644); err != nil {
+		h.t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/govulncheck/enqueue?file="+file+"&"+params, nil)
+	if err := h.server.handleEnqueue(httptest.NewRecorder(), r); err != nil {
+		h.t.Fatal(err)
+	}
+	h.queue.WaitForTesting(context.Background())
+	for _, err := range h.errs {
+		h.t.Error(err)
+	}
+}
+
+// results returns the govulncheck rows uploaded so far.
+func (h *testHarness) results() []*govulncheck.Result {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var rs []*govulncheck.Result
+	for _, row := range h.tables[govulncheck.TableName] {
+		rs = append(rs, row.(*govulncheck.Result))
+	}
+	return rs
+}
+
+func TestHarnessGovulncheckRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test that uses internet in short mode")
+	}
+	// The good module calls a vulnerable function in golang.org/x/text.
+	goMod, err := os.ReadFile("../testdata/module/go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goSum, err := os.ReadFile("../testdata/module/go.sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vulnGo, err := os.ReadFile("../testdata/module/vuln.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHarness(t, []*proxytest.Module{
+		{
+			ModulePath: "example.com/good",
+			Version:    "v1.0.0",
+			Files: map[string]string{
+				"go.mod":  strings.Replace(string(goMod), "module golang.org/vuln", "module example.com/good", 1),
+				"go.sum":  string(goSum),
+				"vuln.go": string(vulnGo),
+			},
+		},
+		{
+			ModulePath: "example.com/broken",
+			Version:    "v1.0.0",
+			Files: map[string]string{
+				"go.mod":    "module example.com/broken\n\ngo 1.18\n",
+				"broken.go": "package broken\n\nfunc F( {\n",
+			},
+		},
+	})
+	h.run("example.com/good v1.0.0 10\nexample.com/broken v1.0.0 5\n", "min=1&nomajor=true&suffix=e2e")
+
+	// Summarize each row as "module mode: error category: vuln IDs".
+	var got []string
+	for _, r := range h.results() {
+		if r.Suffix != "e2e" {
+			t.Errorf("%s %s: got suffix %q, want %q", r.ModulePath, r.ScanMode, r.Suffix, "e2e")
+		}
+		var ids []string
+		for _, v := range r.Vulns {
+			ids = append(ids, v.ID)
+		}
+		sort.Strings(ids)
+		got = append(got, fmt.Sprintf("%s %s: %s: %s", r.ModulePath, r.ScanMode, r.ErrorCategory, strings.Join(ids, ",")))
+	}
+	sort.Strings(got)
+	want := []string{
+		"example.com/broken GOVULNCHECK: LOAD: ",
+		"example.com/broken IMPORTS: LOAD: ",
+		"example.com/good GOVULNCHECK: : GO-2021-0113",
+		"example.com/good IMPORTS: : GO-2021-0113",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got rows\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/notify"
+)
+
+var insertBytesCounter = event.NewCounter("insert_bytes", &event.MetricOptions{Namespace: metricNamespace})
+
+// insertVolumeDB records the number of bytes each run has inserted into
+// BigQuery. It is implemented by jobs.DB.
+type insertVolumeDB interface {
+	AddInsertVolume(ctx context.Context, suffix string, n int64) (int64, error)
+	InsertVolume(ctx context.Context, suffix string) (int64, error)
+}
+
+// memInsertVolumeDB is an insertVolumeDB for when there is no jobs DB.
+// It only accounts for the rows inserted by this process.
+type memInsertVolumeDB struct {
+	mu      sync.Mutex
+	volumes map[string]int64
+}
+
+func (m *memInsertVolumeDB) AddInsertVolume(_ context.Context, suffix string, n int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.volumes == nil {
+		m.volumes = map[string]int64{}
+	}
+	m.volumes[suffix] += n
+	return m.volumes[suffix], nil
+}
+
+func (m *memInsertVolumeDB) InsertVolume(_ context.Context, suffix string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.volumes[suffix], nil
+}
+
+// An insertLimiter tracks the approximate number of bytes inserted into
+// BigQuery per run, and rejects rows for runs that exceed a limit.
+type insertLimiter struct {
+	db       insertVolumeDB
+	limit    int64 // if zero, there is no limit
+	notifier notify.Notifier
+}
+
+// check returns an error wrapping derrors.InsertVolumeExceeded if
+// the run identified by suffix has exceeded the limit.
+// A nil insertLimiter has no limit.
+func (l *insertLimiter) check(ctx context.Context, suffix string) error {
+	if l == nil || l.limit <= 0 {
+		return nil
+	}
+	n, err := l.db.InsertVolume(ctx, suffix)
+	if err != nil {
+		return err
+	}
+	if n >= l.limit {
+		return fmt.Errorf("run %q inserted %d bytes, limit is %d: %w", suffix, n, l.limit, derrors.InsertVolumeExceeded)
+	}
+	return nil
+}
+
+// charge adds the size of rows to the insert volume of the run
+// identified by suffix. It returns an error wrapping
+// derrors.InsertVolumeExceeded, without charging, if the run
+// had already exceeded the limit.
+// The first time a run exceeds the limit, an alert is sent.
+func (l *insertLimiter) charge(ctx context.Context, suffix string, rows []bigquery.Row) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.check(ctx, suffix); err != nil {
+		return err
+	}
+	var size int64
+	for _, r := range rows {
+		size += rowSize(r)
+	}
+	total, err := l.db.AddInsertVolume(ctx, suffix, size)
+	if err != nil {
+		return err
+	}
+	insertBytesCounter.Record(ctx, size, event.String("suffix", suffix))
+	if l.limit > 0 && total >= l.limit && total-size < l.limit {
+		msg := fmt.Sprintf("run %q has inserted %d bytes into BigQuery, exceeding the limit of %d; further rows will be rejected",
+			suffix, total, l.limit)
+		log.Errorf(ctx, derrors.InsertVolumeExceeded, "%s", msg)
+		if l.notifier != nil {
+			if err := l.notifier.Notify(ctx, &notify.Notification{
+				Subject: fmt.Sprintf("insert volume exceeded for run %q", suffix),
+				Body:    msg,
+			}); err != nil {
+				log.Errorf(ctx, err, "sending insert volume alert")
+			}
+		}
+	}
+	return nil
+}
+
+// rowSize approximates the number of bytes that row
+// contributes to an insert by the size of its JSON encoding.
+func rowSize(row bigquery.Row) int64 {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
+
+// InsertVolume describes how much data a run has inserted into BigQuery.
+type InsertVolume struct {
+	Suffix string
+	Bytes  int64
+	Limit  int64 // zero means no limit
+}
+
+// handleInsertVolume displays the insert volume of a run.
+// It is triggered by path /insert-volume?suffix=SUFFIX.
+func (s *Server) handleInsertVolume(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleInsertVolume")
+	ctx := r.Context()
+	suffix := r.FormValue("suffix")
+	n, err := s.insertLimiter.db.InsertVolume(ctx, suffix)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, &InsertVolume{Suffix: suffix, Bytes: n, Limit: s.insertLimiter.limit})
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/notify"
+)
+
+type testNotifier struct {
+	notes []*notify.Notification
+}
+
+func (n *testNotifier) Notify(_ context.Context, note *notify.Notification) error {
+	n.notes = append(n.notes, note)
+	return nil
+}
+
+func TestInsertLimiter(t *testing.T) {
+	ctx := context.Background()
+	rows := []bigquery.Row{&govulncheck.Result{ModulePath: "example.com/m"}}
+	size := rowSize(rows[0])
+	notifier := &testNotifier{}
+	l := &insertLimiter{
+		db:       &memInsertVolumeDB{},
+		limit:    2*size - 1,
+		notifier: notifier,
+	}
+	// The first two charges succeed; the second one exceeds the limit.
+	for i := 0; i < 2; i++ {
+		if err := l.charge(ctx, "run", rows); err != nil {
+			t.Fatalf("charge #%d: %v", i, err)
+		}
+	}
+	if len(notifier.notes) != 1 {
+		t.Errorf("got %d notifications, want 1", len(notifier.notes))
+	}
+	err := l.charge(ctx, "run", rows)
+	if !errors.Is(err, derrors.InsertVolumeExceeded) {
+		t.Errorf("got %v, want InsertVolumeExceeded", err)
+	}
+	if got, want := derrors.CategorizeError(err), "BIGQUERY - INSERT VOLUME EXCEEDED"; got != want {
+		t.Errorf("category: got %q, want %q", got, want)
+	}
+	// Other runs are unaffected.
+	if err := l.charge(ctx, "other", rows); err != nil {
+		t.Errorf("other run: %v", err)
+	}
+	if len(notifier.notes) != 1 {
+		t.Errorf("got %d notifications, want 1", len(notifier.notes))
+	}
+}
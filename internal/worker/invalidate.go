@@ -0,0 +1,151 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// invalidateReport is the response of handleInvalidate.
+type invalidateReport struct {
+	Suffix string
+	From   string
+	To     string
+	Reason string
+	// Rows is the number of rows invalidated, or that would be in a dry
+	// run.
+	Rows int
+	// ModuleVersions is the number of module versions with invalidated
+	// rows.
+	ModuleVersions int
+	// Rescan is the suffix of the run that scans them again, if any, and
+	// Enqueued the number of tasks enqueued for it.
+	Rescan   string `json:",omitempty"`
+	Enqueued int    `json:",omitempty"`
+	DryRun   bool
+}
+
+// handleInvalidate marks the rows of the govulncheck table that are known
+// to be wrong, such as those of a run with a bug, as invalid, so that
+// queries for the latest results and summaries ignore them. It can also
+// enqueue scans of the affected module versions.
+//
+// It is triggered by path /govulncheck/invalidate?params.
+// See govulncheck.InvalidateQueryParams for the query params.
+func (h *GovulncheckServer) handleInvalidate(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleInvalidate")
+
+	ctx := r.Context()
+	params := &govulncheck.InvalidateQueryParams{}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	filter, err := govulncheck.ParseInvalidationFilter(params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Rescan != "" {
+		if err := govulncheck.ValidateSuffix(params.Rescan); err != nil {
+			return fmt.Errorf("%w: rescan: %v", derrors.InvalidArgument, err)
+		}
+		if params.Rescan == params.Suffix {
+			return fmt.Errorf("%w: rescan suffix must differ from the invalidated run", derrors.InvalidArgument)
+		}
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	targets, err := govulncheck.ReadInvalidationTargets(ctx, h.bqClient, filter)
+	if err != nil {
+		return err
+	}
+	report := &invalidateReport{
+		Suffix:         params.Suffix,
+		From:           params.From,
+		To:             params.To,
+		Reason:         params.Reason,
+		ModuleVersions: countModuleVersions(targets),
+		Rescan:         params.Rescan,
+		DryRun:         params.DryRun,
+	}
+	for _, t := range targets {
+		report.Rows += t.Rows
+	}
+	var tasks []queue.Task
+	if params.Rescan != "" {
+		tasks = rescanTasks(targets, params.Rescan)
+		report.Enqueued = len(tasks)
+	}
+	log.Infof(ctx, "invalidate: %d rows of %d module versions (filter %+v, dry run: %t)",
+		report.Rows, report.ModuleVersions, filter, params.DryRun)
+	if params.DryRun || report.Rows == 0 {
+		return writeJSON(w, report)
+	}
+	if err := govulncheck.Invalidate(ctx, h.bqClient, filter, params.Reason); err != nil {
+		return err
+	}
+	suffix := params.Suffix
+	if suffix == "" {
+		suffix = govulncheck.MaintenanceSuffix
+	}
+	defer h.flushRunEvents(ctx)
+	h.runEvents.add(ctx, govulncheck.NewEvent(suffix, govulncheck.EventRowsInvalidated, govulncheck.SeverityWarning,
+		fmt.Sprintf("invalidated %d rows: %s", report.Rows, params.Reason), report))
+	if len(tasks) > 0 {
+		hash := setCorpusHash(tasks)
+		err := h.enqueueTasks(ctx, tasks,
+			&queue.Options{Namespace: "govulncheck", TaskNameSuffix: params.Rescan}, nil)
+		if err != nil {
+			return err
+		}
+		h.runEvents.add(ctx, govulncheck.NewEvent(params.Rescan, govulncheck.EventEnqueued, govulncheck.SeverityInfo,
+			fmt.Sprintf("enqueued %d tasks to rescan invalidated rows", len(tasks)),
+			map[string]any{"tasks": len(tasks), "invalidated": suffix, "corpus_hash": hash}))
+	}
+	return writeJSON(w, report)
+}
+
+// countModuleVersions returns the number of distinct module versions of
+// targets, which are sorted.
+func countModuleVersions(targets []*govulncheck.InvalidationTarget) int {
+	n := 0
+	for i, t := range targets {
+		if i == 0 || t.ModulePath != targets[i-1].ModulePath || t.Version != targets[i-1].Version {
+			n++
+		}
+	}
+	return n
+}
+
+// rescanTasks returns the tasks that scan the module versions of targets
+// again with the given suffix, with the params of their invalidated scans.
+// The standard library and scrubbed modules can't be scanned this way and
+// are omitted.
+func rescanTasks(targets []*govulncheck.InvalidationTarget, suffix string) []queue.Task {
+	var mods []*govulncheck.ErroredModule
+	for _, t := range targets {
+		if t.ModulePath == "stdlib" || t.Scrubbed {
+			continue
+		}
+		mods = append(mods, &govulncheck.ErroredModule{
+			ModulePath:          t.ModulePath,
+			Version:             t.Version,
+			ScanMode:            t.ScanMode,
+			ImportedBy:          t.ImportedBy,
+			RequestedModulePath: t.RequestedModulePath,
+			IgnoredVendor:       t.IgnoredVendor,
+			ReplacedDropped:     t.ReplacedDropped,
+		})
+	}
+	return retryTasks(mods, suffix)
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+func TestRescanTasks(t *testing.T) {
+	targets := []*govulncheck.InvalidationTarget{
+		{ModulePath: "example.com/a", Version: "v1.0.0", ScanMode: "GOVULNCHECK", ImportedBy: 5, Rows: 2},
+		{ModulePath: "example.com/a", Version: "v1.0.0", ScanMode: "IMPORTS", ImportedBy: 5, Rows: 2},
+		{ModulePath: "example.com/b/v2", Version: "v2.1.0", ScanMode: "GOVULNCHECK", Rows: 1,
+			RequestedModulePath: bigquery.NullString("example.com/b"),
+			IgnoredVendor:       bigquery.NullBool(true)},
+		{ModulePath: "example.com/c", Version: "v0.1.0", ScanMode: "GOVULNCHECK", Rows: 1, Scrubbed: true},
+		{ModulePath: "stdlib", Version: "v1.21.0", ScanMode: "GOVULNCHECK", Rows: 1},
+	}
+	if got, want := countModuleVersions(targets), 4; got != want {
+		t.Errorf("countModuleVersions: got %d, want %d", got, want)
+	}
+	req := func(path, version string, qp govulncheck.QueryParams) *govulncheck.Request {
+		qp.Mode = ModeGovulncheck
+		qp.Suffix = "rescan"
+		qp.NoMajor = true
+		return &govulncheck.Request{
+			ModuleURLPath: scan.ModuleURLPath{Module: path, Version: version},
+			QueryParams:   qp,
+		}
+	}
+	want := []queue.Task{
+		req("example.com/a", "v1.0.0", govulncheck.QueryParams{ImportedBy: 5}),
+		req("example.com/b/v2", "v2.1.0", govulncheck.QueryParams{BasePath: "example.com/b", NoVendor: true}),
+	}
+	got := rescanTasks(targets, "rescan")
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(govulncheck.Request{})); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// handleUpdateLatest merges the latest rows of the govulncheck table into
+// the govulncheck-latest table. It is meant to be called periodically by
+// a scheduler.
+//
+// It is triggered by path /govulncheck/update-latest.
+func (h *GovulncheckServer) handleUpdateLatest(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleUpdateLatest")
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	start := time.Now()
+	if err := govulncheck.UpdateLatest(r.Context(), h.bqClient); err != nil {
+		return err
+	}
+	log.Infof(r.Context(), "updated latest rows in %s", time.Since(start))
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// handleUpdateOSVs recomputes the govulncheck-osvs table.
+// It is meant to be called periodically by a scheduler.
+//
+// It is triggered by path /govulncheck/update-osvs.
+func (h *GovulncheckServer) handleUpdateOSVs(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleUpdateOSVs")
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	start := time.Now()
+	if err := govulncheck.UpdateOSVSummaries(r.Context(), h.bqClient); err != nil {
+		return err
+	}
+	log.Infof(r.Context(), "updated OSV summaries in %s", time.Since(start))
+	return nil
+}
+
+// osvSummaryResponse is an OSV summary as served by handleOSVs.
+type osvSummaryResponse struct {
+	*govulncheck.OSVSummary
+	// URL is the path of the endpoint serving the modules affected by
+	// the OSV.
+	URL string
+}
+
+// osvsPath is the path of the endpoint served by handleOSVs.
+const osvsPath = "/govulncheck/osvs"
+
+// handleOSVs serves the OSV entries that ever affected a module of the
+// corpus, with how many modules they affect.
+// It is triggered by path /govulncheck/osvs?params.
+//
+// See govulncheck.OSVsQueryParams for the query params.
+func (h *GovulncheckServer) handleOSVs(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleOSVs")
+
+	params := &govulncheck.OSVsQueryParams{}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	filter, err := govulncheck.ParseOSVSummaryFilter(params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	sums, err := govulncheck.ReadOSVSummaries(r.Context(), h.bqClient, filter)
+	if err != nil {
+		return err
+	}
+	res := []osvSummaryResponse{} // an empty array, not null
+	for _, s := range sums {
+		res = append(res, osvSummaryResponse{s, "/govulncheck/osv/" + s.OSVID})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, res)
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// A policyLoader provides the module policy, reloading it from its location
+// every refresh interval so that it can change without a redeploy.
+// A nil *policyLoader provides the nil policy, which denies nothing.
+type policyLoader struct {
+	loc     string
+	refresh time.Duration
+	read    func(context.Context, string) ([]byte, error) // readLocation, except in tests
+	now     func() time.Time
+
+	mu       sync.Mutex
+	policy   *scan.Policy
+	loadedAt time.Time // zero if the policy was never loaded
+}
+
+func newPolicyLoader(loc string, refresh time.Duration, now func() time.Time) *policyLoader {
+	if loc == "" {
+		return nil
+	}
+	return &policyLoader{loc: loc, refresh: refresh, read: readLocation, now: now}
+}
+
+// get returns the current policy, reloading it if it is stale.
+// If a reload fails, the previous policy is kept. get returns an error
+// only if the policy has never been loaded, so that modules are not
+// scanned without a policy.
+func (l *policyLoader) get(ctx context.Context) (_ *scan.Policy, err error) {
+	if l == nil {
+		return nil, nil
+	}
+	defer derrors.Wrap(&err, "policyLoader.get(%q)", l.loc)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.loadedAt.IsZero() && l.now().Sub(l.loadedAt) < l.refresh {
+		return l.policy, nil
+	}
+	p, err := l.load(ctx)
+	if err != nil {
+		if l.loadedAt.IsZero() {
+			return nil, err
+		}
+		log.Errorf(ctx, err, "reloading module policy; keeping policy %s", l.policy.Hash())
+		// Don't retry until the next refresh.
+		l.loadedAt = l.now()
+		return l.policy, nil
+	}
+	if l.loadedAt.IsZero() || p.Hash() != l.policy.Hash() {
+		log.Infof(ctx, "module policy from %s has hash %q", l.loc, p.Hash())
+	}
+	l.policy = p
+	l.loadedAt = l.now()
+	return p, nil
+}
+
+func (l *policyLoader) load(ctx context.Context) (*scan.Policy, error) {
+	data, err := l.read(ctx, l.loc)
+	if err != nil {
+		return nil, err
+	}
+	return scan.ParsePolicy(string(data))
+}
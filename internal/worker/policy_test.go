@@ -0,0 +1,55 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/clock"
+)
+
+func TestPolicyLoader(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	spec := "example.com/a\n"
+	var readErr error
+	reads := 0
+	l := newPolicyLoader("gs://bucket/policy", time.Hour, clk.Now)
+	l.read = func(context.Context, string) ([]byte, error) {
+		reads++
+		return []byte(spec), readErr
+	}
+	check := func(module string, wantDenied bool, wantReads int) {
+		t.Helper()
+		p, err := l.get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Denies(module); got != wantDenied {
+			t.Errorf("Denies(%q) = %t, want %t", module, got, wantDenied)
+		}
+		if reads != wantReads {
+			t.Errorf("got %d reads, want %d", reads, wantReads)
+		}
+	}
+
+	check("example.com/a", true, 1)
+	// The policy is reused until it is refresh old.
+	spec = "example.com/b\n"
+	clk.Advance(59 * time.Minute)
+	check("example.com/a", true, 1)
+	clk.Advance(time.Minute)
+	check("example.com/b", true, 2)
+	// A failed reload keeps the policy, and isn't retried until the
+	// next refresh.
+	readErr = errors.New("unavailable")
+	clk.Advance(time.Hour)
+	check("example.com/b", true, 3)
+	clk.Advance(time.Minute)
+	check("example.com/b", true, 3)
+}
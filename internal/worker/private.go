@@ -0,0 +1,150 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/pkgsite-metrics/internal"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/proxy"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// privateModules describes how a scan fetches private modules: those
+// matching its GOPRIVATE patterns, which are served by the private proxy
+// with the credentials in a netrc file.
+//
+// A nil *privateModules matches no modules.
+type privateModules struct {
+	patterns  string // GOPRIVATE patterns
+	proxyURL  string
+	netrcPath string // file holding the netrc, if any
+	client    *proxy.Client
+}
+
+// newPrivateModules returns the privateModules of sreq, or nil if it has
+// no GOPRIVATE patterns. The netrc selected by sreq, if any, is written
+// to a temporary file, which the caller must remove by calling cleanup.
+func newPrivateModules(ctx context.Context, cfg *config.Config, sreq *govulncheck.Request) (_ *privateModules, err error) {
+	if sreq.GoPrivate == "" {
+		if sreq.Netrc != "" {
+			return nil, scan.NewRequestError(scan.ErrBadParam, "netrc", "netrc requires goprivate")
+		}
+		return nil, nil
+	}
+	if cfg.PrivateProxyURL == "" {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "goprivate", "no private proxy is configured")
+	}
+	client, err := proxy.New(cfg.PrivateProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	p := &privateModules{patterns: sreq.GoPrivate, proxyURL: cfg.PrivateProxyURL, client: client}
+	if sreq.Netrc == "" {
+		return p, nil
+	}
+	if !slices.Contains(strings.Split(cfg.NetrcSecrets, ","), sreq.Netrc) {
+		return nil, scan.NewRequestError(scan.ErrBadParam, "netrc", "netrc secret %q is not allowed", sreq.Netrc)
+	}
+	netrc, err := internal.GetSecret(ctx, sreq.Netrc)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "netrc")
+	if err != nil {
+		return nil, err
+	}
+	p.netrcPath = f.Name()
+	defer func() {
+		if err != nil {
+			p.cleanup()
+		}
+	}()
+	if _, err := f.WriteString(netrc); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(cfg.PrivateProxyURL); err == nil {
+		if login, password, ok := netrcCredentials(netrc, u.Hostname()); ok {
+			p.client = client.WithBasicAuth(login, password)
+		}
+	}
+	return p, nil
+}
+
+// cleanup removes the netrc file of p, if any.
+func (p *privateModules) cleanup() {
+	if p != nil && p.netrcPath != "" {
+		os.Remove(p.netrcPath)
+	}
+}
+
+// matches reports whether modulePath is a private module.
+func (p *privateModules) matches(modulePath string) bool {
+	return p != nil && govulncheck.IsPrivate(p.patterns, modulePath)
+}
+
+// govulncheckEnv returns the environment variables that keep govulncheck
+// from looking up private modules anywhere but in the module cache.
+func (p *privateModules) govulncheckEnv() []string {
+	if p == nil {
+		return nil
+	}
+	// GONOPROXY defaults to GOPRIVATE, which would have the go command
+	// fetch private modules directly from their repositories instead
+	// of from the private proxy.
+	return []string{"GOPRIVATE=" + p.patterns, "GONOSUMDB=" + p.patterns, "GONOPROXY=none"}
+}
+
+// netrcCredentials returns the login and password for machine in the
+// netrc file with the given contents, falling back to its default entry.
+func netrcCredentials(netrc, machine string) (login, password string, ok bool) {
+	type entry struct{ machine, login, password string }
+	var (
+		entries []*entry
+		e       *entry
+	)
+	fields := strings.Fields(netrc)
+	for i := 0; i < len(fields); i++ {
+		switch f := fields[i]; {
+		case f == "default":
+			e = &entry{}
+			entries = append(entries, e)
+		case i+1 == len(fields):
+		case f == "machine":
+			i++
+			e = &entry{machine: fields[i]}
+			entries = append(entries, e)
+		case f == "login" && e != nil:
+			i++
+			e.login = fields[i]
+		case f == "password" && e != nil:
+			i++
+			e.password = fields[i]
+		}
+	}
+	var def *entry
+	for _, e := range entries {
+		if e.machine == machine {
+			return e.login, e.password, true
+		}
+		if e.machine == "" && def == nil {
+			def = e
+		}
+	}
+	if def != nil {
+		return def.login, def.password, true
+	}
+	return "", "", false
+}
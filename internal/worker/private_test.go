@@ -0,0 +1,64 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetrcCredentials(t *testing.T) {
+	const netrc = `
+machine proxy.corp.example.com
+	login alice
+	password secret
+machine other.example.com login bob password hunter2
+default login anon password guest
+`
+	for _, test := range []struct {
+		machine, login, password string
+		ok                       bool
+	}{
+		{"proxy.corp.example.com", "alice", "secret", true},
+		{"other.example.com", "bob", "hunter2", true},
+		{"unknown.example.com", "anon", "guest", true},
+	} {
+		login, password, ok := netrcCredentials(netrc, test.machine)
+		if login != test.login || password != test.password || ok != test.ok {
+			t.Errorf("%s: got %q, %q, %t; want %q, %q, %t", test.machine, login, password, ok, test.login, test.password, test.ok)
+		}
+	}
+	if _, _, ok := netrcCredentials("machine a login x password y", "b"); ok {
+		t.Error("no match and no default: got ok")
+	}
+}
+
+func TestPrivateModulesGoCommandEnv(t *testing.T) {
+	p := &privateModules{patterns: "*.corp.example.com", proxyURL: "https://proxy.corp.example.com", netrcPath: "/tmp/netrc"}
+	env := goCommandEnv([]string{"HOME=/root"}, &goCommandOptions{insecure: true, private: p})
+	got := map[string]string{}
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		got[k] = v
+	}
+	for k, want := range map[string]string{
+		"GOPRIVATE": "*.corp.example.com",
+		"GONOSUMDB": "*.corp.example.com",
+		"GONOPROXY": "none",
+		"GOPROXY":   "https://proxy.corp.example.com,https://proxy.golang.org/cached-only",
+		"NETRC":     "/tmp/netrc",
+	} {
+		if got[k] != want {
+			t.Errorf("%s: got %q, want %q", k, got[k], want)
+		}
+	}
+	if !p.matches("git.corp.example.com/m") || p.matches("example.com/m") {
+		t.Error("matches: wrong result")
+	}
+	var nilp *privateModules
+	if nilp.matches("git.corp.example.com/m") || nilp.govulncheckEnv() != nil {
+		t.Error("nil privateModules matches or has env")
+	}
+}
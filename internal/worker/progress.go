@@ -0,0 +1,116 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Scan phases reported to clients that request progress.
+const (
+	phaseDownloading = "downloading"
+	phaseBuilding    = "building"
+	phaseScanning    = "scanning"
+	phaseConverting  = "converting"
+
+	// phasePolicy is the phase of the policy check, which is recorded
+	// in errors but not reported to clients.
+	phasePolicy = "policy"
+	// phaseScheduling is the phase of the deferral check; see
+	// deferralScheduler.
+	phaseScheduling = "scheduling"
+)
+
+// keepaliveInterval is how often an idle event stream is sent a comment,
+// so that proxies don't close the connection.
+const keepaliveInterval = 15 * time.Second
+
+// An eventWriter writes server-sent events (text/event-stream) to an HTTP
+// response. It is safe for concurrent use. A nil *eventWriter discards
+// events.
+type eventWriter struct {
+	mu   sync.Mutex
+	w    http.ResponseWriter
+	done chan struct{} // closed by stop
+}
+
+// newEventWriter writes the event stream headers to w and returns an
+// eventWriter for it. It sends keepalive comments until stop is called.
+func newEventWriter(w http.ResponseWriter) *eventWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	e := &eventWriter{w: w, done: make(chan struct{})}
+	e.flush()
+	go func() {
+		ticker := time.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.done:
+				return
+			case <-ticker.C:
+				e.mu.Lock()
+				fmt.Fprint(e.w, ": keepalive\n\n")
+				e.flush()
+				e.mu.Unlock()
+			}
+		}
+	}()
+	return e
+}
+
+// event sends an event with the given name whose data is the JSON encoding
+// of data.
+func (e *eventWriter) event(name string, data any) error {
+	if e == nil {
+		return nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", name, b); err != nil {
+		return err
+	}
+	e.flush()
+	return nil
+}
+
+// phase sends a "phase" event.
+func (e *eventWriter) phase(name string) {
+	e.event("phase", map[string]string{"phase": name})
+}
+
+// progress sends a "progress" event.
+func (e *eventWriter) progress(message string) {
+	e.event("progress", map[string]string{"message": message})
+}
+
+// stop stops sending keepalives.
+func (e *eventWriter) stop() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+}
+
+func (e *eventWriter) flush() {
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
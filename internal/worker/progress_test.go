@@ -0,0 +1,46 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEventWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	e := newEventWriter(w)
+	e.phase(phaseScanning)
+	e.progress("Scanning your code")
+	e.stop()
+	if err := e.event("result", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	e.stop() // stopping twice is fine
+
+	if got, want := w.Header().Get("Content-Type"), "text/event-stream"; got != want {
+		t.Errorf("got Content-Type %q, want %q", got, want)
+	}
+	want := `event: phase
+data: {"phase":"scanning"}
+
+event: progress
+data: {"message":"Scanning your code"}
+
+event: result
+data: [1,2]
+
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if !w.Flushed {
+		t.Error("events were not flushed")
+	}
+
+	var nilWriter *eventWriter
+	nilWriter.phase(phaseDownloading) // must not panic
+	nilWriter.stop()
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// promoteTables are the tables that handlePromote promotes by default.
+var promoteTables = []string{govulncheck.TableName, analysis.TableName}
+
+// handlePromote replaces the rows of tables of the worker's dataset with
+// those of a staging dataset; see bigquery.Client.Promote. The tables are
+// the result tables, unless the comma-separated tables query param says
+// otherwise.
+//
+// It is triggered by path /promote?from=DATASET&tables=T1,T2.
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handlePromote")
+	from := r.FormValue("from")
+	if from == "" {
+		return fmt.Errorf("%w: missing from", derrors.InvalidArgument)
+	}
+	tables := promoteTables
+	if t := r.FormValue("tables"); t != "" {
+		tables = strings.Split(t, ",")
+	}
+	if s.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	ctx := r.Context()
+	start := time.Now()
+	if err := s.bqClient.Promote(ctx, from, tables); err != nil {
+		return err
+	}
+	log.Infof(ctx, "promoted %v from %s in %s", tables, from, time.Since(start))
+	fmt.Fprintf(w, "promoted %s from %s\n", strings.Join(tables, ", "), from)
+	return nil
+}
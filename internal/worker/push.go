@@ -0,0 +1,77 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+// handlePush serves a task that the push subscription of a Pub/Sub queue
+// delivered, by serving the scan request of the task; see queue.PubSub.
+// The status of the scan request acknowledges the message or, if it is
+// not a success, makes Pub/Sub deliver it again.
+//
+// Messages that are not tasks are acknowledged, so that they are dropped
+// instead of being delivered forever. Tasks scheduled for later are
+// refused with 503 Service Unavailable, to be delivered again.
+//
+// It is triggered by path /queue/push.
+func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handlePush")
+	ctx := r.Context()
+	t, err := queue.ParsePush(r)
+	if err != nil {
+		log.Errorf(ctx, err, "dropping pushed message")
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if s.now().Before(t.ScheduleTime) {
+		// This is not an error, so it is not reported.
+		http.Error(w, fmt.Sprintf("task %s is scheduled for %s", t.ID, t.ScheduleTime), http.StatusServiceUnavailable)
+		return nil
+	}
+	log.Infof(ctx, "serving task %s from message %s", t.ID, t.MessageID)
+	return serveTask(ctx, w, t.URI, "pubsub", t.ID)
+}
+
+// serveLocalTask runs a task of the in-memory queue that the worker uses
+// without a queue service, by serving its scan request; see queue.New.
+func serveLocalTask(ctx context.Context, t queue.Task, opts *queue.Options) (int, error) {
+	uri, err := queue.TaskURI(t, opts)
+	if err != nil {
+		return 0, err
+	}
+	w := httptest.NewRecorder()
+	if err := serveTask(ctx, w, uri, "local", t.Name()); err != nil {
+		return 0, err
+	}
+	if w.Code != http.StatusOK {
+		return w.Code, fmt.Errorf("%s: %d %s", uri, w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	return w.Code, nil
+}
+
+// serveTask serves the request for uri, the path and query of the scan
+// request of a task, with the worker's handler for it. The request has
+// the headers of a Cloud Tasks request of the named task and queue, so
+// that it is handled as a task.
+func serveTask(ctx context.Context, w http.ResponseWriter, uri, queueName, taskName string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-CloudTasks-QueueName", queueName)
+	req.Header.Set("X-CloudTasks-TaskName", taskName)
+	http.DefaultServeMux.ServeHTTP(w, req)
+	return nil
+}
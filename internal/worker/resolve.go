@@ -0,0 +1,74 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/proxy"
+)
+
+// resolutionTTL is how long a major version resolution is reused.
+// It is long enough for a dry-run enqueue and the real enqueue that
+// follows it to share resolutions, but short enough that new major
+// versions are picked up by the next run.
+const resolutionTTL = 15 * time.Minute
+
+// A majorPathResolver resolves module paths to the path of their highest
+// major version, remembering each resolution for a while so that repeated
+// enqueues don't probe the proxy again for every module.
+// It is safe for concurrent use.
+type majorPathResolver struct {
+	ttl   time.Duration
+	probe func(context.Context, string) (string, error) // proxy.Client.LatestMajorPath, except in tests
+	now   func() time.Time
+
+	mu    sync.Mutex
+	paths map[string]resolution // by module path
+}
+
+type resolution struct {
+	path string
+	at   time.Time
+}
+
+func newMajorPathResolver(client *proxy.Client, ttl time.Duration, now func() time.Time) *majorPathResolver {
+	return &majorPathResolver{
+		ttl:   ttl,
+		probe: client.LatestMajorPath,
+		now:   now,
+		paths: map[string]resolution{},
+	}
+}
+
+// resolve returns the path of the highest major version of modulePath.
+// Failed probes are not remembered.
+func (r *majorPathResolver) resolve(ctx context.Context, modulePath string) (string, error) {
+	r.mu.Lock()
+	res, ok := r.paths[modulePath]
+	r.mu.Unlock()
+	if ok && r.now().Sub(res.at) < r.ttl {
+		return res.path, nil
+	}
+	p, err := r.probe(ctx, modulePath)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paths[modulePath] = resolution{path: p, at: r.now()}
+	// Drop expired resolutions, so the map doesn't grow
+	// beyond the modules resolved in the last TTL.
+	if len(r.paths)%1000 == 0 {
+		for m, res := range r.paths {
+			if r.now().Sub(res.at) >= r.ttl {
+				delete(r.paths, m)
+			}
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/clock"
+)
+
+func TestMajorPathResolver(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+	probes := 0
+	fail := false
+	r := &majorPathResolver{
+		ttl: time.Minute,
+		probe: func(_ context.Context, m string) (string, error) {
+			probes++
+			if fail {
+				return "", errors.New("probe failed")
+			}
+			return m + "/v2", nil
+		},
+		now:   clk.Now,
+		paths: map[string]resolution{},
+	}
+	check := func(wantProbes int) {
+		t.Helper()
+		p, err := r.resolve(ctx, "example.com/m")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "example.com/m/v2"; p != want {
+			t.Errorf("got %q, want %q", p, want)
+		}
+		if probes != wantProbes {
+			t.Errorf("got %d probes, want %d", probes, wantProbes)
+		}
+	}
+
+	check(1)
+	clk.Advance(30 * time.Second)
+	check(1) // remembered
+	clk.Advance(time.Minute)
+	check(2) // expired
+
+	clk.Advance(time.Minute)
+	fail = true
+	if _, err := r.resolve(ctx, "example.com/m"); err == nil {
+		t.Fatal("got nil error from failed probe")
+	}
+	fail = false
+	check(4) // failures are not remembered
+}
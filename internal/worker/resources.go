@@ -0,0 +1,120 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/notify"
+)
+
+const (
+	// leakWindow is the number of consecutive samples over which
+	// resource counts must grow to be reported as a leak.
+	leakWindow = 6
+	// minLeakGrowth is the minimum growth of a count over leakWindow
+	// samples that is reported as a leak.
+	minLeakGrowth = 100
+)
+
+// resourceMonitor tracks the number of open files and goroutines of the
+// worker, to detect leaks from scans.
+type resourceMonitor struct {
+	notifier notify.Notifier // if nil, leaks are only logged
+	// fds and goroutines hold the most recent samples, oldest first.
+	fds, goroutines []int
+	// leaking records which counts have been reported as leaking,
+	// so each leak is reported once.
+	leaking map[string]bool
+}
+
+// monitorResources checks the worker's resources every interval
+// until ctx is done.
+func (s *Server) monitorResources(ctx context.Context, interval time.Duration) {
+	m := &resourceMonitor{notifier: s.notifier, leaking: map[string]bool{}}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx, openFiles(), runtime.NumGoroutine())
+		}
+	}
+}
+
+// check records a sample and reports counts that have been growing.
+// A negative fds means the number of open files is unknown.
+func (m *resourceMonitor) check(ctx context.Context, fds, goroutines int) {
+	log.Infof(ctx, "resources: %d open files, %d goroutines", fds, goroutines)
+	if fds >= 0 {
+		m.fds = appendSample(m.fds, fds)
+		m.report(ctx, "open files", m.fds)
+	}
+	m.goroutines = appendSample(m.goroutines, goroutines)
+	m.report(ctx, "goroutines", m.goroutines)
+}
+
+func (m *resourceMonitor) report(ctx context.Context, name string, samples []int) {
+	if !sustainedGrowth(samples) {
+		m.leaking[name] = false
+		return
+	}
+	if m.leaking[name] {
+		return
+	}
+	m.leaking[name] = true
+	msg := fmt.Sprintf("number of %s grew from %d to %d over the last %d checks",
+		name, samples[0], samples[len(samples)-1], len(samples))
+	log.Warnf(ctx, "possible leak: %s", msg)
+	if m.notifier != nil {
+		if err := m.notifier.Notify(ctx, &notify.Notification{
+			Subject: "possible worker leak of " + name,
+			Body:    msg,
+		}); err != nil {
+			log.Errorf(ctx, err, "sending leak alert")
+		}
+	}
+}
+
+// appendSample appends n to samples, keeping at most leakWindow samples.
+func appendSample(samples []int, n int) []int {
+	samples = append(samples, n)
+	if len(samples) > leakWindow {
+		samples = samples[len(samples)-leakWindow:]
+	}
+	return samples
+}
+
+// sustainedGrowth reports whether samples is a full window of
+// non-decreasing counts that grew by at least minLeakGrowth.
+func sustainedGrowth(samples []int) bool {
+	if len(samples) < leakWindow {
+		return false
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i] < samples[i-1] {
+			return false
+		}
+	}
+	return samples[len(samples)-1]-samples[0] >= minLeakGrowth
+}
+
+// openFiles returns the number of files the process has open,
+// or -1 if it can't be determined.
+func openFiles() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// One entry is the directory being read.
+	return len(entries) - 1
+}
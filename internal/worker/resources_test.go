@@ -0,0 +1,52 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSustainedGrowth(t *testing.T) {
+	for _, test := range []struct {
+		samples []int
+		want    bool
+	}{
+		{nil, false},
+		{[]int{10, 50, 100, 150, 200}, false},        // too few samples
+		{[]int{10, 50, 100, 150, 200, 250}, true},    // steady growth
+		{[]int{10, 10, 10, 10, 10, 110}, true},       // growth with plateaus
+		{[]int{10, 50, 100, 90, 200, 250}, false},    // dropped
+		{[]int{10, 11, 12, 13, 14, 15}, false},       // too little growth
+		{[]int{500, 500, 500, 500, 500, 500}, false}, // high but flat
+	} {
+		if got := sustainedGrowth(test.samples); got != test.want {
+			t.Errorf("%v: got %t, want %t", test.samples, got, test.want)
+		}
+	}
+}
+
+func TestResourceMonitor(t *testing.T) {
+	ctx := context.Background()
+	notifier := &testNotifier{}
+	m := &resourceMonitor{notifier: notifier, leaking: map[string]bool{}}
+	// Goroutines leak for a while, then stop; open files are unknown.
+	for _, n := range []int{10, 50, 100, 150, 200, 250, 300, 350, 300, 300} {
+		m.check(ctx, -1, n)
+	}
+	if len(notifier.notes) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(notifier.notes))
+	}
+	if len(m.fds) != 0 {
+		t.Errorf("got %d open file samples, want none", len(m.fds))
+	}
+	// A new leak is reported again.
+	for _, n := range []int{400, 500, 600, 700, 800} {
+		m.check(ctx, -1, n)
+	}
+	if len(notifier.notes) != 2 {
+		t.Errorf("got %d notifications, want 2", len(notifier.notes))
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+// A retryPolicy says how a scan that failed with a transient error is
+// retried: it is enqueued again after a backoff that doubles with each
+// attempt, until it has been attempted MaxAttempts times.
+type retryPolicy struct {
+	MaxAttempts int           // including the first attempt
+	Backoff     time.Duration // before the second attempt
+	MaxBackoff  time.Duration
+}
+
+// retryPolicies are the retry policies of the error categories of
+// transient failures, which have nothing to do with the module being
+// scanned. Scans that fail with errors of other categories are not
+// retried automatically; see handleEnqueueErrored.
+var retryPolicies = map[string]retryPolicy{
+	derrors.CategorizeError(derrors.ProxyError):    {MaxAttempts: 4, Backoff: 5 * time.Minute, MaxBackoff: time.Hour},
+	derrors.CategorizeError(derrors.SandboxError):  {MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: 10 * time.Minute},
+	derrors.CategorizeError(derrors.BigQueryError): {MaxAttempts: 3, Backoff: 10 * time.Minute, MaxBackoff: time.Hour},
+}
+
+// backoff returns the delay before the attempt that follows attempt.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// attemptOf returns the number of the attempt of sreq, counting from 1.
+func attemptOf(sreq *govulncheck.Request) int {
+	if sreq.Attempt < 1 {
+		return 1
+	}
+	return sreq.Attempt
+}
+
+// recordAttempts records the attempt of sreq in each of rows whose
+// error category has a retry policy. It returns the policy of the first
+// such row, if any.
+func recordAttempts(sreq *govulncheck.Request, rows []bigquery.Row) (retryPolicy, bool) {
+	var (
+		policy retryPolicy
+		found  bool
+	)
+	for _, row := range rows {
+		r, ok := row.(*govulncheck.Result)
+		if !ok {
+			continue
+		}
+		p, ok := retryPolicies[r.ErrorCategory]
+		if !ok {
+			continue
+		}
+		r.Attempt = bigquery.NullInt(attemptOf(sreq))
+		r.MaxAttempts = bigquery.NullInt(p.MaxAttempts)
+		if !found {
+			policy, found = p, true
+		}
+	}
+	return policy, found
+}
+
+// scheduleRetry enqueues sreq again after the backoff of p, unless it has
+// used up its attempts. Scans that were not enqueued as part of a run are
+// not retried.
+func (s *scanner) scheduleRetry(ctx context.Context, sreq *govulncheck.Request, p retryPolicy) error {
+	n := attemptOf(sreq)
+	if s.queue == nil || govulncheck.IsAdHocSuffix(sreq.QueryParams.Suffix) {
+		return nil
+	}
+	if n >= p.MaxAttempts {
+		log.Warnf(ctx, "%s@%s: giving up after %d attempts", sreq.Path(), sreq.Version, n)
+		return nil
+	}
+	next := *sreq
+	next.Attempt = n + 1
+	delay := p.backoff(n)
+	opts := &queue.Options{
+		Namespace:      "govulncheck",
+		TaskNameSuffix: sreq.QueryParams.Suffix,
+		ScheduleTime:   s.now().Add(delay),
+	}
+	if _, err := s.queue.EnqueueScan(ctx, &next, opts); err != nil {
+		return err
+	}
+	log.Infof(ctx, "%s@%s: enqueued attempt %d of %d in %s", sreq.Path(), sreq.Version, next.Attempt, p.MaxAttempts, delay)
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := retryPolicy{MaxAttempts: 10, Backoff: time.Minute, MaxBackoff: 5 * time.Minute}
+	for attempt, want := range map[int]time.Duration{
+		1: time.Minute,
+		2: 2 * time.Minute,
+		3: 4 * time.Minute,
+		4: 5 * time.Minute,
+		9: 5 * time.Minute,
+	} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("attempt %d: got %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+// recordingQueue is a queue.Queue that records the tasks it is given.
+type recordingQueue struct {
+	tasks []queue.Task
+	opts  []*queue.Options
+}
+
+func (q *recordingQueue) EnqueueScan(_ context.Context, t queue.Task, opts *queue.Options) (bool, error) {
+	q.tasks = append(q.tasks, t)
+	q.opts = append(q.opts, opts)
+	return true, nil
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	proxyCategory := derrors.CategorizeError(derrors.ProxyError)
+	policy := retryPolicies[proxyCategory]
+
+	// write writes a row with the given error category for an attempt
+	// of a scan, and returns the row and the retries it enqueued.
+	write := func(suffix, category string, attempt int) (*govulncheck.Result, *recordingQueue) {
+		q := &recordingQueue{}
+		s := &scanner{
+			queue: q,
+			now:   func() time.Time { return now },
+			uploadRows: func(context.Context, string, []bigquery.Row) error {
+				return nil
+			},
+		}
+		sreq := &govulncheck.Request{
+			ModuleURLPath: scan.ModuleURLPath{Module: "m", Version: "v1.0.0"},
+			QueryParams:   govulncheck.QueryParams{Mode: ModeGovulncheck, Suffix: suffix, Attempt: attempt},
+		}
+		row := &govulncheck.Result{ModulePath: "m", Version: "v1.0.0", ErrorCategory: category}
+		if err := s.writeRows(ctx, nil, sreq, []bigquery.Row{row}); err != nil {
+			t.Fatal(err)
+		}
+		return row, q
+	}
+
+	row, q := write("run", proxyCategory, 0)
+	if row.Attempt != bigquery.NullInt(1) || row.MaxAttempts != bigquery.NullInt(policy.MaxAttempts) {
+		t.Errorf("got attempt %v of %v, want 1 of %d", row.Attempt, row.MaxAttempts, policy.MaxAttempts)
+	}
+	if len(q.tasks) != 1 {
+		t.Fatalf("got %d retries, want 1", len(q.tasks))
+	}
+	if got := q.tasks[0].(*govulncheck.Request).Attempt; got != 2 {
+		t.Errorf("retry has attempt %d, want 2", got)
+	}
+	if got, want := q.opts[0].ScheduleTime, now.Add(policy.Backoff); !got.Equal(want) {
+		t.Errorf("retry scheduled at %s, want %s", got, want)
+	}
+
+	// The last attempt is not retried, but is recorded.
+	row, q = write("run", proxyCategory, policy.MaxAttempts)
+	if row.Attempt != bigquery.NullInt(policy.MaxAttempts) || len(q.tasks) != 0 {
+		t.Errorf("last attempt: got attempt %v and %d retries, want %d and 0", row.Attempt, len(q.tasks), policy.MaxAttempts)
+	}
+
+	// Other errors, and ad hoc scans, are not retried.
+	row, q = write("run", derrors.CategorizeError(derrors.LoadPackagesError), 0)
+	if row.Attempt.Valid || len(q.tasks) != 0 {
+		t.Errorf("load error: got attempt %v and %d retries, want null and 0", row.Attempt, len(q.tasks))
+	}
+	if _, q = write(govulncheck.AdHocSuffix(now), proxyCategory, 0); len(q.tasks) != 0 {
+		t.Errorf("ad hoc: got %d retries, want 0", len(q.tasks))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// defaultTopRisks is the default number of modules returned by handleRisk.
+const defaultTopRisks = 100
+
+// handleRisk returns the modules with the highest risk scores in their
+// latest results.
+//
+// It is triggered by path /govulncheck/risk?n=N.
+func (h *GovulncheckServer) handleRisk(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleRisk")
+	n := defaultTopRisks
+	if s := r.FormValue("n"); s != "" {
+		n, err = strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%w: n must be a positive integer", derrors.InvalidArgument)
+		}
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	risks, err := govulncheck.ReadTopRisks(r.Context(), h.bqClient, n)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, risks)
+}
+
+// handleRecomputeRisk rewrites the risk scores of stored rows, so that
+// changing the weights doesn't need a new scan. The weights are those
+// configured for the worker unless the weights query param, in the form
+// accepted by govulncheck.ParseRiskWeights, overrides them. If the suffix
+// query param is given, only the rows of that run are rewritten.
+//
+// It is triggered by path /govulncheck/recompute-risk?suffix=S&weights=W.
+func (h *GovulncheckServer) handleRecomputeRisk(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleRecomputeRisk")
+	suffix := r.FormValue("suffix")
+	if err := govulncheck.ValidateSuffix(suffix); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	spec := h.cfg.RiskWeights
+	if ws := r.FormValue("weights"); ws != "" {
+		spec = ws
+	}
+	weights, err := govulncheck.ParseRiskWeights(spec)
+	if err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	if err := govulncheck.RecomputeRiskScores(r.Context(), h.bqClient, suffix, weights); err != nil {
+		return err
+	}
+	log.Infof(r.Context(), "recomputed risk scores of run %q with weights %s", suffix, weights)
+	fmt.Fprintf(w, "recomputed risk scores with weights %s\n", weights)
+	return nil
+}
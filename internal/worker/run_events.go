@@ -0,0 +1,125 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// runEventBatchSize is the number of pending run events that causes
+// them to be written.
+const runEventBatchSize = 50
+
+// A runEventLog batches run events and writes them to the
+// govulncheck-events table. Every event is also logged.
+//
+// A runEventLog is safe for concurrent use. A nil *runEventLog only
+// logs events.
+type runEventLog struct {
+	upload    func(context.Context, []*govulncheck.Event) error
+	batchSize int
+
+	mu      sync.Mutex
+	pending []*govulncheck.Event
+}
+
+// newRunEventLog returns a runEventLog that writes to the events table
+// with client, or nil if client is nil.
+func newRunEventLog(client *bigquery.Client) *runEventLog {
+	if client == nil {
+		return nil
+	}
+	return &runEventLog{
+		upload: func(ctx context.Context, events []*govulncheck.Event) error {
+			return bigquery.UploadMany(ctx, client, govulncheck.EventTableName, events, 0)
+		},
+		batchSize: runEventBatchSize,
+	}
+}
+
+// add logs e and adds it to the pending events, writing them if there
+// are enough. Callers should call flush before their request finishes.
+func (l *runEventLog) add(ctx context.Context, e *govulncheck.Event) {
+	msg := fmt.Sprintf("run %q: %s: %s", e.Suffix, e.Type, e.Message)
+	switch e.Severity {
+	case govulncheck.SeverityError:
+		log.Errorf(ctx, errors.New(msg), "run event")
+	case govulncheck.SeverityWarning:
+		log.Warnf(ctx, "%s", msg)
+	default:
+		log.Infof(ctx, "%s", msg)
+	}
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.pending = append(l.pending, e)
+	full := len(l.pending) >= l.batchSize
+	l.mu.Unlock()
+	if full {
+		if err := l.flush(ctx); err != nil {
+			log.Errorf(ctx, err, "writing run events")
+		}
+	}
+}
+
+// flush writes the pending events. If writing fails, the events are
+// dropped; they have already been logged.
+func (l *runEventLog) flush(ctx context.Context) (err error) {
+	defer derrors.Wrap(&err, "runEventLog.flush")
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	events := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	return l.upload(ctx, events)
+}
+
+// flushRunEvents flushes h.runEvents, logging any error. It is meant to be
+// deferred by handlers that add events, so that a failure to write events
+// doesn't fail the request.
+func (h *GovulncheckServer) flushRunEvents(ctx context.Context) {
+	if err := h.runEvents.flush(ctx); err != nil {
+		log.Errorf(ctx, err, "writing run events")
+	}
+}
+
+// handleEvents serves the events of a run, oldest first.
+//
+// It is triggered by path /govulncheck/events?suffix=S.
+func (h *GovulncheckServer) handleEvents(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleEvents")
+	suffix := r.FormValue("suffix")
+	if suffix == "" {
+		return fmt.Errorf("%w: need suffix query param", derrors.InvalidArgument)
+	}
+	if err := govulncheck.ValidateSuffix(suffix); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	// Include the events of this instance that haven't been written yet.
+	h.flushRunEvents(r.Context())
+	events, err := govulncheck.ReadEvents(r.Context(), h.bqClient, suffix)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, events)
+}
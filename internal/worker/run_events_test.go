@@ -0,0 +1,65 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestRunEventLog(t *testing.T) {
+	ctx := context.Background()
+	var uploads [][]*govulncheck.Event
+	l := &runEventLog{
+		upload: func(_ context.Context, events []*govulncheck.Event) error {
+			uploads = append(uploads, events)
+			return nil
+		},
+		batchSize: 2,
+	}
+	event := func(msg string) *govulncheck.Event {
+		return govulncheck.NewEvent("run", govulncheck.EventEnqueued, govulncheck.SeverityInfo, msg, nil)
+	}
+	l.add(ctx, event("a"))
+	if len(uploads) != 0 {
+		t.Fatalf("got %d uploads before the batch was full, want 0", len(uploads))
+	}
+	l.add(ctx, event("b"))
+	l.add(ctx, event("c"))
+	if err := l.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is pending, so this doesn't upload.
+	if err := l.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, u := range uploads {
+		got = append(got, len(u))
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 1 {
+		t.Errorf("got upload sizes %v, want [2 1]", got)
+	}
+
+	// A nil log discards events.
+	var nl *runEventLog
+	nl.add(ctx, event("d"))
+	if err := nl.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewEventPayload(t *testing.T) {
+	e := govulncheck.NewEvent("run", govulncheck.EventAnomaly, govulncheck.SeverityWarning, "m", map[string]int{"n": 1})
+	if !e.Payload.Valid || e.Payload.JSONVal != `{"n":1}` {
+		t.Errorf("got payload %+v, want {\"n\":1}", e.Payload)
+	}
+	e = govulncheck.NewEvent("run", govulncheck.EventAnomaly, govulncheck.SeverityWarning, "m", nil)
+	if e.Payload.Valid {
+		t.Errorf("got payload %+v, want null", e.Payload)
+	}
+}
@@ -18,15 +18,19 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"golang.org/x/mod/modfile"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
 	"golang.org/x/pkgsite-metrics/internal/modules"
 	"golang.org/x/pkgsite-metrics/internal/proxy"
+	"golang.org/x/pkgsite-metrics/internal/sink"
 )
 
 const (
@@ -44,7 +48,14 @@ const (
 
 var activeScans atomic.Int32
 
-func doScan(ctx context.Context, modulePath, version string, insecure bool, f func() error) (err error) {
+// modCacheMu keeps the shared Go caches from being cleaned while scans use
+// them. Scans hold it for reading, and cleanGoCaches for writing.
+var modCacheMu sync.RWMutex
+
+// doScan runs f, which scans modulePath@version. If lockWait is non-nil,
+// it is set to the time spent waiting for the shared Go caches to be
+// available.
+func doScan(ctx context.Context, modulePath, version string, insecure bool, lockWait *time.Duration, f func() error) (err error) {
 	defer derrors.Wrap(&err, "doScan(%q, %q)", modulePath, version)
 
 	defer func() {
@@ -64,10 +75,23 @@ func doScan(ctx context.Context, modulePath, version string, insecure bool, f fu
 			logMemory(ctx, "after 'go clean'")
 		}
 	}()
+
+	start := time.Now()
+	modCacheMu.RLock()
+	defer modCacheMu.RUnlock()
+	if wait := time.Since(start); wait > time.Second {
+		log.Infof(ctx, "waited %s for the Go caches to scan %s@%s", wait.Round(time.Millisecond), modulePath, version)
+	}
+	if lockWait != nil {
+		*lockWait = time.Since(start)
+	}
 	return f()
 }
 
 func cleanGoCaches(ctx context.Context, insecure bool) {
+	modCacheMu.Lock()
+	defer modCacheMu.Unlock()
+
 	var (
 		out []byte
 		err error
@@ -153,35 +177,33 @@ func diskUsage(dirs ...string) string {
 	return strings.TrimSpace(string(out))
 }
 
-func writeResult(ctx context.Context, serve bool, w http.ResponseWriter, client *bigquery.Client, table string, row bigquery.Row) (err error) {
+func writeResult(ctx context.Context, serve bool, w http.ResponseWriter, rs sink.ResultSink, table string, row bigquery.Row) (err error) {
 	defer derrors.Wrap(&err, "writeResult")
 
 	if serve {
-		// Write the result to the client instead of uploading to BigQuery.
+		// Write the result to the client instead of storing it.
 		return serveJSON(ctx, row, w)
 	}
-	// Upload to BigQuery.
-	if client == nil {
-		log.Infof(ctx, "bigquery disabled, not uploading")
+	if rs == nil {
+		log.Infof(ctx, "result sink disabled, not uploading")
 		return nil
 	}
-	return client.Upload(ctx, table, row)
+	return rs.Upload(ctx, table, []bigquery.Row{row})
 }
 
 // writeResults is like writeResult but stores multiple rows in a single transaction.
-func writeResults(ctx context.Context, serve bool, w http.ResponseWriter, client *bigquery.Client, table string, rows []bigquery.Row) (err error) {
+func writeResults(ctx context.Context, serve bool, w http.ResponseWriter, rs sink.ResultSink, table string, rows []bigquery.Row) (err error) {
 	defer derrors.Wrap(&err, "writeResults")
 
 	if serve {
-		// Write the results to the client instead of uploading to BigQuery.
+		// Write the results to the client instead of storing them.
 		return serveJSON(ctx, rows, w)
 	}
-	// Upload to BigQuery.
-	if client == nil {
-		log.Infof(ctx, "bigquery disabled, not uploading")
+	if rs == nil {
+		log.Infof(ctx, "result sink disabled, not uploading")
 		return nil
 	}
-	return bigquery.UploadMany(ctx, client, table, rows, 0)
+	return rs.Upload(ctx, table, rows)
 }
 
 func serveJSON(ctx context.Context, content interface{}, w http.ResponseWriter) error {
@@ -242,28 +264,108 @@ func gcsOpenFileFunc(ctx context.Context, bucket *storage.BucketHandle) openFile
 // directory and takes other actions that increase the chance that package loading will succeed.
 // If init is true, those other actions include calling `go mod init` and `go mod tidy` on modules
 // that don't have go.mod files.
-func prepareModule(ctx context.Context, modulePath, version, dir string, proxyClient *proxy.Client, insecure, init bool) error {
+//
+// If the module's go.mod replaces dependencies with local paths, prepareModule fails with
+// derrors.LocalReplaceError, unless dropReplaces is true; then it drops those replace
+// directives and reports that it did.
+func prepareModule(ctx context.Context, modulePath, version, dir string, proxyClient *proxy.Client, insecure, init, dropReplaces bool, modCache string, private *privateModules) (replacesDropped bool, err error) {
 	log.Debugf(ctx, "downloading %s@%s to %s", modulePath, version, dir)
 	if err := modules.Download(ctx, modulePath, version, dir, proxyClient, true); err != nil {
 		log.Debugf(ctx, "download error: %v (%[1]T)", err)
-		return err
+		return false, err
 	}
 
 	hasGoMod := fileExists(filepath.Join(dir, "go.mod"))
+	if hasGoMod {
+		replaces, err := localReplaces(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return false, err
+		}
+		if len(replaces) > 0 {
+			if !dropReplaces {
+				return false, fmt.Errorf("%s@%s: %s: %w", modulePath, version, strings.Join(replaces, ", "), derrors.LocalReplaceError)
+			}
+			log.Infof(ctx, "%s@%s: dropping local replaces of %s", modulePath, version, strings.Join(replaces, ", "))
+			args := []string{"mod", "edit"}
+			for _, r := range replaces {
+				args = append(args, "-dropreplace="+r)
+			}
+			if err := runGoCommand(ctx, modulePath, version, &goCommandOptions{dir: dir, insecure: insecure, modCache: modCache, private: private}, args...); err != nil {
+				return false, err
+			}
+			replacesDropped = true
+		}
+	}
 	if !init || hasGoMod {
 		// Download all dependencies, using the given directory for the Go module cache
 		// if it is non-empty.
 		opts := &goCommandOptions{
 			dir:      dir,
 			insecure: insecure,
+			modCache: modCache,
+			private:  private,
 		}
-		return runGoCommand(ctx, modulePath, version, opts, "mod", "download")
+		return replacesDropped, runGoCommand(ctx, modulePath, version, opts, "mod", "download")
 	}
 	// Run `go mod init` and `go mod tidy`.
-	if err := goModInit(ctx, modulePath, version, dir, modulePath, insecure); err != nil {
-		return err
+	if err := goModInit(ctx, modulePath, version, dir, modulePath, insecure, modCache, private); err != nil {
+		return false, err
 	}
-	return goModTidy(ctx, modulePath, version, dir, insecure)
+	return false, goModTidy(ctx, modulePath, version, dir, insecure, modCache, private)
+}
+
+// localReplaces returns the modules that the go.mod file at goModPath
+// replaces with local paths, in the form accepted by go mod edit -dropreplace.
+func localReplaces(goModPath string) ([]string, error) {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, err
+	}
+	// ParseLax ignores replace directives, so parse strictly.
+	f, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		// Leave reporting a bad go.mod to the go command.
+		return nil, nil
+	}
+	var replaces []string
+	for _, r := range f.Replace {
+		if !modfile.IsDirectoryPath(r.New.Path) {
+			continue
+		}
+		old := r.Old.Path
+		if r.Old.Version != "" {
+			old += "@" + r.Old.Version
+		}
+		replaces = append(replaces, old)
+	}
+	return replaces, nil
+}
+
+// scanModCachesDir is the directory, relative to the sandbox root, that
+// holds the module caches of scans with isolated module caches.
+const scanModCachesDir = "root/go/pkg/scan-modcache"
+
+// newScanModCache creates a module cache for a single scan in the sandbox,
+// and returns its path outside the sandbox. The cache should be removed
+// with removeScanModCache after the scan.
+//
+// Concurrent scans that share a module cache can fail waiting for each
+// other's locks on it. A scan with its own cache takes no locks on the
+// shared cache: modules are copied from it by using its download
+// directory as a module proxy, which is read without locking.
+func newScanModCache() (string, error) {
+	parent := filepath.Join(sandboxRoot, scanModCachesDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(parent, "")
+}
+
+// removeScanModCache removes a module cache created by newScanModCache.
+func removeScanModCache(dir string) error {
+	// Scan caches are populated with -modcacherw, so they can be removed
+	// without making their files writable first.
+	return os.RemoveAll(dir)
 }
 
 // moduleDir returns a the path of a directory where the module can be downloaded.
@@ -271,15 +373,17 @@ func moduleDir(modulePath, version string) string {
 	return filepath.Join(modulesDir, modulePath+"@"+version)
 }
 
-func goModInit(ctx context.Context, modulePath, version, dir, name string, insecure bool) error {
-	return runGoCommand(ctx, modulePath, version, &goCommandOptions{dir: dir, insecure: insecure}, "mod", "init", name)
+func goModInit(ctx context.Context, modulePath, version, dir, name string, insecure bool, modCache string, private *privateModules) error {
+	return runGoCommand(ctx, modulePath, version, &goCommandOptions{dir: dir, insecure: insecure, modCache: modCache, private: private}, "mod", "init", name)
 }
 
 // goModTidy runs "go mod tidy" on a module in dir.
-func goModTidy(ctx context.Context, modulePath, version, dir string, insecure bool) error {
+func goModTidy(ctx context.Context, modulePath, version, dir string, insecure bool, modCache string, private *privateModules) error {
 	opts := &goCommandOptions{
 		dir:      dir,
 		insecure: insecure,
+		modCache: modCache,
+		private:  private,
 	}
 	return runGoCommand(ctx, modulePath, version, opts, "mod", "tidy")
 }
@@ -287,6 +391,39 @@ func goModTidy(ctx context.Context, modulePath, version, dir string, insecure bo
 type goCommandOptions struct {
 	dir      string
 	insecure bool
+	// modCache, if non-empty, is a module cache created by
+	// newScanModCache, to be used instead of the shared one.
+	modCache string
+	// private, if non-nil, lets the go command fetch private modules.
+	private *privateModules
+}
+
+// goCommandEnv returns the environment for a go command run with opts.
+func goCommandEnv(environ []string, opts *goCommandOptions) []string {
+	goproxy := "https://proxy.golang.org/cached-only"
+	env := environ
+	switch {
+	case opts.modCache != "":
+		// Copy modules from the shared cache if it has them.
+		shared := filepath.Join(sandboxRoot, sandboxGoModCache, "cache", "download")
+		env = append(env,
+			"GOMODCACHE="+opts.modCache,
+			"GOFLAGS=-modcacherw")
+		goproxy = "file://" + filepath.ToSlash(shared) + "," + goproxy
+	case !opts.insecure:
+		// Use sandbox mod cache.
+		env = append(env, "GOMODCACHE="+filepath.Join(sandboxRoot, sandboxGoModCache))
+	}
+	if p := opts.private; p != nil {
+		// Private modules are only served by the private proxy, and the
+		// public proxy should not be asked for them.
+		goproxy = p.proxyURL + "," + goproxy
+		env = append(env, p.govulncheckEnv()...)
+		if p.netrcPath != "" {
+			env = append(env, "NETRC="+p.netrcPath)
+		}
+	}
+	return append(env, "GOPROXY="+goproxy)
 }
 
 // runGoModCommand runs the command `go args...`.
@@ -301,12 +438,7 @@ func runGoCommand(ctx context.Context, modulePath, version string, opts *goComma
 
 	cmd := exec.Command("go", args...)
 	cmd.Dir = opts.dir
-	cmd.Env = cmd.Environ()
-	cmd.Env = append(cmd.Env, "GOPROXY=https://proxy.golang.org/cached-only")
-	if !opts.insecure {
-		// Use sandbox mod cache.
-		cmd.Env = append(cmd.Env, "GOMODCACHE="+filepath.Join(sandboxRoot, sandboxGoModCache))
-	}
+	cmd.Env = goCommandEnv(cmd.Environ(), opts)
 	if _, err := cmd.Output(); err != nil {
 		return fmt.Errorf("%w: 'go %s' for %s@%s returned %s",
 			derrors.BadModule, argstring, modulePath, version, derrors.IncludeStderr(err))
@@ -328,6 +460,10 @@ func isTooManyFiles(err error) bool {
 	return strings.Contains(err.Error(), "too many open files")
 }
 
+func isTimeout(err error) bool {
+	return strings.Contains(err.Error(), "govulncheck timed out")
+}
+
 func isNoRequiredModule(err error) bool {
 	return strings.Contains(err.Error(), "no required module")
 }
@@ -9,8 +9,12 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"golang.org/x/exp/slog"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
@@ -49,10 +53,125 @@ func TestPrepareModule(t *testing.T) {
 	} {
 		t.Run(fmt.Sprintf("%s@%s,%t", test.modulePath, test.version, test.init), func(t *testing.T) {
 			dir := t.TempDir()
-			err := prepareModule(ctx, test.modulePath, test.version, dir, proxyClient, insecure, test.init)
+			_, err := prepareModule(ctx, test.modulePath, test.version, dir, proxyClient, insecure, test.init, false, "", nil)
 			if !errors.Is(err, test.want) {
 				t.Errorf("got %v, want %v", err, test.want)
 			}
 		})
 	}
 }
+
+func TestGoCommandEnv(t *testing.T) {
+	environ := []string{"HOME=/root"}
+	get := func(env []string, name string) string {
+		// Later values take precedence.
+		var v string
+		for _, e := range env {
+			if k, val, _ := strings.Cut(e, "="); k == name {
+				v = val
+			}
+		}
+		return v
+	}
+	shared := filepath.Join(sandboxRoot, sandboxGoModCache)
+	for _, test := range []struct {
+		name                    string
+		opts                    goCommandOptions
+		wantModCache, wantProxy string
+	}{
+		{"insecure", goCommandOptions{insecure: true}, "", "https://proxy.golang.org/cached-only"},
+		{"sandbox", goCommandOptions{}, shared, "https://proxy.golang.org/cached-only"},
+		{
+			"isolated",
+			goCommandOptions{modCache: "/tmp/mc"},
+			"/tmp/mc",
+			"file://" + filepath.ToSlash(filepath.Join(shared, "cache", "download")) + ",https://proxy.golang.org/cached-only",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			env := goCommandEnv(environ, &test.opts)
+			if got := get(env, "GOMODCACHE"); got != test.wantModCache {
+				t.Errorf("GOMODCACHE: got %q, want %q", got, test.wantModCache)
+			}
+			if got := get(env, "GOPROXY"); got != test.wantProxy {
+				t.Errorf("GOPROXY: got %q, want %q", got, test.wantProxy)
+			}
+			if got := get(env, "HOME"); got != "/root" {
+				t.Errorf("HOME: got %q, want %q", got, "/root")
+			}
+		})
+	}
+}
+
+// TestPrepareModuleConcurrent prepares the same module concurrently,
+// each with its own module cache, to check that the scans don't
+// interfere with each other.
+func TestPrepareModuleConcurrent(t *testing.T) {
+	test.NeedsIntegrationEnv(t)
+	ctx := context.Background()
+	proxyClient, err := proxy.New("https://proxy.golang.org/cached-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		dir := t.TempDir()
+		modCache := t.TempDir()
+		go func() {
+			_, err := prepareModule(ctx, "rsc.io/quote", "v1.5.2", dir, proxyClient, false, true, false, modCache, nil)
+			errs <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestDoScanWaitsForClean(t *testing.T) {
+	ctx := context.Background()
+	modCacheMu.Lock()
+	const hold = 50 * time.Millisecond
+	go func() {
+		time.Sleep(hold)
+		modCacheMu.Unlock()
+	}()
+	var wait time.Duration
+	if err := doScan(ctx, "m", "v1.0.0", true, &wait, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if wait < hold/2 {
+		t.Errorf("got lock wait %s, want at least %s", wait, hold/2)
+	}
+}
+
+func TestLocalReplaces(t *testing.T) {
+	const goMod = `module example.com/m
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+	example.com/c v1.0.0
+)
+
+replace example.com/a => ../a
+
+replace example.com/b v1.0.0 => ./b
+
+replace example.com/c => example.com/c2 v1.1.0
+`
+	file := filepath.Join(t.TempDir(), "go.mod")
+	if err := os.WriteFile(file, []byte(goMod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := localReplaces(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"example.com/a", "example.com/b@v1.0.0"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
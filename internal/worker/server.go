@@ -7,6 +7,7 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -23,9 +24,12 @@ import (
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/jobs"
 	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/notify"
 	"golang.org/x/pkgsite-metrics/internal/observe"
 	"golang.org/x/pkgsite-metrics/internal/proxy"
 	"golang.org/x/pkgsite-metrics/internal/queue"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+	"golang.org/x/pkgsite-metrics/internal/sink"
 )
 
 type Server struct {
@@ -35,6 +39,20 @@ type Server struct {
 	proxyClient *proxy.Client
 	queue       queue.Queue
 	jobDB       *jobs.DB
+	notifier    notify.Notifier
+	// resultSink stores the rows of scans. It is nil if they are not
+	// stored.
+	resultSink sink.ResultSink
+	// insertLimiter limits the volume of data inserted per run.
+	insertLimiter *insertLimiter
+	// policy provides the module policy.
+	policy *policyLoader
+	// uploadRows, if non-nil, is called instead of storing
+	// rows in resultSink. For testing.
+	uploadRows func(ctx context.Context, table string, rows []bigquery.Row) error
+	// clock returns the current time. It is time.Now, except in tests;
+	// nil means time.Now. Use s.now.
+	clock func() time.Time
 
 	devMode bool
 	mu      sync.Mutex
@@ -43,6 +61,9 @@ type Server struct {
 func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 	defer derrors.WrapAndReport(&err, "NewServer")
 
+	if err := govulncheck.ValidateTraceStorage(cfg.TraceStorage); err != nil {
+		return nil, err
+	}
 	var bq *bigquery.Client
 	if strings.EqualFold(cfg.BigQueryDataset, "disable") {
 		log.Infof(ctx, "BigQuery disabled")
@@ -51,15 +72,17 @@ func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 		if err != nil {
 			return nil, err
 		}
+		retention, err := bigquery.ParseRetention(cfg.Retention)
+		if err != nil {
+			return nil, err
+		}
+		bq.SetRetention(retention)
+		if err := bq.RecordQueryCosts(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	q, err := queue.New(ctx, cfg,
-		func(ctx context.Context, t queue.Task) (int, error) {
-			// When running locally, only the module path and version are
-			// printed for now.
-			log.Infof(ctx, "enqueuing %s?%s", t.Path(), t.Params())
-			return 0, nil
-		})
+	q, err := queue.New(ctx, cfg, serveLocalTask)
 	log.Debugf(ctx, "queue.New returned err %v", err)
 	if err != nil {
 		return nil, err
@@ -88,6 +111,48 @@ func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 		proxyClient: proxyClient,
 		devMode:     cfg.DevMode,
 		jobDB:       jdb,
+		clock:       time.Now,
+	}
+	s.policy = newPolicyLoader(cfg.ModulePolicy, time.Duration(cfg.PolicyRefreshMinutes)*time.Minute, s.now)
+	if bq != nil {
+		bq.SetClock(s.now)
+	}
+	switch cfg.ResultSink {
+	case "", "bigquery":
+		switch {
+		case bq != nil:
+			bs := sink.NewBigQuery(bq)
+			bs.Upsert = cfg.UpsertResults
+			s.resultSink = bs
+		case cfg.DevMode:
+			log.Infof(ctx, "writing results to %s", cfg.ResultsDir)
+			s.resultSink = sink.NewJSONL(cfg.ResultsDir, s.now)
+		}
+	case "jsonl":
+		s.resultSink = sink.NewJSONL(cfg.ResultsDir, s.now)
+	case "postgres":
+		db, err := sink.OpenPostgres(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.resultSink = sink.NewPostgres(db, s.now)
+	default:
+		return nil, fmt.Errorf("unknown result sink %q; want \"bigquery\", \"postgres\" or \"jsonl\"", cfg.ResultSink)
+	}
+	if cfg.AlertWebhookURL != "" {
+		s.notifier = &notify.Webhook{URL: cfg.AlertWebhookURL}
+	}
+	if cfg.ResourceCheckMinutes > 0 {
+		go s.monitorResources(ctx, time.Duration(cfg.ResourceCheckMinutes)*time.Minute)
+	}
+	var volumes insertVolumeDB = &memInsertVolumeDB{}
+	if jdb != nil {
+		volumes = jdb
+	}
+	s.insertLimiter = &insertLimiter{
+		db:       volumes,
+		limit:    int64(cfg.MaxInsertBytesPerRun),
+		notifier: s.notifier,
 	}
 
 	if cfg.ProjectID != "" && cfg.ServiceID != "" {
@@ -112,13 +177,24 @@ func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 		derrors.SetReportingClient(reportingClient)
 	}
 
-	if err := ensureTable(ctx, bq, govulncheck.TableName); err != nil {
-		return nil, err
+	if s.resultSink != nil {
+		err := s.resultSink.CreateOrUpdateTables(ctx,
+			govulncheck.TableName,
+			govulncheck.ShadowTableName,
+			govulncheck.ExposureTableName,
+			govulncheck.LatestTableName,
+			govulncheck.DailyTableName,
+			govulncheck.OSVSummaryTableName,
+			govulncheck.EventTableName,
+			govulncheck.RunTableName,
+			govulncheck.DeadLetterTableName,
+			queue.EnqueueRunTableName,
+			analysis.TableName)
+		if err != nil {
+			return nil, err
+		}
 	}
 	s.registerGovulncheckHandlers()
-	if err := ensureTable(ctx, bq, analysis.TableName); err != nil {
-		return nil, err
-	}
 	if err := s.registerAnalysisHandlers(ctx); err != nil {
 		return nil, err
 	}
@@ -128,29 +204,31 @@ func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 	// compute missing vuln.go.dev request counts
 	s.handle("/compute-requests", s.handleComputeRequests)
 	s.handle("/jobs/", s.handleJobs)
+	// display the BigQuery insert volume of a run
+	s.handle("/insert-volume", s.handleInsertVolume)
+	// export a day of results to Parquet files
+	s.handle("/export", s.handleExport)
+	// serve tasks pushed by a Pub/Sub queue
+	s.handle(queue.PushPath, s.handlePush)
+	// cancel the pending tasks of an enqueue run
+	s.handle("/queue/cancel", s.handleCancel)
+	// promote tables from a staging dataset
+	s.handle("/promote", s.handlePromote)
 	return s, nil
 }
 
-func ensureTable(ctx context.Context, bq *bigquery.Client, name string) error {
-	if bq == nil {
-		return nil
-	}
-	created, err := bq.CreateOrUpdateTable(ctx, name)
-	if err != nil {
-		return err
-	}
-	verb := "updated"
-	if created {
-		verb = "created"
-	}
-	log.Infof(ctx, "%s table %s\n", verb, name)
-	return nil
-}
-
 const metricNamespace = "ecosystem/worker"
 
 type handlerFunc func(w http.ResponseWriter, r *http.Request) error
 
+// now returns the current time according to s.clock.
+func (s *Server) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
 func (s *Server) handle(pattern string, handler handlerFunc) {
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -185,7 +263,28 @@ func (s *Server) registerGovulncheckHandlers() {
 	h := newGovulncheckServer(s)
 	s.handle("/govulncheck/enqueueall", h.handleEnqueueAll)
 	s.handle("/govulncheck/enqueue", h.handleEnqueue)
+	s.handle("/govulncheck/enqueue-errored", h.handleEnqueueErrored)
+	s.handle("/govulncheck/requeue-failed", h.handleRequeueFailed)
 	s.handle("/govulncheck/scan/", h.handleScan)
+	s.handle("/govulncheck/check-health", h.handleCheckHealth)
+	s.handle("/govulncheck/db-growth", h.handleDBGrowth)
+	s.handle("/govulncheck/confidence", h.handleConfidence)
+	s.handle("/govulncheck/osv/", h.handleOSV)
+	s.handle("/govulncheck/history/", h.handleHistory)
+	s.handle("/govulncheck/shadow-stats", h.handleShadowStats)
+	s.handle("/govulncheck/compare-summary", h.handleCompareSummary)
+	s.handle("/govulncheck/events", h.handleEvents)
+	s.handle("/govulncheck/reconcile-corpus", h.handleReconcileCorpus)
+	s.handle("/govulncheck/update-exposure", h.handleUpdateExposure)
+	s.handle("/govulncheck/exposure", h.handleExposure)
+	s.handle("/govulncheck/update-latest", h.handleUpdateLatest)
+	s.handle("/govulncheck/update-daily", h.handleUpdateDaily)
+	s.handle("/govulncheck/risk", h.handleRisk)
+	s.handle("/govulncheck/recompute-risk", h.handleRecomputeRisk)
+	s.handle("/govulncheck/adoption-lag", h.handleAdoptionLag)
+	s.handle("/govulncheck/invalidate", h.handleInvalidate)
+	s.handle("/govulncheck/update-osvs", h.handleUpdateOSVs)
+	s.handle(osvsPath, h.handleOSVs)
 }
 
 func (s *Server) registerAnalysisHandlers(ctx context.Context) error {
@@ -208,6 +307,12 @@ func (s *serverError) Error() string {
 }
 
 func (s *Server) serveError(ctx context.Context, w http.ResponseWriter, _ *http.Request, err error) {
+	var rerr *scan.RequestError
+	if errors.As(err, &rerr) {
+		log.Warnf(ctx, "returning %v", err)
+		serveProblem(w, rerr)
+		return
+	}
 	if errors.Is(err, derrors.InvalidArgument) {
 		err = &serverError{err: err, status: http.StatusBadRequest}
 	}
@@ -229,6 +334,34 @@ func (s *Server) serveError(ctx context.Context, w http.ResponseWriter, _ *http.
 	http.Error(w, serr.err.Error(), serr.status)
 }
 
+// A problem is the body of an application/problem+json response,
+// as described in RFC 7807.
+type problem struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Status  int    `json:"status"`
+	Detail  string `json:"detail"`
+	Param   string `json:"param,omitempty"`
+	Example string `json:"example,omitempty"`
+}
+
+// serveProblem responds to a malformed request with a 400 and
+// a problem JSON body describing rerr.
+func serveProblem(w http.ResponseWriter, rerr *scan.RequestError) {
+	p := problem{
+		Type:    "about:blank",
+		Title:   http.StatusText(http.StatusBadRequest),
+		Status:  http.StatusBadRequest,
+		Detail:  fmt.Sprintf("%v: %v", rerr.Kind, rerr.Detail),
+		Param:   rerr.Param,
+		Example: rerr.Example,
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	status int
@@ -239,6 +372,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func translateStatus(code int) int64 {
 	if code == 0 {
 		return http.StatusOK
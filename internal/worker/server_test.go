@@ -0,0 +1,73 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScanRequestProblems(t *testing.T) {
+	s := &Server{}
+	h := newGovulncheckServer(s)
+	for _, test := range []struct {
+		name      string
+		url       string
+		wantParam string
+	}{
+		{"bad module path", "/govulncheck/scan/example.com/m", ""},
+		{"missing param", "/govulncheck/scan/example.com/m@v1.0.0", "importedby"},
+		{"bad param", "/govulncheck/scan/example.com/m@v1.0.0?importedby=x", "importedby"},
+		{"bad suffix", "/govulncheck/scan/example.com/m@v1.0.0?importedby=1&suffix=a/b", "suffix"},
+		{"unknown mode", "/govulncheck/scan/example.com/m@v1.0.0?importedby=1&mode=bogus", "mode"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", test.url, nil)
+			w := httptest.NewRecorder()
+			err := h.handleScan(w, r)
+			if err == nil {
+				t.Fatal("got nil error")
+			}
+			s.serveError(context.Background(), w, r, err)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got, want := w.Header().Get("Content-Type"), "application/problem+json"; got != want {
+				t.Errorf("got Content-Type %q, want %q", got, want)
+			}
+			var p problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Status != http.StatusBadRequest || p.Title == "" || p.Detail == "" {
+				t.Errorf("incomplete problem: %+v", p)
+			}
+			if p.Param != test.wantParam {
+				t.Errorf("got param %q, want %q", p.Param, test.wantParam)
+			}
+			if !strings.HasPrefix(p.Example, "/govulncheck/scan/") {
+				t.Errorf("got example %q, want one for /govulncheck/scan/", p.Example)
+			}
+		})
+	}
+}
+
+func TestServeErrorInternal(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	(&Server{}).serveError(context.Background(), w, r, errors.New("boom"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if got := w.Header().Get("Content-Type"); strings.Contains(got, "problem") {
+		t.Errorf("got Content-Type %q for a server fault", got)
+	}
+}
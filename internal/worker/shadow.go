@@ -0,0 +1,82 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// writeShadowDiffs compares each of rows, which were produced by a shadow
+// scan, with the row stored for the same module version and mode, and
+// writes the differences to the govulncheck-shadow table. Rows with no
+// stored counterpart are skipped.
+func (s *scanner) writeShadowDiffs(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, rows []bigquery.Row) (err error) {
+	defer derrors.Wrap(&err, "writeShadowDiffs(%s)", sreq.Path())
+	if s.bqClient == nil {
+		return errors.New("shadow scans need BigQuery")
+	}
+	var diffs []bigquery.Row
+	for _, row := range rows {
+		cur := row.(*govulncheck.Result)
+		stored, err := govulncheck.ReadResult(ctx, s.bqClient, cur.ModulePath, cur.Version, cur.ScanMode)
+		if err != nil {
+			return err
+		}
+		if stored == nil {
+			log.Infof(ctx, "shadow: no stored %s row for %s@%s", cur.ScanMode, cur.ModulePath, cur.Version)
+			continue
+		}
+		fields := govulncheck.DiffResults(stored, cur)
+		if len(fields) > 0 {
+			log.Infof(ctx, "shadow: %s row for %s@%s differs in %v", cur.ScanMode, cur.ModulePath, cur.Version, fields)
+		}
+		added, removed := govulncheck.DiffFingerprints(stored, cur)
+		diffs = append(diffs, &govulncheck.ShadowDiff{
+			ModulePath:          cur.ModulePath,
+			Version:             cur.Version,
+			ScanMode:            cur.ScanMode,
+			Suffix:              sreq.QueryParams.Suffix,
+			StoredWorkerVersion: stored.WorkerVersion,
+			WorkerVersion:       cur.WorkerVersion,
+			Fields:              fields,
+			AddedFindings:       added,
+			RemovedFindings:     removed,
+		})
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	return writeResults(ctx, false, w, s.resultSink, govulncheck.ShadowTableName, diffs)
+}
+
+// handleShadowStats summarizes the shadow scans of a run.
+//
+// It is triggered by path /govulncheck/shadow-stats?suffix=S.
+func (h *GovulncheckServer) handleShadowStats(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleShadowStats")
+	suffix := r.FormValue("suffix")
+	if suffix == "" {
+		return fmt.Errorf("%w: need suffix query param", derrors.InvalidArgument)
+	}
+	if err := govulncheck.ValidateSuffix(suffix); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("bq client is nil")
+	}
+	stats, err := govulncheck.ReadShadowStats(r.Context(), h.bqClient, suffix)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, stats)
+}
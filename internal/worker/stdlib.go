@@ -0,0 +1,183 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// stdlibModulePath is the module path of stdlib rows, and of standard
+// library frames in govulncheck output.
+const stdlibModulePath = "stdlib"
+
+// isStdlibRequest reports whether sreq asks for a scan of the
+// standard library.
+func isStdlibRequest(sreq *govulncheck.Request) bool {
+	return sreq.Module == "std" || sreq.Module == stdlibModulePath
+}
+
+// stdlibProgram is the program scanned for standard library vulnerabilities.
+// govulncheck reports every vulnerability in the standard library at the
+// module level, so the program doesn't need to use any packages.
+var stdlibProgram = map[string]string{
+	"go.mod":  "module stdlib.scan\n",
+	"main.go": "package main\n\nfunc main() {}\n",
+}
+
+// scanStdlib writes a row for the standard library of each configured Go
+// toolchain, with the vulnerabilities that affect it. Rows have module path
+// "stdlib" and the Go version as their version, so they can be joined with
+// the go_version column of module rows. A toolchain is skipped if its row
+// is up to date with the work version, which includes the vuln DB snapshot.
+//
+// The program scanned contains no module code, so it is scanned outside
+// the sandbox.
+func (h *GovulncheckServer) scanStdlib(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, s *scanner) (err error) {
+	defer derrors.Wrap(&err, "scanStdlib")
+
+	dir, err := os.MkdirTemp("", "stdlib")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	for name, contents := range stdlibProgram {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+
+	var rows []bigquery.Row
+	for _, goroot := range goToolchains(h.cfg.GoToolchains) {
+		goVersion, err := toolchainVersion(goroot, s.workVersion.GoVersion)
+		if err != nil {
+			return err
+		}
+		wv := *s.workVersion
+		wv.GoVersion = goVersion
+		skip, err := h.stdlibUpToDate(ctx, s, goVersion, &wv)
+		if err != nil {
+			return err
+		}
+		if skip {
+			log.Infof(ctx, "skipping stdlib@%s: work version unchanged", goVersion)
+			continue
+		}
+		rows = append(rows, s.scanStdlibVersion(ctx, sreq, dir, goroot, goVersion, &wv))
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return s.writeRows(ctx, w, sreq, rows)
+}
+
+// stdlibUpToDate reports whether the stored row for the standard library
+// of goVersion has work version wv.
+func (h *GovulncheckServer) stdlibUpToDate(ctx context.Context, s *scanner, goVersion string, wv *govulncheck.WorkVersion) (bool, error) {
+	_, upToDate, err := h.workState(ctx, s, stdlibModulePath, goVersion, wv)
+	return upToDate, err
+}
+
+func (s *scanner) scanStdlibVersion(ctx context.Context, sreq *govulncheck.Request, dir, goroot, goVersion string, wv *govulncheck.WorkVersion) *govulncheck.Result {
+	row := &govulncheck.Result{
+		ModulePath:  stdlibModulePath,
+		Version:     goVersion,
+		Suffix:      sreq.QueryParams.Suffix,
+		WorkVersion: *wv,
+		ScanMode:    modeStdlib,
+		ImportedBy:  sreq.ImportedBy,
+	}
+	row.VulnDBLastModified = wv.VulnDBLastModified
+	row.VulnDBEntryCount = bigquery.NullInt(s.dbEntryCount)
+
+	stats := &govulncheck.ScanStats{}
+	opts := &govulncheck.RunOptions{MaxFindings: s.maxFindings, GoRoot: goroot, Timeout: s.scanTimeout, VulnDBCaches: s.vulnDBCaches}
+	findings, osvs, err := govulncheck.RunGovulncheckCmd(ctx, s.govulncheckPath, govulncheck.FlagSource, "./...", dir, s.vulnDBDirs, opts, stats)
+	row.ScanSeconds = stats.ScanSeconds
+	row.ScanMemory = int64(stats.ScanMemory)
+	row.SetScanTimes(stats)
+	row.SetCPUUsage(stats)
+	row.SetScannerConfig(stats)
+	if err != nil {
+		log.Errorf(ctx, err, "scanning stdlib@%s", goVersion)
+		row.AddPhaseError(phaseScanning, fmt.Errorf("%v: %w", err, derrors.ScanModuleGovulncheckError))
+		s.scrubber.Scrub(row)
+		return row
+	}
+	findings, _ = s.osvFilter.Filter(findings)
+	var vulns []*govulncheck.Vuln
+	for _, f := range findings {
+		if len(f.Trace) == 0 || f.Trace[0].Module != stdlibModulePath {
+			continue
+		}
+		vulns = append(vulns, govulncheck.ConvertGovulncheckFinding(f))
+	}
+	missing := govulncheck.EnrichVulns(vulns, osvs)
+	if missing = s.osvCache.EnrichMissing(ctx, vulns, missing); len(missing) > 0 {
+		log.Warnf(ctx, "stdlib@%s: OSV entries withdrawn or missing: %v", goVersion, missing)
+	}
+	govulncheck.SetSourceDBs(vulns, s.vulnDBDirs)
+	row.SetVulnCounts(vulns)
+	row.Vulns = stdlibVulns(vulns)
+	s.scrubber.Scrub(row)
+	return row
+}
+
+// stdlibVulns returns one vuln for each vulnerability in vulns.
+// govulncheck reports a vulnerability once at the module level and
+// again for each package or symbol the program uses, but the program
+// scanned for the standard library uses none of them.
+func stdlibVulns(vulns []*govulncheck.Vuln) []*govulncheck.Vuln {
+	seen := map[string]bool{}
+	var vs []*govulncheck.Vuln
+	for _, v := range vulns {
+		if !seen[v.ID] {
+			seen[v.ID] = true
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// goToolchains returns the GOROOTs in the comma-separated list spec.
+// The empty GOROOT, meaning the toolchain on the PATH, is returned
+// if spec is empty.
+func goToolchains(spec string) []string {
+	var roots []string
+	for _, r := range strings.Split(spec, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roots = append(roots, r)
+		}
+	}
+	if len(roots) == 0 {
+		return []string{""}
+	}
+	return roots
+}
+
+// toolchainVersion returns the Go version of the toolchain at goroot,
+// or defaultVersion if goroot is empty.
+func toolchainVersion(goroot, defaultVersion string) (string, error) {
+	if goroot == "" {
+		return defaultVersion, nil
+	}
+	cmd := exec.Command(filepath.Join(goroot, "bin", "go"), "env", "GOVERSION")
+	cmd.Env = append(cmd.Environ(), "GOROOT="+goroot, "GOTOOLCHAIN=local")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("toolchain %s: %v", goroot, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
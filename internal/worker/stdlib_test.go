@@ -0,0 +1,43 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestGoToolchains(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want []string
+	}{
+		{"", []string{""}},
+		{" , ", []string{""}},
+		{"/go1.20", []string{"/go1.20"}},
+		{"/go1.20, /go1.21,", []string{"/go1.20", "/go1.21"}},
+	} {
+		if got := goToolchains(test.spec); !cmp.Equal(got, test.want) {
+			t.Errorf("goToolchains(%q) = %q, want %q", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestStdlibVulns(t *testing.T) {
+	vulns := []*govulncheck.Vuln{
+		{ID: "GO-1", PackagePath: ""},
+		{ID: "GO-1", PackagePath: "net/http"},
+		{ID: "GO-2", PackagePath: ""},
+	}
+	var got []string
+	for _, v := range stdlibVulns(vulns) {
+		got = append(got, v.ID)
+	}
+	if want := []string{"GO-1", "GO-2"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+// A goToolchain is an installed Go toolchain that modules can be
+// scanned with. Toolchains used in the sandbox must be installed under
+// its root file system.
+type goToolchain struct {
+	goroot  string // "" for the toolchain on the PATH
+	version string // as reported by "go env GOVERSION"
+}
+
+// installedToolchains returns the toolchain on the PATH, whose version is
+// defaultVersion, followed by the toolchains in spec; see goToolchains.
+func installedToolchains(spec, defaultVersion string) ([]goToolchain, error) {
+	tcs := []goToolchain{{version: defaultVersion}}
+	for _, goroot := range goToolchains(spec) {
+		if goroot == "" {
+			continue
+		}
+		v, err := toolchainVersion(goroot, defaultVersion)
+		if err != nil {
+			return nil, err
+		}
+		tcs = append(tcs, goToolchain{goroot: goroot, version: v})
+	}
+	return tcs, nil
+}
+
+// findToolchain returns the toolchain of toolchains with Go version v.
+func findToolchain(toolchains []goToolchain, v string) (goToolchain, error) {
+	want, err := govulncheck.GoVersionSemver(v)
+	if err != nil {
+		return goToolchain{}, err
+	}
+	var versions []string
+	for _, tc := range toolchains {
+		if sv, err := govulncheck.GoVersionSemver(tc.version); err == nil && sv == want {
+			return tc, nil
+		}
+		versions = append(versions, tc.version)
+	}
+	return goToolchain{}, fmt.Errorf("Go %s is not installed; installed versions are %v", v, versions)
+}
+
+// selectToolchain returns the toolchain of toolchains, the first of which
+// is the default, to scan a module that requires Go version required:
+// the default if it is recent enough or required is unknown, or else the
+// oldest toolchain that is. If none is, it returns the default, and the
+// go command reports the mismatch.
+func selectToolchain(toolchains []goToolchain, required string) goToolchain {
+	def := toolchains[0]
+	req, err := govulncheck.GoVersionSemver(required)
+	if err != nil {
+		return def
+	}
+	if sv, err := govulncheck.GoVersionSemver(def.version); err != nil || semver.Compare(sv, req) >= 0 {
+		return def
+	}
+	best, bestVersion := def, ""
+	for _, tc := range toolchains[1:] {
+		sv, err := govulncheck.GoVersionSemver(tc.version)
+		if err != nil || semver.Compare(sv, req) < 0 {
+			continue
+		}
+		if bestVersion == "" || semver.Compare(sv, bestVersion) < 0 {
+			best, bestVersion = tc, sv
+		}
+	}
+	return best
+}
+
+// requiredGoVersion returns the Go version that the module in dir
+// requires: the later of those of its go and toolchain directives. It
+// returns "" if the module has neither, or its go.mod file can't be read.
+func requiredGoVersion(dir string) string {
+	goModPath := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return ""
+	}
+	// ParseLax ignores toolchain directives, so it is only used for
+	// go.mod files that Parse rejects.
+	f, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		f, err = modfile.ParseLax(goModPath, data, nil)
+		if err != nil {
+			return ""
+		}
+	}
+	var required, requiredSemver string
+	consider := func(v string) {
+		if sv, err := govulncheck.GoVersionSemver(v); err == nil && (requiredSemver == "" || semver.Compare(sv, requiredSemver) > 0) {
+			required, requiredSemver = v, sv
+		}
+	}
+	if f.Go != nil {
+		consider(f.Go.Version)
+	}
+	if f.Toolchain != nil {
+		consider(f.Toolchain.Name)
+	}
+	return required
+}
+
+// chooseToolchain selects the toolchain that scans the module downloaded
+// to dir, unless the goversion param already selected it.
+func (s *scanner) chooseToolchain(dir string) {
+	if s.pinToolchain || len(s.toolchains) == 0 {
+		return
+	}
+	s.goVersion = requiredGoVersion(dir)
+	s.toolchain = selectToolchain(s.toolchains, s.goVersion)
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelectToolchain(t *testing.T) {
+	toolchains := []goToolchain{
+		{version: "go1.20.7"},
+		{goroot: "/go1.22", version: "go1.22.0"},
+		{goroot: "/go1.21", version: "go1.21.3"},
+	}
+	for _, test := range []struct {
+		required, want string // want is a GOROOT
+	}{
+		{"", ""},
+		{"1.19", ""},
+		{"1.20", ""},
+		{"1.21", "/go1.21"},
+		{"go1.21.3", "/go1.21"},
+		{"go1.21.4", "/go1.22"},
+		{"1.23", ""},
+		{"not a version", ""},
+	} {
+		if got := selectToolchain(toolchains, test.required); got.goroot != test.want {
+			t.Errorf("%q: got %q, want %q", test.required, got.goroot, test.want)
+		}
+	}
+
+	tc, err := findToolchain(toolchains, "1.22.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.goroot != "/go1.22" {
+		t.Errorf("findToolchain: got %q, want /go1.22", tc.goroot)
+	}
+	if _, err := findToolchain(toolchains, "go1.19"); err == nil {
+		t.Error("findToolchain(go1.19): got nil, want error")
+	}
+}
+
+func TestRequiredGoVersion(t *testing.T) {
+	for _, test := range []struct {
+		goMod, want string
+	}{
+		{"module m\n", ""},
+		{"module m\n\ngo 1.21\n", "1.21"},
+		{"module m\n\ngo 1.21\n\ntoolchain go1.21.4\n", "go1.21.4"},
+		{"module m\n\ngo 1.22.0\n\ntoolchain go1.21.4\n", "1.22.0"},
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(test.goMod), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := requiredGoVersion(dir); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.goMod, got, test.want)
+		}
+	}
+	if got := requiredGoVersion(t.TempDir()); got != "" {
+		t.Errorf("no go.mod: got %q, want empty", got)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// govulncheckVersionsDir is the directory under the binary directory
+// that holds installed versions of govulncheck, each in a subdirectory
+// named for its version.
+const govulncheckVersionsDir = "govulncheck-versions"
+
+// installedGovulncheckVersions returns the sorted versions of govulncheck
+// installed under binaryDir.
+func installedGovulncheckVersions(binaryDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(binaryDir, govulncheckVersionsDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(binaryDir, govulncheckVersionsDir, e.Name(), "govulncheck"))
+		if err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0111 != 0 {
+			versions = append(versions, e.Name())
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// govulncheckVersionPath returns the path of the installed govulncheck
+// with the given version.
+func govulncheckVersionPath(binaryDir, version string) (string, error) {
+	versions, err := installedGovulncheckVersions(binaryDir)
+	if err != nil {
+		return "", err
+	}
+	i := sort.SearchStrings(versions, version)
+	if i == len(versions) || versions[i] != version {
+		return "", fmt.Errorf("govulncheck version %q is not installed; installed versions are %v", version, versions)
+	}
+	return filepath.Join(binaryDir, govulncheckVersionsDir, version, "govulncheck"), nil
+}
+
+// govulncheckBinaryVersion returns the version of the module that the
+// govulncheck binary at path was built from, as recorded in its build
+// info. A binary built from a checkout has the version "(devel)", so
+// its VCS revision is added, if known. It returns "" if there is no
+// binary at path.
+func govulncheckBinaryVersion(path string) (string, error) {
+	bi, err := buildinfo.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	v := bi.Main.Version
+	if v == "(devel)" {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				v += " " + s.Value
+			}
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGovulncheckVersions(t *testing.T) {
+	dir := t.TempDir()
+	if got, err := installedGovulncheckVersions(dir); err != nil || got != nil {
+		t.Fatalf("no versions dir: got %v, %v; want nil, nil", got, err)
+	}
+	for v, mode := range map[string]os.FileMode{
+		"v1.0.1":     0755,
+		"v1.1.0-rc1": 0755,
+		"v0.9.0":     0644, // not executable
+	} {
+		vdir := filepath.Join(dir, govulncheckVersionsDir, v)
+		if err := os.MkdirAll(vdir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(vdir, "govulncheck"), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, govulncheckVersionsDir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := installedGovulncheckVersions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"v1.0.1", "v1.1.0-rc1"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	path, err := govulncheckVersionPath(dir, "v1.1.0-rc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, govulncheckVersionsDir, "v1.1.0-rc1", "govulncheck"); path != want {
+		t.Errorf("got %q, want %q", path, want)
+	}
+	for _, v := range []string{"v0.9.0", "empty", "v2.0.0", "../v1.0.1"} {
+		if _, err := govulncheckVersionPath(dir, v); err == nil {
+			t.Errorf("%s: got nil error, want not installed", v)
+		}
+	}
+}
+
+func TestGovulncheckBinaryVersion(t *testing.T) {
+	dir := t.TempDir()
+	if got, err := govulncheckBinaryVersion(filepath.Join(dir, "govulncheck")); err != nil || got != "" {
+		t.Errorf("no binary: got %q, %v; want empty, nil", got, err)
+	}
+	script := filepath.Join(dir, "script")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := govulncheckBinaryVersion(script); err == nil {
+		t.Error("not a Go binary: got nil error")
+	}
+	// The test binary is a Go binary.
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	if _, err := govulncheckBinaryVersion(exe); err != nil {
+		t.Errorf("test binary: %v", err)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
 	"golang.org/x/pkgsite-metrics/internal/osv"
+	"golang.org/x/pkgsite-metrics/internal/sink"
 	"golang.org/x/pkgsite-metrics/internal/vulndb"
 	"golang.org/x/pkgsite-metrics/internal/vulndbreqs"
 )
@@ -85,7 +86,7 @@ func (s *Server) handleVulnDB(w http.ResponseWriter, r *http.Request) (err error
 			log.Infof(ctx, "skipping entry %s, it has not been modified", e.ID)
 			continue
 		}
-		if err = writeResult(ctx, false, w, dbClient, vulndb.TableName, e); err != nil {
+		if err = writeResult(ctx, false, w, sink.NewBigQuery(dbClient), vulndb.TableName, e); err != nil {
 			return err
 		}
 	}
@@ -47,6 +47,10 @@ locals {
   tz                     = "America/New_York"
   worker_service_account = "worker@${var.project}.iam.gserviceaccount.com"
   pkgsite_db             = "${var.pkgsite_db_project}:${var.region}:${var.pkgsite_db_name}"
+
+  # Attempts of a task queue task, after which the worker records it as a
+  # dead letter.
+  max_task_attempts = 30
 }
 
 
@@ -116,6 +120,22 @@ resource "google_cloud_run_service" "worker" {
           name  = "GO_ECOSYSTEM_QUEUE_NAME"
           value = "${var.env}-worker-tasks"
         }
+        env {
+          name  = "GO_ECOSYSTEM_MAX_TASK_ATTEMPTS"
+          value = local.max_task_attempts
+        }
+        env {
+          name  = "GO_ECOSYSTEM_PRIORITY_QUEUE_NAME"
+          value = "${var.env}-worker-priority-tasks"
+        }
+        env {
+          name  = "GO_ECOSYSTEM_MODE_QUEUES"
+          value = join(",", [for mode, q in local.mode_queues : "${mode}=${var.env}-worker-${q.name}-tasks"])
+        }
+        env {
+          name  = "GO_ECOSYSTEM_PUBSUB_TOPIC"
+          value = google_pubsub_topic.worker_tasks.name
+        }
         env {
           name = "GITHUB_ACCESS_TOKEN"
           value_from {
@@ -163,6 +183,10 @@ resource "google_cloud_run_service" "worker" {
           name  = "GO_ECOSYSTEM_VULNDB_BUCKET_PROJECT"
           value = var.vulndb_bucket_project
         }
+        env {
+          name  = "GO_ECOSYSTEM_EXPORT_URI"
+          value = "gs://go-ecosystem/exports/${var.env}"
+        }
       }
 
       service_account_name = local.worker_service_account
@@ -218,7 +242,7 @@ resource "google_cloud_tasks_queue" "worker_tasks" {
   }
 
   retry_config {
-    max_attempts       = -1
+    max_attempts       = local.max_task_attempts
     max_backoff        = "1440s"
     max_doublings      = 16
     max_retry_duration = "604800s"
@@ -230,6 +254,106 @@ resource "google_cloud_tasks_queue" "worker_tasks" {
   }
 }
 
+# Queue of the tasks of modules imported by many others, which dispatches
+# them at a higher rate so that they are scanned first.
+resource "google_cloud_tasks_queue" "worker_priority_tasks" {
+  name     = "${var.env}-worker-priority-tasks"
+  location = var.region
+  project  = var.project
+
+  rate_limits {
+    max_concurrent_dispatches = 500
+    max_dispatches_per_second = 1000
+  }
+
+  retry_config {
+    max_attempts       = local.max_task_attempts
+    max_backoff        = "1440s"
+    max_doublings      = 16
+    max_retry_duration = "604800s"
+    min_backoff        = "60s"
+  }
+
+  stackdriver_logging_config {
+    sampling_ratio = 1
+  }
+}
+
+# Queues of the tasks of scan modes whose tasks take much longer or much
+# less time than others, with their own rate limits, by mode.
+locals {
+  mode_queues = {
+    COMPARE = {
+      name                      = "compare"
+      max_concurrent_dispatches = 20
+      max_dispatches_per_second = 10
+    }
+    EXTRACT = {
+      name                      = "extract"
+      max_concurrent_dispatches = 100
+      max_dispatches_per_second = 100
+    }
+    ANALYSIS = {
+      name                      = "analysis"
+      max_concurrent_dispatches = 50
+      max_dispatches_per_second = 50
+    }
+  }
+}
+
+resource "google_cloud_tasks_queue" "worker_mode_tasks" {
+  for_each = local.mode_queues
+  name     = "${var.env}-worker-${each.value.name}-tasks"
+  location = var.region
+  project  = var.project
+
+  rate_limits {
+    max_concurrent_dispatches = each.value.max_concurrent_dispatches
+    max_dispatches_per_second = each.value.max_dispatches_per_second
+  }
+
+  retry_config {
+    max_attempts       = local.max_task_attempts
+    max_backoff        = "1440s"
+    max_doublings      = 16
+    max_retry_duration = "604800s"
+    min_backoff        = "60s"
+  }
+
+  stackdriver_logging_config {
+    sampling_ratio = 1
+  }
+}
+
+# Alternative to the Cloud Tasks queue, used if GO_ECOSYSTEM_QUEUE_SERVICE
+# is "pubsub".
+resource "google_pubsub_topic" "worker_tasks" {
+  name    = "${var.env}-worker-tasks"
+  project = var.project
+}
+
+resource "google_pubsub_subscription" "worker_tasks" {
+  name    = "${var.env}-worker-tasks-push"
+  topic   = google_pubsub_topic.worker_tasks.name
+  project = var.project
+
+  ack_deadline_seconds       = 600
+  message_retention_duration = "604800s"
+
+  push_config {
+    push_endpoint = "${local.worker_url}/queue/push"
+    oidc_token {
+      service_account_email = local.worker_service_account
+      audience              = local.worker_url
+    }
+  }
+
+  retry_policy {
+    minimum_backoff = "60s"
+    maximum_backoff = "600s"
+  }
+}
+
 resource "google_secret_manager_secret" "github_access_token" {
   secret_id = "${var.env}-github-access-token"
   project   = var.project
@@ -313,3 +437,60 @@ resource "google_cloud_scheduler_job" "enqueuecompare" {
     }
   }
 }
+
+resource "google_cloud_scheduler_job" "update_latest" {
+  count       = var.env == "prod" ? 1 : 0
+  name        = "${var.env}-update-latest"
+  description = "Merge the latest govulncheck rows into the govulncheck-latest table."
+  schedule    = "0 4 * * *" # 4 AM daily
+  time_zone   = local.tz
+  project     = var.project
+
+  attempt_deadline = "1800s" # 30 min max deadline for HTTP target
+  http_target {
+    http_method = "GET"
+    uri         = "${local.worker_url}/govulncheck/update-latest"
+    oidc_token {
+      service_account_email = local.worker_service_account
+      audience              = local.worker_url
+    }
+  }
+}
+
+resource "google_cloud_scheduler_job" "update_daily" {
+  count       = var.env == "prod" ? 1 : 0
+  name        = "${var.env}-update-daily"
+  description = "Summarize yesterday's govulncheck rows in the govulncheck_daily table."
+  schedule    = "30 3 * * *" # 3:30 AM daily
+  time_zone   = local.tz
+  project     = var.project
+
+  attempt_deadline = "1800s" # 30 min max deadline for HTTP target
+  http_target {
+    http_method = "GET"
+    uri         = "${local.worker_url}/govulncheck/update-daily"
+    oidc_token {
+      service_account_email = local.worker_service_account
+      audience              = local.worker_url
+    }
+  }
+}
+
+resource "google_cloud_scheduler_job" "export" {
+  count       = var.env == "prod" ? 1 : 0
+  name        = "${var.env}-export"
+  description = "Export yesterday's results to GCS as Parquet."
+  schedule    = "0 3 * * *" # 3 AM daily
+  time_zone   = local.tz
+  project     = var.project
+
+  attempt_deadline = "1800s" # 30 min max deadline for HTTP target
+  http_target {
+    http_method = "GET"
+    uri         = "${local.worker_url}/export"
+    oidc_token {
+      service_account_email = local.worker_service_account
+      audience              = local.worker_url
+    }
+  }
+}